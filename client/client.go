@@ -0,0 +1,251 @@
+// Package client is a Go client for the mtr-tool HTTP API.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default retry settings
+const (
+	defaultMaxRetries = 3
+	defaultBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Client calls an mtr-tool server
+type Client struct {
+	baseURL    string
+	transport  http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	http       *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithTransport sets the RoundTripper requests are sent through
+// (default: http.DefaultTransport). Retries are layered on top of it.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.transport = rt }
+}
+
+// WithMaxRetries sets how often a GET request is retried after a 429 or a
+// 503, and a read-only one such as Recent also after a transport error
+// (default: 3, 0 disables retries). Requests that start a trace are not
+// retried after a transport error, since the trace may be running already.
+func WithMaxRetries(n int) Option {
+	return func(c *Client) { c.maxRetries = n }
+}
+
+// WithBackoff sets the delay before the first retry, doubled on every
+// further retry up to max. A Retry-After header from the server takes
+// precedence.
+func WithBackoff(initial, max time.Duration) Option {
+	return func(c *Client) { c.backoff, c.maxBackoff = initial, max }
+}
+
+// New creates a client for the server at baseURL, e.g. http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		transport:  http.DefaultTransport,
+		maxRetries: defaultMaxRetries,
+		backoff:    defaultBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.http = &http.Client{Transport: &retryTransport{
+		next:       c.transport,
+		maxRetries: c.maxRetries,
+		backoff:    c.backoff,
+		maxBackoff: c.maxBackoff,
+	}}
+	return c
+}
+
+// Response is the server's reply to a trace request
+type Response struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// SchemaVersion is the version of the server's JSON result layout this
+// client understands
+const SchemaVersion = 2
+
+// Result is the outcome of a synchronous trace (GET /mtr?sync=true), with
+// the hops as structured data for clients that render their own views
+type Result struct {
+	SchemaVersion      int               `json:"schema_version"`
+	Target             string            `json:"target"`
+	ResolvedIPs        []string          `json:"resolved_ips"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	ProbesRequested    int               `json:"probes_requested"`
+	ProbesSent         int               `json:"probes_sent"`
+	Hops               []Hop             `json:"hops"`
+	DestinationReached bool              `json:"destination_reached"`
+	Health             string            `json:"health"`
+	Warnings           []string          `json:"warnings,omitempty"`
+}
+
+// Hop holds the statistics of one hop; times are in milliseconds and Loss
+// is a percentage
+type Hop struct {
+	Hop            int               `json:"hop"`
+	Hostname       string            `json:"hostname"`
+	IP             string            `json:"ip"`
+	Loss           float64           `json:"loss"`
+	Sent           int               `json:"sent"`
+	Last           float64           `json:"last"`
+	Avg            float64           `json:"avg"`
+	Best           float64           `json:"best"`
+	Worst          float64           `json:"worst"`
+	StDev          float64           `json:"stdev"`
+	Jitter         float64           `json:"jitter"`
+	P50            float64           `json:"p50"`
+	P95            float64           `json:"p95"`
+	P99            float64           `json:"p99"`
+	ASN            string            `json:"asn,omitempty"`
+	ASName         string            `json:"as_name,omitempty"`
+	Country        string            `json:"country,omitempty"`
+	City           string            `json:"city,omitempty"`
+	AltIPs         []string          `json:"alt_ips,omitempty"`
+	Annotations    map[string]string `json:"annotations,omitempty"`
+	Classification string            `json:"classification,omitempty"`
+}
+
+// Error is returned for non-2xx responses
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mtr-tool: %d %s", e.StatusCode, e.Message)
+}
+
+// Trace starts an asynchronous trace (GET /mtr). params are the API's query
+// parameters, e.g. url.Values{"hostname": {"example.com"}, "count": {"10"}}.
+func (c *Client) Trace(ctx context.Context, params url.Values) (*Response, error) {
+	body, err := c.get(ctx, "/mtr", params)
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("mtr-tool: invalid response: %v", err)
+	}
+	return &resp, nil
+}
+
+// TraceResult runs a trace synchronously (GET /mtr?sync=true) and returns
+// its parsed hops
+func (c *Client) TraceResult(ctx context.Context, params url.Values) (*Result, error) {
+	query := url.Values{"sync": {"true"}}
+	for key, values := range params {
+		if key != "sync" {
+			query[key] = values
+		}
+	}
+	body, err := c.get(ctx, "/mtr", query)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Result *Result `json:"result"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("mtr-tool: invalid response: %v", err)
+	}
+	if resp.Result == nil {
+		return nil, fmt.Errorf("mtr-tool: invalid response: no result")
+	}
+	// A different layout is decoded as far as it matches rather than
+	// rejected; the warning tells the caller some fields may be missing
+	if v := resp.Result.SchemaVersion; v != SchemaVersion {
+		resp.Result.Warnings = append(resp.Result.Warnings, fmt.Sprintf(
+			"server result schema version %d differs from the client's version %d; some fields may be missing", v, SchemaVersion))
+	}
+	return resp.Result, nil
+}
+
+// Raw runs a trace synchronously and returns mtr's raw output (GET /mtr/raw)
+func (c *Client) Raw(ctx context.Context, params url.Values) (string, error) {
+	body, err := c.get(ctx, "/mtr/raw", params)
+	return string(body), err
+}
+
+// RecentTrace is the outcome of the latest cached trace of a target
+type RecentTrace struct {
+	Target             string    `json:"target"`
+	Health             string    `json:"health"`
+	DestinationReached bool      `json:"destination_reached"`
+	TracedAt           time.Time `json:"traced_at"`
+}
+
+// Recent lists the most recently traced targets, newest first (GET
+// /recent), to poll for the results of traces started with Trace. A limit
+// of 0 uses the server's default.
+func (c *Client) Recent(ctx context.Context, limit int) ([]RecentTrace, error) {
+	params := url.Values{}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	body, err := c.get(readOnly(ctx), "/recent", params)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Recent []RecentTrace `json:"recent"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("mtr-tool: invalid response: %v", err)
+	}
+	return resp.Recent, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// Failed synchronous traces carry their message in error
+		var apiErr struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+		message := strings.TrimSpace(string(body))
+		if json.Unmarshal(body, &apiErr) == nil {
+			if apiErr.Message != "" {
+				message = apiErr.Message
+			} else if apiErr.Error != "" {
+				message = apiErr.Error
+			}
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Message: message}
+	}
+	return body, nil
+}
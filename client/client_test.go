@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// flakyTransport fails the first failures requests in transport and sends
+// the rest on, counting them all
+type flakyTransport struct {
+	failures int32
+	calls    atomic.Int32
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.calls.Add(1) <= t.failures {
+		return nil, errors.New("connection reset by peer")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRetryRateLimited(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, `{"status":"error","message":"rate limited"}`, http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"status":"ok","message":"MTR trace started for example.com"}`))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithBackoff(time.Millisecond, 10*time.Millisecond))
+	resp, err := c.Trace(context.Background(), url.Values{"hostname": {"example.com"}})
+	if err != nil {
+		t.Fatalf("Trace: %v", err)
+	}
+	if resp.Status != "ok" || requests.Load() != 2 {
+		t.Errorf("status %q after %d requests, want ok after 2", resp.Status, requests.Load())
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, `{"status":"error","message":"circuit open"}`, http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, WithMaxRetries(2), WithBackoff(time.Millisecond, 10*time.Millisecond))
+	_, err := c.Trace(context.Background(), url.Values{"hostname": {"example.com"}})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Trace error = %v, want the 503", err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("%d requests, want 1 and 2 retries", requests.Load())
+	}
+}
+
+func TestRetryTransportErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recent":
+			w.Write([]byte(`{"recent":[{"target":"example.com","health":"healthy","destination_reached":true}]}`))
+		default:
+			w.Write([]byte(`{"status":"ok","message":"MTR trace started for example.com"}`))
+		}
+	}))
+	defer srv.Close()
+
+	// A trace may already be running when its request failed in transport
+	transport := &flakyTransport{failures: 1}
+	c := New(srv.URL, WithTransport(transport), WithBackoff(time.Millisecond, 10*time.Millisecond))
+	if _, err := c.Trace(context.Background(), url.Values{"hostname": {"example.com"}}); err == nil {
+		t.Error("Trace succeeded after a transport error")
+	}
+	if n := transport.calls.Load(); n != 1 {
+		t.Errorf("trace request sent %d times, want once", n)
+	}
+
+	// Polling is read-only and retried
+	transport = &flakyTransport{failures: 1}
+	c = New(srv.URL, WithTransport(transport), WithBackoff(time.Millisecond, 10*time.Millisecond))
+	recent, err := c.Recent(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("Recent: %v", err)
+	}
+	if len(recent) != 1 || recent[0].Target != "example.com" || transport.calls.Load() != 2 {
+		t.Errorf("recent %+v after %d requests, want example.com after 2", recent, transport.calls.Load())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %s, %v; want %s, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTraceResultSchemaVersion(t *testing.T) {
+	if SchemaVersion != mtr.SchemaVersion {
+		t.Fatalf("client schema version %d, server %d", SchemaVersion, mtr.SchemaVersion)
+	}
+
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	c := New(srv.URL)
+
+	body = fmt.Sprintf(`{"status":"completed","result":{"schema_version":%d,"target":"example.com","hops":[{"hop":1,"ip":"192.0.2.1"}]}}`, SchemaVersion)
+	res, err := c.TraceResult(context.Background(), url.Values{"hostname": {"example.com"}})
+	if err != nil {
+		t.Fatalf("TraceResult: %v", err)
+	}
+	if len(res.Warnings) != 0 {
+		t.Errorf("warnings %q for the client's version", res.Warnings)
+	}
+
+	// An unexpected version is decoded as far as it matches, with a warning
+	body = `{"status":"completed","result":{"schema_version":99,"target":"example.com","hops":[{"hop":1,"ip":"192.0.2.1","rtt":{"avg":1}}],"warnings":["slow"]}}`
+	res, err = c.TraceResult(context.Background(), url.Values{"hostname": {"example.com"}})
+	if err != nil {
+		t.Fatalf("TraceResult: %v", err)
+	}
+	if res.Target != "example.com" || len(res.Hops) != 1 || res.Hops[0].IP != "192.0.2.1" {
+		t.Errorf("result %+v", res)
+	}
+	want := fmt.Sprintf("server result schema version 99 differs from the client's version %d; some fields may be missing", SchemaVersion)
+	if len(res.Warnings) != 2 || res.Warnings[0] != "slow" || res.Warnings[1] != want {
+		t.Errorf("warnings %q", res.Warnings)
+	}
+}
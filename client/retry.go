@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// readOnlyKey marks the context of a request that only reads state
+type readOnlyKey struct{}
+
+// readOnly marks requests made with ctx as safe to send again after a
+// transport error. A GET that starts a trace is not: the server may have
+// received it and be running the trace already.
+func readOnly(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readOnlyKey{}, true)
+}
+
+// retryTransport retries GET requests rejected with 429 Too Many Requests
+// or 503 Service Unavailable, which the server refused without acting on
+// them, and read-only ones that failed in transport
+type retryTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return t.next.RoundTrip(req)
+	}
+
+	delay := t.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.maxRetries || !retryable(resp, err, req.Context().Value(readOnlyKey{}) != nil) {
+			return resp, err
+		}
+
+		wait := delay
+		if resp != nil {
+			if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = after
+			}
+			// Drain the body so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if wait > t.maxBackoff {
+			wait = t.maxBackoff
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > t.maxBackoff {
+			delay = t.maxBackoff
+		}
+	}
+}
+
+// retryable reports whether a response or transport error is worth
+// retrying. Transport errors are only retried for read-only requests,
+// which are safe to send twice.
+func retryable(resp *http.Response, err error, readOnly bool) bool {
+	if err != nil {
+		return readOnly
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date
+func retryAfter(header string) (time.Duration, bool) {
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
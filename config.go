@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envName returns the environment variable that also sets the flag name,
+// for deployments such as containers where flags are awkward to pass: MTR_
+// and the name in upper case with dashes as underscores, e.g. MTR_COUNT for
+// -count
+func envName(name string) string {
+	return "MTR_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// usage prints the flags like the flag package does, and how the
+// environment and -config set them
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nEvery flag can also be set with an environment variable named %s and the flag's\n"+
+		"name in upper case with dashes as underscores, e.g. %s=50 for -count=50, and MTR_PROTOCOL\n"+
+		"selects icmp, tcp or udp. Flags given on the command line override the environment, which\n"+
+		"overrides the -config file.\n", "MTR_<FLAG>", envName("count"))
+}
+
+// loadSettings resolves the settings of fs not given on the command line,
+// for the CLI and the server alike: from MTR_* environment variables first,
+// then from the -config file, if any, so the command line overrides the
+// environment, which overrides the file and the defaults. The file itself
+// may be named with MTR_CONFIG too.
+func loadSettings(fs *flag.FlagSet, labels labelFlag) error {
+	if err := applyEnv(fs, os.Getenv); err != nil {
+		return err
+	}
+	configPath := fs.Lookup("config").Value.String()
+	if configPath == "" {
+		return nil
+	}
+	return applyConfigFile(fs, configPath, labels)
+}
+
+// applyEnv sets every flag of fs whose variable (see envName) getenv returns
+// a value for, and -tcp or -udp from MTR_PROTOCOL, unless the flag was given
+// on the command line
+func applyEnv(fs *flag.FlagSet, getenv func(string) string) error {
+	given := givenFlags(fs)
+	values := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		if value := getenv(envName(f.Name)); value != "" {
+			values[f.Name] = value
+		}
+	})
+	if err := setProtocol(values, getenv("MTR_PROTOCOL"), given); err != nil {
+		return fmt.Errorf("MTR_PROTOCOL: %v", err)
+	}
+
+	for name, value := range values {
+		if given[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, envName(name), err)
+		}
+	}
+	return nil
+}
+
+// givenFlags returns the names of the flags of fs set so far, on the
+// command line or by applyEnv
+func givenFlags(fs *flag.FlagSet) map[string]bool {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	return given
+}
+
+// setProtocol adds the -tcp and -udp values selecting protocol to values,
+// unless either flag is given
+func setProtocol(values map[string]string, protocol string, given map[string]bool) error {
+	if given["tcp"] || given["udp"] {
+		return nil
+	}
+	switch protocol {
+	case "":
+	case "icmp", "tcp", "udp":
+		values["tcp"] = strconv.FormatBool(protocol == "tcp")
+		values["udp"] = strconv.FormatBool(protocol == "udp")
+	default:
+		return fmt.Errorf("invalid protocol %q (must be icmp, tcp or udp)", protocol)
+	}
+	return nil
+}
+
+// fileConfig is the YAML file read with -config. Every setting is optional
+// and only applies when its flag is not given on the command line or in the
+// environment.
+type fileConfig struct {
+	Port        string            `yaml:"port"`
+	Host        stringList        `yaml:"host"`
+	Count       *int              `yaml:"count"`
+	Report      *bool             `yaml:"report"`
+	Resolve     *bool             `yaml:"resolve"`
+	Interval    string            `yaml:"interval"`
+	Protocol    string            `yaml:"protocol"`
+	ProbePort   *int              `yaml:"probe_port"`
+	PacketSize  *int              `yaml:"psize"`
+	IPv4        *bool             `yaml:"ipv4"`
+	IPv6        *bool             `yaml:"ipv6"`
+	Interface   string            `yaml:"interface"`
+	Format      string            `yaml:"format"`
+	FailLoss    *float64          `yaml:"fail_loss"`
+	FailLatency *float64          `yaml:"fail_latency"`
+	NoSudo      *bool             `yaml:"no_sudo"`
+	DenyPrivate *bool             `yaml:"deny_private"`
+	Allow       stringList        `yaml:"allow"`
+	Deny        stringList        `yaml:"deny"`
+	Labels      map[string]string `yaml:"labels"`
+}
+
+// stringList is a YAML list of strings that may also be written as a
+// single string
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*l = stringList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// loadConfigFile reads a -config file, rejecting unknown keys so typos do
+// not go unnoticed
+func loadConfigFile(path string) (fileConfig, error) {
+	var cfg fileConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty file decodes to io.EOF and configures nothing
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// applyConfigFile sets every flag of fs the -config file at path
+// configures, unless it was given on the command line or set by applyEnv.
+// File labels are added to labels unless a -label flag sets the same key.
+func applyConfigFile(fs *flag.FlagSet, path string, labels labelFlag) error {
+	cfg, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+
+	given := givenFlags(fs)
+	values := make(map[string]string)
+	setInt := func(name string, v *int) {
+		if v != nil {
+			values[name] = strconv.Itoa(*v)
+		}
+	}
+	setBool := func(name string, v *bool) {
+		if v != nil {
+			values[name] = strconv.FormatBool(*v)
+		}
+	}
+	setFloat := func(name string, v *float64) {
+		if v != nil {
+			values[name] = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	}
+	setString := func(name, v string) {
+		if v != "" {
+			values[name] = v
+		}
+	}
+
+	setString("port", cfg.Port)
+	if len(cfg.Host) > 0 {
+		values["host"] = strings.Join(cfg.Host, ",")
+	}
+	setInt("count", cfg.Count)
+	setBool("report", cfg.Report)
+	setBool("resolve", cfg.Resolve)
+	setString("interval", cfg.Interval)
+	setInt("probe-port", cfg.ProbePort)
+	setInt("psize", cfg.PacketSize)
+	setBool("4", cfg.IPv4)
+	setBool("6", cfg.IPv6)
+	setString("interface", cfg.Interface)
+	setString("format", cfg.Format)
+	setFloat("fail-loss", cfg.FailLoss)
+	setFloat("fail-latency", cfg.FailLatency)
+	setBool("no-sudo", cfg.NoSudo)
+	setBool("deny-private", cfg.DenyPrivate)
+	if len(cfg.Allow) > 0 {
+		values["allow"] = strings.Join(cfg.Allow, ",")
+	}
+	if len(cfg.Deny) > 0 {
+		values["deny"] = strings.Join(cfg.Deny, ",")
+	}
+
+	if err := setProtocol(values, cfg.Protocol, given); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	for name, value := range values {
+		if given[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("config file %s: invalid value %q for -%s: %v", path, value, name, err)
+		}
+	}
+	for key, value := range cfg.Labels {
+		if _, ok := labels[key]; !ok {
+			labels[key] = value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testFlags returns a flag set with the flags the tests resolve, parsed
+// from args
+func testFlags(t *testing.T, args ...string) *flag.FlagSet {
+	t.Helper()
+	fs := flag.NewFlagSet("mtr-tool", flag.ContinueOnError)
+	fs.String("port", "8080", "")
+	fs.Int("count", 20, "")
+	fs.Int("probe-port", 0, "")
+	fs.Bool("tcp", false, "")
+	fs.Bool("udp", false, "")
+	fs.String("format", "table", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return fs
+}
+
+// writeConfig writes a -config file with the given YAML
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mtr-tool.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// resolve applies env and then the config file to fs, as loadSettings does
+func resolve(t *testing.T, fs *flag.FlagSet, env map[string]string, configPath string) {
+	t.Helper()
+	getenv := func(name string) string { return env[name] }
+	if err := applyEnv(fs, getenv); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfigFile(fs, configPath, labelFlag{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSettingsPrecedence(t *testing.T) {
+	file := writeConfig(t, "count: 15\nformat: json\n")
+	env := map[string]string{"MTR_COUNT": "10"}
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{"command line over env and file", []string{"-count=5"}, env, "5"},
+		{"env over file", nil, env, "10"},
+		{"file over default", nil, nil, "15"},
+	}
+	for _, tt := range tests {
+		fs := testFlags(t, tt.args...)
+		resolve(t, fs, tt.env, file)
+		if got := fs.Lookup("count").Value.String(); got != tt.want {
+			t.Errorf("%s: count = %s, want %s", tt.name, got, tt.want)
+		}
+		// Settings only the file has still apply
+		if got := fs.Lookup("format").Value.String(); got != "json" {
+			t.Errorf("%s: format = %s, want json", tt.name, got)
+		}
+	}
+
+	fs := testFlags(t)
+	resolve(t, fs, nil, writeConfig(t, ""))
+	if got := fs.Lookup("count").Value.String(); got != "20" {
+		t.Errorf("count = %s, want the default 20", got)
+	}
+}
+
+func TestSettingsPorts(t *testing.T) {
+	// port is the server's port in the file as in MTR_PORT; probe_port is
+	// -probe-port
+	fs := testFlags(t)
+	resolve(t, fs, nil, writeConfig(t, "port: 9090\nprobe_port: 443\n"))
+	if got := fs.Lookup("port").Value.String(); got != "9090" {
+		t.Errorf("port = %s, want 9090", got)
+	}
+	if got := fs.Lookup("probe-port").Value.String(); got != "443" {
+		t.Errorf("probe-port = %s, want 443", got)
+	}
+
+	fs = testFlags(t)
+	resolve(t, fs, map[string]string{"MTR_PORT": "9191", "MTR_PROBE_PORT": "80"}, writeConfig(t, "port: 9090\nprobe_port: 443\n"))
+	if got := fs.Lookup("port").Value.String(); got != "9191" {
+		t.Errorf("port = %s, want MTR_PORT's 9191", got)
+	}
+	if got := fs.Lookup("probe-port").Value.String(); got != "80" {
+		t.Errorf("probe-port = %s, want MTR_PROBE_PORT's 80", got)
+	}
+}
+
+func TestSettingsProtocol(t *testing.T) {
+	// MTR_PROTOCOL selects the protocol over the file's
+	fs := testFlags(t)
+	resolve(t, fs, map[string]string{"MTR_PROTOCOL": "udp"}, writeConfig(t, "protocol: tcp\n"))
+	if fs.Lookup("udp").Value.String() != "true" || fs.Lookup("tcp").Value.String() != "false" {
+		t.Errorf("tcp=%s udp=%s, want MTR_PROTOCOL's udp", fs.Lookup("tcp").Value, fs.Lookup("udp").Value)
+	}
+
+	// and -tcp on the command line over both
+	fs = testFlags(t, "-tcp")
+	resolve(t, fs, map[string]string{"MTR_PROTOCOL": "udp"}, writeConfig(t, "protocol: udp\n"))
+	if fs.Lookup("tcp").Value.String() != "true" || fs.Lookup("udp").Value.String() != "false" {
+		t.Errorf("tcp=%s udp=%s, want -tcp", fs.Lookup("tcp").Value, fs.Lookup("udp").Value)
+	}
+
+	if err := applyEnv(testFlags(t), func(name string) string {
+		if name == "MTR_PROTOCOL" {
+			return "sctp"
+		}
+		return ""
+	}); err == nil {
+		t.Error("invalid MTR_PROTOCOL accepted")
+	}
+}
+
+func TestEnvSetsEveryFlag(t *testing.T) {
+	fs := testFlags(t)
+	fs.Bool("wide", false, "")
+	fs.Duration("kill-grace", 0, "")
+	env := map[string]string{"MTR_WIDE": "true", "MTR_KILL_GRACE": "3s", "MTR_PROBE_PORT": "53"}
+	if err := applyEnv(fs, func(name string) string { return env[name] }); err != nil {
+		t.Fatal(err)
+	}
+	for flagName, want := range map[string]string{"wide": "true", "kill-grace": "3s", "probe-port": "53"} {
+		if got := fs.Lookup(flagName).Value.String(); got != want {
+			t.Errorf("-%s = %s, want %s", flagName, got, want)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	fs := testFlags(t)
+	fs.String("config", "", "")
+	t.Setenv("MTR_CONFIG", writeConfig(t, "count: 15\n"))
+	if err := loadSettings(fs, labelFlag{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fs.Lookup("count").Value.String(); got != "15" {
+		t.Errorf("count = %s, want 15 from the file named by MTR_CONFIG", got)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// unauthenticatedPaths are served without a token or signature so health
+// probes keep working
+var unauthenticatedPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// RequireToken wraps the router so every request except the health probes
+// must carry "Authorization: Bearer <token>" when Options.AuthToken is set,
+// and is refused with 401 otherwise. A validly signed URL (see URLSecret)
+// stands in for the token, so signed links can still be handed out.
+func (h *Handler) RequireToken(next http.Handler) http.Handler {
+	if h.opts.AuthToken == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] || h.hasToken(r) || h.isSigned(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mtr-tool"`)
+		respondWithError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+	})
+}
+
+// RequireSignature wraps the router so every request except the health
+// probes must be a GET with a URL signed with Options.URLSecret when it is
+// set, and is refused with 403 otherwise. This covers /recent, /history and
+// /metrics as well as the trace endpoints.
+func (h *Handler) RequireSignature(next http.Handler) http.Handler {
+	if h.opts.URLSecret == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unauthenticatedPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet {
+			respondWithError(w, http.StatusForbidden, "only signed GET requests are accepted")
+			return
+		}
+		if err := verifySignature(h.opts.URLSecret, r.URL.Path, r.URL.Query(), time.Now()); err != nil {
+			respondWithError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hasToken reports whether the request carries the configured bearer token
+func (h *Handler) hasToken(r *http.Request) bool {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(h.opts.AuthToken)) == 1
+}
+
+// isSigned reports whether the request is a GET with a valid signed URL
+func (h *Handler) isSigned(r *http.Request) bool {
+	return h.opts.URLSecret != "" && r.Method == http.MethodGet &&
+		verifySignature(h.opts.URLSecret, r.URL.Path, r.URL.Query(), time.Now()) == nil
+}
@@ -3,13 +3,22 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/kluwer/mtr-tool/internal/audit"
+	"github.com/kluwer/mtr-tool/internal/cache"
+	"github.com/kluwer/mtr-tool/internal/canary"
 	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/kluwer/mtr-tool/internal/sink"
+	"github.com/kluwer/mtr-tool/internal/tracedb"
 	"github.com/rs/zerolog/log"
 )
 
@@ -18,82 +27,871 @@ type MTRResponse struct {
 	Message string `json:"message"`
 }
 
-func HandleMTR(w http.ResponseWriter, r *http.Request) {
-	// Extract and validate parameters
-	hostname := r.URL.Query().Get("hostname")
-	if hostname == "" {
-		respondWithError(w, http.StatusBadRequest, "hostname parameter is required")
-		return
-	}
+// Options holds server-wide settings applied to every trace
+type Options struct {
+	// UnknownHostLabel, when set, replaces "???" for hops with no IP or name
+	UnknownHostLabel *string
 
-	// Validate hostname format
-	if strings.ContainsAny(hostname, ";&|") {
-		respondWithError(w, http.StatusBadRequest, "invalid hostname format")
-		return
-	}
+	// AllowedCounts restricts the count parameter to these exact values when set
+	AllowedCounts []int
 
-	count := 20 // default value
-	if countStr := r.URL.Query().Get("count"); countStr != "" {
-		var err error
-		count, err = strconv.Atoi(countStr)
-		if err != nil || count <= 0 {
-			respondWithError(w, http.StatusBadRequest, "invalid count parameter")
-			return
-		}
-		if count > 100 {
-			respondWithError(w, http.StatusBadRequest, "count cannot exceed 100")
-			return
-		}
+	// Sinks receive every completed trace result
+	Sinks []sink.Sink
+
+	// Cache, when set, stores completed results so repeated synchronous
+	// requests can be answered without running mtr again
+	Cache *cache.Cache
+
+	// Canary, when set, gates /readyz on periodic canary traces succeeding
+	Canary *canary.Monitor
+
+	// Nice and IONiceClass set the scheduling priority of every trace
+	Nice        int
+	IONiceClass string
+
+	// Enrichers annotate the hops of every trace
+	Enrichers []mtr.Enricher
+
+	// IPOnly rejects hostname targets and disables all DNS lookups
+	IPOnly bool
+
+	// DenyPrivate refuses targets in private and other reserved ranges
+	// with 403, so clients cannot probe the server's internal network
+	DenyPrivate bool
+
+	// Allow, when not empty, limits requests to the targets on it; Deny
+	// refuses the targets on it. Refused requests get 403.
+	Allow mtr.TargetList
+	Deny  mtr.TargetList
+
+	// NoSudo runs mtr directly instead of through sudo
+	NoSudo bool
+
+	// NativeJSON takes hop statistics from mtr's own --json report
+	NativeJSON bool
+
+	// NoColor renders every table without color codes
+	NoColor bool
+
+	// TraceTimeout bounds how long a single trace may run (default:
+	// DefaultTraceTimeout)
+	TraceTimeout time.Duration
+
+	// Retries is how often a transiently failed trace is run again when the
+	// request does not set retries
+	Retries int
+
+	// KillGrace is how long a cancelled mtr may take to exit after SIGTERM
+	KillGrace time.Duration
+
+	// History, when set, compares every trace with the target's past traces
+	History mtr.History
+
+	// HopClasses sets the thresholds hops are classified by
+	HopClasses mtr.HopClassThresholds
+
+	// URLSecret, when set, limits every endpoint except the health probes
+	// to GET requests with a URL signed with this secret (see SignURL and
+	// RequireSignature)
+	URLSecret string
+
+	// AuthToken, when set, is the bearer token RequireToken demands
+	AuthToken string
+
+	// RateLimiter, when set, limits how often each client IP may request
+	// a trace
+	RateLimiter *RateLimiter
+
+	// TraceDB, when set, records every completed trace (as one of Sinks)
+	// and serves them on /history
+	TraceDB *tracedb.DB
+
+	// AuditLog, when set, records every accepted trace request. Requests
+	// are refused when they cannot be recorded.
+	AuditLog *audit.Log
+}
+
+// Handler serves the MTR API using the configured options
+type Handler struct {
+	opts Options
+
+	// background is the context of asynchronous traces, which outlive
+	// their request; Close cancels it and waits for them in running
+	background context.Context
+	stop       context.CancelFunc
+	running    sync.WaitGroup
+}
+
+// NewHandler creates a Handler with the given options
+func NewHandler(opts Options) *Handler {
+	background, stop := context.WithCancel(context.Background())
+	return &Handler{opts: opts, background: background, stop: stop}
+}
+
+// Close cancels the asynchronous traces still running and waits for them to
+// end, so their mtr processes do not outlive the server
+func (h *Handler) Close() {
+	h.stop()
+	h.running.Wait()
+}
+
+// clientGone reports whether the client of r disconnected, which cancels
+// the trace it requested. There is no one left to respond to and the trace
+// did not fail, so it is neither reported nor recorded.
+func clientGone(r *http.Request) bool {
+	if !errors.Is(r.Context().Err(), context.Canceled) {
+		return false
 	}
+	log.Info().Str("path", r.URL.Path).Msg("Client disconnected, MTR trace cancelled")
+	return true
+}
 
-	report := false // default value
-	if reportStr := r.URL.Query().Get("report"); reportStr != "" {
-		var err error
-		report, err = strconv.ParseBool(reportStr)
-		if err != nil {
-			respondWithError(w, http.StatusBadRequest, "invalid report parameter")
-			return
-		}
+// DefaultTraceTimeout bounds how long a single trace may run unless
+// Options.TraceTimeout is set
+const DefaultTraceTimeout = 5 * time.Minute
+
+// traceTimeout returns how long a single trace may run
+func (h *Handler) traceTimeout() time.Duration {
+	if h.opts.TraceTimeout > 0 {
+		return h.opts.TraceTimeout
 	}
+	return DefaultTraceTimeout
+}
 
-	// Create MTR configuration
-	cfg := mtr.Config{
-		Hostname: hostname,
-		Count:    count,
-		Report:   report,
+// TraceResponse is the body of a synchronous /mtr request: the parsed
+// result, with its hops, or the error that stopped the trace
+type TraceResponse struct {
+	Status string      `json:"status"`
+	Target string      `json:"target"`
+	Error  string      `json:"error,omitempty"`
+	Result *mtr.Result `json:"result,omitempty"`
+}
+
+// HandleMTR starts a trace in the background and responds that it was
+// accepted; the result goes to the console and the sinks. With sync=true it
+// waits for the trace and responds with the result instead.
+func (h *Handler) HandleMTR(w http.ResponseWriter, r *http.Request) {
+	if !h.rateLimit(w, r) {
+		return
+	}
+	req, cfg, ok := h.parseConfig(w, r)
+	if !ok || !h.audit(w, r, cfg) {
+		return
+	}
+	if req.Sync {
+		h.runSync(w, r, cfg)
+		return
 	}
 
 	// Respond immediately that the request is being processed
 	response := MTRResponse{
 		Status:  "accepted",
-		Message: fmt.Sprintf("MTR trace to %s started (count=%d, report=%v)", hostname, count, report),
+		Message: fmt.Sprintf("MTR trace to %s started (count=%d, report=%v)", cfg.Hostname, cfg.Count, cfg.Report),
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 
-	// Run MTR command asynchronously
+	// Run MTR command asynchronously. The client already has its response,
+	// so the trace is tied to the server rather than to the request.
+	h.running.Add(1)
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer h.running.Done()
+		ctx, cancel := context.WithTimeout(h.background, h.traceTimeout())
 		defer cancel()
 
 		log.Info().
-			Str("hostname", hostname).
-			Int("count", count).
-			Bool("report", report).
+			Str("hostname", cfg.Hostname).
+			Int("count", cfg.Count).
+			Bool("report", cfg.Report).
+			Fields(labelFields(cfg.Labels)).
 			Msg("Starting MTR trace")
 
 		result, err := mtr.Run(ctx, cfg)
+		if err != nil && h.background.Err() != nil {
+			log.Info().Str("hostname", cfg.Hostname).Msg("MTR trace cancelled by server shutdown")
+			return
+		}
 		if err != nil {
 			log.Error().Err(err).Msg("MTR trace failed")
-			fmt.Printf("\nMTR trace to %s failed: %v\n", hostname, err)
+			fmt.Printf("\nMTR trace to %s failed: %v\n", cfg.Hostname, err)
+			sink.RecordFailureAll(ctx, h.opts.Sinks, cfg.Hostname, cfg.Labels, err)
 			return
 		}
 
+		sink.PublishAll(ctx, h.opts.Sinks, result, cfg)
+		h.storeResult(cfg, result)
+
 		// Print the result to console
-		fmt.Printf("\nMTR trace to %s completed:\n%s\n", hostname, result.Output)
+		fmt.Printf("\nMTR trace to %s completed:\n%s\n", cfg.Hostname, result.Output)
 	}()
 }
 
+// runSync runs the trace for cfg while the client waits and responds with
+// the result as JSON, or as the HTML or Markdown report with format=html or
+// format=markdown
+func (h *Handler) runSync(w http.ResponseWriter, r *http.Request, cfg mtr.Config) {
+	if cached, ok := h.cachedResult(cfg); ok {
+		writeResult(w, cfg, cached)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.traceTimeout())
+	defer cancel()
+
+	log.Info().
+		Str("hostname", cfg.Hostname).
+		Int("count", cfg.Count).
+		Bool("report", cfg.Report).
+		Fields(labelFields(cfg.Labels)).
+		Msg("Starting synchronous MTR trace")
+
+	result, err := mtr.Run(ctx, cfg)
+	if err != nil && clientGone(r) {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("MTR trace failed")
+		sink.RecordFailureAll(ctx, h.opts.Sinks, cfg.Hostname, cfg.Labels, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(traceErrorStatus(ctx, err))
+		json.NewEncoder(w).Encode(TraceResponse{Status: "error", Target: cfg.Hostname, Error: err.Error()})
+		return
+	}
+
+	sink.PublishAll(ctx, h.opts.Sinks, result, cfg)
+	h.storeResult(cfg, result)
+	writeResult(w, cfg, result)
+}
+
+// writeResult responds with a completed trace's result. The reports are
+// rendered from the result rather than taken from its Output, which cached
+// results do not keep.
+func writeResult(w http.ResponseWriter, cfg mtr.Config, res *mtr.Result) {
+	switch cfg.Format {
+	case mtr.FormatHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, mtr.RenderHTML(res, cfg))
+	case mtr.FormatMarkdown:
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		io.WriteString(w, mtr.RenderMarkdown(res, cfg))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TraceResponse{Status: "completed", Target: cfg.Hostname, Result: res})
+	}
+}
+
+// traceErrorStatus maps a failed trace to an HTTP status: 504 when it ran
+// out of time, 422 when the target does not resolve, 403 when it is private
+// or not allowed and 502 when mtr failed
+func traceErrorStatus(ctx context.Context, err error) int {
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case errors.Is(err, mtr.ErrPrivateTarget), errors.Is(err, mtr.ErrTargetNotAllowed):
+		return http.StatusForbidden
+	case strings.HasPrefix(err.Error(), "failed to resolve hostname"):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+// HandleRaw runs the trace synchronously and returns mtr's verbatim --raw
+// output as text/plain, for clients that parse mtr's format themselves
+func (h *Handler) HandleRaw(w http.ResponseWriter, r *http.Request) {
+	if !h.rateLimit(w, r) {
+		return
+	}
+	_, cfg, ok := h.parseConfig(w, r)
+	if !ok || !h.audit(w, r, cfg) {
+		return
+	}
+	cfg.Report = true // raw records are only produced in report mode
+	cfg.RawOnly = true
+
+	if cached, ok := h.cachedResult(cfg); ok {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, cached.RawOutput)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.traceTimeout())
+	defer cancel()
+
+	log.Info().
+		Str("hostname", cfg.Hostname).
+		Int("count", cfg.Count).
+		Msg("Starting raw MTR trace")
+
+	result, err := mtr.Run(ctx, cfg)
+	if err != nil && clientGone(r) {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Raw MTR trace failed")
+		respondWithError(w, traceErrorStatus(ctx, err), err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, result.RawOutput)
+}
+
+// ReadinessResponse reports whether the server can run traces
+type ReadinessResponse struct {
+	Status string         `json:"status"`
+	Error  string         `json:"error,omitempty"`
+	Canary *canary.Status `json:"canary,omitempty"`
+}
+
+// HandleHealthz reports that the process is alive
+func (h *Handler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MTRResponse{Status: "ok", Message: "alive"})
+}
+
+// HandleReadyz reports whether traces can run: the mtr binary must be
+// present and, when a canary is configured, its recent traces must succeed
+func (h *Handler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	resp := ReadinessResponse{Status: "ready"}
+	code := http.StatusOK
+	if err := mtr.CheckBinary(); err != nil {
+		resp.Status, resp.Error = "unready", err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	if h.opts.Canary != nil {
+		status := h.opts.Canary.Status()
+		resp.Canary = &status
+		if !status.Ready && code == http.StatusOK {
+			resp.Status, resp.Error = "unready", "canary trace failing: "+status.LastError
+			code = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// defaultRecentLimit is how many targets /recent lists without a limit param
+const defaultRecentLimit = 20
+
+// RecentResponse lists the most recently traced targets, newest first
+type RecentResponse struct {
+	Recent []cache.Recent `json:"recent"`
+}
+
+// HandleRecent lists the most recently traced targets with the outcome of
+// their latest trace, as held in the result cache
+func (h *Handler) HandleRecent(w http.ResponseWriter, r *http.Request) {
+	if h.opts.Cache == nil {
+		respondWithError(w, http.StatusNotFound, "recent traces require the result cache (-cache-ttl)")
+		return
+	}
+	limit := defaultRecentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit: must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecentResponse{Recent: h.opts.Cache.Recent(limit)})
+}
+
+// HistoryResponse lists the recorded runs of a target, newest first
+type HistoryResponse struct {
+	Target string        `json:"target"`
+	Runs   []tracedb.Run `json:"runs"`
+}
+
+// HandleHistory lists the most recent runs of the hostname param recorded
+// in the trace database, with their hops
+func (h *Handler) HandleHistory(w http.ResponseWriter, r *http.Request) {
+	if h.opts.TraceDB == nil {
+		respondWithError(w, http.StatusNotFound, "trace history requires the trace database (-db)")
+		return
+	}
+	target := r.URL.Query().Get("hostname")
+	if target == "" {
+		respondWithError(w, http.StatusBadRequest, "hostname is required")
+		return
+	}
+	limit := defaultRecentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			respondWithError(w, http.StatusBadRequest, "invalid limit: must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	runs, err := h.opts.TraceDB.Recent(r.Context(), target, limit)
+	if err != nil {
+		log.Error().Err(err).Str("hostname", target).Msg("Failed to read trace history")
+		respondWithError(w, http.StatusInternalServerError, "failed to read trace history")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HistoryResponse{Target: target, Runs: runs})
+}
+
+// audit records the request in the audit log, if one is configured. When
+// that fails it responds with an error and returns false.
+func (h *Handler) audit(w http.ResponseWriter, r *http.Request, cfg mtr.Config) bool {
+	if h.opts.AuditLog == nil {
+		return true
+	}
+	err := h.opts.AuditLog.Record(audit.Entry{
+		Time:     time.Now().UTC(),
+		Client:   clientIP(r),
+		Endpoint: r.URL.Path,
+		Target:   cfg.Hostname,
+		Count:    cfg.Count,
+		Labels:   cfg.Labels,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write audit log")
+		respondWithError(w, http.StatusInternalServerError, "request could not be audited")
+		return false
+	}
+	return true
+}
+
+// cacheKey identifies traces whose results are interchangeable: the same
+// target probed the same way, cut short and judged and labelled the same
+// way, with or without metadata. Display and streaming settings are left
+// out, since results are rendered again for each request.
+func cacheKey(cfg mtr.Config) string {
+	protocol := "icmp"
+	switch {
+	case cfg.TCP:
+		protocol = "tcp"
+	case cfg.UDP, len(cfg.VaryPorts) > 0:
+		protocol = "udp"
+	}
+	family := "any"
+	switch {
+	case cfg.IPv4:
+		family = "ipv4"
+	case cfg.IPv6:
+		family = "ipv6"
+	}
+	return fmt.Sprintf("target=%q cidr_pick=%q count=%d interval=%s protocol=%s port=%d vary_ports=%v psize=%d family=%s interface=%q "+
+		"report=%t resolve=%t first_hop_only=%t abort_latency=%g destination_hop=%d destination_loss_only=%t ignore_loss_before_hop=%d "+
+		"no_meta=%t labels=%v",
+		cfg.Hostname, cfg.CIDRPick, cfg.Count, cfg.Interval, protocol, cfg.Port, cfg.VaryPorts, cfg.PacketSize, family, cfg.Interface,
+		cfg.Report, cfg.Resolve, cfg.FirstHopOnly, cfg.AbortLatency, cfg.DestinationHop, cfg.DestinationLossOnly, cfg.IgnoreLossBeforeHop,
+		cfg.NoMeta, cfg.Labels)
+}
+
+// cachedResult returns a fresh cached result for cfg, if caching is enabled
+func (h *Handler) cachedResult(cfg mtr.Config) (*mtr.Result, bool) {
+	if h.opts.Cache == nil {
+		return nil, false
+	}
+	return h.opts.Cache.Get(cacheKey(cfg))
+}
+
+// storeResult caches a completed result, if caching is enabled
+func (h *Handler) storeResult(cfg mtr.Config, result *mtr.Result) {
+	if h.opts.Cache == nil {
+		return
+	}
+	if err := h.opts.Cache.Put(cacheKey(cfg), result); err != nil {
+		log.Warn().Err(err).Msg("Failed to cache trace result")
+	}
+}
+
+// TraceRequest holds the trace parameters. GET requests pass them as query
+// parameters and POST requests as a JSON body using the same names.
+type TraceRequest struct {
+	Hostname            string            `json:"hostname"`
+	Count               int               `json:"count"`
+	Report              bool              `json:"report"`
+	Resolve             bool              `json:"resolve"`
+	DestinationLossOnly bool              `json:"destination_loss_only"`
+	IgnoreLossBeforeHop int               `json:"ignore_loss_before_hop"`
+	DestinationHop      int               `json:"destination_hop"`
+	Matrix              bool              `json:"matrix"`
+	Explain             bool              `json:"explain"`
+	SummaryLevel        string            `json:"summary_level"`
+	Format              string            `json:"format"`
+	Align               string            `json:"align"`
+	Wide                bool              `json:"wide"`
+	Percentiles         bool              `json:"percentiles"`
+	NoColor             bool              `json:"no_color"`
+	HopBadges           bool              `json:"hop_badges"`
+	NoMeta              bool              `json:"no_meta"`
+	CIDRPick            string            `json:"cidr_pick"`
+	FirstHopOnly        bool              `json:"first_hop_only"`
+	Interval            float64           `json:"interval"`
+	PacketSize          int               `json:"psize"`
+	IPv4                bool              `json:"ipv4"`
+	IPv6                bool              `json:"ipv6"`
+	VaryPort            string            `json:"vary_port"`
+	TCP                 bool              `json:"tcp"`
+	UDP                 bool              `json:"udp"`
+	Port                int               `json:"port"`
+	MaxDisplayHops      int               `json:"max_display_hops"`
+	AbortLatency        float64           `json:"abort_if_latency_exceeds"`
+	Retries             int               `json:"retries"`
+	Labels              map[string]string `json:"labels"`
+	Sync                bool              `json:"sync"`
+	FlushInterval       int               `json:"flush_interval"`
+}
+
+// parseConfig extracts and validates the trace parameters shared by all
+// endpoints and returns them with the configuration built from them. It
+// responds with 400 and returns ok=false on invalid input, or with 403 when
+// the target may not be traced.
+func (h *Handler) parseConfig(w http.ResponseWriter, r *http.Request) (TraceRequest, mtr.Config, bool) {
+	var req TraceRequest
+	var err error
+	if r.Method == http.MethodPost {
+		req, err = readBody(r)
+	} else {
+		req, err = readQuery(r)
+	}
+	if err == nil {
+		var cfg mtr.Config
+		if cfg, err = h.buildConfig(req); err == nil {
+			// Refuse private and unlisted targets before accepting the
+			// request; the trace checks again once it runs
+			if err := mtr.CheckTarget(r.Context(), cfg); err != nil {
+				respondWithError(w, http.StatusForbidden, err.Error())
+				return TraceRequest{}, mtr.Config{}, false
+			}
+			return req, cfg, true
+		}
+	}
+	respondWithError(w, http.StatusBadRequest, err.Error())
+	return TraceRequest{}, mtr.Config{}, false
+}
+
+// readQuery reads a TraceRequest from the URL query parameters
+func readQuery(r *http.Request) (TraceRequest, error) {
+	q := &queryReader{values: r.URL.Query()}
+	req := TraceRequest{
+		Hostname:            q.values.Get("hostname"),
+		Count:               q.positiveInt("count"),
+		Report:              q.bool("report"),
+		Resolve:             q.bool("resolve"),
+		DestinationLossOnly: q.bool("destination_loss_only"),
+		IgnoreLossBeforeHop: q.positiveInt("ignore_loss_before_hop"),
+		DestinationHop:      q.positiveInt("destination_hop"),
+		Matrix:              q.bool("matrix"),
+		Explain:             q.bool("explain"),
+		SummaryLevel:        q.values.Get("summary_level"),
+		Format:              q.values.Get("format"),
+		Align:               q.values.Get("align"),
+		Wide:                q.bool("wide"),
+		Percentiles:         q.bool("percentiles"),
+		NoColor:             q.bool("no_color"),
+		HopBadges:           q.bool("hop_badges"),
+		NoMeta:              q.bool("no_meta"),
+		CIDRPick:            q.values.Get("cidr_pick"),
+		FirstHopOnly:        q.bool("first_hop_only"),
+		Interval:            q.float("interval"),
+		PacketSize:          q.positiveInt("psize"),
+		IPv4:                q.bool("ipv4"),
+		IPv6:                q.bool("ipv6"),
+		VaryPort:            q.values.Get("vary_port"),
+		TCP:                 q.bool("tcp"),
+		UDP:                 q.bool("udp"),
+		Port:                q.positiveInt("port"),
+		MaxDisplayHops:      q.positiveInt("max_display_hops"),
+		AbortLatency:        q.float("abort_if_latency_exceeds"),
+		Retries:             q.positiveInt("retries"),
+		Sync:                q.bool("sync"),
+		FlushInterval:       q.positiveInt("flush_interval"),
+	}
+
+	// Labels are passed as repeated label=key=value parameters
+	for _, label := range q.values["label"] {
+		key, value, found := strings.Cut(label, "=")
+		if !found {
+			return req, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		if req.Labels == nil {
+			req.Labels = make(map[string]string)
+		}
+		req.Labels[key] = value
+	}
+	return req, q.err
+}
+
+// readBody reads a TraceRequest from a JSON request body
+func readBody(r *http.Request) (TraceRequest, error) {
+	var req TraceRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, fmt.Errorf("invalid request body: %v", err)
+	}
+	if req.Count < 0 {
+		return req, fmt.Errorf("invalid count parameter")
+	}
+	if req.AbortLatency < 0 {
+		return req, fmt.Errorf("invalid abort_if_latency_exceeds parameter")
+	}
+	if req.MaxDisplayHops < 0 {
+		return req, fmt.Errorf("invalid max_display_hops parameter")
+	}
+	if req.IgnoreLossBeforeHop < 0 {
+		return req, fmt.Errorf("invalid ignore_loss_before_hop parameter")
+	}
+	if req.DestinationHop < 0 {
+		return req, fmt.Errorf("invalid destination_hop parameter")
+	}
+	if req.PacketSize < 0 {
+		return req, fmt.Errorf("invalid psize parameter")
+	}
+	if req.Retries < 0 {
+		return req, fmt.Errorf("invalid retries parameter")
+	}
+	if req.Interval < 0 {
+		return req, fmt.Errorf("invalid interval parameter")
+	}
+	if req.FlushInterval < 0 {
+		return req, fmt.Errorf("invalid flush_interval parameter")
+	}
+	return req, nil
+}
+
+// buildConfig validates a request and turns it into an MTR configuration
+func (h *Handler) buildConfig(req TraceRequest) (mtr.Config, error) {
+	if req.Hostname == "" {
+		return mtr.Config{}, fmt.Errorf("hostname parameter is required")
+	}
+
+	// Validate hostname format
+	if strings.ContainsAny(req.Hostname, ";&|") {
+		return mtr.Config{}, fmt.Errorf("invalid hostname format")
+	}
+	if err := mtr.ValidateHostname(req.Hostname); err != nil {
+		return mtr.Config{}, err
+	}
+	if h.opts.IPOnly {
+		if err := mtr.ValidateIPLiteral(req.Hostname); err != nil {
+			return mtr.Config{}, err
+		}
+		if req.Resolve {
+			return mtr.Config{}, fmt.Errorf("resolve is not available in IP-only mode")
+		}
+	}
+
+	count := 20 // default value
+	if req.Count != 0 {
+		count = req.Count
+	}
+	if count > 100 {
+		return mtr.Config{}, fmt.Errorf("count cannot exceed 100")
+	}
+	if !h.countAllowed(count) {
+		return mtr.Config{}, fmt.Errorf("count must be one of %s", formatCounts(h.opts.AllowedCounts))
+	}
+
+	if err := mtr.ValidateLabels(req.Labels); err != nil {
+		return mtr.Config{}, err
+	}
+	if err := mtr.ValidateFamily(req.IPv4, req.IPv6); err != nil {
+		return mtr.Config{}, err
+	}
+
+	if err := mtr.ValidatePacketSize(req.PacketSize); err != nil {
+		return mtr.Config{}, err
+	}
+	retries := h.opts.Retries
+	if req.Retries > 0 {
+		if err := mtr.ValidateRetries(req.Retries); err != nil {
+			return mtr.Config{}, err
+		}
+		retries = req.Retries
+	}
+
+	// interval is in seconds, like mtr's -i
+	interval := time.Duration(req.Interval * float64(time.Second))
+	if interval != 0 {
+		if err := mtr.ValidateInterval(interval); err != nil {
+			return mtr.Config{}, err
+		}
+		if limit := h.traceTimeout(); time.Duration(count)*interval > limit {
+			return mtr.Config{}, fmt.Errorf("count %d at an interval of %s exceeds the %s trace time limit", count, interval, limit)
+		}
+	}
+
+	var varyPorts []int
+	if req.VaryPort != "" {
+		var err error
+		if varyPorts, err = mtr.ParsePortRange(req.VaryPort); err != nil {
+			return mtr.Config{}, err
+		}
+	}
+	if err := mtr.ValidateProbeMode(req.TCP, req.UDP, req.Port, req.VaryPort != ""); err != nil {
+		return mtr.Config{}, err
+	}
+
+	level, err := mtr.ParseSummaryLevel(req.SummaryLevel)
+	if err != nil {
+		return mtr.Config{}, err
+	}
+
+	format, err := mtr.ParseFormat(req.Format)
+	if err != nil {
+		return mtr.Config{}, err
+	}
+	if format == mtr.FormatBinary {
+		return mtr.Config{}, fmt.Errorf("binary format is only available in CLI mode")
+	}
+	alignment, err := mtr.ParseAlignment(req.Align)
+	if err != nil {
+		return mtr.Config{}, err
+	}
+	pick, err := mtr.ParseCIDRPick(req.CIDRPick)
+	if err != nil {
+		return mtr.Config{}, err
+	}
+	if mtr.IsCIDR(req.Hostname) {
+		if _, err := mtr.ParseCIDR(req.Hostname); err != nil {
+			return mtr.Config{}, err
+		}
+	}
+
+	// Create MTR configuration
+	cfg := mtr.Config{
+		Hostname: req.Hostname,
+		Count:    count,
+		Report:   req.Report,
+		Resolve:  req.Resolve,
+
+		DestinationLossOnly: req.DestinationLossOnly,
+		IgnoreLossBeforeHop: req.IgnoreLossBeforeHop,
+		DestinationHop:      req.DestinationHop,
+		Matrix:              req.Matrix,
+		Explain:             req.Explain,
+		SummaryLevel:        level,
+		Format:              format,
+		Align:               alignment,
+		Wide:                req.Wide,
+		Percentiles:         req.Percentiles,
+		NoColor:             req.NoColor || h.opts.NoColor,
+		HopBadges:           req.HopBadges,
+		HopClasses:          h.opts.HopClasses,
+		NoMeta:              req.NoMeta,
+		CIDRPick:            pick,
+		FirstHopOnly:        req.FirstHopOnly,
+		Interval:            interval,
+		PacketSize:          req.PacketSize,
+		IPv4:                req.IPv4,
+		IPv6:                req.IPv6,
+		VaryPorts:           varyPorts,
+		TCP:                 req.TCP,
+		UDP:                 req.UDP,
+		Port:                req.Port,
+		MaxDisplayHops:      req.MaxDisplayHops,
+		AbortLatency:        req.AbortLatency,
+		Labels:              req.Labels,
+		UnknownHostLabel:    h.opts.UnknownHostLabel,
+		Nice:                h.opts.Nice,
+		IONiceClass:         h.opts.IONiceClass,
+		Enrichers:           h.opts.Enrichers,
+		IPOnly:              h.opts.IPOnly,
+		DenyPrivate:         h.opts.DenyPrivate,
+		Allow:               h.opts.Allow,
+		Deny:                h.opts.Deny,
+		NoSudo:              h.opts.NoSudo,
+		NativeJSON:          h.opts.NativeJSON,
+		KillGrace:           h.opts.KillGrace,
+		Retries:             retries,
+		History:             h.opts.History,
+	}
+	return cfg, nil
+}
+
+// countAllowed reports whether count is permitted by the AllowedCounts option
+func (h *Handler) countAllowed(count int) bool {
+	if len(h.opts.AllowedCounts) == 0 {
+		return true
+	}
+	for _, allowed := range h.opts.AllowedCounts {
+		if count == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func formatCounts(counts []int) string {
+	strs := make([]string, len(counts))
+	for i, c := range counts {
+		strs[i] = strconv.Itoa(c)
+	}
+	return strings.Join(strs, ", ")
+}
+
+// queryReader reads typed query parameters. Missing parameters read as the
+// zero value; the first malformed one is kept in err.
+type queryReader struct {
+	values url.Values
+	err    error
+}
+
+func (q *queryReader) fail(name string) {
+	if q.err == nil {
+		q.err = fmt.Errorf("invalid %s parameter", name)
+	}
+}
+
+func (q *queryReader) bool(name string) bool {
+	str := q.values.Get(name)
+	if str == "" {
+		return false
+	}
+	value, err := strconv.ParseBool(str)
+	if err != nil {
+		q.fail(name)
+	}
+	return value
+}
+
+// positiveInt reads a whole number greater than zero
+func (q *queryReader) positiveInt(name string) int {
+	str := q.values.Get(name)
+	if str == "" {
+		return 0
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil || value <= 0 {
+		q.fail(name)
+		return 0
+	}
+	return value
+}
+
+// float reads a non-negative number
+func (q *queryReader) float(name string) float64 {
+	str := q.values.Get(name)
+	if str == "" {
+		return 0
+	}
+	value, err := strconv.ParseFloat(str, 64)
+	if err != nil || value < 0 {
+		q.fail(name)
+		return 0
+	}
+	return value
+}
+
+// labelFields converts trace labels into log fields
+func labelFields(labels map[string]string) map[string]interface{} {
+	fields := make(map[string]interface{}, len(labels))
+	for key, value := range labels {
+		fields["label_"+key] = value
+	}
+	return fields
+}
+
 func respondWithError(w http.ResponseWriter, code int, message string) {
 	response := MTRResponse{
 		Status:  "error",
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/cache"
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+func TestCacheKey(t *testing.T) {
+	base := mtr.Config{Hostname: "example.com", Count: 10, Report: true}
+
+	// Settings that change what is probed or how it is judged need their
+	// own cache entries
+	differ := map[string]func(*mtr.Config){
+		"target":          func(c *mtr.Config) { c.Hostname = "example.net" },
+		"count":           func(c *mtr.Config) { c.Count = 20 },
+		"interval":        func(c *mtr.Config) { c.Interval = 2 * time.Second },
+		"tcp":             func(c *mtr.Config) { c.TCP = true },
+		"udp":             func(c *mtr.Config) { c.UDP = true },
+		"port":            func(c *mtr.Config) { c.Port = 443 },
+		"psize":           func(c *mtr.Config) { c.PacketSize = 1400 },
+		"ipv4":            func(c *mtr.Config) { c.IPv4 = true },
+		"ipv6":            func(c *mtr.Config) { c.IPv6 = true },
+		"interface":       func(c *mtr.Config) { c.Interface = "eth1" },
+		"destination hop": func(c *mtr.Config) { c.DestinationHop = 3 },
+		"labels":          func(c *mtr.Config) { c.Labels = map[string]string{"site": "ams"} },
+		"abort latency":   func(c *mtr.Config) { c.AbortLatency = 100 },
+		"no meta":         func(c *mtr.Config) { c.NoMeta = true },
+	}
+	for name, change := range differ {
+		cfg := base
+		change(&cfg)
+		if cacheKey(cfg) == cacheKey(base) {
+			t.Errorf("%s: key unchanged: %s", name, cacheKey(cfg))
+		}
+	}
+
+	// Display settings do not, since results are rendered per request
+	same := map[string]func(*mtr.Config){
+		"format":   func(c *mtr.Config) { c.Format = mtr.FormatJSON },
+		"matrix":   func(c *mtr.Config) { c.Matrix = true },
+		"wide":     func(c *mtr.Config) { c.Wide = true },
+		"raw only": func(c *mtr.Config) { c.RawOnly = true },
+		"progress": func(c *mtr.Config) { c.Progress = func(mtr.HopData) {} },
+	}
+	for name, change := range same {
+		cfg := base
+		change(&cfg)
+		if cacheKey(cfg) != cacheKey(base) {
+			t.Errorf("%s: key changed from %s to %s", name, cacheKey(base), cacheKey(cfg))
+		}
+	}
+}
+
+func TestCacheAbortLatency(t *testing.T) {
+	h := NewHandler(Options{Cache: cache.New(time.Hour, "")})
+	bounded, err := h.buildConfig(TraceRequest{Hostname: "192.0.2.1", AbortLatency: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := h.buildConfig(TraceRequest{Hostname: "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A trace cut short by the bound is not served as a full one, nor the
+	// reverse
+	h.storeResult(bounded, &mtr.Result{Target: "192.0.2.1", Aborted: true})
+	if _, ok := h.cachedResult(full); ok {
+		t.Error("aborted trace served without the bound")
+	}
+	h = NewHandler(Options{Cache: cache.New(time.Hour, "")})
+	h.storeResult(full, &mtr.Result{Target: "192.0.2.1"})
+	if _, ok := h.cachedResult(bounded); ok {
+		t.Error("full trace served with the bound")
+	}
+	if _, ok := h.cachedResult(full); !ok {
+		t.Error("full trace not served again")
+	}
+}
+
+func TestTraceErrorStatus(t *testing.T) {
+	timedOut, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want int
+	}{
+		{"timed out", timedOut, errors.New("mtr error: signal: terminated"), http.StatusGatewayTimeout},
+		{"private", context.Background(), fmt.Errorf("target 10.0.0.1 is in a %w", mtr.ErrPrivateTarget), http.StatusForbidden},
+		{"not allowed", context.Background(), fmt.Errorf("%w: example.com", mtr.ErrTargetNotAllowed), http.StatusForbidden},
+		{"unresolved", context.Background(), errors.New("failed to resolve hostname: example.invalid"), http.StatusUnprocessableEntity},
+		{"mtr failed", context.Background(), errors.New("mtr error: exit status 1"), http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if got := traceErrorStatus(tt.ctx, tt.err); got != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandleRawTimeout(t *testing.T) {
+	h := NewHandler(Options{TraceTimeout: time.Nanosecond, NoSudo: true})
+	rec := httptest.NewRecorder()
+	h.HandleRaw(rec, httptest.NewRequest(http.MethodGet, "/mtr/raw?hostname=192.0.2.1", nil))
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("status %d, want 504: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAllowedCounts(t *testing.T) {
+	h := NewHandler(Options{AllowedCounts: []int{10, 50}})
+	for _, count := range []int{10, 50} {
+		if _, err := h.buildConfig(TraceRequest{Hostname: "example.com", Count: count}); err != nil {
+			t.Errorf("count %d refused: %v", count, err)
+		}
+	}
+	for _, count := range []int{0, 20, 49, 101} {
+		if _, err := h.buildConfig(TraceRequest{Hostname: "example.com", Count: count}); err == nil {
+			t.Errorf("count %d accepted", count)
+		}
+	}
+	_, err := h.buildConfig(TraceRequest{Hostname: "example.com", Count: 20})
+	if err == nil || err.Error() != "count must be one of 10, 50" {
+		t.Errorf("error %v, want the allowed counts listed", err)
+	}
+
+	// Without a list every count up to 100 is accepted
+	h = NewHandler(Options{})
+	if _, err := h.buildConfig(TraceRequest{Hostname: "example.com", Count: 37}); err != nil {
+		t.Errorf("count 37 refused without a list: %v", err)
+	}
+}
+
+func TestHandleRawVerbatim(t *testing.T) {
+	output := "h 0 10.0.0.1\nx 0 1\np 0 1234 1\nd 0 gw.example.net\nh 1 192.0.2.1\nx 1 2\np 1 10250 2\n"
+	fakeMTR(t, output)
+
+	h := NewHandler(Options{NoSudo: true})
+	rec := httptest.NewRecorder()
+	h.HandleRaw(rec, httptest.NewRequest(http.MethodGet, "/mtr/raw?hostname=192.0.2.1&count=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+		t.Errorf("content type %q", ct)
+	}
+	if rec.Body.String() != output {
+		t.Errorf("body %q, want mtr's output %q", rec.Body.String(), output)
+	}
+}
+
+func TestIPOnlyRequests(t *testing.T) {
+	h := NewHandler(Options{IPOnly: true})
+	for _, target := range []string{"192.0.2.1", "2001:db8::1"} {
+		cfg, err := h.buildConfig(TraceRequest{Hostname: target})
+		if err != nil {
+			t.Errorf("%s refused: %v", target, err)
+			continue
+		}
+		if !cfg.IPOnly {
+			t.Errorf("%s: trace not in IP-only mode", target)
+		}
+	}
+	if _, err := h.buildConfig(TraceRequest{Hostname: "example.com"}); err == nil {
+		t.Error("hostname accepted")
+	}
+	if _, err := h.buildConfig(TraceRequest{Hostname: "192.0.2.1", Resolve: true}); err == nil {
+		t.Error("resolve accepted")
+	}
+}
+
+// seededCache returns a cache loaded with a trace of each target, the
+// first traced most recently
+func seededCache(t *testing.T, targets ...string) *cache.Cache {
+	t.Helper()
+	var entries []cache.Entry
+	for i, target := range targets {
+		entries = append(entries, cache.Entry{
+			Key:      target,
+			Result:   &mtr.Result{Target: target, Health: mtr.HealthOK, DestinationReached: true},
+			StoredAt: time.Now().Add(-time.Duration(i+1) * time.Minute),
+		})
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := cache.New(time.Hour, file)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestHandleRecent(t *testing.T) {
+	h := NewHandler(Options{Cache: seededCache(t, "c.example.com", "a.example.com", "b.example.com")})
+	recent := func(query string) (int, []string) {
+		rec := httptest.NewRecorder()
+		h.HandleRecent(rec, httptest.NewRequest(http.MethodGet, "/recent"+query, nil))
+		var resp RecentResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		var targets []string
+		for _, r := range resp.Recent {
+			targets = append(targets, r.Target)
+		}
+		return rec.Code, targets
+	}
+
+	if code, targets := recent(""); code != http.StatusOK || strings.Join(targets, ",") != "c.example.com,a.example.com,b.example.com" {
+		t.Errorf("status %d, targets %v; want newest first", code, targets)
+	}
+	if code, targets := recent("?limit=2"); code != http.StatusOK || strings.Join(targets, ",") != "c.example.com,a.example.com" {
+		t.Errorf("limit 2: status %d, targets %v", code, targets)
+	}
+	for _, limit := range []string{"0", "-1", "ten"} {
+		if code, _ := recent("?limit=" + limit); code != http.StatusBadRequest {
+			t.Errorf("limit %s: status %d, want 400", limit, code)
+		}
+	}
+
+	// The default limit applies without a limit param
+	var many []string
+	for i := 0; i < defaultRecentLimit+5; i++ {
+		many = append(many, fmt.Sprintf("t%d.example.com", i))
+	}
+	h = NewHandler(Options{Cache: seededCache(t, many...)})
+	if _, targets := recent(""); len(targets) != defaultRecentLimit {
+		t.Errorf("%d targets listed, want %d", len(targets), defaultRecentLimit)
+	}
+
+	rec := httptest.NewRecorder()
+	NewHandler(Options{}).HandleRecent(rec, httptest.NewRequest(http.MethodGet, "/recent", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("without a cache: status %d, want 404", rec.Code)
+	}
+}
+
+func TestIPv6Request(t *testing.T) {
+	h := NewHandler(Options{})
+	req, err := readQuery(httptest.NewRequest(http.MethodGet, "/mtr?hostname=example.com&ipv6=true", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := h.buildConfig(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.IPv6 || cfg.IPv4 {
+		t.Errorf("ipv4 %v ipv6 %v, want IPv6 forced", cfg.IPv4, cfg.IPv6)
+	}
+	if _, err := h.buildConfig(TraceRequest{Hostname: "example.com", IPv4: true, IPv6: true}); err == nil {
+		t.Error("both families accepted")
+	}
+}
@@ -0,0 +1,46 @@
+package api
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain runs the tests with a fake mtr printing the file named by
+// FAKE_MTR_OUTPUT. The mtr package reads MTR_PATH when it is initialised,
+// so the tests are run again in a process that has it set.
+func TestMain(m *testing.M) {
+	if os.Getenv("MTR_PATH") != "" {
+		os.Exit(m.Run())
+	}
+	dir, err := os.MkdirTemp("", "fake-mtr")
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(dir, "mtr")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\ncat \"$FAKE_MTR_OUTPUT\"\n"), 0o755); err != nil {
+		panic(err)
+	}
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), "MTR_PATH="+path)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Run()
+	os.RemoveAll(dir)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// fakeMTR makes the fake mtr print output for the duration of the test
+func fakeMTR(t *testing.T, output string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(path, []byte(output), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_MTR_OUTPUT", path)
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateUnits are the periods a rate limit can be given per
+var rateUnits = map[string]time.Duration{
+	"s":    time.Second,
+	"sec":  time.Second,
+	"m":    time.Minute,
+	"min":  time.Minute,
+	"h":    time.Hour,
+	"hour": time.Hour,
+}
+
+// limiterIdle is how long a client's limiter is kept after its last request
+const limiterIdle = 10 * time.Minute
+
+// RateLimiter throttles trace requests per client IP with a token bucket:
+// each client may make up to burst requests at once, refilled at limit
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu          sync.Mutex
+	clients     map[string]*clientLimiter
+	lastCleanup time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ParseRateLimit parses a limit such as "5/min" (also per s or h) into a
+// limiter allowing that many requests per period and per client, all of
+// them at once if the client has been idle
+func ParseRateLimit(spec string) (*RateLimiter, error) {
+	count, unit, found := strings.Cut(spec, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	period, ok := rateUnits[strings.TrimSpace(unit)]
+	if !found || err != nil || n < 1 || !ok {
+		return nil, fmt.Errorf("invalid rate %q, expected e.g. 5/min", spec)
+	}
+	return &RateLimiter{
+		limit:   rate.Limit(float64(n) / period.Seconds()),
+		burst:   n,
+		clients: make(map[string]*clientLimiter),
+	}, nil
+}
+
+// allow takes a token for client, or reports how long it has to wait for one
+func (l *RateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget clients that have been idle for a while, at most that often
+	if now.Sub(l.lastCleanup) > limiterIdle {
+		for ip, c := range l.clients {
+			if now.Sub(c.lastSeen) > limiterIdle {
+				delete(l.clients, ip)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	c, ok := l.clients[client]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = c
+	}
+	c.lastSeen = now
+	reservation := c.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// rateLimit applies the rate limiter, if one is configured. When the client
+// is over its limit it responds with 429 and Retry-After and returns false.
+func (h *Handler) rateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if h.opts.RateLimiter == nil {
+		return true
+	}
+	ok, wait := h.opts.RateLimiter.allow(clientIP(r), time.Now())
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, http.StatusTooManyRequests, "rate limit exceeded")
+	}
+	return ok
+}
+
+// clientIP returns the address the request came from, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
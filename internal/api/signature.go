@@ -0,0 +1,66 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// signatureMessage is what a URL's signature covers: the path and every
+// query parameter except sig, including expires, in canonical (sorted) order
+func signatureMessage(path string, query url.Values) string {
+	unsigned := url.Values{}
+	for key, values := range query {
+		if key != "sig" {
+			unsigned[key] = values
+		}
+	}
+	return path + "?" + unsigned.Encode()
+}
+
+// sign returns the hex HMAC-SHA256 of message under secret
+func sign(secret, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignURL adds an expires timestamp and a sig parameter to a path with a
+// query, e.g. "/mtr?hostname=example.com", so a server started with the
+// same secret accepts it until the link expires
+func SignURL(secret, rawURL string, expires time.Time) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+	query := u.Query()
+	query.Del("sig")
+	query.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", sign(secret, signatureMessage(u.Path, query)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+// verifySignature checks that a request's query was signed with secret and
+// has not expired
+func verifySignature(secret, path string, query url.Values, now time.Time) error {
+	sig, expires := query.Get("sig"), query.Get("expires")
+	if sig == "" || expires == "" {
+		return fmt.Errorf("request is not signed")
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expires parameter")
+	}
+	if !hmac.Equal([]byte(sig), []byte(sign(secret, signatureMessage(path, query)))) {
+		return fmt.Errorf("invalid signature")
+	}
+	if now.Unix() > unix {
+		return fmt.Errorf("signed URL expired")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "s3cret"
+
+func signedQuery(t *testing.T, rawURL string, expires time.Time) (string, url.Values) {
+	t.Helper()
+	signed, err := SignURL(testSecret, rawURL, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Path, u.Query()
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	path, query := signedQuery(t, "/mtr?hostname=example.com&count=10", now.Add(time.Hour))
+	if err := verifySignature(testSecret, path, query, now); err != nil {
+		t.Errorf("valid signature rejected: %v", err)
+	}
+
+	path, query = signedQuery(t, "/mtr?hostname=example.com", now.Add(-time.Second))
+	if err := verifySignature(testSecret, path, query, now); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired signature: got %v", err)
+	}
+
+	tampered := []struct {
+		name   string
+		path   string
+		tamper func(url.Values)
+	}{
+		{"parameter changed", "/mtr", func(q url.Values) { q.Set("hostname", "example.net") }},
+		{"parameter added", "/mtr", func(q url.Values) { q.Set("count", "100") }},
+		{"expiry extended", "/mtr", func(q url.Values) { q.Set("expires", "9999999999") }},
+		{"invalid expiry", "/mtr", func(q url.Values) { q.Set("expires", "soon") }},
+		{"path changed", "/mtr/raw", func(q url.Values) {}},
+		{"sig altered", "/mtr", func(q url.Values) { q.Set("sig", strings.Repeat("0", 64)) }},
+		{"sig missing", "/mtr", func(q url.Values) { q.Del("sig") }},
+		{"other secret", "/mtr", func(q url.Values) { q.Set("sig", sign("other", signatureMessage("/mtr", q))) }},
+	}
+	for _, tt := range tampered {
+		_, query := signedQuery(t, "/mtr?hostname=example.com", now.Add(time.Hour))
+		tt.tamper(query)
+		if err := verifySignature(testSecret, tt.path, query, now); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	h := NewHandler(Options{URLSecret: testSecret})
+	handler := h.RequireSignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	valid := func(path string) string {
+		signed, err := SignURL(testSecret, path, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	expired, err := SignURL(testSecret, "/recent", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   int
+	}{
+		{"health probe", http.MethodGet, "/healthz", http.StatusOK},
+		{"readiness probe", http.MethodGet, "/readyz", http.StatusOK},
+		{"unsigned trace", http.MethodGet, "/mtr?hostname=example.com", http.StatusForbidden},
+		{"unsigned recent", http.MethodGet, "/recent", http.StatusForbidden},
+		{"unsigned history", http.MethodGet, "/history?target=example.com", http.StatusForbidden},
+		{"unsigned metrics", http.MethodGet, "/metrics", http.StatusForbidden},
+		{"signed recent", http.MethodGet, valid("/recent"), http.StatusOK},
+		{"signed metrics", http.MethodGet, valid("/metrics"), http.StatusOK},
+		{"signed trace", http.MethodGet, valid("/mtr?hostname=example.com"), http.StatusOK},
+		{"expired recent", http.MethodGet, expired, http.StatusForbidden},
+		{"tampered history", http.MethodGet, strings.Replace(valid("/history?target=example.com"), "example.com", "example.net", 1), http.StatusForbidden},
+		{"signed POST", http.MethodPost, valid("/mtr"), http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/kluwer/mtr-tool/internal/sink"
+	"github.com/rs/zerolog/log"
+)
+
+// HandleStream runs the trace while the client waits and streams its
+// progress as server-sent events: a "hop" event with a hop's statistics
+// whenever one of its probes is answered, then a final "result" or "error"
+// event with the TraceResponse. By default every hop update is flushed as it
+// happens; flush_interval coalesces them and sends the latest update of each
+// hop every so many milliseconds instead.
+func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request) {
+	if !h.rateLimit(w, r) {
+		return
+	}
+	req, cfg, ok := h.parseConfig(w, r)
+	if !ok || !h.audit(w, r, cfg) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	stream := newEventStream(w, flusher, time.Duration(req.FlushInterval)*time.Millisecond, realClock{})
+	defer stream.close()
+	cfg.Progress = stream.hop
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.traceTimeout())
+	defer cancel()
+
+	log.Info().
+		Str("hostname", cfg.Hostname).
+		Int("count", cfg.Count).
+		Fields(labelFields(cfg.Labels)).
+		Msg("Starting streamed MTR trace")
+
+	result, err := mtr.Run(ctx, cfg)
+	if err != nil && clientGone(r) {
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Streamed MTR trace failed")
+		sink.RecordFailureAll(ctx, h.opts.Sinks, cfg.Hostname, cfg.Labels, err)
+		stream.final("error", TraceResponse{Status: "error", Target: cfg.Hostname, Error: err.Error()})
+		return
+	}
+
+	sink.PublishAll(ctx, h.opts.Sinks, result, cfg)
+	h.storeResult(cfg, result)
+	stream.final("result", TraceResponse{Status: "completed", Target: cfg.Hostname, Result: result})
+}
+
+// clock is the source of the batching ticker, replaceable for tests
+type clock interface {
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// eventStream writes server-sent events. With an interval it keeps only the
+// latest update of each hop and writes the pending ones on every tick.
+type eventStream struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+	pending map[int]mtr.HopData // nil when every update is written at once
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newEventStream(w http.ResponseWriter, flusher http.Flusher, interval time.Duration, c clock) *eventStream {
+	s := &eventStream{w: w, flusher: flusher, done: make(chan struct{})}
+	if interval <= 0 {
+		return s
+	}
+	s.pending = make(map[int]mtr.HopData)
+	ticks, stop := c.NewTicker(interval)
+	s.stopped.Add(1)
+	go func() {
+		defer s.stopped.Done()
+		defer stop()
+		for {
+			select {
+			case <-ticks:
+				s.mu.Lock()
+				if s.writePending() {
+					s.flusher.Flush()
+				}
+				s.mu.Unlock()
+			case <-s.done:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// hop queues or writes an update of one hop
+func (s *eventStream) hop(hop mtr.HopData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending != nil {
+		s.pending[hop.Hop] = hop
+		return
+	}
+	s.write("hop", hop)
+	s.flusher.Flush()
+}
+
+// final writes any pending hop updates and the closing event, and flushes
+// them immediately
+func (s *eventStream) final(event string, data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writePending()
+	s.write(event, data)
+	s.flusher.Flush()
+}
+
+// close stops the batching ticker
+func (s *eventStream) close() {
+	close(s.done)
+	s.stopped.Wait()
+}
+
+// writePending writes the queued hop updates in hop order and reports
+// whether there were any. The caller holds s.mu.
+func (s *eventStream) writePending() bool {
+	if len(s.pending) == 0 {
+		return false
+	}
+	hops := make([]int, 0, len(s.pending))
+	for n := range s.pending {
+		hops = append(hops, n)
+	}
+	sort.Ints(hops)
+	for _, n := range hops {
+		s.write("hop", s.pending[n])
+		delete(s.pending, n)
+	}
+	return true
+}
+
+// write writes one event. The caller holds s.mu.
+func (s *eventStream) write(event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode stream event")
+		return
+	}
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload)
+}
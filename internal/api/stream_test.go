@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// fakeClock hands out a ticker that only ticks when the test says so
+type fakeClock struct {
+	ticks    chan time.Time
+	interval time.Duration
+	stopped  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.interval = d
+	return c.ticks, func() { c.stopped = true }
+}
+
+// tick delivers one tick and waits until the stream has handled it: the
+// ticker channel is unbuffered, so a second send only completes once the
+// stream is back waiting for the next tick
+func (c *fakeClock) tick() {
+	c.ticks <- time.Now()
+	c.ticks <- time.Now()
+}
+
+// countingFlusher counts the flushes of the stream
+type countingFlusher struct{ n int }
+
+func (f *countingFlusher) Flush() { f.n++ }
+
+// hopEvents returns the hop numbers of the hop events written so far, in order
+func hopEvents(body string) []string {
+	var hops []string
+	for _, event := range strings.Split(body, "\n\n") {
+		if !strings.HasPrefix(event, "event: hop\n") {
+			continue
+		}
+		i := strings.Index(event, `"hop":`)
+		hops = append(hops, strings.SplitN(event[i+len(`"hop":`):], ",", 2)[0])
+	}
+	return hops
+}
+
+func TestEventStreamUnbatched(t *testing.T) {
+	rec := httptest.NewRecorder()
+	flusher := &countingFlusher{}
+	c := newFakeClock()
+	s := newEventStream(rec, flusher, 0, c)
+	defer s.close()
+
+	s.hop(mtr.HopData{Hop: 1})
+	s.hop(mtr.HopData{Hop: 2})
+	s.hop(mtr.HopData{Hop: 1})
+
+	if got := strings.Join(hopEvents(rec.Body.String()), ","); got != "1,2,1" {
+		t.Errorf("hop events %s, want 1,2,1", got)
+	}
+	if flusher.n != 3 {
+		t.Errorf("%d flushes, want one per update", flusher.n)
+	}
+	if c.interval != 0 {
+		t.Error("ticker started without a flush interval")
+	}
+}
+
+func TestEventStreamBatched(t *testing.T) {
+	rec := httptest.NewRecorder()
+	flusher := &countingFlusher{}
+	c := newFakeClock()
+	s := newEventStream(rec, flusher, 500*time.Millisecond, c)
+	if c.interval != 500*time.Millisecond {
+		t.Fatalf("ticker interval %s, want 500ms", c.interval)
+	}
+
+	s.hop(mtr.HopData{Hop: 3, Last: 1})
+	s.hop(mtr.HopData{Hop: 1, Last: 1})
+	s.hop(mtr.HopData{Hop: 3, Last: 2})
+	if rec.Body.Len() != 0 || flusher.n != 0 {
+		t.Fatalf("updates written before the tick: %q", rec.Body.String())
+	}
+
+	// A tick writes the latest update of each hop, in hop order
+	c.tick()
+	if got := strings.Join(hopEvents(rec.Body.String()), ","); got != "1,3" {
+		t.Errorf("hop events %s, want 1,3", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"last":2`) {
+		t.Errorf("hop 3 not written with its latest update: %s", rec.Body.String())
+	}
+	if flusher.n != 1 {
+		t.Errorf("%d flushes after the tick, want 1", flusher.n)
+	}
+
+	// Ticks with nothing pending neither write nor flush
+	c.tick()
+	if flusher.n != 1 {
+		t.Errorf("%d flushes after an idle tick, want 1", flusher.n)
+	}
+
+	// The final event flushes pending updates at once, without a tick
+	s.hop(mtr.HopData{Hop: 2})
+	s.final("result", TraceResponse{Status: "completed", Target: "example.com"})
+	body := rec.Body.String()
+	if got := strings.Join(hopEvents(body), ","); got != "1,3,2" {
+		t.Errorf("hop events %s, want 1,3,2", got)
+	}
+	if !strings.HasSuffix(body, "event: result\ndata: {\"status\":\"completed\",\"target\":\"example.com\"}\n\n") {
+		t.Errorf("stream does not end with the result: %q", body)
+	}
+	if flusher.n != 2 {
+		t.Errorf("%d flushes after the final event, want 2", flusher.n)
+	}
+
+	s.close()
+	if !c.stopped {
+		t.Error("ticker not stopped on close")
+	}
+}
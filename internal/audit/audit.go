@@ -0,0 +1,256 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is the layout of the timestamp appended to rotated files
+const rotatedSuffix = "20060102T150405.000000000Z"
+
+// Entry records one trace request: who asked for which target, and when.
+// With a hash chain, PrevHash is the Hash of the entry before it and Hash
+// covers every other field, so altering or removing an entry breaks the chain.
+// With a key Hash is an HMAC, which cannot be recomputed without the key.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Client   string            `json:"client"`
+	Endpoint string            `json:"endpoint"`
+	Target   string            `json:"target"`
+	Count    int               `json:"count"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	PrevHash string            `json:"prev_hash,omitempty"`
+	Hash     string            `json:"hash,omitempty"`
+}
+
+// Log is an append-only audit log of JSON lines, rotated when it reaches a
+// maximum size. Rotated files keep their name with a timestamp suffix; the
+// hash chain continues across them.
+type Log struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	chain   bool
+	key     []byte
+
+	f    *os.File
+	size int64
+	last string // Hash of the last entry written
+}
+
+// Open opens the audit log at path for appending, creating it if needed.
+// maxSize is the size in bytes at which it is rotated (0 never rotates).
+// With chain set, each entry is linked to the previous one by its hash,
+// continuing the chain of an existing file. A key makes the hashes
+// HMAC-SHA256s keyed with it.
+func Open(path string, maxSize int64, chain bool, key []byte) (*Log, error) {
+	l := &Log{path: path, maxSize: maxSize, chain: chain, key: key}
+	if chain {
+		last, err := lastHash(path)
+		if err != nil {
+			return nil, err
+		}
+		l.last = last
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Record appends an entry, rotating the file first if it would exceed the
+// maximum size
+func (l *Log) Record(e Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.chain {
+		e.PrevHash = l.last
+		e.Hash = hashEntry(e, l.key)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("audit: %v", err)
+	}
+	line = append(line, '\n')
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("audit: %v", err)
+	}
+	l.last = e.Hash
+	return nil
+}
+
+// Close closes the audit log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// open opens l.path for appending. The caller must hold l.mu or own l.
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("audit: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("audit: %v", err)
+	}
+	l.f, l.size = f, info.Size()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one. The caller must
+// hold l.mu.
+func (l *Log) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("audit: %v", err)
+	}
+	rotated := l.path + "." + time.Now().UTC().Format(rotatedSuffix)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return fmt.Errorf("audit: %v", err)
+	}
+	return l.open()
+}
+
+// hashEntry returns the hex SHA-256 of the entry's JSON form without its
+// Hash, or its HMAC-SHA256 with a key
+func hashEntry(e Entry, key []byte) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	var h hash.Hash
+	if key != nil {
+		h = hmac.New(sha256.New, key)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// lastHash returns the hash of the last entry in the file at path, or ""
+// when the file does not exist or is empty
+func lastHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("audit: %v", err)
+	}
+	defer f.Close()
+
+	last := ""
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return "", fmt.Errorf("audit: invalid entry in %s: %v", path, err)
+		}
+		last = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("audit: %v", err)
+	}
+	return last, nil
+}
+
+// Verify checks the hash chain of an audit log and returns the number of
+// entries and the hash of the last one. The first entry must link to prev:
+// "" at the start of a log, or the last hash of the rotated file before it,
+// so entries removed from the head are noticed as well. Every later one must
+// link to the entry before it, and each must match its own hash, made with
+// key when the log was written with one.
+func Verify(r io.Reader, prev string, key []byte) (int, string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return n, prev, fmt.Errorf("entry %d: invalid JSON: %v", n, err)
+		}
+		if e.Hash == "" {
+			return n, prev, fmt.Errorf("entry %d: no hash (the log was written without a hash chain)", n)
+		}
+		switch {
+		case e.PrevHash == prev:
+		case n == 1 && prev == "":
+			return n, prev, fmt.Errorf("entry 1: links to an entry before it, so the start of the log was removed")
+		case n == 1:
+			return n, prev, fmt.Errorf("entry 1: does not link to the last entry of the file before it")
+		default:
+			return n, prev, fmt.Errorf("entry %d: does not link to the previous entry", n)
+		}
+		if hashEntry(e, key) != e.Hash {
+			return n, prev, fmt.Errorf("entry %d: hash mismatch, the entry was altered or hashed with another key", n)
+		}
+		prev = e.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return n, prev, err
+	}
+	return n, prev, nil
+}
+
+// Files returns the files of the audit log at path, oldest first: the files
+// it was rotated into followed by path itself
+func Files(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("audit: %v", err)
+	}
+	var files []string
+	for _, m := range matches {
+		if _, err := time.Parse(rotatedSuffix, m[len(path)+1:]); err == nil {
+			files = append(files, m)
+		}
+	}
+	// The timestamps sort in the order the files were rotated
+	sort.Strings(files)
+	return append(files, path), nil
+}
+
+// VerifyFiles checks files as one hash chain whose first entry links to
+// prev, as Open writes it across rotations, and returns the number of
+// entries
+func VerifyFiles(files []string, prev string, key []byte) (int, error) {
+	total := 0
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return total, err
+		}
+		var n int
+		n, prev, err = Verify(f, prev, key)
+		f.Close()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return total, nil
+}
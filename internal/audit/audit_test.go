@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeLog records n entries in a hash-chained log at a new path, rotating
+// after every maxSize bytes, and returns the path
+func writeLog(t *testing.T, n int, maxSize int64, key []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, maxSize, true, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		e := Entry{Time: time.Unix(1700000000+int64(i), 0).UTC(), Client: "192.0.2.7", Endpoint: "/mtr", Target: "example.com", Count: 10}
+		if err := l.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readLines(t *testing.T, path string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	return lines[:len(lines)-1]
+}
+
+func writeLines(t *testing.T, path string, lines [][]byte) {
+	t.Helper()
+	if err := os.WriteFile(path, bytes.Join(lines, nil), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyIntact(t *testing.T) {
+	path := writeLog(t, 3, 0, nil)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	n, last, err := Verify(f, "", nil)
+	if err != nil || n != 3 {
+		t.Fatalf("Verify = %d, %v; want 3 entries", n, err)
+	}
+	var e Entry
+	lines := readLines(t, path)
+	if err := json.Unmarshal(lines[len(lines)-1], &e); err != nil {
+		t.Fatal(err)
+	}
+	if last != e.Hash {
+		t.Errorf("last hash %s, want %s", last, e.Hash)
+	}
+
+	// Reopening continues the chain
+	l, err := Open(path, 0, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Record(Entry{Target: "example.net"}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if n, err := VerifyFiles([]string{path}, "", nil); err != nil || n != 4 {
+		t.Errorf("VerifyFiles after reopening = %d, %v; want 4 entries", n, err)
+	}
+}
+
+func TestVerifyAcrossRotations(t *testing.T) {
+	// Each entry is over 200 bytes, so every file holds one
+	path := writeLog(t, 4, 200, nil)
+	files, err := Files(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || files[3] != path {
+		t.Fatalf("files %v, want 3 rotated ones and %s", files, path)
+	}
+	if n, err := VerifyFiles(files, "", nil); err != nil || n != 4 {
+		t.Fatalf("VerifyFiles = %d, %v; want 4 entries", n, err)
+	}
+
+	// Removing the oldest file is noticed, unless its last hash is given
+	if _, err := VerifyFiles(files[1:], "", nil); err == nil || !strings.Contains(err.Error(), "start of the log was removed") {
+		t.Errorf("VerifyFiles without the oldest file: %v", err)
+	}
+	var oldest Entry
+	if err := json.Unmarshal(readLines(t, files[0])[0], &oldest); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := VerifyFiles(files[1:], oldest.Hash, nil); err != nil || n != 3 {
+		t.Errorf("VerifyFiles from the anchor = %d, %v; want 3 entries", n, err)
+	}
+
+	// as is a file out of order
+	if _, err := VerifyFiles([]string{files[1], files[0], files[2], files[3]}, "", nil); err == nil {
+		t.Error("files out of order verified")
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func([][]byte) [][]byte
+		want   string
+	}{
+		{"head truncated", func(l [][]byte) [][]byte { return l[1:] }, "entry 1: links to an entry before it"},
+		{"entry removed", func(l [][]byte) [][]byte { return append(l[:1:1], l[2:]...) }, "entry 2: does not link"},
+		{"entry edited", func(l [][]byte) [][]byte {
+			l[1] = bytes.Replace(l[1], []byte("example.com"), []byte("example.org"), 1)
+			return l
+		}, "entry 2: hash mismatch"},
+		{"entries swapped", func(l [][]byte) [][]byte {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, "entry 2: does not link"},
+		{"hash removed", func(l [][]byte) [][]byte {
+			l[0] = bytes.Replace(l[0], []byte(`"hash"`), []byte(`"nohash"`), 1)
+			return l
+		}, "entry 1: no hash"},
+	}
+	for _, tt := range tests {
+		path := writeLog(t, 3, 0, nil)
+		writeLines(t, path, tt.tamper(readLines(t, path)))
+		if _, err := VerifyFiles([]string{path}, "", nil); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	key := []byte("audit-key")
+	path := writeLog(t, 3, 0, key)
+	if n, err := VerifyFiles([]string{path}, "", key); err != nil || n != 3 {
+		t.Fatalf("VerifyFiles with the key = %d, %v; want 3 entries", n, err)
+	}
+	if _, err := VerifyFiles([]string{path}, "", nil); err == nil {
+		t.Error("HMAC chain verified without the key")
+	}
+	if _, err := VerifyFiles([]string{path}, "", []byte("other")); err == nil {
+		t.Error("HMAC chain verified with another key")
+	}
+
+	// An edit whose chain is recomputed without the key does not verify
+	lines := readLines(t, path)
+	prev := ""
+	for i, line := range lines {
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			e.Target = "example.org"
+		}
+		e.PrevHash = prev
+		e.Hash = hashEntry(e, nil)
+		prev = e.Hash
+		data, _ := json.Marshal(e)
+		lines[i] = append(data, '\n')
+	}
+	writeLines(t, path, lines)
+	if _, err := VerifyFiles([]string{path}, "", key); err == nil || !strings.Contains(err.Error(), "entry 1: hash mismatch") {
+		t.Errorf("recomputed chain: error %v, want a hash mismatch", err)
+	}
+}
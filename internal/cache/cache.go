@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// Entry is a cached trace result. Output and RawOutput are kept alongside the
+// result because they are not part of its JSON form.
+type Entry struct {
+	Key       string      `json:"key"`
+	Result    *mtr.Result `json:"result"`
+	Output    string      `json:"output"`
+	RawOutput string      `json:"raw_output"`
+	StoredAt  time.Time   `json:"stored_at"`
+}
+
+// Cache holds recent trace results for a fixed time to live, optionally
+// persisted to a file so they survive restarts
+type Cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	file    string
+	entries map[string]Entry
+}
+
+// New creates a cache whose entries expire after ttl. When file is set,
+// the cache is written to it after every change.
+func New(ttl time.Duration, file string) *Cache {
+	return &Cache{ttl: ttl, file: file, entries: make(map[string]Entry)}
+}
+
+// Get returns the result stored under key unless it has expired
+func (c *Cache) Get(key string) (*mtr.Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.expired(entry, time.Now()) {
+		return nil, false
+	}
+	return entry.restore(), true
+}
+
+// Put stores res under key and persists the cache if a file is configured
+func (c *Cache) Put(key string, res *mtr.Result) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = Entry{
+		Key:       key,
+		Result:    res,
+		Output:    res.Output,
+		RawOutput: res.RawOutput,
+		StoredAt:  time.Now(),
+	}
+	c.prune(time.Now())
+	return c.save()
+}
+
+// Load reads entries persisted by a previous run, discarding expired ones.
+// A missing file is not an error.
+func (c *Cache) Load() error {
+	if c.file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(c.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+
+	var entries []Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("cache: invalid cache file %s: %v", c.file, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.Result != nil && !c.expired(entry, now) {
+			c.entries[entry.Key] = entry
+		}
+	}
+	return nil
+}
+
+// Recent describes the latest cached trace of a target
+type Recent struct {
+	Target             string     `json:"target"`
+	Health             mtr.Health `json:"health"`
+	DestinationReached bool       `json:"destination_reached"`
+	TracedAt           time.Time  `json:"traced_at"`
+}
+
+// Recent returns the most recently traced targets, newest first, with the
+// outcome of their latest cached trace. A limit of 0 or less returns all.
+func (c *Cache) Recent(limit int) []Recent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	latest := make(map[string]Recent)
+	for _, entry := range c.entries {
+		if c.expired(entry, now) {
+			continue
+		}
+		target := entry.Result.Target
+		if prev, ok := latest[target]; ok && !entry.StoredAt.After(prev.TracedAt) {
+			continue
+		}
+		latest[target] = Recent{
+			Target:             target,
+			Health:             entry.Result.Health,
+			DestinationReached: entry.Result.DestinationReached,
+			TracedAt:           entry.StoredAt,
+		}
+	}
+
+	recent := make([]Recent, 0, len(latest))
+	for _, r := range latest {
+		recent = append(recent, r)
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		if !recent[i].TracedAt.Equal(recent[j].TracedAt) {
+			return recent[i].TracedAt.After(recent[j].TracedAt)
+		}
+		return recent[i].Target < recent[j].Target
+	})
+	if limit > 0 && len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent
+}
+
+// FormatRecent renders recently traced targets as a table
+func FormatRecent(recent []Recent) string {
+	if len(recent) == 0 {
+		return "No recent traces.\n"
+	}
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("%-40s %-10s %-12s %s\n", "Target", "Health", "Destination", "Traced At"))
+	for _, r := range recent {
+		reached := "reached"
+		if !r.DestinationReached {
+			reached = "not reached"
+		}
+		out.WriteString(fmt.Sprintf("%-40s %-10s %-12s %s\n", r.Target, r.Health, reached, r.TracedAt.Format(time.RFC3339)))
+	}
+	return out.String()
+}
+
+func (c *Cache) expired(entry Entry, now time.Time) bool {
+	return now.Sub(entry.StoredAt) > c.ttl
+}
+
+// prune drops expired entries. The caller must hold c.mu.
+func (c *Cache) prune(now time.Time) {
+	for key, entry := range c.entries {
+		if c.expired(entry, now) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// save atomically writes the cache to its file by writing a temporary file
+// in the same directory and renaming it. The caller must hold c.mu.
+func (c *Cache) save() error {
+	if c.file == "" {
+		return nil
+	}
+
+	entries := make([]Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.file), filepath.Base(c.file)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cache: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("cache: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), c.file); err != nil {
+		return fmt.Errorf("cache: %v", err)
+	}
+	return nil
+}
+
+// restore returns a copy of the cached result with its text output attached
+func (e Entry) restore() *mtr.Result {
+	res := *e.Result
+	res.Output = e.Output
+	res.RawOutput = e.RawOutput
+	return &res
+}
@@ -0,0 +1,134 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+func TestPersistRoundTrip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.json")
+	c := New(time.Hour, file)
+	res := &mtr.Result{
+		Target:    "example.com",
+		Hops:      []mtr.HopData{{Hop: 1, IP: "10.0.0.1", Avg: 1.5}},
+		Output:    "table",
+		RawOutput: "h 0 10.0.0.1\n",
+	}
+	if err := c.Put("example.com|10", res); err != nil {
+		t.Fatal(err)
+	}
+
+	// The write leaves no temporary files behind
+	files, err := os.ReadDir(filepath.Dir(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("%d files in the cache directory, want only the cache file", len(files))
+	}
+
+	reloaded := New(time.Hour, file)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	got, ok := reloaded.Get("example.com|10")
+	if !ok {
+		t.Fatal("entry lost on reload")
+	}
+	if got.Target != res.Target || len(got.Hops) != 1 || got.Hops[0].Avg != 1.5 {
+		t.Errorf("reloaded result %+v", got)
+	}
+	if got.Output != res.Output || got.RawOutput != res.RawOutput {
+		t.Errorf("reloaded output %q raw %q", got.Output, got.RawOutput)
+	}
+}
+
+func TestLoadDiscardsExpired(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.json")
+	now := time.Now()
+	entries := []Entry{
+		{Key: "fresh", Result: &mtr.Result{Target: "fresh.example.com"}, StoredAt: now.Add(-time.Minute)},
+		{Key: "stale", Result: &mtr.Result{Target: "stale.example.com"}, StoredAt: now.Add(-2 * time.Hour)},
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(time.Hour, file)
+	if err := c.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Get("fresh"); !ok {
+		t.Error("fresh entry discarded")
+	}
+	if _, ok := c.Get("stale"); ok {
+		t.Error("expired entry loaded")
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("%d entries loaded, want 1", len(c.entries))
+	}
+
+	// A missing file starts an empty cache
+	if err := New(time.Hour, filepath.Join(t.TempDir(), "none.json")).Load(); err != nil {
+		t.Errorf("missing file: %v", err)
+	}
+}
+
+func TestRecent(t *testing.T) {
+	now := time.Now()
+	c := New(time.Hour, "")
+	seed := []struct {
+		key, target string
+		age         time.Duration
+		reached     bool
+	}{
+		{"a|10", "a.example.com", 30 * time.Minute, true},
+		{"b|10", "b.example.com", 10 * time.Minute, true},
+		{"a|50", "a.example.com", 5 * time.Minute, false},
+		{"c|10", "c.example.com", 20 * time.Minute, true},
+		{"d|10", "d.example.com", 2 * time.Hour, true},
+	}
+	for _, s := range seed {
+		c.entries[s.key] = Entry{
+			Key:      s.key,
+			Result:   &mtr.Result{Target: s.target, DestinationReached: s.reached},
+			StoredAt: now.Add(-s.age),
+		}
+	}
+
+	recent := c.Recent(0)
+	var targets []string
+	for _, r := range recent {
+		targets = append(targets, r.Target)
+	}
+	// Newest first, each target once with its latest trace; expired ones
+	// are left out
+	if got := strings.Join(targets, ","); got != "a.example.com,b.example.com,c.example.com" {
+		t.Fatalf("recent targets %s", got)
+	}
+	if recent[0].DestinationReached || !recent[0].TracedAt.Equal(now.Add(-5*time.Minute)) {
+		t.Errorf("a.example.com not listed with its latest trace: %+v", recent[0])
+	}
+
+	if got := c.Recent(2); len(got) != 2 || got[1].Target != "b.example.com" {
+		t.Errorf("limit 2: %+v", got)
+	}
+
+	table := FormatRecent(recent)
+	if lines := strings.Split(strings.TrimSpace(table), "\n"); len(lines) != 4 || !strings.Contains(lines[1], "not reached") {
+		t.Errorf("table:\n%s", table)
+	}
+	if FormatRecent(nil) != "No recent traces.\n" {
+		t.Errorf("empty table %q", FormatRecent(nil))
+	}
+}
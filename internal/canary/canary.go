@@ -0,0 +1,132 @@
+// Package canary periodically traces a canary host so the server can report
+// itself unready when it loses connectivity or mtr stops working.
+package canary
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/rs/zerolog/log"
+)
+
+// canaryCount is the number of probe cycles per canary trace, kept small so
+// the monitor does not eat into the probe budget of real traces
+const canaryCount = 3
+
+// MinInterval is the shortest interval allowed between canary traces
+const MinInterval = 10 * time.Second
+
+// TraceFunc runs one canary trace and returns an error if it failed
+type TraceFunc func(ctx context.Context, host string) error
+
+// Status is the monitor state reported by /readyz
+type Status struct {
+	Ready               bool      `json:"ready"`
+	Host                string    `json:"canary_host"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastCheck           time.Time `json:"last_check,omitempty"`
+}
+
+// Monitor traces Host every Interval and becomes unready after Threshold
+// consecutive failures. A single successful trace makes it ready again.
+type Monitor struct {
+	host      string
+	interval  time.Duration
+	threshold int
+	trace     TraceFunc
+
+	mu     sync.Mutex
+	status Status
+}
+
+// New creates a Monitor for host. It starts out ready so a server is not
+// taken out of rotation before the first canary trace has finished.
+func New(host string, interval time.Duration, threshold int) (*Monitor, error) {
+	if interval < MinInterval {
+		return nil, fmt.Errorf("canary interval must be at least %s", MinInterval)
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("canary failure threshold must be at least 1")
+	}
+	return &Monitor{
+		host:      host,
+		interval:  interval,
+		threshold: threshold,
+		trace:     traceHost,
+		status:    Status{Ready: true, Host: host},
+	}, nil
+}
+
+// traceHost runs a short trace and fails unless the destination answered
+func traceHost(ctx context.Context, host string) error {
+	res, err := mtr.Run(ctx, mtr.Config{Hostname: host, Count: canaryCount, Report: true})
+	if err != nil {
+		return err
+	}
+	if !res.DestinationReached {
+		return fmt.Errorf("canary %s was not reached", host)
+	}
+	return nil
+}
+
+// Run traces the canary immediately and then every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check runs one canary trace and updates the status
+func (m *Monitor) check(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.interval)
+	defer cancel()
+	err := m.trace(ctx, m.host)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		return // shutting down
+	}
+	m.record(err, time.Now())
+}
+
+// record applies the outcome of one canary trace
+func (m *Monitor) record(err error, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.LastCheck = at
+	if err == nil {
+		if !m.status.Ready {
+			log.Info().Str("canary", m.host).Msg("Canary trace succeeded, marking ready")
+		}
+		m.status.Ready = true
+		m.status.ConsecutiveFailures = 0
+		m.status.LastError = ""
+		return
+	}
+
+	m.status.ConsecutiveFailures++
+	m.status.LastError = err.Error()
+	log.Warn().Err(err).Str("canary", m.host).Int("failures", m.status.ConsecutiveFailures).Msg("Canary trace failed")
+	if m.status.Ready && m.status.ConsecutiveFailures >= m.threshold {
+		log.Error().Str("canary", m.host).Msg("Canary trace failed repeatedly, marking unready")
+		m.status.Ready = false
+	}
+}
+
+// Status returns the current monitor state
+func (m *Monitor) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
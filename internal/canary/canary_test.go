@@ -0,0 +1,84 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// scriptedTrace returns a TraceFunc failing or succeeding as outcomes says,
+// one outcome per trace
+func scriptedTrace(outcomes ...error) TraceFunc {
+	return func(ctx context.Context, host string) error {
+		err := outcomes[0]
+		outcomes = outcomes[1:]
+		return err
+	}
+}
+
+func TestMonitorTransitions(t *testing.T) {
+	m, err := New("canary.example.com", MinInterval, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := errors.New("canary canary.example.com was not reached")
+	m.trace = scriptedTrace(down, nil, down, down, down, nil)
+
+	steps := []struct {
+		ready    bool
+		failures int
+	}{
+		{true, 1}, // a single failure is tolerated
+		{true, 0}, // and forgotten after a success
+		{true, 1},
+		{false, 2}, // the threshold makes it unready
+		{false, 3},
+		{true, 0}, // one success makes it ready again
+	}
+	if !m.Status().Ready {
+		t.Fatal("not ready before the first trace")
+	}
+	for i, want := range steps {
+		m.check(context.Background())
+		s := m.Status()
+		if s.Ready != want.ready || s.ConsecutiveFailures != want.failures {
+			t.Errorf("after trace %d: ready %v with %d failures, want %v with %d",
+				i+1, s.Ready, s.ConsecutiveFailures, want.ready, want.failures)
+		}
+		if want.failures > 0 && s.LastError != down.Error() {
+			t.Errorf("after trace %d: last error %q", i+1, s.LastError)
+		}
+		if want.failures == 0 && s.LastError != "" {
+			t.Errorf("after trace %d: last error %q kept after a success", i+1, s.LastError)
+		}
+		if s.LastCheck.IsZero() {
+			t.Errorf("after trace %d: no check time", i+1)
+		}
+	}
+}
+
+func TestMonitorIgnoresShutdown(t *testing.T) {
+	m, err := New("canary.example.com", MinInterval, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.trace = func(ctx context.Context, host string) error {
+		cancel()
+		return ctx.Err()
+	}
+	m.check(ctx)
+	if s := m.Status(); !s.Ready || s.ConsecutiveFailures != 0 {
+		t.Errorf("a trace cut short by shutdown counted as a failure: %+v", s)
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	if _, err := New("canary.example.com", time.Second, 1); err == nil {
+		t.Error("interval below the minimum accepted")
+	}
+	if _, err := New("canary.example.com", MinInterval, 0); err == nil {
+		t.Error("threshold 0 accepted")
+	}
+}
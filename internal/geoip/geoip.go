@@ -0,0 +1,77 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// DB annotates hops with the location of their IP from a MaxMind GeoIP2 or
+// GeoLite2 City (or Country) database: "country" (ISO code), "city", and
+// "lat" and "lon", which also place the hop in -format geojson. The
+// database is opened once and shared by every trace.
+type DB struct {
+	reader *geoip2.Reader
+}
+
+// Open opens the mmdb database at path
+func Open(path string) (*DB, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open GeoIP database: %v", err)
+	}
+	return &DB{reader: reader}, nil
+}
+
+// Enrich looks up the location of every hop IP. IPs the database does not
+// know, such as private addresses, are left without annotations.
+func (db *DB) Enrich(ctx context.Context, hops []mtr.HopData) (map[string]map[string]string, error) {
+	annotations := make(map[string]map[string]string)
+	for _, hop := range hops {
+		if _, seen := annotations[hop.IP]; seen || hop.IP == "" {
+			continue
+		}
+		annotations[hop.IP] = db.lookup(hop.IP)
+	}
+	return annotations, nil
+}
+
+// lookup returns the location annotations of one IP
+func (db *DB) lookup(addr string) map[string]string {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil
+	}
+	found := make(map[string]string)
+	switch db.reader.Metadata().DatabaseType {
+	case "GeoIP2-Country", "GeoLite2-Country":
+		record, err := db.reader.Country(ip)
+		if err != nil {
+			return nil
+		}
+		if record.Country.IsoCode != "" {
+			found["country"] = record.Country.IsoCode
+		}
+	default:
+		record, err := db.reader.City(ip)
+		if err != nil {
+			return nil
+		}
+		if record.Country.IsoCode != "" {
+			found["country"] = record.Country.IsoCode
+		}
+		if name := record.City.Names["en"]; name != "" {
+			found["city"] = name
+		}
+		// Unknown locations have no coordinates rather than 0,0
+		if record.Location.Latitude != 0 || record.Location.Longitude != 0 {
+			found["lat"] = strconv.FormatFloat(record.Location.Latitude, 'f', -1, 64)
+			found["lon"] = strconv.FormatFloat(record.Location.Longitude, 'f', -1, 64)
+		}
+	}
+	return found
+}
@@ -0,0 +1,102 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// DefaultWindow is how many recent traces of a target form its baseline
+const DefaultWindow = 100
+
+// record is one line of the history file: the end-to-end metrics of a trace
+type record struct {
+	Target  string    `json:"target"`
+	Time    time.Time `json:"time"`
+	Latency float64   `json:"latency_ms"`
+	Loss    float64   `json:"loss"`
+}
+
+// Store keeps the end-to-end latency and loss of every trace as JSON lines
+// in a file, and serves the most recent ones of a target as its baseline
+type Store struct {
+	mu     sync.Mutex
+	path   string
+	window int
+}
+
+// New creates a store backed by the file at path. window is how many recent
+// traces of a target form its baseline (DefaultWindow when 0 or less).
+func New(path string, window int) *Store {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Store{path: path, window: window}
+}
+
+// Baseline returns the destination latency and loss of the target's most
+// recent traces. A missing file is an empty history.
+func (s *Store) Baseline(target string) ([]float64, []float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("history: %v", err)
+	}
+	defer f.Close()
+
+	var latency, loss []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, nil, fmt.Errorf("history: invalid record in %s: %v", s.path, err)
+		}
+		if r.Target != target {
+			continue
+		}
+		latency = append(latency, r.Latency)
+		loss = append(loss, r.Loss)
+		if len(latency) > s.window {
+			latency, loss = latency[1:], loss[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("history: %v", err)
+	}
+	return latency, loss, nil
+}
+
+// Record appends the destination metrics of res to the history file
+func (s *Store) Record(res *mtr.Result) error {
+	if len(res.Hops) == 0 {
+		return nil
+	}
+	dest := res.Hops[len(res.Hops)-1]
+	line, err := json.Marshal(record{Target: res.Target, Time: time.Now().UTC(), Latency: dest.Avg, Loss: dest.Loss})
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("history: %v", err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,38 @@
+package history
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+func TestStoreBaseline(t *testing.T) {
+	s := New(filepath.Join(t.TempDir(), "history.jsonl"), 3)
+
+	// A missing file is an empty history
+	latency, loss, err := s.Baseline("example.com")
+	if err != nil || len(latency) != 0 || len(loss) != 0 {
+		t.Fatalf("baseline %v %v, error %v", latency, loss, err)
+	}
+
+	for i, target := range []string{"example.com", "example.net", "example.com", "example.com", "example.com"} {
+		res := &mtr.Result{Target: target, Hops: []mtr.HopData{
+			{Hop: 1, IP: "10.0.0.1", Avg: 1},
+			{Hop: 2, IP: "192.0.2.1", Avg: float64(10 * (i + 1)), Loss: float64(i)},
+		}}
+		if err := s.Record(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Only the window of the target's most recent traces
+	latency, loss, err = s.Baseline("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(latency, []float64{30, 40, 50}) || !reflect.DeepEqual(loss, []float64{2, 3, 4}) {
+		t.Errorf("baseline latency %v loss %v", latency, loss)
+	}
+}
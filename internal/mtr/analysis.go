@@ -0,0 +1,207 @@
+package mtr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Classification is a machine-readable interpretation of a trace
+type Classification string
+
+const (
+	ClassDestinationHealthy    Classification = "destination_healthy"
+	ClassDestinationUnreached  Classification = "destination_unreached"
+	ClassRoutingLoop           Classification = "routing_loop_suspected"
+	ClassHighLossPath          Classification = "high_loss_path"
+	ClassHighLatency           Classification = "high_latency"
+	ClassICMPRateLimiting      Classification = "icmp_rate_limiting_suspected"
+	ClassDestinationLossOnly   Classification = "destination_loss"
+	ClassInsufficientResponses Classification = "insufficient_responses"
+)
+
+// classPriority orders classifications from most to least severe; the first
+// one found becomes the primary classification
+var classPriority = []Classification{
+	ClassRoutingLoop,
+	ClassDestinationUnreached,
+	ClassHighLossPath,
+	ClassDestinationLossOnly,
+	ClassHighLatency,
+	ClassICMPRateLimiting,
+	ClassInsufficientResponses,
+	ClassDestinationHealthy,
+}
+
+// Finding is one classification together with the hops that support it
+type Finding struct {
+	Classification Classification `json:"classification"`
+	Hops           []int          `json:"hops,omitempty"`
+	Detail         string         `json:"detail"`
+}
+
+// Analysis interprets a trace. Classification is the most severe finding.
+type Analysis struct {
+	Classification Classification `json:"classification"`
+	Findings       []Finding      `json:"findings"`
+}
+
+// Has reports whether the analysis contains the given classification
+func (a Analysis) Has(c Classification) bool {
+	for _, f := range a.Findings {
+		if f.Classification == c {
+			return true
+		}
+	}
+	return false
+}
+
+// analyze classifies the deduplicated hops of res. loop is the suspected
+// routing loop, if any.
+func analyze(hops []HopData, res *Result, loop *loopInfo) Analysis {
+	var findings []Finding
+
+	if loop != nil {
+		var loopHops []int
+		for h := loop.FirstHop; h <= loop.LastHop; h++ {
+			loopHops = append(loopHops, h)
+		}
+		findings = append(findings, Finding{
+			Classification: ClassRoutingLoop,
+			Hops:           loopHops,
+			Detail:         fmt.Sprintf("%s answers at %d consecutive hops", loop.IP, len(loopHops)),
+		})
+	}
+
+	if len(hops) == 0 {
+		findings = append(findings, Finding{
+			Classification: ClassInsufficientResponses,
+			Detail:         "no hop answered",
+		})
+		return newAnalysis(findings)
+	}
+	dest := hops[len(hops)-1]
+
+	if !res.DestinationReached {
+		findings = append(findings, Finding{
+			Classification: ClassDestinationUnreached,
+			Hops:           []int{dest.Hop},
+			Detail:         fmt.Sprintf("the last answering hop is %d, not the destination", dest.Hop),
+		})
+	}
+
+	// Loss that starts at a hop and carries through to the end is real loss
+	// on the path; loss confined to intermediate hops is usually a router
+	// deprioritising or rate limiting its own ICMP replies
+	if dest.Loss > lossWarnThreshold {
+		start := len(hops) - 1
+		for start > 0 && hops[start-1].Loss > lossWarnThreshold {
+			start--
+		}
+		var lossy []int
+		for _, hop := range hops[start:] {
+			lossy = append(lossy, hop.Hop)
+		}
+		switch {
+		case dest.Loss > lossHighThreshold && len(lossy) > 1:
+			findings = append(findings, Finding{
+				Classification: ClassHighLossPath,
+				Hops:           lossy,
+				Detail:         fmt.Sprintf("loss starts at hop %d and persists to the end (%.1f%%)", lossy[0], dest.Loss),
+			})
+		default:
+			findings = append(findings, Finding{
+				Classification: ClassDestinationLossOnly,
+				Hops:           lossy,
+				Detail:         fmt.Sprintf("%.1f%% loss at the final hop", dest.Loss),
+			})
+		}
+	} else {
+		var limited []int
+		for _, hop := range hops[:len(hops)-1] {
+			if hop.Loss > lossWarnThreshold && hop.IP != "" {
+				limited = append(limited, hop.Hop)
+			}
+		}
+		if len(limited) > 0 {
+			findings = append(findings, Finding{
+				Classification: ClassICMPRateLimiting,
+				Hops:           limited,
+				Detail:         "intermediate hops drop replies but the loss does not carry through to the final hop",
+			})
+		}
+	}
+
+	if dest.Avg >= latencyHighThreshold {
+		first := len(hops) - 1
+		for first > 0 && hops[first-1].Avg >= latencyHighThreshold {
+			first--
+		}
+		findings = append(findings, Finding{
+			Classification: ClassHighLatency,
+			Hops:           []int{hops[first].Hop},
+			Detail:         fmt.Sprintf("average latency reaches %.1f ms from hop %d onwards", dest.Avg, hops[first].Hop),
+		})
+	}
+
+	if len(findings) == 0 || (len(findings) == 1 && findings[0].Classification == ClassICMPRateLimiting) {
+		findings = append(findings, Finding{
+			Classification: ClassDestinationHealthy,
+			Hops:           []int{dest.Hop},
+			Detail:         fmt.Sprintf("destination answers with %.1f%% loss and %.1f ms average latency", dest.Loss, dest.Avg),
+		})
+	}
+
+	return newAnalysis(findings)
+}
+
+// newAnalysis orders findings by severity and picks the primary classification
+func newAnalysis(findings []Finding) Analysis {
+	var a Analysis
+	for _, c := range classPriority {
+		for _, f := range findings {
+			if f.Classification == c {
+				a.Findings = append(a.Findings, f)
+			}
+		}
+	}
+	if len(a.Findings) > 0 {
+		a.Classification = a.Findings[0].Classification
+	}
+	return a
+}
+
+// classExplanations is the human-readable meaning of each classification
+var classExplanations = map[Classification]string{
+	ClassDestinationHealthy:    "The destination is reachable without significant loss or latency.",
+	ClassDestinationUnreached:  "The trace never reached the destination; traffic is dropped or filtered along the way.",
+	ClassRoutingLoop:           "The same router answers at several consecutive hops, which suggests a routing loop.",
+	ClassHighLossPath:          "Packet loss begins at a hop and continues to the destination, so it affects real traffic.",
+	ClassDestinationLossOnly:   "Only the final hop shows loss; the destination may be rate limiting ICMP or be overloaded.",
+	ClassHighLatency:           "Latency to the destination is high.",
+	ClassICMPRateLimiting:      "Some routers drop replies to probes, but later hops are fine; this is ICMP rate limiting, not real loss.",
+	ClassInsufficientResponses: "Not enough hops answered to interpret the trace.",
+}
+
+// formatExplanation renders the analysis as human-readable text
+func formatExplanation(a Analysis) string {
+	var out strings.Builder
+	out.WriteString("\nInterpretation:\n")
+	out.WriteString("---------------\n")
+	for _, f := range a.Findings {
+		out.WriteString(classExplanations[f.Classification] + "\n")
+		detail := f.Detail
+		if len(f.Hops) > 0 {
+			detail += fmt.Sprintf(" (hops: %s)", joinHops(f.Hops))
+		}
+		out.WriteString("  " + detail + "\n")
+	}
+	return out.String()
+}
+
+func joinHops(hops []int) string {
+	parts := make([]string, len(hops))
+	for i, h := range hops {
+		parts[i] = fmt.Sprint(h)
+	}
+	return strings.Join(parts, ", ")
+}
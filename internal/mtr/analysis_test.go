@@ -0,0 +1,138 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// withStats sets the loss and average latency of each hop of a route
+func withStats(hops []HopData, loss, avg []float64) []HopData {
+	for i := range hops {
+		hops[i].Loss, hops[i].Avg = loss[i], avg[i]
+	}
+	return hops
+}
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name     string
+		hops     []HopData
+		reached  bool
+		loop     *loopInfo
+		want     Classification
+		others   []Classification
+		evidence []int
+	}{
+		{
+			name:     "healthy",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1", "192.0.2.1"), []float64{0, 0, 0}, []float64{1, 5, 10}),
+			reached:  true,
+			want:     ClassDestinationHealthy,
+			evidence: []int{3},
+		},
+		{
+			name:     "rate limiting intermediate hop",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1", "192.0.2.1"), []float64{0, 60, 0}, []float64{1, 5, 10}),
+			reached:  true,
+			want:     ClassICMPRateLimiting,
+			others:   []Classification{ClassDestinationHealthy},
+			evidence: []int{2},
+		},
+		{
+			name:     "loss carried to the end",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1", "192.0.2.1"), []float64{0, 30, 30}, []float64{1, 5, 10}),
+			reached:  true,
+			want:     ClassHighLossPath,
+			evidence: []int{2, 3},
+		},
+		{
+			name:     "loss only at the destination",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1", "192.0.2.1"), []float64{0, 0, 10}, []float64{1, 5, 10}),
+			reached:  true,
+			want:     ClassDestinationLossOnly,
+			evidence: []int{3},
+		},
+		{
+			name:     "high latency",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1", "192.0.2.1"), []float64{0, 0, 0}, []float64{1, 150, 160}),
+			reached:  true,
+			want:     ClassHighLatency,
+			evidence: []int{2},
+		},
+		{
+			name:     "unreached",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1"), []float64{0, 0}, []float64{1, 5}),
+			want:     ClassDestinationUnreached,
+			evidence: []int{2},
+		},
+		{
+			name:     "routing loop",
+			hops:     withStats(route("10.0.0.1", "10.1.0.1", "10.1.0.1", "10.1.0.1"), []float64{0, 0, 0, 0}, []float64{1, 5, 5, 5}),
+			loop:     &loopInfo{IP: "10.1.0.1", FirstHop: 2, LastHop: 4},
+			want:     ClassRoutingLoop,
+			others:   []Classification{ClassDestinationUnreached},
+			evidence: []int{2, 3, 4},
+		},
+		{
+			name: "no answers",
+			want: ClassInsufficientResponses,
+		},
+	}
+	for _, tt := range tests {
+		a := analyze(tt.hops, &Result{DestinationReached: tt.reached}, tt.loop)
+		if a.Classification != tt.want {
+			t.Errorf("%s: classified %s, want %s", tt.name, a.Classification, tt.want)
+			continue
+		}
+		if got, want := fmt.Sprint(a.Findings[0].Hops), fmt.Sprint(tt.evidence); got != want {
+			t.Errorf("%s: evidence hops %s, want %s", tt.name, got, want)
+		}
+		if len(a.Findings) != 1+len(tt.others) {
+			t.Errorf("%s: findings %+v", tt.name, a.Findings)
+		}
+		for _, other := range tt.others {
+			if !a.Has(other) {
+				t.Errorf("%s: no %s finding", tt.name, other)
+			}
+		}
+	}
+}
+
+func TestExplanationFromAnalysis(t *testing.T) {
+	hops := withStats(route("10.0.0.1", "10.1.0.1", "192.0.2.1"), []float64{0, 60, 0}, []float64{1, 5, 10})
+	a := analyze(hops, &Result{DestinationReached: true}, nil)
+	text := formatExplanation(a)
+	for _, f := range a.Findings {
+		if !strings.Contains(text, classExplanations[f.Classification]) {
+			t.Errorf("explanation lacks the %s finding:\n%s", f.Classification, text)
+		}
+	}
+	if !strings.Contains(text, "(hops: 2)") {
+		t.Errorf("explanation lacks the evidence:\n%s", text)
+	}
+}
+
+func TestRunAnalysisInJSON(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{1: 1}, 2)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10, 1: 12}, 2))
+
+	cfg := testConfig(2)
+	cfg.Format = FormatJSON
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var out struct {
+		Analysis Analysis `json:"analysis"`
+	}
+	if err := json.Unmarshal([]byte(res.Output), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Analysis.Classification != ClassICMPRateLimiting || !out.Analysis.Has(ClassDestinationHealthy) {
+		t.Errorf("analysis %+v", out.Analysis)
+	}
+}
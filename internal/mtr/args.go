@@ -0,0 +1,246 @@
+package mtr
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ArgExplanation describes one argument (with its value, if any) passed to
+// sudo or mtr, and the configuration option that caused it
+type ArgExplanation struct {
+	Args        []string
+	Description string
+	Option      string
+}
+
+// argDescriptions explains what each argument does, keyed by program and flag
+var argDescriptions = map[string]string{
+	"nice -n":    "Run with this scheduling niceness so traces yield CPU to other work",
+	"ionice -c":  "Run in this I/O scheduling class",
+	"sudo":       "Run mtr with the privileges it needs for raw sockets",
+	"sudo -n":    "Run sudo non-interactively so it never waits for a password on stdin",
+	"mtr":        "Path of the mtr binary (MTR_PATH or the default location)",
+	"mtr --raw":  "Print raw per-probe records, which this tool parses itself",
+	"mtr --json": "Print mtr's own report as JSON, whose statistics are used as they are",
+	"mtr -b":     "Show hop addresses next to their names, so the JSON report has both",
+	"mtr -n":     "Do not resolve hop addresses to names",
+	"mtr -s":     "Size of each probe packet in bytes",
+	"mtr -4":     "Use IPv4 only",
+	"mtr -6":     "Use IPv6 only",
+	"mtr -c":     "Number of probe cycles to send to each hop",
+	"mtr -i":     "Seconds to wait between probe cycles",
+	"mtr -m":     "Maximum number of hops (TTL) to probe",
+	"mtr -u":     "Send UDP probes instead of ICMP echo requests",
+	"mtr -L":     "Source port of the UDP probes",
+	"mtr --tcp":  "Send TCP SYN probes instead of ICMP echo requests",
+	"mtr -P":     "Destination port of the probes",
+	"mtr -I":     "Send probes out of this network interface",
+	"--":         "End mtr's options, so the target is never taken for one",
+	"target":     "Host to trace",
+}
+
+// ExplainArgs returns, in order, every argument of the command line the
+// trace for cfg runs (priority wrappers, sudo and mtr), each with a
+// description of what it does and which option triggered it. commandLine
+// derives the actual command line from it.
+func ExplainArgs(cfg Config) []ArgExplanation {
+	var args []ArgExplanation
+	add := func(option, key string, values ...string) {
+		args = append(args, ArgExplanation{values, argDescriptions[key], option})
+	}
+
+	// Wrappers go before sudo so the sudoers rule for mtr still matches;
+	// sudo and mtr inherit the priority
+	if cfg.Nice != 0 {
+		add("nice", "nice -n", "nice", "-n", strconv.Itoa(cfg.Nice))
+	}
+	if cfg.IONiceClass != "" {
+		add("ionice", "ionice -c", "ionice", "-c", ioniceClasses[cfg.IONiceClass])
+	}
+
+	if !cfg.withoutSudo() {
+		add("no-sudo=false", "sudo", sudoPath)
+		add("no-sudo=false", "sudo -n", "-n")
+	}
+	add("MTR_PATH", "mtr", mtrPath)
+
+	switch {
+	case cfg.NativeJSON:
+		add("mtr-json", "mtr --json", "--json")
+	case cfg.Report:
+		add("report", "mtr --raw", "--raw") // Use raw format for better parsing
+	}
+
+	// Report mode resolves hop names; live mode only does with Resolve
+	switch {
+	case cfg.IPOnly:
+		add("ip-only", "mtr -n", "-n")
+	case !cfg.Report && !cfg.Resolve:
+		add("report=false", "mtr -n", "-n")
+	case cfg.NativeJSON:
+		add("mtr-json", "mtr -b", "-b")
+	}
+
+	if cfg.IPv4 {
+		add("4", "mtr -4", "-4")
+	}
+	if cfg.IPv6 {
+		add("6", "mtr -6", "-6")
+	}
+
+	if cfg.Count > 0 {
+		add("count", "mtr -c", "-c", strconv.Itoa(cfg.Count))
+	}
+
+	if cfg.Interval > 0 {
+		add("interval", "mtr -i", "-i", strconv.FormatFloat(cfg.Interval.Seconds(), 'f', -1, 64))
+	}
+
+	if cfg.PacketSize > 0 {
+		add("psize", "mtr -s", "-s", strconv.Itoa(cfg.PacketSize))
+	}
+
+	if cfg.FirstHopOnly {
+		add("first-hop-only", "mtr -m", "-m", "1") // Stop after the first hop
+	}
+
+	switch {
+	case cfg.TCP:
+		add("tcp", "mtr --tcp", "--tcp")
+	case cfg.UDP:
+		add("udp", "mtr -u", "-u")
+	case cfg.LocalPort > 0:
+		add("vary-port", "mtr -u", "-u")
+	}
+	if cfg.LocalPort > 0 {
+		add("vary-port", "mtr -L", "-L", strconv.Itoa(cfg.LocalPort))
+	}
+	if cfg.Port > 0 {
+		add("port", "mtr -P", "-P", strconv.Itoa(cfg.Port))
+	}
+
+	if cfg.Interface != "" {
+		add("interface", "mtr -I", "-I", cfg.Interface)
+	}
+
+	// Add hostname
+	add("host", "--", "--")
+	switch {
+	case IsCIDR(cfg.Hostname) && cfg.CIDRPick == PickRandom:
+		add("host (a random address of the subnet is picked when the trace runs)", "target", cfg.Hostname)
+	case IsCIDR(cfg.Hostname):
+		addr, err := PickCIDRAddress(cfg.Hostname, cfg.CIDRPick)
+		if err != nil {
+			addr = cfg.Hostname
+		}
+		add("host, cidr-pick", "target", addr)
+	default:
+		add("host", "target", cfg.Hostname)
+	}
+	return args
+}
+
+// commandLine returns the program and arguments used to run mtr for cfg
+func commandLine(cfg Config) []string {
+	var argv []string
+	for _, arg := range ExplainArgs(cfg) {
+		argv = append(argv, arg.Args...)
+	}
+	return argv
+}
+
+// Command returns the command line that Run would execute for cfg
+func Command(cfg Config) string {
+	return strings.Join(commandLine(cfg), " ")
+}
+
+// withoutSudo reports whether mtr runs directly rather than through sudo
+func (c Config) withoutSudo() bool {
+	if c.NoSudo {
+		return true
+	}
+	info, err := os.Stat(sudoPath)
+	return err != nil || info.Mode().Perm()&0o111 == 0
+}
+
+// privilegedProbes reports whether mtr usually needs root for its raw
+// sockets on this platform
+func privilegedProbes() bool {
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "openbsd", "netbsd":
+		return true
+	}
+	return false
+}
+
+// Bounds of the probe interval of a single trace
+const (
+	MinInterval = 100 * time.Millisecond
+	MaxInterval = 10 * time.Second
+)
+
+// ValidateInterval checks that a probe interval is within MinInterval and
+// MaxInterval
+func ValidateInterval(interval time.Duration) error {
+	if interval < MinInterval || interval > MaxInterval {
+		return fmt.Errorf("interval must be between %s and %s", MinInterval, MaxInterval)
+	}
+	return nil
+}
+
+// Bounds of PacketSize accepted by mtr
+const (
+	MinPacketSize = 28
+	MaxPacketSize = 4470
+)
+
+// ValidatePacketSize checks a probe size; 0 leaves mtr's default
+func ValidatePacketSize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("packet size must not be negative")
+	}
+	if size != 0 && (size < MinPacketSize || size > MaxPacketSize) {
+		return fmt.Errorf("packet size must be between %d and %d bytes", MinPacketSize, MaxPacketSize)
+	}
+	return nil
+}
+
+// ioniceClasses maps the I/O scheduling classes accepted by IONiceClass to
+// ionice's class numbers
+var ioniceClasses = map[string]string{
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// ValidatePriority checks the niceness and I/O class options
+func ValidatePriority(nice int, ioClass string) error {
+	if nice < -20 || nice > 19 {
+		return fmt.Errorf("nice must be between -20 and 19")
+	}
+	if ioClass == "" {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("ionice is only available on Linux")
+	}
+	if _, ok := ioniceClasses[ioClass]; !ok {
+		return fmt.Errorf("invalid ionice class %q (expected idle or best-effort)", ioClass)
+	}
+	return nil
+}
+
+// CheckBinary verifies that the mtr binary exists and is executable
+func CheckBinary() error {
+	info, err := os.Stat(mtrPath)
+	if err != nil {
+		return fmt.Errorf("mtr binary not found: %v", err)
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		return fmt.Errorf("mtr binary %s is not executable", mtrPath)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package mtr
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// hasArgs reports whether want appears as consecutive arguments of argv
+func hasArgs(argv []string, want ...string) bool {
+	return strings.Contains(" "+strings.Join(argv, " ")+" ", " "+strings.Join(want, " ")+" ")
+}
+
+func TestFirstHopOnly(t *testing.T) {
+	cfg := testConfig(1)
+	if hasArgs(commandLine(cfg), "-m", "1") {
+		t.Errorf("command line %q limits the hops without first-hop-only", commandLine(cfg))
+	}
+	cfg.FirstHopOnly = true
+	if !hasArgs(commandLine(cfg), "-m", "1") {
+		t.Errorf("command line %q lacks -m 1", commandLine(cfg))
+	}
+
+	// The fake mtr honours -m 1 as mtr does
+	seq := 0
+	first := rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)
+	rest := rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1)
+	fakeMTRScript(t, "cat <<'EOF'\n"+first+"EOF\ncase \"$*\" in *'-m 1'*) exit 0;; esac\ncat <<'EOF'\n"+rest+"EOF\n")
+
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Hops) != 1 || res.Hops[0].IP != "10.0.0.1" {
+		t.Errorf("hops %+v, want only the first", res.Hops)
+	}
+	// The destination is not expected to be reached
+	for _, warning := range res.Warnings {
+		if strings.Contains(warning, "was not reached") {
+			t.Errorf("warning %q for a first-hop check", warning)
+		}
+	}
+}
+
+func TestExplainArgs(t *testing.T) {
+	cfg := testConfig(10)
+	cfg.TCP = true
+	cfg.Port = 443
+	cfg.PacketSize = 1400
+	cfg.Interface = "eth1"
+
+	explained := ExplainArgs(cfg)
+	byFlag := make(map[string]ArgExplanation)
+	var argv []string
+	for _, arg := range explained {
+		byFlag[arg.Args[0]] = arg
+		argv = append(argv, arg.Args...)
+		if arg.Description == "" {
+			t.Errorf("argument %q has no description", arg.Args)
+		}
+	}
+	// The explanation covers exactly the command line that runs
+	if strings.Join(argv, " ") != Command(cfg) {
+		t.Errorf("explained %q, command line %q", argv, Command(cfg))
+	}
+
+	for flag, option := range map[string]string{
+		"--raw": "report",
+		"-c":    "count",
+		"-s":    "psize",
+		"--tcp": "tcp",
+		"-P":    "port",
+		"-I":    "interface",
+	} {
+		arg, ok := byFlag[flag]
+		if !ok {
+			t.Errorf("no explanation of %s", flag)
+			continue
+		}
+		if arg.Option != option {
+			t.Errorf("%s explained as triggered by %q, want %q", flag, arg.Option, option)
+		}
+	}
+	if got := byFlag["-P"].Args; len(got) != 2 || got[1] != "443" {
+		t.Errorf("-P explained with %q, want its value", got)
+	}
+	// Options left unset add nothing
+	for _, flag := range []string{"-u", "-m", "-i", "-4", "-6", sudoPath} {
+		if _, ok := byFlag[flag]; ok {
+			t.Errorf("%s explained though not used", flag)
+		}
+	}
+}
+
+func TestPriorityArgs(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.Nice = 10
+	cfg.IONiceClass = "idle"
+	argv := commandLine(cfg)
+	// The wrappers run mtr, so they come first
+	if len(argv) < 7 || strings.Join(argv[:7], " ") != "nice -n 10 ionice -c 3 "+mtrPath {
+		t.Errorf("command line %q does not start with nice -n 10 ionice -c 3 %s", argv, mtrPath)
+	}
+
+	cfg.IONiceClass = "best-effort"
+	if !hasArgs(commandLine(cfg), "ionice", "-c", "2") {
+		t.Errorf("command line %q lacks the best-effort class", commandLine(cfg))
+	}
+	if hasArgs(commandLine(testConfig(1)), "nice") || hasArgs(commandLine(testConfig(1)), "ionice") {
+		t.Errorf("command line %q wrapped without a priority", commandLine(testConfig(1)))
+	}
+
+	for _, nice := range []int{-20, 0, 19} {
+		if err := ValidatePriority(nice, ""); err != nil {
+			t.Errorf("nice %d refused: %v", nice, err)
+		}
+	}
+	for _, nice := range []int{-21, 20} {
+		if err := ValidatePriority(nice, ""); err == nil {
+			t.Errorf("nice %d accepted", nice)
+		}
+	}
+	if err := ValidatePriority(0, "realtime"); err == nil {
+		t.Error("ionice class realtime accepted")
+	}
+}
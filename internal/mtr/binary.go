@@ -0,0 +1,141 @@
+package mtr
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// binaryMagic starts every binary result stream
+const binaryMagic = "MTRB"
+
+// binaryVersion is the schema version written by NewBinaryWriter. Readers
+// keep decoding every older version.
+const binaryVersion byte = 1
+
+// binaryResultV1 is the version 1 schema. Fields may be added (gob ignores
+// unknown fields and zeroes missing ones), but changing the meaning of a
+// field requires a new version.
+type binaryResultV1 struct {
+	Target             string
+	ProbedAddress      string
+	Labels             map[string]string
+	Meta               *Meta
+	ResolvedIPs        []string
+	DNSResolution      time.Duration
+	ProbesRequested    int
+	ProbesSent         int
+	Hops               []HopData
+	LoopSuspected      bool
+	DestinationReached bool
+	Aborted            bool
+	AbortReason        string
+	Health             Health
+	Warnings           []string
+	Analysis           Analysis
+	History            *HistoryComparison
+	StartedAt          time.Time
+	FinishedAt         time.Time
+	Duration           time.Duration
+}
+
+// BinaryWriter writes results as a compact stream: a magic header and
+// version followed by gob-encoded results. Type information is sent once,
+// so archives of many results stay small.
+type BinaryWriter struct {
+	w   io.Writer
+	enc *gob.Encoder
+}
+
+// NewBinaryWriter writes the stream header to w
+func NewBinaryWriter(w io.Writer) (*BinaryWriter, error) {
+	if _, err := io.WriteString(w, binaryMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte{binaryVersion}); err != nil {
+		return nil, err
+	}
+	return &BinaryWriter{w: w, enc: gob.NewEncoder(w)}, nil
+}
+
+// Write appends a result to the stream
+func (b *BinaryWriter) Write(res *Result) error {
+	return b.enc.Encode(binaryResultV1{
+		Target:             res.Target,
+		ProbedAddress:      res.ProbedAddress,
+		Labels:             res.Labels,
+		Meta:               res.Meta,
+		ResolvedIPs:        res.ResolvedIPs,
+		DNSResolution:      res.DNSResolution,
+		ProbesRequested:    res.ProbesRequested,
+		ProbesSent:         res.ProbesSent,
+		Hops:               res.Hops,
+		LoopSuspected:      res.LoopSuspected,
+		DestinationReached: res.DestinationReached,
+		Aborted:            res.Aborted,
+		AbortReason:        res.AbortReason,
+		Health:             res.Health,
+		Warnings:           res.Warnings,
+		Analysis:           res.Analysis,
+		History:            res.History,
+		StartedAt:          res.StartedAt,
+		FinishedAt:         res.FinishedAt,
+		Duration:           res.Duration,
+	})
+}
+
+// BinaryReader reads a stream written by BinaryWriter
+type BinaryReader struct {
+	version byte
+	dec     *gob.Decoder
+}
+
+// NewBinaryReader checks the stream header of r
+func NewBinaryReader(r io.Reader) (*BinaryReader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(binaryMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(binaryMagic)]) != binaryMagic {
+		return nil, fmt.Errorf("not a binary mtr result stream")
+	}
+	version := header[len(binaryMagic)]
+	if version == 0 || version > binaryVersion {
+		return nil, fmt.Errorf("unsupported binary result version %d", version)
+	}
+	return &BinaryReader{version: version, dec: gob.NewDecoder(br)}, nil
+}
+
+// Read returns the next result, or io.EOF at the end of the stream
+func (b *BinaryReader) Read() (*Result, error) {
+	var v binaryResultV1
+	if err := b.dec.Decode(&v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid binary result: %v", err)
+	}
+	return &Result{
+		Target:             v.Target,
+		ProbedAddress:      v.ProbedAddress,
+		Labels:             v.Labels,
+		Meta:               v.Meta,
+		ResolvedIPs:        v.ResolvedIPs,
+		DNSResolution:      v.DNSResolution,
+		ProbesRequested:    v.ProbesRequested,
+		ProbesSent:         v.ProbesSent,
+		Hops:               v.Hops,
+		LoopSuspected:      v.LoopSuspected,
+		DestinationReached: v.DestinationReached,
+		Aborted:            v.Aborted,
+		AbortReason:        v.AbortReason,
+		Health:             v.Health,
+		Warnings:           v.Warnings,
+		Analysis:           v.Analysis,
+		History:            v.History,
+		StartedAt:          v.StartedAt,
+		FinishedAt:         v.FinishedAt,
+		Duration:           v.Duration,
+	}, nil
+}
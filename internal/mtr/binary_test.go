@@ -0,0 +1,104 @@
+package mtr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archivedResult is a result as a scheduled trace stores it
+func archivedResult(i int) *Result {
+	started := time.Date(2024, 5, 1, 12, i, 0, 0, time.UTC)
+	res := &Result{
+		Target:             "example.com",
+		Labels:             map[string]string{"site": "ams"},
+		Meta:               &Meta{LocalHostname: "probe-1", StartedAt: started, ToolVersion: "v1.2.3"},
+		ResolvedIPs:        []string{"192.0.2.1"},
+		DNSResolution:      3 * time.Millisecond,
+		ProbesRequested:    10,
+		ProbesSent:         10,
+		DestinationReached: true,
+		Health:             HealthOK,
+		Warnings:           []string{"Hop 2 rate limits ICMP"},
+		Analysis:           Analysis{Classification: ClassDestinationHealthy, Findings: []Finding{{Classification: ClassDestinationHealthy, Hops: []int{3}}}},
+		StartedAt:          started,
+		FinishedAt:         started.Add(10 * time.Second),
+		Duration:           10 * time.Second,
+	}
+	for hop := 1; hop <= 3; hop++ {
+		res.Hops = append(res.Hops, HopData{
+			Hop: hop, IP: fmt.Sprintf("10.0.%d.1", hop), Hostname: fmt.Sprintf("r%d.example.net", hop),
+			Sent: 10, Last: float64(hop), Avg: float64(hop) + 0.5, Best: float64(hop), Worst: float64(hop) + 1,
+		})
+	}
+	return res
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewBinaryWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []*Result
+	for i := 0; i < 3; i++ {
+		res := archivedResult(i)
+		want = append(want, res)
+		if err := w.Write(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewBinaryReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range want {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("result %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, res) {
+			t.Errorf("result %d:\n%+v\nwant\n%+v", i, got, res)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("read past the end: %v, want io.EOF", err)
+	}
+}
+
+func TestBinaryHeader(t *testing.T) {
+	if _, err := NewBinaryReader(strings.NewReader(`{"target": "example.com"}`)); err == nil {
+		t.Error("JSON accepted as a binary stream")
+	}
+	if _, err := NewBinaryReader(strings.NewReader(binaryMagic + "\x02")); err == nil || !strings.Contains(err.Error(), "version 2") {
+		t.Errorf("newer version: %v", err)
+	}
+}
+
+func TestBinarySmallerThanJSON(t *testing.T) {
+	var bin, js bytes.Buffer
+	w, err := NewBinaryWriter(&bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		res := archivedResult(i)
+		if err := w.Write(res); err != nil {
+			t.Fatal(err)
+		}
+		data, err := json.Marshal(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		js.Write(append(data, '\n'))
+	}
+	if bin.Len()*2 > js.Len() {
+		t.Errorf("binary archive %d bytes, JSON lines %d bytes; want under half", bin.Len(), js.Len())
+	}
+}
@@ -0,0 +1,92 @@
+package mtr
+
+import (
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net/netip"
+	"strings"
+)
+
+// CIDRPick selects which address of a subnet target is traced
+type CIDRPick string
+
+const (
+	PickFirst  CIDRPick = "first"  // First usable address, usually the gateway (.1)
+	PickRandom CIDRPick = "random" // A random host address
+)
+
+// Narrowest prefixes accepted as subnet targets; broader ranges are rejected
+const (
+	minCIDRPrefixV4 = 16
+	minCIDRPrefixV6 = 48
+)
+
+// ParseCIDRPick validates a subnet address choice; an empty string is first
+func ParseCIDRPick(pick string) (CIDRPick, error) {
+	switch CIDRPick(pick) {
+	case "":
+		return PickFirst, nil
+	case PickFirst, PickRandom:
+		return CIDRPick(pick), nil
+	}
+	return "", fmt.Errorf("invalid subnet address choice %q (expected first or random)", pick)
+}
+
+// IsCIDR reports whether target is written as a subnet such as 10.1.2.0/24
+func IsCIDR(target string) bool {
+	return strings.Contains(target, "/")
+}
+
+// ParseCIDR validates a subnet target and returns its masked prefix
+func ParseCIDR(target string) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(target)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid subnet %q", target)
+	}
+	min := minCIDRPrefixV4
+	if prefix.Addr().Is6() {
+		min = minCIDRPrefixV6
+	}
+	if prefix.Bits() < min {
+		return netip.Prefix{}, fmt.Errorf("subnet %s is too broad (at least /%d required)", target, min)
+	}
+	return prefix.Masked(), nil
+}
+
+// PickCIDRAddress returns the address of the subnet target to trace
+func PickCIDRAddress(target string, pick CIDRPick) (string, error) {
+	prefix, err := ParseCIDR(target)
+	if err != nil {
+		return "", err
+	}
+	return pickAddress(prefix, pick, rand.Int63).String(), nil
+}
+
+// pickAddress chooses a host address of prefix. The network address and,
+// for IPv4, the broadcast address are skipped when the subnet has room for
+// hosts besides them.
+func pickAddress(prefix netip.Prefix, pick CIDRPick, random func() int64) netip.Addr {
+	network := prefix.Addr()
+	hostBits := network.BitLen() - prefix.Bits()
+	if hostBits <= 1 {
+		return network // /31, /32 and their IPv6 equivalents have no reserved addresses
+	}
+
+	hosts := new(big.Int).Lsh(big.NewInt(1), uint(hostBits))
+	hosts.Sub(hosts, big.NewInt(1)) // skip the network address
+	if network.Is4() {
+		hosts.Sub(hosts, big.NewInt(1)) // skip the broadcast address
+	}
+
+	offset := big.NewInt(1)
+	if pick == PickRandom {
+		offset.Rand(rand.New(rand.NewSource(random())), hosts)
+		offset.Add(offset, big.NewInt(1))
+	}
+
+	base := new(big.Int).SetBytes(network.AsSlice())
+	addr := base.Add(base, offset).FillBytes(make([]byte, network.BitLen()/8))
+	picked, _ := netip.AddrFromSlice(addr)
+	return picked
+}
@@ -0,0 +1,82 @@
+package mtr
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestParseCIDR(t *testing.T) {
+	valid := map[string]string{
+		"10.1.2.0/24":     "10.1.2.0/24",
+		"10.1.2.77/24":    "10.1.2.0/24",
+		"192.0.2.1/32":    "192.0.2.1/32",
+		"10.1.0.0/16":     "10.1.0.0/16",
+		"2001:db8::/64":   "2001:db8::/64",
+		"2001:db8:1::/48": "2001:db8:1::/48",
+	}
+	for target, want := range valid {
+		prefix, err := ParseCIDR(target)
+		if err != nil || prefix.String() != want {
+			t.Errorf("%s: got %s, %v; want %s", target, prefix, err, want)
+		}
+	}
+	for _, target := range []string{"10.0.0.0/8", "2001:db8::/32", "10.1.2.0/33", "example.com/24", "10.1.2.0"} {
+		if _, err := ParseCIDR(target); err == nil {
+			t.Errorf("%s accepted", target)
+		}
+	}
+}
+
+func TestPickAddress(t *testing.T) {
+	first := map[string]string{
+		"10.1.2.0/24":   "10.1.2.1",
+		"10.1.2.8/30":   "10.1.2.9",
+		"10.1.2.8/31":   "10.1.2.8",
+		"192.0.2.1/32":  "192.0.2.1",
+		"2001:db8::/64": "2001:db8::1",
+	}
+	for target, want := range first {
+		if got, err := PickCIDRAddress(target, PickFirst); err != nil || got != want {
+			t.Errorf("%s: first address %s, %v; want %s", target, got, err, want)
+		}
+	}
+
+	// Random picks stay within the host addresses, never the network or
+	// broadcast address
+	prefix := netip.MustParsePrefix("10.1.2.8/30")
+	seen := make(map[string]bool)
+	for seed := int64(0); seed < 50; seed++ {
+		addr := pickAddress(prefix, PickRandom, func() int64 { return seed })
+		if addr.String() != "10.1.2.9" && addr.String() != "10.1.2.10" {
+			t.Fatalf("seed %d picked %s", seed, addr)
+		}
+		seen[addr.String()] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("random picks %v, want both host addresses", seen)
+	}
+}
+
+func TestRunCIDRTarget(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1))
+
+	cfg := testConfig(1)
+	cfg.Hostname = "192.0.2.0/24"
+	if !hasArgs(commandLine(cfg), "--", "192.0.2.1") {
+		t.Errorf("command line %q does not trace the first address", commandLine(cfg))
+	}
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Target != "192.0.2.0/24" || res.ProbedAddress != "192.0.2.1" {
+		t.Errorf("target %s probed %s", res.Target, res.ProbedAddress)
+	}
+	if !strings.Contains(res.Output, "192.0.2.0/24 (probed 192.0.2.1)") {
+		t.Errorf("output does not say which address was probed:\n%s", res.Output)
+	}
+}
@@ -0,0 +1,30 @@
+package mtr
+
+import "fmt"
+
+// collapseHops splits hops into the ones shown at the start and end of the
+// table and the ones in between that are omitted to keep at most max rows.
+// Nothing is omitted when max is 0 or the route already fits.
+func collapseHops(hops []HopData, max int) (head, omitted, tail []HopData) {
+	if max <= 0 || len(hops) <= max {
+		return hops, nil, nil
+	}
+	headLen := (max + 1) / 2
+	tailLen := max - headLen
+	return hops[:headLen], hops[headLen : len(hops)-tailLen], hops[len(hops)-tailLen:]
+}
+
+// formatOmitted describes the collapsed middle of the route, keeping the
+// worst loss visible
+func formatOmitted(omitted []HopData) string {
+	worst := omitted[0]
+	for _, hop := range omitted {
+		if hop.Loss > worst.Loss {
+			worst = hop
+		}
+	}
+	if worst.Loss == 0 {
+		return fmt.Sprintf("... (%d hops omitted, no loss) ...", len(omitted))
+	}
+	return fmt.Sprintf("... (%d hops omitted, worst loss %.1f%% at hop %d) ...", len(omitted), worst.Loss, worst.Hop)
+}
@@ -0,0 +1,68 @@
+package mtr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// longRoute returns n answering hops
+func longRoute(n int) []HopData {
+	hops := make([]HopData, n)
+	for i := range hops {
+		hops[i] = HopData{Hop: i + 1, IP: fmt.Sprintf("10.0.0.%d", i+1), Hostname: fmt.Sprintf("r%d.example.net", i+1), Sent: 10}
+	}
+	return hops
+}
+
+func TestCollapseHops(t *testing.T) {
+	tests := []struct {
+		hops, max                 int
+		head, omitted, tail, from int
+	}{
+		{hops: 20, max: 6, head: 3, omitted: 14, tail: 3, from: 4},
+		{hops: 20, max: 5, head: 3, omitted: 15, tail: 2, from: 4},
+		{hops: 20, max: 1, head: 1, omitted: 19, tail: 0, from: 2},
+		{hops: 6, max: 6, head: 6},
+		{hops: 6, max: 0, head: 6},
+	}
+	for _, tt := range tests {
+		head, omitted, tail := collapseHops(longRoute(tt.hops), tt.max)
+		if len(head) != tt.head || len(omitted) != tt.omitted || len(tail) != tt.tail {
+			t.Errorf("%d hops at most %d: %d/%d/%d, want %d/%d/%d", tt.hops, tt.max,
+				len(head), len(omitted), len(tail), tt.head, tt.omitted, tt.tail)
+			continue
+		}
+		if tt.omitted > 0 && omitted[0].Hop != tt.from {
+			t.Errorf("%d hops at most %d: omitted from hop %d, want %d", tt.hops, tt.max, omitted[0].Hop, tt.from)
+		}
+		if tt.tail > 0 && tail[len(tail)-1].Hop != tt.hops {
+			t.Errorf("%d hops at most %d: the last hop is not shown", tt.hops, tt.max)
+		}
+	}
+}
+
+func TestMaxDisplayHops(t *testing.T) {
+	hops := longRoute(12)
+	hops[5].Loss = 20
+	hops[7].Loss = 40
+
+	table := colorizeOutput(hops, Config{MaxDisplayHops: 4, NoColor: true})
+	if !strings.Contains(table, "... (8 hops omitted, worst loss 40.0% at hop 8) ...") {
+		t.Errorf("table lacks the summary of the omitted hops:\n%s", table)
+	}
+	for _, shown := range []string{"r1.", "r2.", "r11.", "r12."} {
+		if !strings.Contains(table, shown) {
+			t.Errorf("table lacks %s:\n%s", shown, table)
+		}
+	}
+	for _, hidden := range []string{"r3.", "r10."} {
+		if strings.Contains(table, hidden) {
+			t.Errorf("table shows the omitted %s:\n%s", hidden, table)
+		}
+	}
+
+	if got := formatOmitted(longRoute(3)); got != "... (3 hops omitted, no loss) ..." {
+		t.Errorf("omitted without loss: %q", got)
+	}
+}
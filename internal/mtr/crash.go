@@ -0,0 +1,55 @@
+package mtr
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+)
+
+// CrashError reports that mtr was killed by a signal (e.g. a segfault)
+// rather than exiting with an error of its own
+type CrashError struct {
+	Signal syscall.Signal
+	// Output is whatever mtr printed before it died
+	Output string
+}
+
+func (e *CrashError) Error() string {
+	return "mtr terminated by signal " + signalName(e.Signal)
+}
+
+// signalNames covers the signals a crashing or killed mtr usually dies from
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGSEGV: "SIGSEGV",
+	syscall.SIGABRT: "SIGABRT",
+	syscall.SIGBUS:  "SIGBUS",
+	syscall.SIGILL:  "SIGILL",
+	syscall.SIGFPE:  "SIGFPE",
+	syscall.SIGKILL: "SIGKILL",
+	syscall.SIGTERM: "SIGTERM",
+	syscall.SIGINT:  "SIGINT",
+	syscall.SIGHUP:  "SIGHUP",
+}
+
+func signalName(sig syscall.Signal) string {
+	if name, ok := signalNames[sig]; ok {
+		return name
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
+
+// crashError returns a *CrashError when err shows the process was terminated
+// by a signal. sudo re-raises the signal that killed mtr, so this works
+// through it.
+func crashError(err error, output string) (*CrashError, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ProcessState == nil {
+		return nil, false
+	}
+	status, ok := exitErr.ProcessState.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return nil, false
+	}
+	return &CrashError{Signal: status.Signal(), Output: output}, true
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package mtr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRunReportsCrash(t *testing.T) {
+	fakeMTRScript(t, "echo 'h 0 10.0.0.1'\necho 'mtr: internal error' >&2\nkill -SEGV $$\n")
+
+	_, err := Run(context.Background(), testConfig(1))
+	var crash *CrashError
+	if !errors.As(err, &crash) {
+		t.Fatalf("Run error = %v, want a *CrashError", err)
+	}
+	if crash.Signal != syscall.SIGSEGV || err.Error() != "mtr terminated by signal SIGSEGV" {
+		t.Errorf("error %q for signal %d", err, crash.Signal)
+	}
+	if !strings.Contains(crash.Output, "mtr: internal error") {
+		t.Errorf("crash output %q lacks what mtr printed", crash.Output)
+	}
+}
+
+func TestRunExitStatusIsNotCrash(t *testing.T) {
+	fakeMTRScript(t, "echo 'mtr: bad option' >&2\nexit 1\n")
+
+	_, err := Run(context.Background(), testConfig(1))
+	var crash *CrashError
+	if err == nil || errors.As(err, &crash) {
+		t.Errorf("Run error = %v, want a plain failure", err)
+	}
+}
+
+func TestSignalName(t *testing.T) {
+	if got := signalName(syscall.SIGABRT); got != "SIGABRT" {
+		t.Errorf("SIGABRT named %q", got)
+	}
+	if got := signalName(syscall.Signal(63)); got != "signal 63" {
+		t.Errorf("unnamed signal named %q", got)
+	}
+}
@@ -0,0 +1,234 @@
+package mtr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SignificantLatencyDelta is the change in a hop's average latency, in ms,
+// that FormatDiff highlights
+const SignificantLatencyDelta = 10.0
+
+// HopChange describes how a hop differs between two results
+type HopChange string
+
+const (
+	HopUnchanged HopChange = "unchanged" // Same hop at the same TTL
+	HopMoved     HopChange = "moved"     // Same IP found at a different TTL
+	HopChanged   HopChange = "changed"   // Different IP answering at the same TTL
+	HopAdded     HopChange = "added"     // Hop only present in the new result
+	HopRemoved   HopChange = "removed"   // Hop only present in the old result
+)
+
+// HopDiff compares one hop across two results. Deltas are new minus old and
+// are only set when the hop exists in both.
+type HopDiff struct {
+	Change HopChange `json:"change"`
+	OldHop int       `json:"old_hop,omitempty"`
+	NewHop int       `json:"new_hop,omitempty"`
+	OldIP  string    `json:"old_ip,omitempty"`
+	NewIP  string    `json:"new_ip,omitempty"`
+
+	LossDelta  float64 `json:"loss_delta"`
+	AvgDelta   float64 `json:"avg_delta"`
+	BestDelta  float64 `json:"best_delta"`
+	WorstDelta float64 `json:"worst_delta"`
+}
+
+// ResultDiff is the comparison of two results of the same target
+type ResultDiff struct {
+	OldTarget string    `json:"old_target"`
+	NewTarget string    `json:"new_target"`
+	Hops      []HopDiff `json:"hops"`
+
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Moved   int `json:"moved"`
+	Changed int `json:"changed"`
+
+	// PathChanged is set when any known hop was added, removed, moved or replaced
+	PathChanged bool `json:"path_changed"`
+	// EndToEndLossDelta and EndToEndAvgDelta compare the final hops
+	EndToEndLossDelta float64 `json:"end_to_end_loss_delta"`
+	EndToEndAvgDelta  float64 `json:"end_to_end_avg_delta"`
+}
+
+// DiffResults compares two results hop by hop. Hops are matched by IP first
+// so a hop that moved TTL is still recognised; hops that could not be matched
+// by IP fall back to matching by hop number.
+func DiffResults(old, new Result) ResultDiff {
+	diff := ResultDiff{OldTarget: old.Target, NewTarget: new.Target}
+
+	oldMatched := make([]bool, len(old.Hops))
+	newMatched := make([]bool, len(new.Hops))
+
+	// Match by IP
+	for ni, nh := range new.Hops {
+		if nh.IP == "" {
+			continue
+		}
+		for oi, oh := range old.Hops {
+			if !oldMatched[oi] && oh.IP == nh.IP {
+				change := HopUnchanged
+				if oh.Hop != nh.Hop {
+					change = HopMoved
+				}
+				diff.Hops = append(diff.Hops, compareHops(change, oh, nh))
+				oldMatched[oi], newMatched[ni] = true, true
+				break
+			}
+		}
+	}
+
+	// Fall back to matching the remaining hops by hop number
+	for ni, nh := range new.Hops {
+		if newMatched[ni] {
+			continue
+		}
+		for oi, oh := range old.Hops {
+			if oldMatched[oi] || oh.Hop != nh.Hop {
+				continue
+			}
+			change := HopUnchanged
+			if oh.IP != "" && nh.IP != "" && oh.IP != nh.IP {
+				change = HopChanged
+			}
+			diff.Hops = append(diff.Hops, compareHops(change, oh, nh))
+			oldMatched[oi], newMatched[ni] = true, true
+			break
+		}
+	}
+
+	for ni, nh := range new.Hops {
+		if !newMatched[ni] {
+			diff.Hops = append(diff.Hops, HopDiff{Change: HopAdded, NewHop: nh.Hop, NewIP: nh.IP})
+		}
+	}
+	for oi, oh := range old.Hops {
+		if !oldMatched[oi] {
+			diff.Hops = append(diff.Hops, HopDiff{Change: HopRemoved, OldHop: oh.Hop, OldIP: oh.IP})
+		}
+	}
+
+	// Order by position in the new route, with removed hops at their old position
+	sort.SliceStable(diff.Hops, func(i, j int) bool {
+		return diff.Hops[i].position() < diff.Hops[j].position()
+	})
+
+	for _, hd := range diff.Hops {
+		switch hd.Change {
+		case HopAdded:
+			diff.Added++
+		case HopRemoved:
+			diff.Removed++
+		case HopMoved:
+			diff.Moved++
+		case HopChanged:
+			diff.Changed++
+		}
+	}
+	diff.PathChanged = diff.Added+diff.Removed+diff.Moved+diff.Changed > 0
+
+	if len(old.Hops) > 0 && len(new.Hops) > 0 {
+		oldLast, newLast := old.Hops[len(old.Hops)-1], new.Hops[len(new.Hops)-1]
+		diff.EndToEndLossDelta = newLast.Loss - oldLast.Loss
+		diff.EndToEndAvgDelta = newLast.Avg - oldLast.Avg
+	}
+
+	return diff
+}
+
+func compareHops(change HopChange, oh, nh HopData) HopDiff {
+	return HopDiff{
+		Change:     change,
+		OldHop:     oh.Hop,
+		NewHop:     nh.Hop,
+		OldIP:      oh.IP,
+		NewIP:      nh.IP,
+		LossDelta:  nh.Loss - oh.Loss,
+		AvgDelta:   nh.Avg - oh.Avg,
+		BestDelta:  nh.Best - oh.Best,
+		WorstDelta: nh.Worst - oh.Worst,
+	}
+}
+
+// position is the hop number used to order a diff entry
+func (d HopDiff) position() int {
+	if d.NewHop != 0 {
+		return d.NewHop
+	}
+	return d.OldHop
+}
+
+// FormatDiff renders a comparison side by side: every hop's old and new IP
+// with its loss and latency deltas, hops that were added, removed or
+// replaced in red, moved hops and significant latency changes in yellow
+func FormatDiff(diff ResultDiff, cfg Config) string {
+	var out strings.Builder
+	target := diff.NewTarget
+	if diff.OldTarget != diff.NewTarget {
+		target = fmt.Sprintf("%s -> %s", diff.OldTarget, diff.NewTarget)
+	}
+	out.WriteString(fmt.Sprintf("Path Comparison: %s\n\n", target))
+
+	label := cfg.unknownHostLabel()
+	ipWidth := len("Old IP")
+	for _, hd := range diff.Hops {
+		for _, addr := range []string{hd.OldIP, hd.NewIP, label} {
+			if len(addr) > ipWidth {
+				ipWidth = len(addr)
+			}
+		}
+	}
+	out.WriteString(fmt.Sprintf("%-9s %-*s %-*s %-10s %8s %9s\n",
+		"Hop", ipWidth, "Old IP", ipWidth, "New IP", "Change", "Loss Δ", "Avg Δ"))
+	out.WriteString(strings.Repeat("-", 2*ipWidth+42) + "\n")
+
+	ip := func(hop int, addr string) string {
+		switch {
+		case hop == 0:
+			return "-"
+		case addr == "":
+			return label
+		}
+		return addr
+	}
+	for _, hd := range diff.Hops {
+		hop := fmt.Sprint(hd.position())
+		if hd.Change == HopMoved {
+			hop = fmt.Sprintf("%d -> %d", hd.OldHop, hd.NewHop)
+		}
+		changeColor := ""
+		switch hd.Change {
+		case HopAdded, HopRemoved, HopChanged:
+			changeColor = colorRed
+		case HopMoved:
+			changeColor = colorYellow
+		}
+		out.WriteString(fmt.Sprintf("%-9s %-*s %-*s ",
+			hop, ipWidth, ip(hd.OldHop, hd.OldIP), ipWidth, ip(hd.NewHop, hd.NewIP)))
+		if hd.Change == HopAdded || hd.Change == HopRemoved {
+			// There is nothing to compare the hop with
+			out.WriteString(fmt.Sprintf("%s%s%s\n", changeColor, hd.Change, colorReset))
+			continue
+		}
+		out.WriteString(fmt.Sprintf("%s%-10s%s", changeColor, hd.Change, colorReset))
+		avgColor, avgReset := "", ""
+		if hd.AvgDelta >= SignificantLatencyDelta || hd.AvgDelta <= -SignificantLatencyDelta {
+			avgColor, avgReset = colorYellow, colorReset
+		}
+		out.WriteString(fmt.Sprintf(" %+8.1f %s%+9.1f%s\n", hd.LossDelta, avgColor, hd.AvgDelta, avgReset))
+	}
+
+	out.WriteString("\n")
+	if diff.PathChanged {
+		out.WriteString(fmt.Sprintf("%sPath changed:%s %d added, %d removed, %d moved, %d replaced\n",
+			colorRed, colorReset, diff.Added, diff.Removed, diff.Moved, diff.Changed))
+	} else {
+		out.WriteString("Path unchanged\n")
+	}
+	out.WriteString(fmt.Sprintf("End-to-end: loss %+.1f points, average latency %+.1f ms\n",
+		diff.EndToEndLossDelta, diff.EndToEndAvgDelta))
+	return cfg.withColors(out.String())
+}
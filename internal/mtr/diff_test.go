@@ -0,0 +1,87 @@
+package mtr
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// traced is a result of the route through ips; "" is a silent hop
+func traced(ips ...string) Result {
+	return Result{Target: "example.com", Hops: route(ips...)}
+}
+
+// describe lists the changes of a diff as change:old>new hop numbers
+func describe(diff ResultDiff) string {
+	var parts []string
+	for _, hd := range diff.Hops {
+		parts = append(parts, fmt.Sprintf("%s:%d>%d", hd.Change, hd.OldHop, hd.NewHop))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestDiffResults(t *testing.T) {
+	tests := []struct {
+		name        string
+		old, new    Result
+		want        string
+		pathChanged bool
+	}{
+		{"same path", traced("A", "B", "C"), traced("A", "B", "C"),
+			"unchanged:1>1 unchanged:2>2 unchanged:3>3", false},
+		{"hop inserted, later hops moved", traced("A", "B", "C"), traced("A", "X", "B", "C"),
+			"unchanged:1>1 added:0>2 moved:2>3 moved:3>4", true},
+		{"hop disappeared", traced("A", "B", "C"), traced("A", "C"),
+			"unchanged:1>1 moved:3>2 removed:2>0", true},
+		{"other router at the same TTL", traced("A", "B", "C"), traced("A", "D", "C"),
+			"unchanged:1>1 changed:2>2 unchanged:3>3", true},
+		{"silent hops matched by number", traced("A", "", "C"), traced("A", "", "C"),
+			"unchanged:1>1 unchanged:2>2 unchanged:3>3", false},
+		{"hop went silent", traced("A", "B", "C"), traced("A", "", "C"),
+			"unchanged:1>1 unchanged:2>2 unchanged:3>3", false},
+		{"path extended", traced("A", "B"), traced("A", "B", "C"),
+			"unchanged:1>1 unchanged:2>2 added:0>3", true},
+		{"path shortened", traced("A", "B", "C"), traced("A", "B"),
+			"unchanged:1>1 unchanged:2>2 removed:3>0", true},
+	}
+	for _, tt := range tests {
+		diff := DiffResults(tt.old, tt.new)
+		if got := describe(diff); got != tt.want {
+			t.Errorf("%s: %s, want %s", tt.name, got, tt.want)
+		}
+		if diff.PathChanged != tt.pathChanged {
+			t.Errorf("%s: path changed %v, want %v", tt.name, diff.PathChanged, tt.pathChanged)
+		}
+	}
+}
+
+func TestDiffResultsCounts(t *testing.T) {
+	// X and D take TTLs whose old hops moved on, so they cannot replace them
+	diff := DiffResults(traced("A", "B", "C", "E"), traced("A", "X", "B", "D", "E"))
+	if diff.Added != 2 || diff.Moved != 2 || diff.Changed != 0 || diff.Removed != 1 {
+		t.Errorf("added %d moved %d changed %d removed %d, want 2, 2, 0 and 1: %s",
+			diff.Added, diff.Moved, diff.Changed, diff.Removed, describe(diff))
+	}
+}
+
+func TestDiffResultsDeltas(t *testing.T) {
+	old, new := traced("A", "B"), traced("A", "B")
+	old.Hops[1].Loss, old.Hops[1].Avg, old.Hops[1].Best, old.Hops[1].Worst = 10, 20, 15, 30
+	new.Hops[1].Loss, new.Hops[1].Avg, new.Hops[1].Best, new.Hops[1].Worst = 0, 35, 14, 50
+
+	diff := DiffResults(old, new)
+	hd := diff.Hops[1]
+	if hd.LossDelta != -10 || hd.AvgDelta != 15 || hd.BestDelta != -1 || hd.WorstDelta != 20 {
+		t.Errorf("deltas %+v", hd)
+	}
+	if diff.EndToEndLossDelta != -10 || diff.EndToEndAvgDelta != 15 {
+		t.Errorf("end-to-end deltas %v and %v, want -10 and 15", diff.EndToEndLossDelta, diff.EndToEndAvgDelta)
+	}
+
+	// Added and removed hops have nothing to compare
+	for _, hd := range DiffResults(traced("A"), traced("A", "B")).Hops {
+		if hd.Change == HopAdded && (hd.LossDelta != 0 || hd.AvgDelta != 0) {
+			t.Errorf("added hop with deltas %+v", hd)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package mtr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultEnrichTimeout bounds how long an enrichment command may run
+const DefaultEnrichTimeout = 10 * time.Second
+
+// Enricher adds annotations (e.g. owner or device name from an inventory)
+// to hops. It returns annotations keyed by hop IP.
+type Enricher interface {
+	Enrich(ctx context.Context, hops []HopData) (map[string]map[string]string, error)
+}
+
+// CommandEnricher runs an external command that reads the hops as a JSON
+// array on stdin and writes a JSON object on stdout mapping IPs to
+// annotations, e.g. {"10.0.0.1": {"owner": "netops"}}
+type CommandEnricher struct {
+	Path    string
+	Timeout time.Duration
+}
+
+// Enrich runs the command with the hops as input
+func (c CommandEnricher) Enrich(ctx context.Context, hops []HopData) (map[string]map[string]string, error) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultEnrichTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	input, err := json.Marshal(hops)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("enrichment command timed out after %s", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("enrichment command failed: %v: %s", err, msg)
+		}
+		return nil, fmt.Errorf("enrichment command failed: %v", err)
+	}
+
+	var annotations map[string]map[string]string
+	if err := json.Unmarshal(stdout.Bytes(), &annotations); err != nil {
+		return nil, fmt.Errorf("invalid enrichment output: %v", err)
+	}
+	return annotations, nil
+}
+
+// enrichHops merges the annotations of every enricher into hops. Enrichers
+// that fail only produce a warning on res.
+func enrichHops(ctx context.Context, enrichers []Enricher, hops []HopData, res *Result) {
+	for _, e := range enrichers {
+		annotations, err := e.Enrich(ctx, hops)
+		if err != nil {
+			res.Warnings = append(res.Warnings, "Enrichment failed: "+err.Error())
+			continue
+		}
+		for i := range hops {
+			for key, value := range annotations[hops[i].IP] {
+				if hops[i].Annotations == nil {
+					hops[i].Annotations = make(map[string]string)
+				}
+				hops[i].Annotations[key] = value
+			}
+		}
+	}
+	for i := range hops {
+		// Name the hops mtr left as bare addresses, e.g. with -n
+		if ptr := hops[i].Annotations["ptr"]; ptr != "" && hops[i].Hostname == hops[i].IP {
+			hops[i].Hostname = ptr
+		}
+		hops[i].ASN = hops[i].Annotations["asn"]
+		hops[i].ASName = hops[i].Annotations["as_name"]
+		hops[i].Country = hops[i].Annotations["country"]
+		hops[i].City = hops[i].Annotations["city"]
+	}
+}
+
+// hasASN reports whether the origin AS of any hop is known, so the tables
+// add an AS column
+func hasASN(hops []HopData) bool {
+	for _, hop := range hops {
+		if hop.ASN != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatAS renders the origin AS of a hop, e.g. "AS13335 CLOUDFLARENET, US"
+func formatAS(hop HopData) string {
+	return strings.TrimSpace(hop.ASN + " " + hop.ASName)
+}
+
+// hasLocation reports whether the location of any hop is known, so the
+// tables add a location column
+func hasLocation(hops []HopData) bool {
+	for _, hop := range hops {
+		if hop.Country != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLocation renders the location of a hop, e.g. "Amsterdam, NL"
+func formatLocation(hop HopData) string {
+	if hop.City == "" {
+		return hop.Country
+	}
+	return hop.City + ", " + hop.Country
+}
+
+// textColumnWidth returns the width of a table column holding value for
+// every hop under title
+func textColumnWidth(hops []HopData, title string, value func(HopData) string) int {
+	width := utf8.RuneCountInString(title)
+	for _, hop := range hops {
+		if n := utf8.RuneCountInString(value(hop)); n > width {
+			width = n
+		}
+	}
+	return width
+}
+
+// formatAnnotations renders the annotations of a hop as sorted key=value pairs
+func formatAnnotations(annotations map[string]string) string {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + annotations[key]
+	}
+	return strings.Join(pairs, ", ")
+}
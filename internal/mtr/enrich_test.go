@@ -0,0 +1,93 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enrichScript writes an enrichment command running body
+func enrichScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "enrich")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunCommandEnricher(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1))
+	input := filepath.Join(t.TempDir(), "input.json")
+	script := enrichScript(t, "cat > "+input+"\n"+
+		`echo '{"10.0.0.1": {"owner": "netops", "device": "core-sw-1"}, "198.51.100.1": {"owner": "nobody"}}'`+"\n")
+
+	cfg := testConfig(1)
+	cfg.NoColor = true
+	cfg.Enrichers = []Enricher{CommandEnricher{Path: script}}
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// The command got the parsed hops
+	data, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hops []HopData
+	if err := json.Unmarshal(data, &hops); err != nil {
+		t.Fatalf("enrichment input %s: %v", data, err)
+	}
+	if len(hops) != 2 || hops[0].IP != "10.0.0.1" || hops[1].IP != "192.0.2.1" {
+		t.Errorf("enrichment input %s", data)
+	}
+
+	if got := res.Hops[0].Annotations; got["owner"] != "netops" || got["device"] != "core-sw-1" {
+		t.Errorf("hop 1 annotations %v", got)
+	}
+	if res.Hops[1].Annotations != nil {
+		t.Errorf("hop 2 annotated with %v", res.Hops[1].Annotations)
+	}
+	if !strings.Contains(res.Output, "Hop 1 (10.0.0.1): device=core-sw-1, owner=netops") {
+		t.Errorf("output lacks the annotations:\n%s", res.Output)
+	}
+}
+
+func TestCommandEnricherFailures(t *testing.T) {
+	hops := route("10.0.0.1")
+	tests := []struct {
+		name    string
+		body    string
+		timeout time.Duration
+		want    string
+	}{
+		{"exit status", "echo 'inventory unavailable' >&2\nexit 3\n", 0, "enrichment command failed: exit status 3: inventory unavailable"},
+		{"invalid output", "echo 'not json'\n", 0, "invalid enrichment output"},
+		{"timeout", "exec sleep 5\n", 200 * time.Millisecond, "enrichment command timed out after 200ms"},
+	}
+	for _, tt := range tests {
+		e := CommandEnricher{Path: enrichScript(t, tt.body), Timeout: tt.timeout}
+		start := time.Now()
+		_, err := e.Enrich(context.Background(), hops)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+		if time.Since(start) > 3*time.Second {
+			t.Errorf("%s: took %s", tt.name, time.Since(start))
+		}
+	}
+
+	// A failing enricher only warns
+	res := &Result{}
+	enrichHops(context.Background(), []Enricher{CommandEnricher{Path: enrichScript(t, "exit 1\n")}}, hops, res)
+	if len(res.Warnings) != 1 || !strings.HasPrefix(res.Warnings[0], "Enrichment failed: ") {
+		t.Errorf("warnings %q", res.Warnings)
+	}
+}
@@ -0,0 +1,90 @@
+package mtr
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// geoAnnotationKeys are the annotation keys, as set by an enricher, that
+// hold a hop's latitude and longitude
+var geoAnnotationKeys = [][2]string{
+	{"lat", "lon"},
+	{"latitude", "longitude"},
+}
+
+// hopLocation returns the position of a hop from its annotations
+func hopLocation(hop HopData) (lat, lon float64, ok bool) {
+	for _, keys := range geoAnnotationKeys {
+		latStr, latOK := hop.Annotations[keys[0]]
+		lonStr, lonOK := hop.Annotations[keys[1]]
+		if !latOK || !lonOK {
+			continue
+		}
+		lat, err1 := strconv.ParseFloat(latStr, 64)
+		lon, err2 := strconv.ParseFloat(lonStr, 64)
+		if err1 != nil || err2 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			return 0, 0, false
+		}
+		return lat, lon, true
+	}
+	return 0, 0, false
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// formatGeoJSON renders the route as a GeoJSON FeatureCollection with a Point
+// for every hop that has a location and a LineString connecting them in hop
+// order. Hops without a location are left out.
+func formatGeoJSON(res *Result, cfg Config) string {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	var path [][2]float64
+	for _, hop := range res.Hops {
+		lat, lon, ok := hopLocation(hop)
+		if !ok {
+			continue
+		}
+		point := [2]float64{lon, lat} // GeoJSON positions are longitude first
+		path = append(path, point)
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "Point", Coordinates: point},
+			Properties: map[string]any{
+				"hop":      hop.Hop,
+				"ip":       hop.IP,
+				"host":     displayHost(hop, cfg.unknownHostLabel()),
+				"loss":     hop.Loss,
+				"avg_ms":   hop.Avg,
+				"best_ms":  hop.Best,
+				"worst_ms": hop.Worst,
+			},
+		})
+	}
+	if len(path) >= 2 {
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: path},
+			Properties: map[string]any{
+				"target":              res.Target,
+				"health":              res.Health,
+				"destination_reached": res.DestinationReached,
+			},
+		})
+	}
+
+	data, _ := json.MarshalIndent(collection, "", "  ")
+	return string(data)
+}
@@ -0,0 +1,80 @@
+package mtr
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// geoJSONDoc is the structure of a GeoJSON FeatureCollection
+type geoJSONDoc struct {
+	Type     string `json:"type"`
+	Features []struct {
+		Type     string `json:"type"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]any `json:"properties"`
+	} `json:"features"`
+}
+
+// compact removes the indentation of a JSON value
+func compact(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestFormatGeoJSON(t *testing.T) {
+	res := traced("10.0.0.1", "198.51.100.1", "203.0.113.1", "192.0.2.1")
+	res.Health = HealthOK
+	res.DestinationReached = true
+	res.Hops[1].Annotations = map[string]string{"lat": "52.37", "lon": "4.89"}
+	res.Hops[1].Loss, res.Hops[1].Avg = 10, 8.5
+	res.Hops[2].Annotations = map[string]string{"lat": "north", "lon": "4.89"} // unusable
+	res.Hops[3].Annotations = map[string]string{"latitude": "50.11", "longitude": "8.68"}
+
+	var doc geoJSONDoc
+	if err := json.Unmarshal([]byte(formatGeoJSON(&res, Config{})), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != "FeatureCollection" || len(doc.Features) != 3 {
+		t.Fatalf("%s with %d features, want a FeatureCollection of 2 points and a line", doc.Type, len(doc.Features))
+	}
+
+	wantPoints := []struct {
+		hop    float64
+		coords string
+	}{{2, "[4.89,52.37]"}, {4, "[8.68,50.11]"}}
+	for i, want := range wantPoints {
+		f := doc.Features[i]
+		if f.Type != "Feature" || f.Geometry.Type != "Point" || compact(t, f.Geometry.Coordinates) != want.coords {
+			t.Errorf("feature %d: %s %s %s, want a Point at %s", i, f.Type, f.Geometry.Type, f.Geometry.Coordinates, want.coords)
+		}
+		if f.Properties["hop"] != want.hop {
+			t.Errorf("feature %d: hop %v, want %v", i, f.Properties["hop"], want.hop)
+		}
+	}
+	if p := doc.Features[0].Properties; p["loss"] != 10.0 || p["avg_ms"] != 8.5 || p["ip"] != "198.51.100.1" {
+		t.Errorf("point properties %v", p)
+	}
+
+	line := doc.Features[2]
+	if line.Geometry.Type != "LineString" || compact(t, line.Geometry.Coordinates) != "[[4.89,52.37],[8.68,50.11]]" {
+		t.Errorf("line %s %s", line.Geometry.Type, line.Geometry.Coordinates)
+	}
+	if line.Properties["target"] != "example.com" || line.Properties["destination_reached"] != true {
+		t.Errorf("line properties %v", line.Properties)
+	}
+
+	// No located hops leaves an empty collection, not null
+	empty := traced("10.0.0.1")
+	var emptyDoc geoJSONDoc
+	if err := json.Unmarshal([]byte(formatGeoJSON(&empty, Config{})), &emptyDoc); err != nil || emptyDoc.Features == nil || len(emptyDoc.Features) != 0 {
+		t.Errorf("empty route: %v, %+v", err, emptyDoc)
+	}
+}
@@ -0,0 +1,79 @@
+package mtr
+
+// Thresholds used for coloring and for the overall health verdict
+const (
+	lossWarnThreshold    = 5.0   // Loss% above which a hop is degraded
+	lossHighThreshold    = 20.0  // Loss% above which a hop is poor
+	latencyHighThreshold = 100.0 // Average latency (ms) considered high
+)
+
+// Health is the overall verdict for a traced path
+type Health string
+
+const (
+	HealthOK       Health = "OK"
+	HealthDegraded Health = "Degraded"
+	HealthPoor     Health = "Poor"
+)
+
+// evaluateHealth judges the path from the worst relevant loss and the
+// destination's average latency
+func evaluateHealth(hops []HopData, cfg Config) Health {
+	if len(hops) == 0 {
+		return HealthPoor
+	}
+	dest := cfg.destination(hops)
+
+	loss := dest.Loss
+	if !cfg.DestinationLossOnly {
+		for _, hop := range hops {
+			if !cfg.lossIgnored(hop) && !cfg.beyondDestination(hop) && hop.Loss > loss {
+				loss = hop.Loss
+			}
+		}
+	}
+
+	switch {
+	case loss > lossHighThreshold:
+		return HealthPoor
+	case loss > lossWarnThreshold || dest.Avg >= latencyHighThreshold:
+		return HealthDegraded
+	default:
+		return HealthOK
+	}
+}
+
+// destination returns the hop judged as the destination: the one
+// Config.DestinationHop names, or else the final hop
+func (c Config) destination(hops []HopData) HopData {
+	if c.DestinationHop > 0 {
+		for _, hop := range hops {
+			if hop.Hop == c.DestinationHop {
+				return hop
+			}
+		}
+	}
+	return hops[len(hops)-1]
+}
+
+// beyondDestination reports whether hop comes after Config.DestinationHop,
+// so its loss is disregarded
+func (c Config) beyondDestination(hop HopData) bool {
+	return c.DestinationHop > 0 && hop.Hop > c.DestinationHop
+}
+
+// hasHop reports whether hops include the hop numbered n
+func hasHop(hops []HopData, n int) bool {
+	for _, hop := range hops {
+		if hop.Hop == n {
+			return true
+		}
+	}
+	return false
+}
+
+// lossIgnored reports whether the loss of an early hop is disregarded by
+// Config.IgnoreLossBeforeHop
+func (c Config) lossIgnored(hop HopData) bool {
+	return hop.Hop <= c.IgnoreLossBeforeHop
+}
@@ -0,0 +1,90 @@
+package mtr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHealthDestinationHop(t *testing.T) {
+	hops := firewalledRoute()
+	if got := evaluateHealth(hops, Config{}); got != HealthPoor {
+		t.Errorf("health without a destination hop %s, want Poor", got)
+	}
+	if got := evaluateHealth(hops, Config{DestinationHop: 2}); got != HealthOK {
+		t.Errorf("health with destination hop 2 %s, want OK", got)
+	}
+
+	// The designated hop's latency is the destination's
+	hops[1].Avg = 150
+	if got := evaluateHealth(hops, Config{DestinationHop: 2}); got != HealthDegraded {
+		t.Errorf("health with a slow destination hop %s, want Degraded", got)
+	}
+}
+
+func TestHealthDestinationLossOnly(t *testing.T) {
+	// Intermediate routers rate-limiting their ICMP replies
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Avg: 1},
+		{Hop: 2, IP: "10.0.0.2", Loss: 60, Avg: 8},
+		{Hop: 3, IP: "10.0.0.3", Loss: 100},
+		{Hop: 4, IP: "192.0.2.1", Avg: 20},
+	}
+	if got := evaluateHealth(hops, Config{}); got != HealthPoor {
+		t.Errorf("health %s, want Poor from the intermediate loss", got)
+	}
+	if got := evaluateHealth(hops, Config{DestinationLossOnly: true}); got != HealthOK {
+		t.Errorf("health with DestinationLossOnly %s, want OK", got)
+	}
+
+	// Loss at the destination still counts
+	hops[3].Loss = 10
+	if got := evaluateHealth(hops, Config{DestinationLossOnly: true}); got != HealthDegraded {
+		t.Errorf("health with destination loss %s, want Degraded", got)
+	}
+}
+
+func TestIgnoreLossBeforeHop(t *testing.T) {
+	// The local gateway and the next hop drop probes, as they often do
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "gw.local", Sent: 10, Loss: 50, Avg: 1},
+		{Hop: 2, IP: "10.0.0.2", Hostname: "isp.example.net", Sent: 10, Loss: 30, Avg: 5},
+		{Hop: 3, IP: "192.0.2.1", Hostname: "dest.example.com", Sent: 10, Avg: 20},
+	}
+	cfg := Config{IgnoreLossBeforeHop: 2}
+
+	table := colorizeOutput(hops, cfg)
+	rows := make(map[string]string)
+	for _, line := range strings.Split(table, "\n") {
+		for _, hop := range hops {
+			if strings.Contains(line, hop.Hostname) {
+				rows[hop.Hostname] = line
+			}
+		}
+	}
+	for _, host := range []string{"gw.local", "isp.example.net"} {
+		if strings.Contains(rows[host], colorRed) || strings.Contains(rows[host], colorYellow) {
+			t.Errorf("row of %s colored for its loss: %q", host, rows[host])
+		}
+	}
+	if !strings.Contains(rows["gw.local"], "50.0") || !strings.Contains(rows["isp.example.net"], "30.0") {
+		t.Errorf("loss numbers not shown:\n%s", table)
+	}
+	// Without the option the same loss is red
+	if !strings.Contains(colorizeOutput(hops, Config{}), colorRed) {
+		t.Error("loss not colored red without the option")
+	}
+
+	if got := evaluateHealth(hops, cfg); got != HealthOK {
+		t.Errorf("health %s, want OK with the early loss ignored", got)
+	}
+	if got := evaluateHealth(hops, Config{}); got != HealthPoor {
+		t.Errorf("health %s without the option, want Poor", got)
+	}
+	limits := Thresholds{Loss: 10}
+	if breaches := limits.Check(&Result{Hops: hops}, cfg); len(breaches) != 0 {
+		t.Errorf("breaches %q from ignored hops", breaches)
+	}
+	if breaches := limits.Check(&Result{Hops: hops}, Config{}); len(breaches) != 2 {
+		t.Errorf("breaches %q without the option, want hops 1 and 2", breaches)
+	}
+}
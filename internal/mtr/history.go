@@ -0,0 +1,88 @@
+package mtr
+
+import (
+	"fmt"
+	"sort"
+)
+
+// minHistorySamples is how many stored traces a target needs before the
+// current trace is compared against them
+const minHistorySamples = 5
+
+// History stores the end-to-end metrics of past traces so new traces can be
+// compared against them
+type History interface {
+	// Baseline returns the destination latency (ms) and loss (%) of the
+	// target's recent traces
+	Baseline(target string) (latency, loss []float64, err error)
+	// Record stores the metrics of a completed trace
+	Record(res *Result) error
+}
+
+// HistoryComparison is how a trace's end-to-end metrics compare with the
+// target's stored history
+type HistoryComparison struct {
+	Samples    int      `json:"samples"`
+	LatencyP50 float64  `json:"latency_p50_ms"`
+	LatencyP95 float64  `json:"latency_p95_ms"`
+	LossP50    float64  `json:"loss_p50"`
+	LossP95    float64  `json:"loss_p95"`
+	Anomalies  []string `json:"anomalies,omitempty"`
+}
+
+// compareHistory compares the destination of res with the target's history,
+// then records res. Failures only produce a warning on res.
+func compareHistory(h History, res *Result) {
+	if len(res.Hops) == 0 {
+		return
+	}
+	dest := res.Hops[len(res.Hops)-1]
+
+	latency, loss, err := h.Baseline(res.Target)
+	if err != nil {
+		res.Warnings = append(res.Warnings, "History unavailable: "+err.Error())
+	} else if len(latency) >= minHistorySamples {
+		res.History = newHistoryComparison(dest, latency, loss)
+	}
+
+	if err := h.Record(res); err != nil {
+		res.Warnings = append(res.Warnings, "Failed to record history: "+err.Error())
+	}
+}
+
+// newHistoryComparison flags the destination's latency or loss when it is
+// above the p95 of the stored traces
+func newHistoryComparison(dest HopData, latency, loss []float64) *HistoryComparison {
+	latency = append([]float64(nil), latency...)
+	loss = append([]float64(nil), loss...)
+	sort.Float64s(latency)
+	sort.Float64s(loss)
+
+	c := &HistoryComparison{
+		Samples:    len(latency),
+		LatencyP50: percentile(latency, 50),
+		LatencyP95: percentile(latency, 95),
+		LossP50:    percentile(loss, 50),
+		LossP95:    percentile(loss, 95),
+	}
+	if dest.Avg > c.LatencyP95 {
+		c.Anomalies = append(c.Anomalies, fmt.Sprintf("current latency %.1f ms is above the p95 of %.1f ms", dest.Avg, c.LatencyP95))
+	}
+	if dest.Loss > c.LossP95 {
+		c.Anomalies = append(c.Anomalies, fmt.Sprintf("current loss %.1f%% is above the p95 of %.1f%%", dest.Loss, c.LossP95))
+	}
+	return c
+}
+
+// formatHistory renders the comparison with the target's history
+func formatHistory(c *HistoryComparison) string {
+	out := fmt.Sprintf("\nCompared to the last %d traces (latency p50 %.1f ms, p95 %.1f ms; loss p50 %.1f%%, p95 %.1f%%):\n",
+		c.Samples, c.LatencyP50, c.LatencyP95, c.LossP50, c.LossP95)
+	if len(c.Anomalies) == 0 {
+		return out + "  Within the usual range\n"
+	}
+	for _, anomaly := range c.Anomalies {
+		out += fmt.Sprintf("  %s%s%s\n", colorRed, anomaly, colorReset)
+	}
+	return out
+}
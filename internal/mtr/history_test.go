@@ -0,0 +1,90 @@
+package mtr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// memoryHistory is a History holding the baseline of a single target
+type memoryHistory struct {
+	latency, loss []float64
+	err           error
+	recorded      []*Result
+}
+
+func (h *memoryHistory) Baseline(target string) ([]float64, []float64, error) {
+	return h.latency, h.loss, h.err
+}
+
+func (h *memoryHistory) Record(res *Result) error {
+	h.recorded = append(h.recorded, res)
+	return nil
+}
+
+// tracedTo returns a result whose destination has the given latency and loss
+func tracedTo(avg, loss float64) *Result {
+	return &Result{Target: "example.com", Hops: []HopData{
+		{Hop: 1, IP: "10.0.0.1", Avg: 1},
+		{Hop: 2, IP: "192.0.2.1", Avg: avg, Loss: loss},
+	}}
+}
+
+func TestCompareHistory(t *testing.T) {
+	seeded := func() *memoryHistory {
+		return &memoryHistory{
+			latency: []float64{80, 85, 90, 70, 75, 60, 65, 88, 82, 78},
+			loss:    []float64{0, 0, 0, 0, 0, 0, 0, 0, 0, 10},
+		}
+	}
+
+	h := seeded()
+	res := tracedTo(180, 0)
+	compareHistory(h, res)
+	if res.History == nil {
+		t.Fatal("no comparison with 10 stored traces")
+	}
+	if res.History.Samples != 10 || res.History.LatencyP50 != 78 || res.History.LatencyP95 != 90 {
+		t.Errorf("comparison %+v", res.History)
+	}
+	if len(res.History.Anomalies) != 1 || res.History.Anomalies[0] != "current latency 180.0 ms is above the p95 of 90.0 ms" {
+		t.Errorf("anomalies %q, want the latency", res.History.Anomalies)
+	}
+	if len(h.recorded) != 1 || h.recorded[0] != res {
+		t.Error("trace not recorded")
+	}
+
+	res = tracedTo(85, 20)
+	compareHistory(seeded(), res)
+	if len(res.History.Anomalies) != 1 || !strings.HasPrefix(res.History.Anomalies[0], "current loss 20.0% is above the p95 of 10.0%") {
+		t.Errorf("anomalies %q, want the loss", res.History.Anomalies)
+	}
+
+	// Within the usual range
+	res = tracedTo(85, 0)
+	compareHistory(seeded(), res)
+	if len(res.History.Anomalies) != 0 {
+		t.Errorf("anomalies %q within the usual range", res.History.Anomalies)
+	}
+	if !strings.Contains(formatHistory(res.History), "Within the usual range") {
+		t.Errorf("rendered as %q", formatHistory(res.History))
+	}
+
+	// Too little history to judge by
+	h = &memoryHistory{latency: []float64{10, 10, 10, 10}, loss: []float64{0, 0, 0, 0}}
+	res = tracedTo(180, 50)
+	compareHistory(h, res)
+	if res.History != nil {
+		t.Errorf("compared with %d traces", res.History.Samples)
+	}
+	if len(h.recorded) != 1 {
+		t.Error("trace not recorded without enough history")
+	}
+
+	h = &memoryHistory{err: errors.New("disk full")}
+	res = tracedTo(180, 0)
+	compareHistory(h, res)
+	if res.History != nil || strings.Join(res.Warnings, "\n") != "History unavailable: disk full" {
+		t.Errorf("history %v, warnings %q", res.History, res.Warnings)
+	}
+}
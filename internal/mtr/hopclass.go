@@ -0,0 +1,98 @@
+package mtr
+
+import (
+	"fmt"
+	"math"
+)
+
+// HopClass describes how consistently a hop answered across its samples
+type HopClass string
+
+const (
+	HopStable  HopClass = "stable"  // Little loss and steady round-trip times
+	HopJittery HopClass = "jittery" // Little loss but widely varying round-trip times
+	HopLossy   HopClass = "lossy"   // Loss above the threshold
+)
+
+// Default thresholds for classifying hops
+const (
+	DefaultLossyThreshold = lossWarnThreshold // Loss% above which a hop is lossy
+	DefaultJitterCoV      = 0.5               // RTT coefficient of variation above which a hop is jittery
+)
+
+// HopClassThresholds are the limits used to classify hops. Zero values use
+// the defaults.
+type HopClassThresholds struct {
+	// Loss is the loss percentage above which a hop is lossy
+	Loss float64
+	// CoV is the coefficient of variation (standard deviation / mean) of the
+	// round-trip times above which a hop is jittery
+	CoV float64
+}
+
+// Validate checks that the thresholds are usable
+func (t HopClassThresholds) Validate() error {
+	if t.Loss < 0 || t.Loss > 100 {
+		return fmt.Errorf("lossy threshold must be between 0 and 100")
+	}
+	if t.CoV < 0 {
+		return fmt.Errorf("jitter threshold must not be negative")
+	}
+	return nil
+}
+
+// classifyHops sets the Classification of every hop from its samples
+func classifyHops(hops []HopData, t HopClassThresholds) {
+	if t.Loss == 0 {
+		t.Loss = DefaultLossyThreshold
+	}
+	if t.CoV == 0 {
+		t.CoV = DefaultJitterCoV
+	}
+	for i := range hops {
+		hops[i].Classification = classifyHop(hops[i], t)
+	}
+}
+
+// classifyHop judges a hop by its loss first, then by the variation of its
+// round-trip times
+func classifyHop(hop HopData, t HopClassThresholds) HopClass {
+	if hop.Loss > t.Loss {
+		return HopLossy
+	}
+	if rttCoV(hop.Samples) > t.CoV {
+		return HopJittery
+	}
+	return HopStable
+}
+
+// rttCoV returns the coefficient of variation of the answered samples' RTTs,
+// or 0 with fewer than two answers
+func rttCoV(samples []Sample) float64 {
+	var sum float64
+	var n int
+	for _, s := range samples {
+		if !s.Lost {
+			sum += s.RTT
+			n++
+		}
+	}
+	if n < 2 || sum == 0 {
+		return 0
+	}
+	mean := sum / float64(n)
+	var sq float64
+	for _, s := range samples {
+		if !s.Lost {
+			sq += (s.RTT - mean) * (s.RTT - mean)
+		}
+	}
+	return math.Sqrt(sq/float64(n)) / mean
+}
+
+// hopClassColor is the color of each class's badge in the table
+var hopClassColor = map[HopClass]string{
+	HopStable:  colorGreen,
+	HopJittery: colorYellow,
+	HopLossy:   colorRed,
+}
@@ -0,0 +1,67 @@
+package mtr
+
+import (
+	"strings"
+	"testing"
+)
+
+// answered returns samples with the given RTTs, followed by lost lost samples
+func answered(lost int, rtts ...float64) []Sample {
+	var samples []Sample
+	for _, rtt := range rtts {
+		samples = append(samples, Sample{RTT: rtt})
+	}
+	for i := 0; i < lost; i++ {
+		samples = append(samples, Sample{Lost: true})
+	}
+	return samples
+}
+
+func TestClassifyHops(t *testing.T) {
+	tests := []struct {
+		name    string
+		loss    float64
+		samples []Sample
+		limits  HopClassThresholds
+		want    HopClass
+	}{
+		{"steady", 0, answered(0, 10, 11, 10, 9, 10), HopClassThresholds{}, HopStable},
+		{"single answer", 0, answered(0, 10), HopClassThresholds{}, HopStable},
+		{"no samples", 0, nil, HopClassThresholds{}, HopStable},
+		{"varying", 0, answered(0, 5, 50, 5, 60, 5), HopClassThresholds{}, HopJittery},
+		{"lost probes ignored for variation", 5, answered(1, 10, 11, 10, 9, 10), HopClassThresholds{}, HopStable},
+		{"loss above the default", 20, answered(4, 10, 11, 10, 9, 10), HopClassThresholds{}, HopLossy},
+		{"loss before jitter", 20, answered(4, 5, 50, 5, 60), HopClassThresholds{}, HopLossy},
+		{"loss below a raised limit", 20, answered(4, 10, 11, 10, 9, 10), HopClassThresholds{Loss: 30}, HopStable},
+		{"variation below a raised limit", 0, answered(0, 5, 50, 5, 60, 5), HopClassThresholds{CoV: 2}, HopStable},
+		{"variation above a lowered limit", 0, answered(0, 10, 12, 10, 8, 10), HopClassThresholds{CoV: 0.1}, HopJittery},
+	}
+	for _, tt := range tests {
+		hops := []HopData{{Hop: 1, Loss: tt.loss, Samples: tt.samples}}
+		classifyHops(hops, tt.limits)
+		if hops[0].Classification != tt.want {
+			t.Errorf("%s: %s, want %s (CoV %.2f)", tt.name, hops[0].Classification, tt.want, rttCoV(tt.samples))
+		}
+	}
+
+	for _, limits := range []HopClassThresholds{{Loss: -1}, {Loss: 101}, {CoV: -0.5}} {
+		if limits.Validate() == nil {
+			t.Errorf("thresholds %+v accepted", limits)
+		}
+	}
+}
+
+func TestHopBadges(t *testing.T) {
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "gw.local", Classification: HopStable},
+		{Hop: 2, IP: "192.0.2.1", Hostname: "dest.example.com", Loss: 50, Classification: HopLossy},
+	}
+	if table := colorizeOutput(hops, Config{}); strings.Contains(table, "Class") || strings.Contains(table, "lossy") {
+		t.Errorf("badges shown without HopBadges:\n%s", table)
+	}
+	table := colorizeOutput(hops, Config{HopBadges: true})
+	if !strings.Contains(table, "  Class\n") || !strings.Contains(table, colorGreen+"stable"+colorReset) ||
+		!strings.Contains(table, colorRed+"lossy"+colorReset) {
+		t.Errorf("badges missing:\n%s", table)
+	}
+}
@@ -0,0 +1,126 @@
+package mtr
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// htmlHop is one row of the HTML report, with the classes its loss and
+// latency cells are colored by
+type htmlHop struct {
+	HopData
+	Host         string
+	AS           string
+	Location     string
+	LossClass    string
+	LatencyClass string
+}
+
+// htmlReport is the data the HTML report template renders
+type htmlReport struct {
+	Target       string
+	Probes       string
+	Meta         *Meta
+	Hops         []htmlHop
+	Percentiles  bool
+	ShowAS       bool
+	ShowLocation bool
+	Summary      string
+}
+
+// htmlTemplate is a self-contained page, with its styles inline, so the
+// report can be attached to a ticket or mailed as a single file.
+// html/template escapes the hop names, which come from DNS.
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"ms":   func(v float64) string { return fmt.Sprintf("%.1f", v) },
+	"time": func(t time.Time) string { return t.Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>MTR report: {{.Target}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.2em 1em; }
+dt { font-weight: bold; }
+dd { margin: 0; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: right; }
+th { background: #f4f4f4; }
+td.host, th.host { text-align: left; }
+.loss-ok { background: #e6f4ea; }
+.loss-warn { background: #fff4ce; }
+.loss-high { background: #fde2e1; color: #a50e0e; font-weight: bold; }
+.latency-high { background: #fff4ce; }
+pre { background: #f8f8f8; padding: 1em; border: 1px solid #ddd; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>MTR report: {{.Target}}</h1>
+<dl>
+{{- if .Probes}}
+<dt>Probes</dt><dd>{{.Probes}}</dd>
+{{- end}}
+{{- with .Meta}}
+<dt>Traced From</dt><dd>{{.LocalHostname}}</dd>
+<dt>Started At</dt><dd>{{time .StartedAt}}</dd>
+<dt>Tool Version</dt><dd>{{.ToolVersion}}</dd>
+{{- end}}
+</dl>
+<table>
+<thead>
+<tr><th>Hop</th><th>Loss%</th><th>Sent</th><th>Last</th><th>Avg</th><th>Best</th><th>Worst</th><th>StDev</th><th>Jttr</th>
+{{- if .Percentiles}}<th>p50</th><th>p95</th><th>p99</th>{{end}}<th class="host">Host</th>{{if .ShowAS}}<th class="host">AS</th>{{end}}{{if .ShowLocation}}<th class="host">Location</th>{{end}}</tr>
+</thead>
+<tbody>
+{{- range .Hops}}
+<tr><td>{{.Hop}}</td><td class="{{.LossClass}}">{{ms .Loss}}%</td><td>{{.Sent}}</td><td>{{ms .Last}}</td><td class="{{.LatencyClass}}">{{ms .Avg}}</td><td>{{ms .Best}}</td><td>{{ms .Worst}}</td><td>{{ms .StDev}}</td><td>{{ms .Jitter}}</td>
+{{- if $.Percentiles}}<td>{{ms .P50}}</td><td>{{ms .P95}}</td><td>{{ms .P99}}</td>{{end}}<td class="host">{{.Host}}</td>{{if $.ShowAS}}<td class="host">{{.AS}}</td>{{end}}{{if $.ShowLocation}}<td class="host">{{.Location}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+<h2>Summary</h2>
+<pre>{{.Summary}}</pre>
+</body>
+</html>
+`))
+
+// RenderHTML renders a result as a self-contained HTML page with the hop
+// table, its loss and latency cells colored like the terminal table, and
+// the summary. It is used by Run and to serve stored results as HTML.
+func RenderHTML(res *Result, cfg Config) string {
+	label := cfg.unknownHostLabel()
+	report := htmlReport{
+		Target:       res.targetDisplay(),
+		Probes:       strings.TrimSuffix(strings.TrimPrefix(formatProbes(res), "Probes: "), "\n"),
+		Meta:         res.Meta,
+		Percentiles:  cfg.Percentiles,
+		ShowAS:       hasASN(res.Hops),
+		ShowLocation: hasLocation(res.Hops),
+		Summary:      strings.TrimSpace(colorCode.ReplaceAllString(generateSummary(res, cfg), "")),
+	}
+	for _, hop := range res.Hops {
+		row := htmlHop{HopData: hop, Host: tableHost(hop, label), AS: formatAS(hop), Location: formatLocation(hop), LossClass: "loss-ok"}
+		if cfg.lossIgnored(hop) {
+			row.LossClass = ""
+		} else if hop.Loss > lossHighThreshold {
+			row.LossClass = "loss-high"
+		} else if hop.Loss > lossWarnThreshold {
+			row.LossClass = "loss-warn"
+		}
+		if hop.Avg >= latencyHighThreshold {
+			row.LatencyClass = "latency-high"
+		}
+		report.Hops = append(report.Hops, row)
+	}
+
+	var buf strings.Builder
+	if err := htmlTemplate.Execute(&buf, report); err != nil {
+		// The template is fixed, so this only fails on a programming error
+		return fmt.Sprintf("<!DOCTYPE html><p>Failed to render report: %s</p>", template.HTMLEscapeString(err.Error()))
+	}
+	return buf.String()
+}
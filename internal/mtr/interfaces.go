@@ -0,0 +1,160 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseInterfaces parses a comma-separated list of interface names such as
+// "eth0,eth1"
+func ParseInterfaces(list string) ([]string, error) {
+	var ifaces []string
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(list, ",") {
+		name := strings.TrimSpace(field)
+		if err := ValidateInterface(name); err != nil {
+			return nil, err
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("interface %q listed twice", name)
+		}
+		seen[name] = true
+		ifaces = append(ifaces, name)
+	}
+	return ifaces, nil
+}
+
+// ValidateInterface checks that name can be passed to mtr -I
+func ValidateInterface(name string) error {
+	if name == "" {
+		return fmt.Errorf("interface name must not be empty")
+	}
+	if strings.HasPrefix(name, "-") || strings.ContainsAny(name, " \t/;&|") {
+		return fmt.Errorf("invalid interface name %q", name)
+	}
+	return nil
+}
+
+// InterfaceResult is the outcome of tracing from one source interface.
+// Err is set instead of Result when the trace failed.
+type InterfaceResult struct {
+	Interface string
+	Result    *Result
+	Err       error
+}
+
+// RunInterfaces traces cfg once per interface, concurrently, binding each
+// run with mtr -I. Each result gets an "interface" label. A failing interface
+// does not affect the others.
+func RunInterfaces(ctx context.Context, cfg Config, ifaces []string) []InterfaceResult {
+	results := make([]InterfaceResult, len(ifaces))
+	var wg sync.WaitGroup
+	for i, iface := range ifaces {
+		sub := cfg
+		sub.Interface = iface
+		sub.Labels = make(map[string]string, len(cfg.Labels)+1)
+		for k, v := range cfg.Labels {
+			sub.Labels[k] = v
+		}
+		sub.Labels["interface"] = iface
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			res, err := Run(ctx, sub)
+			results[i] = InterfaceResult{Interface: sub.Interface, Result: res, Err: err}
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// FormatInterfaceComparison renders the end-to-end metrics of every
+// interface side by side, followed by the path each one took
+func FormatInterfaceComparison(results []InterfaceResult, cfg Config) string {
+	var out strings.Builder
+	out.WriteString(formatHeader())
+	var meta *Meta
+	if !cfg.NoMeta {
+		meta = newMeta(time.Now())
+	}
+	out.WriteString(formatHostInfo(cfg.Hostname, "", meta))
+
+	out.WriteString("Per-Interface Comparison:\n")
+	out.WriteString(fmt.Sprintf("%-12s %-10s %-5s %-8s %-8s %-8s %s\n",
+		"Interface", "Health", "Hops", "Loss%", "Avg", "Worst", "Destination"))
+	out.WriteString(strings.Repeat("-", 70) + "\n")
+	for _, r := range results {
+		if r.Err != nil {
+			out.WriteString(fmt.Sprintf("%-12s %sno connectivity: %v%s\n", r.Interface, colorRed, r.Err, colorReset))
+			continue
+		}
+		hops := r.Result.Hops
+		dest := hops[len(hops)-1]
+		reached := "reached"
+		if !r.Result.DestinationReached {
+			reached = colorYellow + "not reached" + colorReset
+		}
+		out.WriteString(fmt.Sprintf("%-12s %-10s %-5d %-8.1f %-8.1f %-8.1f %s\n",
+			r.Interface, r.Result.Health, len(hops), dest.Loss, dest.Avg, dest.Worst, reached))
+	}
+
+	out.WriteString("\nPaths:\n")
+	out.WriteString(fmt.Sprintf("%-*s", columnWidths["hop"]+2, "Hop"))
+	maxHops := 0
+	for _, r := range results {
+		out.WriteString(fmt.Sprintf("%-*s", columnWidths["host"], r.Interface))
+		if r.Err == nil && len(r.Result.Hops) > maxHops {
+			maxHops = len(r.Result.Hops)
+		}
+	}
+	out.WriteString("\n")
+	for i := 0; i < maxHops; i++ {
+		out.WriteString(fmt.Sprintf("%-*d", columnWidths["hop"]+2, i+1))
+		for _, r := range results {
+			cell := ""
+			if r.Err == nil && i < len(r.Result.Hops) {
+				cell = displayHost(r.Result.Hops[i], cfg.unknownHostLabel())
+			}
+			out.WriteString(fmt.Sprintf("%-*s", columnWidths["host"], cell))
+		}
+		out.WriteString("\n")
+	}
+
+	if hop, ok := pathDivergence(results); ok {
+		out.WriteString(fmt.Sprintf("\nPaths diverge at hop %d\n", hop))
+	} else {
+		out.WriteString("\nAll interfaces took the same path\n")
+	}
+	return cfg.withColors(out.String())
+}
+
+// pathDivergence returns the first hop at which the successful traces
+// answered from different IPs
+func pathDivergence(results []InterfaceResult) (int, bool) {
+	var paths [][]HopData
+	for _, r := range results {
+		if r.Err == nil {
+			paths = append(paths, r.Result.Hops)
+		}
+	}
+	if len(paths) < 2 {
+		return 0, false
+	}
+	for i := 0; ; i++ {
+		for _, path := range paths[1:] {
+			switch {
+			case i >= len(paths[0]) && i >= len(path):
+				continue
+			case i >= len(paths[0]) || i >= len(path) || path[i].IP != paths[0][i].IP:
+				return i + 1, true
+			}
+		}
+		if i >= len(paths[0]) {
+			return 0, false
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package mtr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseInterfaces(t *testing.T) {
+	ifaces, err := ParseInterfaces("eth0, eth1")
+	if err != nil || strings.Join(ifaces, ",") != "eth0,eth1" {
+		t.Errorf("got %v, %v", ifaces, err)
+	}
+	for _, list := range []string{"", "eth0,", "eth0,eth0", "-n", "eth0;reboot"} {
+		if _, err := ParseInterfaces(list); err == nil {
+			t.Errorf("%q accepted", list)
+		}
+	}
+}
+
+func TestFormatInterfaceComparison(t *testing.T) {
+	eth0 := traced("10.0.0.1", "10.1.0.1", "192.0.2.1")
+	eth0.DestinationReached = true
+	eth0.Health = HealthOK
+	eth0.Hops[2].Avg, eth0.Hops[2].Worst = 10, 14
+	eth1 := traced("10.0.0.1", "10.2.0.1", "192.0.2.1")
+	eth1.DestinationReached = true
+	eth1.Health = HealthDegraded
+	eth1.Hops[2].Loss, eth1.Hops[2].Avg, eth1.Hops[2].Worst = 10, 30, 45
+
+	results := []InterfaceResult{
+		{Interface: "eth0", Result: &eth0},
+		{Interface: "eth1", Result: &eth1},
+		{Interface: "wwan0", Err: errors.New("mtr error: exit status 1")},
+	}
+	out := FormatInterfaceComparison(results, Config{Hostname: "example.com", NoColor: true, NoMeta: true})
+
+	rows := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			if _, ok := rows[fields[0]]; !ok {
+				rows[fields[0]] = strings.Join(fields, " ")
+			}
+		}
+	}
+	if got := rows["eth0"]; got != "eth0 OK 3 0.0 10.0 14.0 reached" {
+		t.Errorf("eth0 row %q", got)
+	}
+	if got := rows["eth1"]; got != "eth1 Degraded 3 10.0 30.0 45.0 reached" {
+		t.Errorf("eth1 row %q", got)
+	}
+	if got := rows["wwan0"]; got != "wwan0 no connectivity: mtr error: exit status 1" {
+		t.Errorf("wwan0 row %q", got)
+	}
+	if got := rows["2"]; got != "2 10.1.0.1 10.2.0.1" {
+		t.Errorf("path row of hop 2 %q", got)
+	}
+	if !strings.Contains(out, "Paths diverge at hop 2") {
+		t.Errorf("divergence not reported:\n%s", out)
+	}
+
+	same := FormatInterfaceComparison(results[:1], Config{Hostname: "example.com", NoColor: true, NoMeta: true})
+	if !strings.Contains(same, "All interfaces took the same path") {
+		t.Errorf("single path reported as diverging:\n%s", same)
+	}
+}
@@ -0,0 +1,80 @@
+package mtr
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// SchemaVersion is the version of the JSON result layout, reported as
+// schema_version. It is bumped whenever a field is removed, renamed or
+// changes meaning; new fields do not change it.
+const SchemaVersion = 2
+
+// resultJSON adds the fields of Result whose JSON form differs from the Go type
+type resultJSON struct {
+	SchemaVersion int `json:"schema_version"`
+	resultAlias
+	DNSResolutionMS float64 `json:"dns_resolution_ms"`
+	DurationMS      float64 `json:"duration_ms"`
+}
+
+// resultAlias has Result's fields without its JSON methods
+type resultAlias Result
+
+// MarshalJSON encodes the result, reporting durations in milliseconds
+func (r Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(resultJSON{
+		SchemaVersion:   SchemaVersion,
+		resultAlias:     resultAlias(r),
+		DNSResolutionMS: float64(r.DNSResolution.Microseconds()) / 1000.0,
+		DurationMS:      float64(r.Duration.Microseconds()) / 1000.0,
+	})
+}
+
+// UnmarshalJSON decodes a result produced by MarshalJSON
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var v resultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = Result(v.resultAlias)
+	r.DNSResolution = time.Duration(v.DNSResolutionMS * float64(time.Millisecond))
+	r.Duration = time.Duration(v.DurationMS * float64(time.Millisecond))
+	return nil
+}
+
+// ReadResultJSON decodes a saved result: a Result's JSON, the output of
+// -format json in either -json-keys style, or a synchronous API response
+// with the result under "result". Results of a newer schema version than
+// SchemaVersion are rejected.
+func ReadResultJSON(data []byte) (*Result, error) {
+	data, err := snakeKeys(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid result JSON: %v", err)
+	}
+	var wrapper struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.Unmarshal(data, &wrapper); err != nil {
+		return nil, fmt.Errorf("invalid result JSON: %v", err)
+	}
+	if len(wrapper.Result) > 0 && string(wrapper.Result) != "null" {
+		data = wrapper.Result
+	}
+
+	var res resultJSON
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("invalid result JSON: %v", err)
+	}
+	if res.SchemaVersion > SchemaVersion {
+		return nil, fmt.Errorf("result has schema version %d, this tool reads up to %d", res.SchemaVersion, SchemaVersion)
+	}
+	if len(res.Hops) == 0 {
+		return nil, fmt.Errorf("result has no hops")
+	}
+	result := Result(res.resultAlias)
+	result.DNSResolution = time.Duration(res.DNSResolutionMS * float64(time.Millisecond))
+	result.Duration = time.Duration(res.DurationMS * float64(time.Millisecond))
+	return &result, nil
+}
@@ -0,0 +1,166 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadResultJSONKeyStyles(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	res := &Result{
+		Target:             "example.com",
+		StartedAt:          started,
+		FinishedAt:         started.Add(3 * time.Second),
+		Duration:           3 * time.Second,
+		Health:             HealthOK,
+		DestinationReached: true,
+		Hops: []HopData{
+			{Hop: 1, IP: "10.0.0.1", Hostname: "gw.example.net", Sent: 10, Avg: 1.5, StDev: 0.2, AltIPs: []string{"10.0.0.2"}},
+			{Hop: 2, IP: "93.184.216.34", Hostname: "example.com", Sent: 10, Loss: 10, Avg: 20.5},
+		},
+	}
+
+	for _, style := range []KeyStyle{KeysSnake, KeysCamel} {
+		out := formatJSON(res, Config{Count: 10, JSONKeys: style})
+		if strings.Contains(out, `"timestamp"`) {
+			t.Errorf("%s: envelope repeats started_at as timestamp:\n%s", style, out)
+		}
+		if style == KeysCamel && !strings.Contains(out, `"startedAt"`) {
+			t.Fatalf("%s: keys not camelCase:\n%s", style, out)
+		}
+
+		got, err := ReadResultJSON([]byte(out))
+		if err != nil {
+			t.Fatalf("%s: %v", style, err)
+		}
+		if got.Target != res.Target || !got.StartedAt.Equal(started) || !got.FinishedAt.Equal(res.FinishedAt) ||
+			got.Duration != res.Duration || got.Health != HealthOK || !got.DestinationReached {
+			t.Errorf("%s: result %+v", style, got)
+		}
+		if len(got.Hops) != 2 || got.Hops[0].StDev != 0.2 || got.Hops[0].AltIPs[0] != "10.0.0.2" || got.Hops[1].Loss != 10 {
+			t.Errorf("%s: hops %+v", style, got.Hops)
+		}
+	}
+}
+
+func TestReadResultJSONSchemaVersion(t *testing.T) {
+	if _, err := ReadResultJSON([]byte(`{"schema_version": 99, "hops": [{"hop": 1}]}`)); err == nil {
+		t.Error("newer schema version accepted")
+	}
+	if _, err := ReadResultJSON([]byte(`{"schemaVersion": 99, "hops": [{"hop": 1}]}`)); err == nil {
+		t.Error("newer camelCase schema version accepted")
+	}
+	if _, err := ReadResultJSON([]byte(`{"schema_version": 1, "hops": [{"hop": 1}]}`)); err != nil {
+		t.Errorf("older schema version rejected: %v", err)
+	}
+}
+
+func TestMarshalKeys(t *testing.T) {
+	res := &Result{
+		Target: "example.com",
+		Labels: map[string]string{"site_name": "ams"},
+		Hops:   []HopData{{Hop: 1, IP: "10.0.0.1", AltIPs: []string{"10.0.0.2"}}},
+	}
+
+	snake, err := MarshalKeys(res, KeysSnake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	camel, err := MarshalKeys(res, KeysCamel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"dns_resolution_ms":`, `"alt_ips":`, `"destination_reached":`} {
+		if !strings.Contains(string(snake), key) {
+			t.Errorf("snake_case output lacks %s: %s", key, snake)
+		}
+	}
+	for _, key := range []string{`"dnsResolutionMs":`, `"altIps":`, `"destinationReached":`} {
+		if !strings.Contains(string(camel), key) {
+			t.Errorf("camelCase output lacks %s: %s", key, camel)
+		}
+	}
+	// Only the label key below keeps its underscore
+	if strings.Count(string(camel), "_") != 1 {
+		t.Errorf("camelCase output has snake_case keys: %s", camel)
+	}
+	// Label keys are the user's and keep their names in either style
+	if !strings.Contains(string(camel), `"site_name":"ams"`) {
+		t.Errorf("camelCase output renamed a label: %s", camel)
+	}
+
+	if style, err := ParseKeyStyle(""); err != nil || style != KeysSnake {
+		t.Errorf("default key style %q, %v; want snake", style, err)
+	}
+	if _, err := ParseKeyStyle("kebab"); err == nil {
+		t.Error("invalid key style accepted")
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	res := &Result{Target: "example.com", Hops: []HopData{{Hop: 1, IP: "192.0.2.1", Sent: 1}}}
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, out := range map[string]string{
+		"result":      string(data),
+		"format json": formatJSON(res, Config{}),
+	} {
+		var v struct {
+			SchemaVersion *int `json:"schema_version"`
+		}
+		if err := json.Unmarshal([]byte(out), &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.SchemaVersion == nil || *v.SchemaVersion != SchemaVersion {
+			t.Errorf("%s: schema_version %v, want %d", name, v.SchemaVersion, SchemaVersion)
+		}
+	}
+
+	older := fmt.Sprintf(`{"schema_version":%d,"target":"example.com","hops":[{"hop":1,"ip":"192.0.2.1"}]}`, SchemaVersion-1)
+	if _, err := ReadResultJSON([]byte(older)); err != nil {
+		t.Errorf("older version refused: %v", err)
+	}
+	newer := fmt.Sprintf(`{"schema_version":%d,"target":"example.com","hops":[{"hop":1,"ip":"192.0.2.1"}]}`, SchemaVersion+1)
+	if _, err := ReadResultJSON([]byte(newer)); err == nil || !strings.Contains(err.Error(), "schema version") {
+		t.Errorf("newer version: error %v", err)
+	}
+}
+
+func TestAnnotationKeysVerbatim(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1))
+	script := enrichScript(t, `echo '{"10.0.0.1": {"asn_name": "EXAMPLE-NET", "rack_id": "r12"}}'`+"\n")
+
+	cfg := testConfig(1)
+	cfg.Format = FormatJSON
+	cfg.JSONKeys = KeysCamel
+	cfg.Enrichers = []Enricher{CommandEnricher{Path: script}}
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The hook's keys are as it emitted them, the rest camelCase
+	for _, key := range []string{`"asn_name": "EXAMPLE-NET"`, `"rack_id": "r12"`, `"destinationReached"`} {
+		if !strings.Contains(res.Output, key) {
+			t.Errorf("output lacks %s:\n%s", key, res.Output)
+		}
+	}
+	if strings.Contains(res.Output, "asnName") || strings.Contains(res.Output, "rackId") {
+		t.Errorf("annotation keys renamed:\n%s", res.Output)
+	}
+
+	read, err := ReadResultJSON([]byte(res.Output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read.Hops[0].Annotations; got["asn_name"] != "EXAMPLE-NET" || got["rack_id"] != "r12" {
+		t.Errorf("annotations read back as %v", got)
+	}
+}
@@ -0,0 +1,141 @@
+package mtr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KeyStyle is the naming style of JSON object keys
+type KeyStyle string
+
+const (
+	KeysSnake KeyStyle = "snake" // dns_resolution_ms (default, matches the struct tags)
+	KeysCamel KeyStyle = "camel" // dnsResolutionMs
+)
+
+// verbatimKeys hold user-supplied maps whose keys are never renamed: trace
+// labels and the annotations enrichment commands return
+var verbatimKeys = map[string]bool{"labels": true, "annotations": true}
+
+// ParseKeyStyle validates a JSON key style; an empty string is snake_case
+func ParseKeyStyle(style string) (KeyStyle, error) {
+	switch KeyStyle(style) {
+	case "":
+		return KeysSnake, nil
+	case KeysSnake, KeysCamel:
+		return KeyStyle(style), nil
+	}
+	return "", fmt.Errorf("invalid JSON key style %q (expected snake or camel)", style)
+}
+
+// MarshalKeys encodes v as JSON with object keys in the given style. Field
+// order is preserved; the keys of label and annotation maps are left as given.
+func MarshalKeys(v any, style KeyStyle) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || style != KeysCamel {
+		return data, err
+	}
+	return renameKeys(data, camelCase)
+}
+
+// snakeKeys converts the object keys of JSON written in either key style
+// back to snake_case, so it decodes into the tagged structs
+func snakeKeys(data []byte) ([]byte, error) {
+	return renameKeys(data, snakeCase)
+}
+
+// renameKeys rewrites the object keys of a JSON document with rename,
+// except within label maps
+func renameKeys(data []byte, rename func(string) string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out bytes.Buffer
+	if err := rewriteKeys(dec, &out, rename); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// rewriteKeys copies one JSON value from dec to out, converting object keys
+// with rename unless it is nil
+func rewriteKeys(dec *json.Decoder, out *bytes.Buffer, rename func(string) string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		// Strings, json.Number, booleans and null re-encode verbatim
+		b, err := json.Marshal(tok)
+		if err != nil {
+			return err
+		}
+		out.Write(b)
+		return nil
+	}
+
+	isObject := delim == '{'
+	out.WriteRune(rune(delim))
+	for i := 0; dec.More(); i++ {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		childRename := rename
+		if isObject {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := keyTok.(string)
+			name := key
+			if rename != nil {
+				name = rename(key)
+			}
+			b, _ := json.Marshal(name)
+			out.Write(b)
+			out.WriteByte(':')
+			if verbatimKeys[name] {
+				childRename = nil
+			}
+		}
+		if err := rewriteKeys(dec, out, childRename); err != nil {
+			return err
+		}
+	}
+	end, err := dec.Token() // closing delimiter
+	if err != nil {
+		return err
+	}
+	out.WriteRune(rune(end.(json.Delim)))
+	return nil
+}
+
+// camelCase converts a snake_case key such as dns_resolution_ms to dnsResolutionMs
+func camelCase(key string) string {
+	parts := strings.Split(key, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// snakeCase converts a camelCase key such as dnsResolutionMs to
+// dns_resolution_ms; snake_case keys are returned unchanged
+func snakeCase(key string) string {
+	var b strings.Builder
+	for i, r := range key {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,22 @@
+package mtr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// labelNamePattern follows the Prometheus label name rules so labels can be
+// passed to every metrics sink unchanged
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateLabels checks that every label name is a valid metric label name.
+// Names starting with "__" are reserved.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name %q: must match [a-zA-Z_][a-zA-Z0-9_]* and not start with __", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package mtr
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for LookupEnricher
+const (
+	DefaultLookupWorkers   = 8
+	DefaultLookupCacheSize = 4096
+)
+
+// lookupTimeout bounds each DNS query, so one slow server does not hold up
+// the whole trace
+const lookupTimeout = 2 * time.Second
+
+// LookupResolver performs the reverse DNS and TXT lookups of LookupEnricher;
+// *net.Resolver satisfies it
+type LookupResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// LookupEnricher annotates hops with their reverse DNS name ("ptr", which
+// also becomes the Hostname of hops mtr did not name) and
+// origin AS ("asn", "as_prefix", "as_name", from Team Cymru's DNS service).
+// Lookups that fail leave their annotations out rather than failing. Every unique
+// IP is looked up once, by a bounded pool of workers fetching all enabled
+// annotations together, and the results are kept in an LRU cache for the
+// lifetime of the enricher so repeated traces do not look them up again.
+type LookupEnricher struct {
+	PTR      bool
+	ASN      bool
+	Workers  int
+	Resolver LookupResolver
+
+	cache *lookupCache
+	// asNames caches the name of every AS looked up, as many hops share one
+	asNames sync.Map
+}
+
+// NewLookupEnricher creates an enricher with a cache of cacheSize IPs
+// (DefaultLookupCacheSize when 0 or less)
+func NewLookupEnricher(ptr, asn bool, workers, cacheSize int) *LookupEnricher {
+	if cacheSize <= 0 {
+		cacheSize = DefaultLookupCacheSize
+	}
+	return &LookupEnricher{PTR: ptr, ASN: asn, Workers: workers, cache: newLookupCache(cacheSize)}
+}
+
+// Enrich looks up every hop IP not already cached
+func (e *LookupEnricher) Enrich(ctx context.Context, hops []HopData) (map[string]map[string]string, error) {
+	annotations := make(map[string]map[string]string)
+	var pending []string
+	for _, hop := range hops {
+		ip := hop.IP
+		if ip == "" {
+			continue
+		}
+		if _, seen := annotations[ip]; seen {
+			continue
+		}
+		if cached, ok := e.cache.get(ip); ok {
+			annotations[ip] = cached
+			continue
+		}
+		annotations[ip] = nil
+		pending = append(pending, ip)
+	}
+
+	workers := e.Workers
+	if workers <= 0 {
+		workers = DefaultLookupWorkers
+	}
+	ips := make(chan string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(pending); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range ips {
+				found := e.lookup(ctx, ip)
+				if ctx.Err() == nil {
+					e.cache.put(ip, found)
+				}
+				mu.Lock()
+				annotations[ip] = found
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, ip := range pending {
+		ips <- ip
+	}
+	close(ips)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return annotations, fmt.Errorf("hop lookups interrupted: %v", err)
+	}
+	return annotations, nil
+}
+
+// lookup fetches every enabled annotation of one IP. Failed lookups leave
+// their annotations out.
+func (e *LookupEnricher) lookup(ctx context.Context, ip string) map[string]string {
+	resolver := e.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	found := make(map[string]string)
+	if e.PTR {
+		if names, err := lookupAddr(ctx, resolver, ip); err == nil && len(names) > 0 {
+			found["ptr"] = strings.TrimSuffix(names[0], ".")
+		}
+	}
+	if e.ASN {
+		if name, ok := cymruName(ip); ok {
+			if records, err := lookupTXT(ctx, resolver, name); err == nil && len(records) > 0 {
+				// "13335 | 1.1.1.0/24 | AU | apnic | 2011-08-11"
+				fields := strings.Split(records[0], "|")
+				if asn := strings.Fields(strings.TrimSpace(fields[0])); len(asn) > 0 {
+					found["asn"] = "AS" + asn[0]
+					if name := e.asName(ctx, resolver, found["asn"]); name != "" {
+						found["as_name"] = name
+					}
+				}
+				if len(fields) > 1 {
+					found["as_prefix"] = strings.TrimSpace(fields[1])
+				}
+			}
+		}
+	}
+	return found
+}
+
+// asName looks up the name of an AS, e.g. "CLOUDFLARENET, US" for
+// "AS13335", or returns "" when it cannot be found
+func (e *LookupEnricher) asName(ctx context.Context, resolver LookupResolver, asn string) string {
+	if name, ok := e.asNames.Load(asn); ok {
+		return name.(string)
+	}
+	records, err := lookupTXT(ctx, resolver, asn+".asn.cymru.com")
+	if err != nil || len(records) == 0 {
+		return ""
+	}
+	// "13335 | US | arin | 2010-07-14 | CLOUDFLARENET, US"
+	fields := strings.Split(records[0], "|")
+	if len(fields) < 5 {
+		return ""
+	}
+	name := strings.TrimSpace(fields[4])
+	e.asNames.Store(asn, name)
+	return name
+}
+
+// lookupAddr and lookupTXT run one query within lookupTimeout
+func lookupAddr(ctx context.Context, resolver LookupResolver, ip string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	return resolver.LookupAddr(ctx, ip)
+}
+
+func lookupTXT(ctx context.Context, resolver LookupResolver, name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+	return resolver.LookupTXT(ctx, name)
+}
+
+// cymruName returns the Team Cymru origin lookup name of a public IP
+func cymruName(addr string) (string, bool) {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return "", false
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0]), true
+	}
+	const hexDigits = "0123456789abcdef"
+	var nibbles []string
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hexDigits[ip[i]&0xf]), string(hexDigits[ip[i]>>4]))
+	}
+	return strings.Join(nibbles, ".") + ".origin6.asn.cymru.com", true
+}
+
+// lookupCache is a fixed-size LRU cache of annotations by IP
+type lookupCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front is most recently used
+	entries map[string]*list.Element
+}
+
+type lookupCacheEntry struct {
+	ip    string
+	found map[string]string
+}
+
+func newLookupCache(size int) *lookupCache {
+	return &lookupCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (c *lookupCache) get(ip string) (map[string]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[ip]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lookupCacheEntry).found, true
+}
+
+func (c *lookupCache) put(ip string, found map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[ip]; ok {
+		el.Value.(*lookupCacheEntry).found = found
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[ip] = c.order.PushFront(&lookupCacheEntry{ip: ip, found: found})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lookupCacheEntry).ip)
+	}
+}
@@ -0,0 +1,99 @@
+package mtr
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// countingResolver answers every lookup and counts the queries by name
+type countingResolver struct {
+	mu      sync.Mutex
+	queries map[string]int
+}
+
+func (r *countingResolver) count(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.queries == nil {
+		r.queries = make(map[string]int)
+	}
+	r.queries[name]++
+}
+
+func (r *countingResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.count(addr)
+	return []string{"host-" + addr + ".example.net."}, nil
+}
+
+func (r *countingResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r.count(name)
+	if name == "AS64500.asn.cymru.com" {
+		return []string{"64500 | US | arin | 2010-07-14 | EXAMPLE-NET, US"}, nil
+	}
+	return []string{"64500 | 192.0.2.0/24 | US | arin | 2010-07-14"}, nil
+}
+
+func TestLookupEnricherOncePerIP(t *testing.T) {
+	// The route passes 192.0.2.1 twice, as in a loop
+	hops := route("10.0.0.1", "192.0.2.1", "192.0.2.2", "192.0.2.1", "192.0.2.3")
+	hops = append(hops, HopData{Hop: 6})
+	resolver := &countingResolver{}
+	e := NewLookupEnricher(true, true, 2, 0)
+	e.Resolver = resolver
+
+	annotations, err := e.Enrich(context.Background(), hops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"10.0.0.1", "192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		if n := resolver.queries[ip]; n != 1 {
+			t.Errorf("PTR of %s looked up %d times, want once", ip, n)
+		}
+		if annotations[ip]["ptr"] != "host-"+ip+".example.net" {
+			t.Errorf("%s annotated with %v", ip, annotations[ip])
+		}
+	}
+	for _, name := range []string{"1.2.0.192.origin.asn.cymru.com", "2.2.0.192.origin.asn.cymru.com", "3.2.0.192.origin.asn.cymru.com"} {
+		if n := resolver.queries[name]; n != 1 {
+			t.Errorf("%s looked up %d times, want once", name, n)
+		}
+	}
+	// Private addresses have no origin AS
+	if n := resolver.queries["1.0.0.10.origin.asn.cymru.com"]; n != 0 {
+		t.Errorf("private address looked up %d times", n)
+	}
+	if got := annotations["192.0.2.2"]; got["asn"] != "AS64500" || got["as_name"] != "EXAMPLE-NET, US" || got["as_prefix"] != "192.0.2.0/24" {
+		t.Errorf("192.0.2.2 annotated with %v", got)
+	}
+
+	// A repeated trace is served from the cache
+	before := make(map[string]int)
+	for name, n := range resolver.queries {
+		before[name] = n
+	}
+	if _, err := e.Enrich(context.Background(), hops); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resolver.queries, before) {
+		t.Errorf("lookups %v after a repeated trace, want %v", resolver.queries, before)
+	}
+}
+
+func TestLookupCacheEvicts(t *testing.T) {
+	c := newLookupCache(2)
+	c.put("192.0.2.1", map[string]string{"ptr": "a"})
+	c.put("192.0.2.2", map[string]string{"ptr": "b"})
+	c.get("192.0.2.1")
+	c.put("192.0.2.3", map[string]string{"ptr": "c"})
+
+	if _, ok := c.get("192.0.2.2"); ok {
+		t.Error("least recently used entry kept")
+	}
+	for _, ip := range []string{"192.0.2.1", "192.0.2.3"} {
+		if _, ok := c.get(ip); !ok {
+			t.Errorf("%s evicted", ip)
+		}
+	}
+}
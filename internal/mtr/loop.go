@@ -0,0 +1,43 @@
+package mtr
+
+import "fmt"
+
+// minLoopRepeats is the number of consecutive TTLs an IP must repeat at
+// before the route is reported as a suspected loop
+const minLoopRepeats = 3
+
+// loopInfo describes a run of hops answering from the same IP
+type loopInfo struct {
+	IP       string
+	FirstHop int
+	LastHop  int
+}
+
+func (l loopInfo) String() string {
+	return fmt.Sprintf("Possible routing loop: %s answers at hops %d-%d", l.IP, l.FirstHop, l.LastHop)
+}
+
+// detectLoop looks for the same IP at minLoopRepeats or more consecutive hops.
+// Repeats of the final IP are the destination answering at higher TTLs and are
+// ignored, so this must run before removeDuplicateHops, which keeps the
+// hops of the loop it reports.
+func detectLoop(hops []HopData) (loopInfo, bool) {
+	if len(hops) == 0 {
+		return loopInfo{}, false
+	}
+	destIP := hops[len(hops)-1].IP
+
+	start := 0
+	for i := 1; i <= len(hops); i++ {
+		if i < len(hops) && hops[i].IP != "" && hops[i].IP == hops[start].IP &&
+			hops[i].Hop == hops[i-1].Hop+1 {
+			continue
+		}
+		run := hops[start:i]
+		if len(run) >= minLoopRepeats && run[0].IP != "" && run[0].IP != destIP {
+			return loopInfo{IP: run[0].IP, FirstHop: run[0].Hop, LastHop: run[len(run)-1].Hop}, true
+		}
+		start = i
+	}
+	return loopInfo{}, false
+}
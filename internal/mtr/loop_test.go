@@ -0,0 +1,98 @@
+package mtr
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// route builds hops numbered from 1 answering from ips
+func route(ips ...string) []HopData {
+	hops := make([]HopData, len(ips))
+	for i, ip := range ips {
+		hops[i] = HopData{Hop: i + 1, IP: ip, Hostname: ip}
+	}
+	return hops
+}
+
+func TestDetectLoop(t *testing.T) {
+	tests := []struct {
+		name string
+		hops []HopData
+		want *loopInfo
+	}{
+		{"loop", route("10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.0.2", "192.0.2.1"), &loopInfo{IP: "10.0.0.2", FirstHop: 2, LastHop: 4}},
+		{"loop to the end", route("10.0.0.1", "10.0.0.9", "10.0.0.9", "10.0.0.9", "10.0.0.9", "192.0.2.1"), &loopInfo{IP: "10.0.0.9", FirstHop: 2, LastHop: 5}},
+		{"two repeats", route("10.0.0.1", "10.0.0.2", "10.0.0.2", "192.0.2.1"), nil},
+		{"destination repeats", route("10.0.0.1", "192.0.2.1", "192.0.2.1", "192.0.2.1"), nil},
+		{"silent hops", route("10.0.0.1", "", "", "", "192.0.2.1"), nil},
+		{"empty", nil, nil},
+	}
+	for _, tt := range tests {
+		loop, ok := detectLoop(tt.hops)
+		if ok != (tt.want != nil) || ok && loop != *tt.want {
+			t.Errorf("%s: got %+v, %v; want %+v", tt.name, loop, ok, tt.want)
+		}
+	}
+}
+
+func TestRemoveDuplicateHopsKeepsLoop(t *testing.T) {
+	hops := route("10.0.0.1", "10.0.0.2", "10.0.0.2", "10.0.0.2", "192.0.2.1", "192.0.2.1", "192.0.2.1")
+	loop, ok := detectLoop(hops)
+	if !ok {
+		t.Fatal("loop not detected")
+	}
+
+	got := removeDuplicateHops(hops, &loop)
+	var numbers []int
+	for _, hop := range got {
+		numbers = append(numbers, hop.Hop)
+		if hop.Loop != (hop.Hop >= 2 && hop.Hop <= 4) {
+			t.Errorf("hop %d: Loop = %v", hop.Hop, hop.Loop)
+		}
+	}
+	// The loop is kept; only the destination's repeats are dropped
+	if len(numbers) != 5 || numbers[4] != 5 {
+		t.Errorf("kept hops %v, want 1-5", numbers)
+	}
+
+	// Without a loop, repeats collapse as before
+	if got := removeDuplicateHops(route("10.0.0.1", "10.0.0.2", "10.0.0.2", "192.0.2.1"), nil); len(got) != 3 {
+		t.Errorf("kept %d hops, want 3", len(got))
+	}
+}
+
+func TestRunLoopedRoute(t *testing.T) {
+	seq := 0
+	replies := map[int]float64{0: 5, 1: 5}
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, replies, 2)+
+		rawProbes(1, "10.0.0.2", &seq, replies, 2)+
+		rawProbes(2, "10.0.0.2", &seq, replies, 2)+
+		rawProbes(3, "10.0.0.2", &seq, replies, 2)+
+		rawProbes(4, "10.0.0.2", &seq, replies, 2)+
+		rawProbes(5, "192.0.2.1", &seq, replies, 2))
+
+	cfg := testConfig(2)
+	cfg.NoColor = true
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !res.LoopSuspected {
+		t.Error("loop not suspected")
+	}
+	if len(res.Hops) != 6 {
+		t.Fatalf("got %d hops, want all 6", len(res.Hops))
+	}
+	for _, hop := range res.Hops[1:5] {
+		if !hop.Loop {
+			t.Errorf("hop %d not marked as looping", hop.Hop)
+		}
+	}
+	if n := strings.Count(res.Output, "[loop]"); n != 4 {
+		t.Errorf("table marks %d hops as looping, want 4:\n%s", n, res.Output)
+	}
+	if !strings.Contains(strings.Join(res.Warnings, "\n"), "Possible routing loop: 10.0.0.2 answers at hops 2-5") {
+		t.Errorf("warnings %q lack the loop", res.Warnings)
+	}
+}
@@ -0,0 +1,70 @@
+package mtr
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// markdownEscaper escapes the characters that would break a hop name out of
+// its table cell or format it
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "`", "\\`", `*`, `\*`, `_`, `\_`)
+
+// RenderMarkdown renders a result as GitHub-flavored Markdown: the trace
+// details, a hop table with right-aligned numbers and the summary, for
+// pasting into issues and incident write-ups. It never contains color codes.
+func RenderMarkdown(res *Result, cfg Config) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## MTR report: %s\n\n", markdownEscaper.Replace(res.targetDisplay()))
+	if probes := strings.TrimSpace(formatProbes(res)); probes != "" {
+		fmt.Fprintf(&b, "- %s\n", probes)
+	}
+	if meta := res.Meta; meta != nil {
+		fmt.Fprintf(&b, "- Traced From: %s\n", markdownEscaper.Replace(meta.LocalHostname))
+		fmt.Fprintf(&b, "- Started At: %s\n", meta.StartedAt.Format(time.RFC3339))
+		fmt.Fprintf(&b, "- Tool Version: %s\n", markdownEscaper.Replace(meta.ToolVersion))
+	}
+	b.WriteString("\n")
+
+	header := "| Hop | Loss% | Sent | Last | Avg | Best | Worst | StDev | Jttr |"
+	align := "|----:|------:|-----:|-----:|----:|-----:|------:|------:|-----:|"
+	if cfg.Percentiles {
+		header += " p50 | p95 | p99 |"
+		align += "----:|----:|----:|"
+	}
+	header += " Host |"
+	align += ":-----|"
+	showAS, showLocation := hasASN(res.Hops), hasLocation(res.Hops)
+	if showAS {
+		header += " AS |"
+		align += ":---|"
+	}
+	if showLocation {
+		header += " Location |"
+		align += ":---------|"
+	}
+	b.WriteString(header + "\n" + align + "\n")
+
+	label := cfg.unknownHostLabel()
+	for _, hop := range res.Hops {
+		fmt.Fprintf(&b, "| %d | %.1f%% | %d | %.1f | %.1f | %.1f | %.1f | %.1f | %.1f |",
+			hop.Hop, hop.Loss, hop.Sent, hop.Last, hop.Avg, hop.Best, hop.Worst, hop.StDev, hop.Jitter)
+		if cfg.Percentiles {
+			fmt.Fprintf(&b, " %.1f | %.1f | %.1f |", hop.P50, hop.P95, hop.P99)
+		}
+		fmt.Fprintf(&b, " %s |", markdownEscaper.Replace(tableHost(hop, label)))
+		if showAS {
+			fmt.Fprintf(&b, " %s |", markdownEscaper.Replace(formatAS(hop)))
+		}
+		if showLocation {
+			fmt.Fprintf(&b, " %s |", markdownEscaper.Replace(formatLocation(hop)))
+		}
+		b.WriteString("\n")
+	}
+
+	// The summary keeps its plain-text layout in a code block
+	summary := strings.TrimSpace(colorCode.ReplaceAllString(generateSummary(res, cfg), ""))
+	summary = strings.TrimPrefix(summary, "Summary:\n--------\n")
+	fmt.Fprintf(&b, "\n### Summary\n\n```\n%s\n```\n", summary)
+	return b.String()
+}
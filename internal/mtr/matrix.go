@@ -0,0 +1,59 @@
+package mtr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// matrixCyclesPerBlock is how many cycles are shown side by side before the
+// matrix wraps into another block
+const matrixCyclesPerBlock = 10
+
+// formatMatrix renders the RTT of every probe per hop, one column per cycle,
+// with "*" for lost probes. Wide matrices wrap into blocks of cycles.
+func formatMatrix(hops []HopData) string {
+	cycles := 0
+	for _, hop := range hops {
+		if len(hop.Samples) > cycles {
+			cycles = len(hop.Samples)
+		}
+	}
+	if cycles == 0 {
+		return ""
+	}
+
+	var matrix strings.Builder
+	matrix.WriteString("\nPer-Cycle Matrix (ms, * = lost):\n")
+	matrix.WriteString("--------------------------------\n")
+
+	for start := 0; start < cycles; start += matrixCyclesPerBlock {
+		end := start + matrixCyclesPerBlock
+		if end > cycles {
+			end = cycles
+		}
+
+		matrix.WriteString(fmt.Sprintf("\n%-*s", columnWidths["hop"]+2, "Hop"))
+		for c := start; c < end; c++ {
+			matrix.WriteString(fmt.Sprintf("%*d", columnWidths["avg"]+1, c+1))
+		}
+		matrix.WriteString("\n")
+
+		for _, hop := range hops {
+			matrix.WriteString(fmt.Sprintf("%-*d", columnWidths["hop"]+2, hop.Hop))
+			for c := start; c < end; c++ {
+				cell := ""
+				if c < len(hop.Samples) {
+					if hop.Samples[c].Lost {
+						cell = "*"
+					} else {
+						cell = fmt.Sprintf("%.1f", hop.Samples[c].RTT)
+					}
+				}
+				matrix.WriteString(fmt.Sprintf("%*s", columnWidths["avg"]+1, cell))
+			}
+			matrix.WriteString("\n")
+		}
+	}
+
+	return matrix.String()
+}
@@ -0,0 +1,54 @@
+package mtr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatMatrix(t *testing.T) {
+	hops := []HopData{
+		{Hop: 1, Samples: []Sample{{RTT: 1}, {Lost: true}, {RTT: 2.5}}},
+		{Hop: 2, Samples: []Sample{{RTT: 10.3}, {RTT: 11}}},
+	}
+	lines := strings.Split(strings.TrimRight(formatMatrix(hops), "\n"), "\n")
+	// Title, rule, blank line, then the header and a row per hop
+	lines = lines[1:]
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines, want 6:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	want := [][]string{
+		{"Hop", "1", "2", "3"},
+		{"1", "1.0", "*", "2.5"},
+		{"2", "10.3", "11.0"},
+	}
+	for i, fields := range want {
+		line := lines[3+i]
+		if got := strings.Fields(line); strings.Join(got, " ") != strings.Join(fields, " ") {
+			t.Errorf("line %q, want fields %q", line, fields)
+		}
+	}
+	// Cells are right-aligned under their cycle
+	header, row := lines[3], lines[4]
+	if len(row) != len(header) || !strings.HasSuffix(row, " 2.5") {
+		t.Errorf("row %q not aligned with header %q", row, header)
+	}
+
+	if formatMatrix([]HopData{{Hop: 1}}) != "" {
+		t.Error("matrix rendered without samples")
+	}
+}
+
+func TestFormatMatrixWraps(t *testing.T) {
+	samples := make([]Sample, matrixCyclesPerBlock+2)
+	for i := range samples {
+		samples[i] = Sample{RTT: float64(i + 1)}
+	}
+	out := formatMatrix([]HopData{{Hop: 1, Samples: samples}})
+	blocks := strings.Split(out, "\nHop")
+	if len(blocks) != 3 {
+		t.Fatalf("got %d blocks, want 2:\n%s", len(blocks)-1, out)
+	}
+	if got := strings.Fields(blocks[2]); strings.Join(got, " ") != "11 12 1 11.0 12.0" {
+		t.Errorf("second block %q, want cycles 11 and 12", blocks[2])
+	}
+}
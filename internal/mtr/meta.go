@@ -0,0 +1,36 @@
+package mtr
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Version is the tool version reported in result metadata. Release builds
+// set it with -ldflags "-X github.com/kluwer/mtr-tool/internal/mtr.Version=v1.2.3".
+var Version = "dev"
+
+// Meta describes where, when and with what a trace was run, so saved
+// reports are self-describing
+type Meta struct {
+	LocalHostname string    `json:"local_hostname"`
+	StartedAt     time.Time `json:"started_at"`
+	ToolVersion   string    `json:"tool_version"`
+}
+
+// newMeta collects the metadata of a trace started at start
+func newMeta(start time.Time) *Meta {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Meta{LocalHostname: host, StartedAt: start, ToolVersion: Version}
+}
+
+func formatMeta(meta *Meta) string {
+	if meta == nil {
+		return ""
+	}
+	return fmt.Sprintf("Traced From: %s\nStarted At: %s\nTool Version: %s\n",
+		meta.LocalHostname, meta.StartedAt.Format(time.RFC3339), meta.ToolVersion)
+}
@@ -0,0 +1,55 @@
+package mtr
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunMeta(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1))
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := testConfig(1)
+	cfg.NoMeta = false
+	cfg.NoColor = true
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Meta == nil || res.Meta.LocalHostname != host || res.Meta.ToolVersion != Version || !res.Meta.StartedAt.Equal(res.StartedAt) {
+		t.Fatalf("meta %+v", res.Meta)
+	}
+	for _, want := range []string{"Traced From: " + host + "\n", "Started At: ", "Tool Version: " + Version + "\n", "Duration: "} {
+		if !strings.Contains(res.Output, want) {
+			t.Errorf("table lacks %q:\n%s", want, res.Output)
+		}
+	}
+	js := formatJSON(res, cfg)
+	if !strings.Contains(js, `"local_hostname": "`+host+`"`) || !strings.Contains(js, `"tool_version": "`+Version+`"`) {
+		t.Errorf("json lacks the meta:\n%s", js)
+	}
+
+	cfg.NoMeta = true
+	res, err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Meta != nil {
+		t.Errorf("meta %+v with NoMeta", res.Meta)
+	}
+	for _, unwanted := range []string{"Traced From:", "Started At:", "Tool Version:", "Duration:"} {
+		if strings.Contains(res.Output, unwanted) {
+			t.Errorf("table has %q with NoMeta:\n%s", unwanted, res.Output)
+		}
+	}
+	if js := formatJSON(res, cfg); strings.Contains(js, `"meta"`) {
+		t.Errorf("json has the meta with NoMeta:\n%s", js)
+	}
+}
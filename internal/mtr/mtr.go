@@ -2,28 +2,55 @@ package mtr
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
 	"os"
 	"os/exec"
-	"strconv"
+	"regexp"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog/log"
 )
 
 var (
-	// Default paths
-	defaultMTRPath = "/opt/homebrew/sbin/mtr"
-	sudoPath       = "/usr/bin/sudo"
-	
-	// Get MTR path from environment or use default
-	mtrPath = func() string {
-		if path := os.Getenv("MTR_PATH"); path != "" {
+	// Get sudo path from environment or use the default
+	sudoPath = func() string {
+		if path := os.Getenv("SUDO_PATH"); path != "" {
 			return path
 		}
-		return defaultMTRPath
+		return "/usr/bin/sudo"
 	}()
+	
+	// mtrLocations are where package managers install mtr, checked when it
+	// is not on PATH (sbin directories often are not for regular users)
+	mtrLocations = []string{"/usr/sbin/mtr", "/usr/local/sbin/mtr", "/opt/homebrew/sbin/mtr", "/usr/bin/mtr"}
+
+	// Get MTR path from environment or detect it
+	mtrPath = findMTR()
 )
 
+// findMTR returns MTR_PATH, else the mtr found on PATH or in one of
+// mtrLocations. When mtr is not installed it returns the bare name, so
+// running it fails with the install hint.
+func findMTR() string {
+	if path := os.Getenv("MTR_PATH"); path != "" {
+		return path
+	}
+	if path, err := exec.LookPath("mtr"); err == nil {
+		return path
+	}
+	for _, path := range mtrLocations {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() && info.Mode().Perm()&0o111 != 0 {
+			return path
+		}
+	}
+	return "mtr"
+}
+
 const (
 	// ANSI color codes
 	colorReset  = "\033[0m"
@@ -32,44 +59,389 @@ const (
 	colorYellow = "\033[33m"
 )
 
-// Column widths for table formatting
+// colorCode matches the color codes above
+var colorCode = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// withColors returns s, without its color codes when NoColor is set
+func (c Config) withColors(s string) string {
+	if c.NoColor {
+		return colorCode.ReplaceAllString(s, "")
+	}
+	return s
+}
+
+// Column widths for table formatting. The main table sizes its host
+// column to the longest host instead, up to maxHostWidth.
 var columnWidths = map[string]int{
-	"hop":   3,    // Hop number
-	"loss":  6,    // Loss%
-	"snt":   3,    // Sent
-	"last":  7,    // Last
-	"avg":   7,    // Avg
-	"best":  7,    // Best
-	"worst": 7,    // Worst
-	"stdev": 7,    // StDev
-	"host":  40,   // Hostname
+	"hop":    3,  // Hop number
+	"loss":   6,  // Loss%
+	"snt":    3,  // Sent
+	"last":   7,  // Last
+	"avg":    7,  // Avg
+	"best":   7,  // Best
+	"worst":  7,  // Worst
+	"stdev":  7,  // StDev
+	"jitter": 7,  // Jitter
+	"host":   40, // Hostname
+}
+
+// percentileColumns are the columns Config.Percentiles adds, in order,
+// each percentileWidth wide
+var percentileColumns = []string{"p50", "p95", "p99"}
+
+const percentileWidth = 7
+
+// maxHostWidth is the widest the table's host column grows; longer hosts
+// are truncated unless Config.Wide is set
+const maxHostWidth = 60
+
+// Alignment selects how the numeric columns of the table are aligned
+type Alignment string
+
+const (
+	AlignLeft  Alignment = "left"  // Numbers start at the column's left edge (default)
+	AlignRight Alignment = "right" // Numbers line up on their last digit
+)
+
+// ParseAlignment validates a column alignment; an empty string is left
+func ParseAlignment(align string) (Alignment, error) {
+	switch Alignment(align) {
+	case "":
+		return AlignLeft, nil
+	case AlignLeft, AlignRight:
+		return Alignment(align), nil
+	}
+	return "", fmt.Errorf("invalid alignment %q (expected left or right)", align)
 }
 
+// DefaultUnknownHostLabel is shown for hops that never answered with an address
+const DefaultUnknownHostLabel = "???"
+
 // Config represents the configuration for running MTR
 type Config struct {
 	Hostname string
 	Count    int
 	Report   bool
+
+	// UnknownHostLabel replaces "???" for hops with no IP or name. It is a
+	// pointer so that an empty label can be chosen; nil uses the default.
+	UnknownHostLabel *string
+
+	// DestinationLossOnly ignores intermediate hop loss when judging the path,
+	// since it is usually ICMP rate limiting rather than real loss
+	DestinationLossOnly bool
+
+	// IgnoreLossBeforeHop leaves the loss of hops up to and including this
+	// hop uncolored and out of the health verdict, since transient loss at
+	// the local gateway is common and not meaningful (0 disables)
+	IgnoreLossBeforeHop int
+
+	// DestinationHop makes this hop stand in for the destination in the
+	// summary, the health verdict and the thresholds, e.g. the last hop
+	// before a firewall that drops probes to the real target. The loss of
+	// the hops after it is disregarded (0 uses the final hop).
+	DestinationHop int
+
+	// FirstHopOnly probes only the first hop (mtr -m 1), a cheap reachability
+	// check of the local gateway
+	FirstHopOnly bool
+
+	// VaryPorts spreads the probe cycles over UDP probes from these source
+	// ports, exercising different ECMP paths within one trace. LocalPort is
+	// the source port of a single run.
+	VaryPorts []int
+	LocalPort int
+
+	// TCP and UDP send TCP SYN or UDP probes to Port on the target (mtr
+	// --tcp or -u, with -P) instead of ICMP echo requests, for paths where
+	// ICMP is filtered or rate limited but the service port gets through.
+	// At most one may be set.
+	TCP  bool
+	UDP  bool
+	Port int
+
+	// Interval is the time between probe cycles (mtr -i, 0 uses mtr's
+	// default of one second). Shorter intervals speed up long traces.
+	Interval time.Duration
+
+	// PacketSize is the size of each probe in bytes, including the IP and
+	// ICMP/UDP headers (mtr -s, 0 uses mtr's default). Large probes reveal
+	// path MTU blackholes.
+	PacketSize int
+
+	// IPv4 and IPv6 trace over one address family only (mtr -4 or -6):
+	// hostnames are resolved to addresses of that family and literals of
+	// the other are rejected. At most one may be set.
+	IPv4 bool
+	IPv6 bool
+
+	// Interface binds the probes to this network interface (mtr -I)
+	Interface string
+
+	// MaxDisplayHops limits the table to the first and last hops, collapsing
+	// the rest into one line (0 shows every hop)
+	MaxDisplayHops int
+
+	// AbortLatency cancels the trace as soon as any probe's RTT exceeds this
+	// many milliseconds (0 disables the check)
+	AbortLatency float64
+
+	// Matrix adds a per-cycle table of every probe's RTT to the output
+	Matrix bool
+
+	// Explain adds a plain-language interpretation of Result.Analysis to the output
+	Explain bool
+
+	// SummaryLevel selects how detailed the summary is (default: normal)
+	SummaryLevel SummaryLevel
+
+	// Format selects the layout of Result.Output (default: table)
+	Format OutputFormat
+
+	// JSONKeys is the key style of the json format; NullUnknownHosts writes
+	// the hostname and IP of hops that never answered as null instead of the
+	// unknown host label and an empty IP
+	JSONKeys         KeyStyle
+	NullUnknownHosts bool
+
+	// Align selects the alignment of the table's numeric columns (default: left)
+	Align Alignment
+
+	// Wide never truncates the table's host column, however long the
+	// longest host is
+	Wide bool
+
+	// NoColor leaves the color codes out of the table and summary, e.g.
+	// when they are written to a file
+	NoColor bool
+
+	// Percentiles adds p50, p95 and p99 latency columns to the table. JSON
+	// results always carry them.
+	Percentiles bool
+
+	// HopClasses sets the thresholds hops are classified by; HopBadges shows
+	// each hop's class in the table
+	HopClasses HopClassThresholds
+	HopBadges  bool
+
+	// CIDRPick selects the address traced when Hostname is a subnet such as
+	// 10.1.2.0/24 (default: the first usable address)
+	CIDRPick CIDRPick
+
+	// Nice runs mtr with this scheduling niceness (-20 to 19, 0 leaves it
+	// unchanged) and IONiceClass in this I/O class ("idle" or "best-effort",
+	// Linux only), so background traces don't disrupt foreground work
+	Nice        int
+	IONiceClass string
+
+	// Resolve lets mtr look up hop names in live mode as well (report mode
+	// always does unless IPOnly is set)
+	Resolve bool
+
+	// IPOnly requires Hostname to be an IP literal and disables every DNS
+	// lookup, including mtr's reverse lookups of hop addresses
+	IPOnly bool
+
+	// DenyPrivate refuses targets in private, loopback, link-local and
+	// other reserved ranges (see ErrPrivateTarget), for servers exposed to
+	// untrusted clients
+	DenyPrivate bool
+
+	// Allow, when not empty, limits traces to the targets on it; Deny
+	// refuses the targets on it (see ErrTargetNotAllowed)
+	Allow TargetList
+	Deny  TargetList
+
+	// NoSudo runs mtr directly instead of through sudo, for installs where
+	// mtr already has the privileges it needs (setuid or capabilities). mtr
+	// also runs without sudo when sudo is not installed.
+	NoSudo bool
+
+	// KillGrace is how long mtr may take to exit after SIGTERM when the
+	// trace is cancelled before it is killed (default: DefaultKillGrace)
+	KillGrace time.Duration
+
+	// Retries is how many times a trace that failed transiently, e.g. with
+	// a socket error, is run again before giving up (0 to MaxRetries)
+	Retries int
+
+	// History, when set, compares the trace with the target's past traces
+	// and stores it for future comparisons
+	History History
+
+	// Enrichers annotate the hops before the output is formatted
+	Enrichers []Enricher
+
+	// NoMeta leaves out the local hostname, start time and tool version
+	NoMeta bool
+
+	// Labels are arbitrary key/value pairs attached to the result and passed
+	// on to metrics, logs and JSON output
+	Labels map[string]string
+
+	// Progress, when set, receives a copy of a hop's statistics so far
+	// whenever one of its probes is answered. With VaryPorts it is called
+	// from several goroutines at once.
+	Progress func(HopData)
+
+	// NativeJSON runs mtr --json and takes the hop statistics from mtr's own
+	// report instead of computing them from raw probe records. The report
+	// has no per-probe samples, so Matrix and Progress have nothing to show
+	// and VaryPorts and AbortLatency cannot be used. mtr versions without
+	// --json fall back to raw records with a warning.
+	NativeJSON bool
+
+	// RawOnly skips parsing and formatting; only RawOutput is filled in
+	RawOnly bool
+
+	// Resolver is used to resolve the target before tracing (default: net.DefaultResolver)
+	Resolver Resolver
+}
+
+// unknownHostLabel returns the configured placeholder or the default one
+func (c Config) unknownHostLabel() string {
+	if c.UnknownHostLabel != nil {
+		return *c.UnknownHostLabel
+	}
+	return DefaultUnknownHostLabel
 }
 
 // Result represents the result of running MTR
 type Result struct {
-	Output string
-	Error  error
+	Output string `json:"-"`
+	Error  error  `json:"-"`
+
+	// RawOutput is the verbatim output mtr produced on stdout
+	RawOutput string `json:"-"`
+
+	// ErrOutput is what mtr, or sudo, printed on stderr: diagnostics that
+	// are kept out of the records the parser reads
+	ErrOutput string `json:"-"`
+
+	// Target is the hostname, IP or subnet the trace was run against
+	Target string `json:"target"`
+	// ProbedAddress is the address traced when Target is a subnet
+	ProbedAddress string `json:"probed_address,omitempty"`
+	// Labels are the key/value pairs from Config.Labels
+	Labels map[string]string `json:"labels,omitempty"`
+	// Meta says which machine ran the trace, when and with which version (nil with Config.NoMeta)
+	Meta *Meta `json:"meta,omitempty"`
+	// StartedAt is when the trace started and FinishedAt when mtr finished,
+	// in UTC; Duration is the time between them, serialized as duration_ms
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"-"`
+	// ResolvedIPs holds the addresses the target resolved to
+	ResolvedIPs []string `json:"resolved_ips"`
+	// DNSResolution is how long resolving the target took (zero for IP
+	// literals). It is serialized as dns_resolution_ms.
+	DNSResolution time.Duration `json:"-"`
+	// ProbesRequested is the number of probe cycles asked for and ProbesSent
+	// the number mtr actually sent to the furthest hop, the basis of its
+	// statistics
+	ProbesRequested int `json:"probes_requested"`
+	ProbesSent      int `json:"probes_sent"`
+	// Hops holds the parsed per-hop statistics
+	Hops []HopData `json:"hops"`
+	// LoopSuspected is set when the same IP repeats at consecutive TTLs before the destination
+	LoopSuspected bool `json:"loop_suspected"`
+	// DestinationReached is set when the final hop is one of ResolvedIPs
+	DestinationReached bool `json:"destination_reached"`
+	// Aborted is set when the trace was cancelled early; AbortReason says why
+	Aborted     bool   `json:"aborted,omitempty"`
+	AbortReason string `json:"abort_reason,omitempty"`
+	// Health is the overall verdict for the path
+	Health Health `json:"health"`
+	// Warnings lists notable conditions detected while processing the trace
+	Warnings []string `json:"warnings,omitempty"`
+	// Analysis classifies the trace for dashboards; it is empty in first-hop-only mode
+	Analysis Analysis `json:"analysis"`
+	// History compares the trace with the target's past traces (nil without
+	// Config.History or enough stored traces)
+	History *HistoryComparison `json:"history,omitempty"`
 }
 
-// HopData represents the data for a single hop in the MTR output
+// HopData represents the data for a single hop in the MTR output.
+// Hostname and IP are empty when the hop never answered.
 type HopData struct {
-	Hop      int
-	Hostname string
-	IP       string
-	Loss     float64
-	Sent     int
-	Last     float64
-	Avg      float64
-	Best     float64
-	Worst    float64
-	StDev    float64
+	Hop      int     `json:"hop"`
+	Hostname string  `json:"hostname"`
+	IP       string  `json:"ip"`
+	Loss     float64 `json:"loss"`
+	Sent     int     `json:"sent"`
+	Last     float64 `json:"last"`
+	Avg      float64 `json:"avg"`
+	Best     float64 `json:"best"`
+	Worst    float64 `json:"worst"`
+	StDev    float64 `json:"stdev"`
+	// Jitter is the mean absolute difference between the RTTs of
+	// consecutive answered probes (RFC 3550 style, without smoothing)
+	Jitter float64 `json:"jitter"`
+	// P50, P95 and P99 are nearest-rank percentiles of the answered probes'
+	// RTTs
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+
+	// ASN and ASName are the origin AS of the hop's IP, e.g. "AS13335" and
+	// "CLOUDFLARENET, US", from its "asn" and "as_name" annotations (see
+	// LookupEnricher). They are empty when unknown.
+	ASN    string `json:"asn,omitempty"`
+	ASName string `json:"as_name,omitempty"`
+
+	// Country (ISO code) and City locate the hop's IP, from its "country"
+	// and "city" annotations, e.g. from a GeoIP database. They are empty
+	// when unknown.
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+
+	// AltIPs lists other addresses that answered at this hop
+	AltIPs []string `json:"alt_ips,omitempty"`
+
+	// Samples holds every probe sent to this hop in cycle order
+	Samples []Sample `json:"samples,omitempty"`
+
+	// Annotations are added by enrichers, e.g. the device name or owner
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Classification says how consistently the hop answered
+	Classification HopClass `json:"classification,omitempty"`
+
+	// Loop is set on the hops of a suspected routing loop, which are kept
+	// although they repeat the same IP
+	Loop bool `json:"loop,omitempty"`
+}
+
+// Sample is a single probe sent to a hop
+type Sample struct {
+	Seq  string  `json:"seq"`
+	RTT  float64 `json:"rtt_ms"`
+	Lost bool    `json:"lost"`
+}
+
+// addAltIP records ip as an alternative address of the hop
+func (h *HopData) addAltIP(ip string) {
+	if ip == h.IP {
+		return
+	}
+	for _, alt := range h.AltIPs {
+		if alt == ip {
+			return
+		}
+	}
+	h.AltIPs = append(h.AltIPs, ip)
+}
+
+// recordSample stores the round-trip time of the probe with the given sequence
+func (h *HopData) recordSample(seq string, ms float64) {
+	for i := len(h.Samples) - 1; i >= 0; i-- {
+		if h.Samples[i].Seq == seq {
+			h.Samples[i].RTT = ms
+			h.Samples[i].Lost = false
+			return
+		}
+	}
+	h.Samples = append(h.Samples, Sample{Seq: seq, RTT: ms})
 }
 
 func formatHeader() string {
@@ -85,24 +457,103 @@ Avg      : Average latency of all packets (ms)
 Best     : The best (lowest) latency observed (ms)
 Wrst     : The worst (highest) latency observed (ms)
 StDev    : Standard deviation of latencies (ms)
+Jttr     : Jitter, the mean difference between consecutive latencies (ms)
 Hostname : Hostname or IP address of the hop
 
 Color Indicators:
 Red     : High packet loss (≥20%)
-Yellow  : High latency (≥100ms)
+Yellow  : High average latency (≥100ms, Avg column)
 
 `
 }
 
-func formatHostInfo(hostname string) string {
-	return fmt.Sprintf("Target Host: %s\n\n", hostname)
+// formatHostInfo renders the target, the optional probes line and the meta
+func formatHostInfo(hostname, probes string, meta *Meta) string {
+	return fmt.Sprintf("Target Host: %s\n", hostname) + probes + formatMeta(meta) + "\n"
+}
+
+// formatTiming states how long the trace took and when it finished. Like
+// the start time in the metadata, it is left out with Config.NoMeta, and
+// for stored results that predate it.
+func formatTiming(res *Result) string {
+	if res.Meta == nil || res.FinishedAt.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("Duration: %s (finished %s)\n",
+		res.Duration.Round(100*time.Millisecond), res.FinishedAt.Format(time.RFC3339))
+}
+
+// formatProbes states how many probe cycles were requested and, when it
+// differs, how many the statistics are actually based on
+func formatProbes(res *Result) string {
+	if res.ProbesRequested == 0 {
+		return ""
+	}
+	if res.ProbesSent > 0 && res.ProbesSent != res.ProbesRequested {
+		return fmt.Sprintf("Probes: %d requested (%d sent to furthest hop)\n", res.ProbesRequested, res.ProbesSent)
+	}
+	return fmt.Sprintf("Probes: %d requested\n", res.ProbesRequested)
+}
+
+// targetDisplay names the target, including the address probed for subnets
+func (r *Result) targetDisplay() string {
+	if r.ProbedAddress != "" {
+		return fmt.Sprintf("%s (probed %s)", r.Target, r.ProbedAddress)
+	}
+	return r.Target
+}
+
+// displayHost returns the name shown for a hop, falling back to label when unknown
+func displayHost(hop HopData, label string) string {
+	if hop.Hostname == "" {
+		return label
+	}
+	return hop.Hostname
+}
+
+// tableHost returns the host shown in the table: the hop's name with its
+// address, when the name does not already include it
+func tableHost(hop HopData, label string) string {
+	host := displayHost(hop, label)
+	if hop.IP != "" && hop.Hostname != hop.IP && !strings.Contains(hop.Hostname, hop.IP) {
+		host = fmt.Sprintf("%s (%s)", hop.Hostname, hop.IP)
+	}
+	if hop.Loop {
+		host += " [loop]"
+	}
+	return host
 }
 
-func colorizeOutput(hops []HopData) string {
+func colorizeOutput(hops []HopData, cfg Config) string {
+	label := cfg.unknownHostLabel()
 	var table strings.Builder
 	
+	// Numeric columns are padded on the left when right-aligned. The loss
+	// and latency color codes wrap the padded field so they do not affect
+	// the width.
+	pad := "%-*"
+	if cfg.Align == AlignRight {
+		pad = "%*"
+	}
+	// The host column comes last, after the optional percentile columns
+	headerFormat := "%-*s  " + strings.Repeat(pad+"s  ", 8)
+	rowFormat := "%-*d  %s" + pad + ".1f%s  " + pad + "d  " + pad + ".1f  %s" + pad + ".1f%s  " +
+		strings.Repeat(pad+".1f  ", 4)
+
+	// Size the host column to the longest host, so long PTR names are not
+	// cut off and short ones waste no space
+	hostWidth := len("Host")
+	for _, hop := range hops {
+		if n := utf8.RuneCountInString(tableHost(hop, label)); n > hostWidth {
+			hostWidth = n
+		}
+	}
+	if hostWidth > maxHostWidth && !cfg.Wide {
+		hostWidth = maxHostWidth
+	}
+
 	// Write header
-	table.WriteString(fmt.Sprintf("%-*s  %-*s  %-*s  %-*s  %-*s  %-*s  %-*s  %-*s  %-*s\n",
+	table.WriteString(fmt.Sprintf(headerFormat,
 		columnWidths["hop"], "Hop",
 		columnWidths["loss"], "Loss%",
 		columnWidths["snt"], "Snt",
@@ -111,284 +562,448 @@ func colorizeOutput(hops []HopData) string {
 		columnWidths["best"], "Best",
 		columnWidths["worst"], "Wrst",
 		columnWidths["stdev"], "StDev",
-		columnWidths["host"], "Host"))
+		columnWidths["jitter"], "Jttr"))
+	if cfg.Percentiles {
+		for _, name := range percentileColumns {
+			table.WriteString(fmt.Sprintf(pad+"s  ", percentileWidth, name))
+		}
+	}
+	table.WriteString(fmt.Sprintf("%-*s", hostWidth, "Host"))
+	// The AS and location columns are only shown when enrichment found
+	// them, each as wide as its longest value
+	var asWidth, locationWidth int
+	if hasASN(hops) {
+		asWidth = textColumnWidth(hops, "AS", formatAS)
+		table.WriteString(fmt.Sprintf("  %-*s", asWidth, "AS"))
+	}
+	if hasLocation(hops) {
+		locationWidth = textColumnWidth(hops, "Location", formatLocation)
+		table.WriteString(fmt.Sprintf("  %-*s", locationWidth, "Location"))
+	}
+	if cfg.HopBadges {
+		table.WriteString("  Class")
+	}
+	table.WriteString("\n")
 	
 	// Write separator
-	totalWidth := 0
-	for _, width := range columnWidths {
-		totalWidth += width + 2 // +2 for spacing
+	totalWidth := hostWidth + 2 // +2 for spacing
+	for name, width := range columnWidths {
+		if name != "host" {
+			totalWidth += width + 2
+		}
+	}
+	if cfg.Percentiles {
+		totalWidth += len(percentileColumns) * (percentileWidth + 2)
 	}
 	table.WriteString(strings.Repeat("-", totalWidth) + "\n")
 	
 	// Write data rows
-	for _, hop := range hops {
+	writeRow := func(hop HopData) {
 		// Color code for loss percentage
 		lossColor := colorGreen
-		if hop.Loss > 20 {
+		if cfg.lossIgnored(hop) {
+			lossColor = ""
+		} else if hop.Loss > lossHighThreshold {
 			lossColor = colorRed
-		} else if hop.Loss > 5 {
+		} else if hop.Loss > lossWarnThreshold {
 			lossColor = colorYellow
 		}
 		
-		// Format host string
-		hostStr := hop.Hostname
-		if hop.IP != "" && hop.Hostname != hop.IP && !strings.Contains(hop.Hostname, hop.IP) {
-			hostStr = fmt.Sprintf("%s (%s)", hop.Hostname, hop.IP)
+		// Color code for average latency
+		avgColor, avgReset := "", ""
+		if hop.Avg >= latencyHighThreshold {
+			avgColor, avgReset = colorYellow, colorReset
+		}
+
+		hostStr := tableHost(hop, label)
+		if utf8.RuneCountInString(hostStr) > hostWidth {
+			hostStr = string([]rune(hostStr)[:hostWidth-1]) + "…"
 		}
 		
 		// Write the row with colors
-		table.WriteString(fmt.Sprintf("%-*d  %s%-*.1f%s  %-*d  %-*.1f  %-*.1f  %-*.1f  %-*.1f  %-*.1f  %-*s\n",
+		table.WriteString(fmt.Sprintf(rowFormat,
 			columnWidths["hop"], hop.Hop,
 			lossColor, columnWidths["loss"], hop.Loss, colorReset,
 			columnWidths["snt"], hop.Sent,
 			columnWidths["last"], hop.Last,
-			columnWidths["avg"], hop.Avg,
+			avgColor, columnWidths["avg"], hop.Avg, avgReset,
 			columnWidths["best"], hop.Best,
 			columnWidths["worst"], hop.Worst,
 			columnWidths["stdev"], hop.StDev,
-			columnWidths["host"], hostStr))
+			columnWidths["jitter"], hop.Jitter))
+		if cfg.Percentiles {
+			for _, v := range []float64{hop.P50, hop.P95, hop.P99} {
+				table.WriteString(fmt.Sprintf(pad+".1f  ", percentileWidth, v))
+			}
+		}
+		table.WriteString(fmt.Sprintf("%-*s", hostWidth, hostStr))
+		if asWidth > 0 {
+			table.WriteString(fmt.Sprintf("  %-*s", asWidth, formatAS(hop)))
+		}
+		if locationWidth > 0 {
+			table.WriteString(fmt.Sprintf("  %-*s", locationWidth, formatLocation(hop)))
+		}
+		if cfg.HopBadges && hop.Classification != "" {
+			table.WriteString(fmt.Sprintf("  %s%s%s", hopClassColor[hop.Classification], hop.Classification, colorReset))
+		}
+		table.WriteString("\n")
+	}
+
+	// Collapse the middle of long routes into a single summary line
+	head, omitted, tail := collapseHops(hops, cfg.MaxDisplayHops)
+	for _, hop := range head {
+		writeRow(hop)
+	}
+	if len(omitted) > 0 {
+		table.WriteString(formatOmitted(omitted) + "\n")
+	}
+	for _, hop := range tail {
+		writeRow(hop)
 	}
 	
 	return table.String()
 }
 
-func generateSummary(hops []HopData) string {
-	if len(hops) == 0 {
-		return "\nNo route data available.\n"
+// ErrNoReply is returned when no probe of a trace was answered by any hop
+var ErrNoReply = errors.New("no probe received a reply")
+
+// shortOfProbes reports whether mtr sent significantly fewer probes than
+// requested. The last probe often misses mtr's collection window, so a
+// shortfall of one cycle, or of up to 10% of long runs, is expected.
+func shortOfProbes(sent, requested int) bool {
+	tolerance := requested / 10
+	if tolerance < 1 {
+		tolerance = 1
 	}
-	
-	var summary strings.Builder
-	summary.WriteString("\nSummary:\n")
-	summary.WriteString("--------\n")
-	
-	// Find worst performing hops
-	var worstLoss, worstLatency HopData
-	worstLoss = hops[0]
-	worstLatency = hops[0]
-	
+	return requested-sent > tolerance
+}
+
+// anyReply reports whether any probe of the run was answered
+func anyReply(hops []HopData) bool {
 	for _, hop := range hops {
-		if hop.Loss > worstLoss.Loss {
-			worstLoss = hop
+		if hop.Loss < 100 {
+			return true
 		}
-		if hop.Avg > worstLatency.Avg {
-			worstLatency = hop
-		}
-	}
-	
-	// Report worst loss
-	if worstLoss.Loss > 0 {
-		summary.WriteString(fmt.Sprintf("Worst packet loss at hop %d (%s): %.1f%%\n",
-			worstLoss.Hop, worstLoss.Hostname, worstLoss.Loss))
-	} else {
-		summary.WriteString("No packet loss detected\n")
-	}
-	
-	// Report worst latency
-	summary.WriteString(fmt.Sprintf("Highest average latency at hop %d (%s): %.1f ms\n",
-		worstLatency.Hop, worstLatency.Hostname, worstLatency.Avg))
-	
-	// Calculate end-to-end metrics
-	if len(hops) > 0 {
-		lastHop := hops[len(hops)-1]
-		summary.WriteString(fmt.Sprintf("\nEnd-to-end metrics for %s:\n", lastHop.Hostname))
-		summary.WriteString(fmt.Sprintf("  Average: %.1f ms\n", lastHop.Avg))
-		summary.WriteString(fmt.Sprintf("  Best: %.1f ms\n", lastHop.Best))
-		summary.WriteString(fmt.Sprintf("  Worst: %.1f ms\n", lastHop.Worst))
-		summary.WriteString(fmt.Sprintf("  Standard Deviation: %.1f ms\n", lastHop.StDev))
 	}
-	
-	return summary.String()
+	return false
 }
 
-func parseOutput(output string, count int) []HopData {
-	lines := strings.Split(output, "\n")
-	hopMap := make(map[string]*HopData)
-	
-	// Track sequence numbers to match p lines with their corresponding hop
-	seqMap := make(map[string]string) // maps sequence -> hop number
-	
-	// Track received pings per hop
-	receivedPings := make(map[string]int)
-	
-	for _, line := range lines {
-		if line == "" {
+// removeDuplicateHops drops repeated last hops, which mtr reports once per
+// TTL after the destination has been reached. The hops of a suspected loop,
+// if any, are kept and marked instead, since the repeats are the finding.
+func removeDuplicateHops(hops []HopData, loop *loopInfo) []HopData {
+	var result []HopData
+	var lastHop *HopData
+	for i := range hops {
+		hop := &hops[i]
+		if loop != nil && hop.Hop >= loop.FirstHop && hop.Hop <= loop.LastHop {
+			hop.Loop = true
+			result = append(result, *hop)
+			lastHop = hop
 			continue
 		}
-		
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
+		// Skip if this is a duplicate of the last hop (same IP/hostname) and not the first hop
+		if lastHop != nil && hop.Hop > 1 &&
+			((hop.IP != "" && hop.IP == lastHop.IP) ||
+				(hop.Hostname != "" && hop.Hostname == lastHop.Hostname)) {
 			continue
 		}
-		
-		recordType := parts[0]
-		hopNum := parts[1]
-		
-		// Convert hop number to 1-based index for display
-		hopNumInt, _ := strconv.Atoi(hopNum)
-		hopNumInt++ // Convert to 1-based
-		hopNum = strconv.Itoa(hopNumInt)
-		
-		// Initialize hop if not exists
-		if _, exists := hopMap[hopNum]; !exists {
-			hopMap[hopNum] = &HopData{
-				Hop:      hopNumInt,
-				Hostname: "???",
-				IP:      "",
-				Loss:    100.0,
-				Sent:    count, // Set sent to total attempts from config
-				Last:    0.0,
-				Avg:     0.0,
-				Best:    math.MaxFloat64,
-				Worst:   0.0,
-				StDev:   0.0,
-			}
+		result = append(result, *hop)
+		lastHop = hop
+	}
+	return result
+}
+
+// DefaultKillGrace is how long a cancelled mtr may take to exit after SIGTERM
+const DefaultKillGrace = 5 * time.Second
+
+// execute runs mtr once for cfg and returns the parsed hops. The raw output
+// and abort state are recorded on res.
+func execute(ctx context.Context, cfg Config, res *Result) ([]HopData, error) {
+	argv := commandLine(cfg)
+	log.Debug().Str("command", strings.Join(argv, " ")).Msg("Running mtr")
+
+	// Parse the output as it arrives so bounds can be checked while mtr runs
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var raw, rawErr strings.Builder
+	p := newParser(cfg.Count)
+	lines := &lineWriter{fn: func(line string) {
+		raw.WriteString(line + "\n")
+		log.Debug().Str("line", line).Msg("mtr output")
+		if cfg.NativeJSON {
+			return
 		}
-		
-		hop := hopMap[hopNum]
-		
-		switch recordType {
-		case "h": // IP address
-			if len(parts) >= 3 {
-				hop.IP = parts[2]
-				if hop.Hostname == "???" { // Only use IP as hostname if we don't have a DNS name
-					hop.Hostname = parts[2]
-				}
-			}
-			
-		case "d": // DNS name
-			if len(parts) >= 3 {
-				hostname := strings.Join(parts[2:], " ")
-				hop.Hostname = hostname
-			}
-			
-		case "x": // New sequence
-			if len(parts) >= 3 {
-				seqMap[parts[2]] = hopNum
-			}
-			
-		case "p": // Ping result
-			if len(parts) >= 4 {
-				// Match sequence number to get correct hop
-				seq := parts[3]
-				if hopForSeq, exists := seqMap[seq]; exists {
-					hop = hopMap[hopForSeq]
-					receivedPings[hopNum]++
-					
-					// Convert usec to ms
-					usec, err := strconv.ParseFloat(parts[2], 64)
-					if err == nil {
-						ms := usec / 1000.0
-						hop.Last = ms
-						
-						// Update Best/Worst
-						if ms < hop.Best {
-							hop.Best = ms
-						}
-						if ms > hop.Worst {
-							hop.Worst = ms
-						}
-						
-						// Update Average
-						received := float64(receivedPings[hopNum])
-						hop.Avg = (hop.Avg*(received-1) + ms) / received
-						
-						// Update StDev if we have more than one sample
-						if received > 1 {
-							sumSq := 0.0
-							for i := 0; i < int(received-1); i++ {
-								sumSq += (hop.Last - hop.Avg) * (hop.Last - hop.Avg)
-							}
-							hop.StDev = math.Sqrt(sumSq / (received - 1))
-						}
-					}
-				}
-			}
+		hop, ms, reply := p.feed(line)
+		if reply && cfg.Progress != nil {
+			cfg.Progress(p.snapshot(hop))
 		}
+		if reply && cfg.AbortLatency > 0 && ms > cfg.AbortLatency && !res.Aborted {
+			res.Aborted = true
+			res.AbortReason = fmt.Sprintf("hop %d latency %.1f ms exceeded the %.1f ms bound", hop, ms, cfg.AbortLatency)
+			cancel()
+		}
+	}}
+	errLines := &lineWriter{fn: func(line string) {
+		rawErr.WriteString(line + "\n")
+		log.Debug().Str("line", line).Msg("mtr stderr")
+	}}
+
+	// On cancellation ask mtr to exit with SIGTERM and only kill it once
+	// the grace period has passed. Signalling only the child would reach
+	// sudo or a wrapper rather than mtr itself, so the whole process group
+	// the command runs in is signalled. The kill is not sent once Run has
+	// returned, when the group leader has been reaped and its ID may be
+	// reused.
+	grace := cfg.KillGrace
+	if grace <= 0 {
+		grace = DefaultKillGrace
 	}
-	
-	// Convert map to sorted slice
-	var result []HopData
-	maxHop := 0
-	for _, hop := range hopMap {
-		if hop.Hop > maxHop {
-			maxHop = hop.Hop
+	var (
+		killMu    sync.Mutex
+		killTimer *time.Timer
+		reaped    bool
+		killed    bool
+	)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = lines
+	cmd.Stderr = errLines
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		killMu.Lock()
+		killTimer = time.AfterFunc(grace, func() {
+			killMu.Lock()
+			defer killMu.Unlock()
+			if !reaped {
+				killed = true
+				signalGroup(cmd, syscall.SIGKILL)
+			}
+		})
+		killMu.Unlock()
+		return signalGroup(cmd, syscall.SIGTERM)
+	}
+	// Stop waiting for output held open by processes outside the group
+	cmd.WaitDelay = grace + time.Second
+	err := cmd.Run()
+	killMu.Lock()
+	reaped = true
+	wasKilled := killed
+	if killTimer != nil {
+		killTimer.Stop()
+	}
+	killMu.Unlock()
+	lines.flush()
+	errLines.flush()
+	outputStr := raw.String()
+	res.RawOutput = outputStr
+	res.ErrOutput = rawErr.String()
+	log.Debug().Err(err).Int("bytes", len(outputStr)).Int("stderr_bytes", len(res.ErrOutput)).Msg("mtr exited")
+	// Failures are reported on either stream, depending on who noticed them
+	diagnostics := res.diagnostics()
+
+	if wasKilled {
+		lingered := fmt.Sprintf("mtr did not exit within %s of SIGTERM and was killed", grace)
+		if !res.Aborted {
+			return nil, fmt.Errorf("%s: %v", lingered, err)
 		}
+		res.Warnings = append(res.Warnings, lingered)
 	}
-	
-	// Initialize Best to 0 for hops with no successful pings
-	for hopNum, hop := range hopMap {
-		if hop.Best == math.MaxFloat64 {
-			hop.Best = 0
+
+	if err != nil && !res.Aborted && cfg.NativeJSON && jsonUnsupported(diagnostics) {
+		res.Warnings = append(res.Warnings, "This mtr does not support --json; statistics were computed from raw probe records")
+		cfg.NativeJSON = false
+		return execute(ctx, cfg, res)
+	}
+
+	if err != nil && !res.Aborted {
+		// A signal we sent on cancellation is not a crash
+		if crash, ok := crashError(err, diagnostics); ok && ctx.Err() == nil {
+			return nil, crash
 		}
-		
-		// Calculate loss percentage based on received pings
-		received := float64(receivedPings[hopNum])
-		if count > 0 {
-			hop.Loss = 100.0 * (float64(count) - received) / float64(count)
-		} else {
-			hop.Loss = 100.0
+		// Without sudo a missing mtr fails to start rather than printing
+		if strings.Contains(diagnostics, "command not found") || errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("mtr command not found - please install mtr using 'brew install mtr'")
+		}
+		if strings.Contains(diagnostics, "socket: Permission denied") {
+			return nil, fmt.Errorf("permission denied - try running with sudo")
+		}
+		mtrErr := fmt.Errorf("mtr error: %v", err)
+		if diagnostics != "" {
+			mtrErr = fmt.Errorf("mtr error: %v, output: %s", err, strings.TrimSpace(diagnostics))
 		}
+		if ctx.Err() == nil && transientFailure(diagnostics) {
+			return nil, &transientError{err: mtrErr}
+		}
+		return nil, mtrErr
 	}
-	
-	// Build sorted result, removing duplicate last hops
-	var lastHop *HopData
-	for i := 1; i <= maxHop; i++ {
-		if hop, exists := hopMap[strconv.Itoa(i)]; exists {
-			// Skip if this is a duplicate of the last hop (same IP/hostname) and not the first hop
-			if lastHop != nil && i > 1 && 
-				((hop.IP != "" && hop.IP == lastHop.IP) || 
-				(hop.Hostname != "???" && hop.Hostname == lastHop.Hostname)) {
-				continue
-			}
-			result = append(result, *hop)
-			lastHop = hop
+
+	if cfg.NativeJSON {
+		if !strings.Contains(outputStr, "{") {
+			// Errors such as a failed lookup are printed instead of a report
+			return nil, nil
 		}
+		hops, err := parseJSONReport(outputStr)
+		logHops("Parsed mtr JSON report", hops)
+		return hops, err
 	}
-	
-	return result
+
+	if sent := p.probesSent(); sent > 0 && shortOfProbes(sent, cfg.Count) && !res.Aborted {
+		res.Warnings = append(res.Warnings, fmt.Sprintf(
+			"mtr sent %d probes per hop instead of the requested %d; loss is computed from the probes sent", sent, cfg.Count))
+	}
+	hops := p.hops()
+	logHops("Parsed mtr raw output", hops)
+	return hops, nil
+}
+
+// logHops logs the statistics of every parsed hop at debug level, to see
+// what the parser made of mtr's output
+func logHops(msg string, hops []HopData) {
+	log.Debug().Int("hops", len(hops)).Msg(msg)
+	for _, hop := range hops {
+		log.Debug().
+			Int("hop", hop.Hop).
+			Str("ip", hop.IP).
+			Str("hostname", hop.Hostname).
+			Int("sent", hop.Sent).
+			Float64("loss", hop.Loss).
+			Float64("avg", hop.Avg).
+			Float64("best", hop.Best).
+			Float64("worst", hop.Worst).
+			Msg("Parsed hop")
+	}
+}
+
+// diagnostics returns mtr's stderr followed by its stdout, for the error
+// messages either may hold
+func (r *Result) diagnostics() string {
+	return r.ErrOutput + r.RawOutput
 }
 
 // Run executes the MTR command with the given configuration
 func Run(ctx context.Context, cfg Config) (*Result, error) {
-	args := []string{"-n", mtrPath} // -n flag for sudo to avoid reading from stdin
-	
-	if cfg.Report {
-		args = append(args, "--raw") // Use raw format for better parsing
-	} else {
-		args = append(args, "-n") // Don't resolve names in live mode
+	start := time.Now()
+	// cfg.Hostname is replaced with the address traced below; errors name
+	// the target as given
+	target := cfg.Hostname
+	res := &Result{Target: target, Labels: cfg.Labels, StartedAt: start.UTC()}
+	if !cfg.NoMeta {
+		res.Meta = newMeta(start)
 	}
-	
-	if cfg.Count > 0 {
-		args = append(args, "-c", fmt.Sprintf("%d", cfg.Count))
+	if err := ValidateHostname(cfg.Hostname); err != nil {
+		return nil, err
+	}
+	if err := ValidateFamily(cfg.IPv4, cfg.IPv6); err != nil {
+		return nil, err
+	}
+	if err := ValidateRetries(cfg.Retries); err != nil {
+		return nil, err
+	}
+	if cfg.NativeJSON && (len(cfg.VaryPorts) > 0 || cfg.AbortLatency > 0) {
+		return nil, fmt.Errorf("mtr's JSON report has no per-probe samples, so it cannot be combined with vary-port or abort-if-latency-exceeds")
+	}
+	if cfg.IPOnly {
+		if err := ValidateIPLiteral(cfg.Hostname); err != nil {
+			return nil, err
+		}
+	}
+	if IsCIDR(cfg.Hostname) {
+		addr, err := PickCIDRAddress(cfg.Hostname, cfg.CIDRPick)
+		if err != nil {
+			return nil, err
+		}
+		if subnet, err := ParseCIDR(cfg.Hostname); err == nil && cfg.restrictsTargets() {
+			if err := cfg.checkSubnet(cfg.Hostname, subnet); err != nil {
+				return nil, err
+			}
+		}
+		res.ProbedAddress = addr
+		cfg.Hostname = addr
+	}
+	if err := resolveTarget(ctx, cfg, res); err != nil {
+		return nil, err
+	}
+	if cfg.restrictsTargets() {
+		if err := cfg.checkTarget(cfg.Hostname, res.ResolvedIPs); err != nil {
+			return nil, err
+		}
+		// Trace the checked address, so mtr's own lookup cannot be
+		// answered with a private one (DNS rebinding)
+		cfg.Hostname = res.ResolvedIPs[0]
 	}
-	
-	// Add hostname
-	args = append(args, cfg.Hostname)
-
-	cmd := exec.CommandContext(ctx, sudoPath, args...)
-	output, err := cmd.CombinedOutput()
-	outputStr := string(output)
 
-	if err != nil {
-		if strings.Contains(outputStr, "command not found") {
-			return nil, fmt.Errorf("mtr command not found - please install mtr using 'brew install mtr'")
+	hops, err := withRetries(ctx, cfg, res, func() ([]HopData, error) {
+		if len(cfg.VaryPorts) > 0 {
+			return executeVaried(ctx, cfg, res)
 		}
-		if strings.Contains(outputStr, "socket: Permission denied") {
-			return nil, fmt.Errorf("permission denied - try running with sudo")
+		return execute(ctx, cfg, res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.FinishedAt = time.Now().UTC()
+	res.Duration = res.FinishedAt.Sub(res.StartedAt)
+	outputStr := res.diagnostics()
+
+	// Callers that only want mtr's own output skip our parsing and formatting
+	if cfg.RawOnly {
+		return res, nil
+	}
+
+	if res.Aborted {
+		res.Warnings = append(res.Warnings, "Trace aborted early: "+res.AbortReason)
+	}
+	var loopPtr *loopInfo
+	if loop, ok := detectLoop(hops); ok {
+		res.LoopSuspected = true
+		res.Warnings = append(res.Warnings, loop.String())
+		loopPtr = &loop
+	}
+	hops = removeDuplicateHops(hops, loopPtr)
+	if cfg.DestinationHop > 0 && len(hops) > 0 && !hasHop(hops, cfg.DestinationHop) {
+		return nil, fmt.Errorf("destination hop %d was not discovered; the trace found hops 1 to %d", cfg.DestinationHop, hops[len(hops)-1].Hop)
+	}
+	classifyHops(hops, cfg.HopClasses)
+	enrichHops(ctx, cfg.Enrichers, hops, res)
+	res.Hops = hops
+	res.ProbesRequested = cfg.Count
+	if len(hops) > 0 {
+		dest := hops[len(hops)-1]
+		res.ProbesSent = len(dest.Samples)
+		if dest.Samples == nil {
+			// Without probe records (mtr's JSON report) mtr's count is all there is
+			res.ProbesSent = dest.Sent
 		}
-		if outputStr != "" {
-			return nil, fmt.Errorf("mtr error: %v, output: %s", err, outputStr)
+	}
+
+	// A trace is judged on its whole run: hops that stayed silent in the
+	// first cycles and answered later are regular hops with some loss. It
+	// only fails when no probe was answered at all.
+	if len(hops) > 0 && !anyReply(hops) {
+		if cfg.withoutSudo() && privilegedProbes() {
+			return nil, fmt.Errorf("%w in the whole run (%d probes sent to the furthest hop) and mtr ran without sudo; "+
+				"it probably lacks the raw socket privileges it needs, so this is unlikely to be real packet loss", ErrNoReply, res.ProbesSent)
 		}
-		return nil, fmt.Errorf("mtr error: %v", err)
+		return nil, fmt.Errorf("%w in the whole run (%d probes sent to the furthest hop)", ErrNoReply, res.ProbesSent)
 	}
 
-	// Parse the output
-	hops := parseOutput(outputStr, cfg.Count)
+	res.Health = evaluateHealth(hops, cfg)
+	res.DestinationReached = destinationReached(hops, res.ResolvedIPs)
+	if len(hops) > 0 && !res.DestinationReached && !cfg.FirstHopOnly {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("Destination %s was not reached", strings.Join(res.ResolvedIPs, ", ")))
+	}
+	if !cfg.FirstHopOnly {
+		res.Analysis = analyze(hops, res, loopPtr)
+	}
+	if cfg.History != nil && !cfg.FirstHopOnly {
+		compareHistory(cfg.History, res)
+	}
 	
 	// If no hops were found, check the raw output for error messages
 	if len(hops) == 0 {
 		if strings.Contains(outputStr, "Failure to resolve") {
-			return nil, fmt.Errorf("failed to resolve hostname: %s", cfg.Hostname)
+			return nil, fmt.Errorf("failed to resolve hostname: %s", target)
 		}
 		if strings.Contains(outputStr, "socket: Permission denied") {
 			return nil, fmt.Errorf("permission denied - try running with sudo")
@@ -399,15 +1014,41 @@ func Run(ctx context.Context, cfg Config) (*Result, error) {
 		return nil, fmt.Errorf("no route data available\nRaw output:\n%s", outputStr)
 	}
 	
-	// Combine all output components
-	finalOutput := formatHeader() +
+	switch cfg.Format {
+	case FormatReport:
+		res.Output = formatReport(hops, cfg, start)
+	case FormatBinary:
+		// Binary results are encoded by the caller with NewBinaryWriter
+	case FormatGeoJSON:
+		res.Output = formatGeoJSON(res, cfg)
+	case FormatJSON:
+		res.Output = formatJSON(res, cfg)
+	case FormatCSV:
+		res.Output = formatCSV(res, cfg)
+	case FormatHTML:
+		res.Output = RenderHTML(res, cfg)
+	case FormatMarkdown:
+		res.Output = RenderMarkdown(res, cfg)
+	default:
+		res.Output = Render(res, cfg)
+	}
+	return res, nil
+}
+
+// Render formats a parsed result as the table with its summary. It is used
+// by Run and to display stored results again.
+func Render(res *Result, cfg Config) string {
+	output := formatHeader() +
 		formatHeaderExplanation() +
-		formatHostInfo(cfg.Hostname) +
-		colorizeOutput(hops) +
-		generateSummary(hops)
-	
-	return &Result{
-		Output: finalOutput,
-		Error:  nil,
-	}, nil
+		formatHostInfo(res.targetDisplay(), formatProbes(res)+formatTiming(res), res.Meta) +
+		colorizeOutput(res.Hops, cfg) +
+		generateSummary(res, cfg)
+	if cfg.Matrix {
+		output += formatMatrix(res.Hops)
+	}
+	// Detailed summaries already include the interpretation
+	if cfg.Explain && !cfg.FirstHopOnly && cfg.SummaryLevel != SummaryDetailed {
+		output += formatExplanation(res.Analysis)
+	}
+	return cfg.withColors(output)
 }
@@ -0,0 +1,473 @@
+package mtr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMTR replaces the mtr binary with a script printing output, for the
+// duration of the test
+func fakeMTR(t *testing.T, output string) {
+	t.Helper()
+	fakeMTRScript(t, "cat <<'EOF'\n"+output+"EOF\n")
+}
+
+// fakeMTRScript replaces the mtr binary with a shell script running body
+func fakeMTRScript(t *testing.T, body string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "mtr")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := mtrPath
+	mtrPath = path
+	t.Cleanup(func() { mtrPath = old })
+}
+
+// testConfig is a configuration for tracing a documentation address with
+// the fake mtr
+func testConfig(count int) Config {
+	return Config{Hostname: "192.0.2.1", Count: count, Report: true, NoSudo: true, NoMeta: true}
+}
+
+// rawProbes renders mtr --raw records for hop (0-based) answering from ip,
+// or silent when ip is empty: an x line per cycle and a p line for every
+// cycle with an RTT in ms in rtts. seq numbers the probes across hops.
+func rawProbes(hop int, ip string, seq *int, rtts map[int]float64, cycles int) string {
+	var b strings.Builder
+	if ip != "" {
+		fmt.Fprintf(&b, "h %d %s\n", hop, ip)
+	}
+	for c := 0; c < cycles; c++ {
+		*seq++
+		fmt.Fprintf(&b, "x %d %d\n", hop, *seq)
+		if ms, ok := rtts[c]; ok {
+			fmt.Fprintf(&b, "p %d %d %d\n", hop, int(ms*1000), *seq)
+		}
+	}
+	return b.String()
+}
+
+func TestRunLateRepliesPass(t *testing.T) {
+	// Both hops stay silent for the first two of four cycles
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{2: 1, 3: 1}, 4)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{2: 10, 3: 12}, 4))
+
+	res, err := Run(context.Background(), testConfig(4))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Hops) != 2 {
+		t.Fatalf("got %d hops, want 2", len(res.Hops))
+	}
+	for _, hop := range res.Hops {
+		if hop.Loss != 50 {
+			t.Errorf("hop %d loss = %v, want 50", hop.Hop, hop.Loss)
+		}
+	}
+	if !res.DestinationReached {
+		t.Error("destination not reached")
+	}
+}
+
+func TestRunNoReplyFails(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "", &seq, nil, 4)+rawProbes(1, "", &seq, nil, 4))
+
+	_, err := Run(context.Background(), testConfig(4))
+	if !errors.Is(err, ErrNoReply) {
+		t.Fatalf("Run error = %v, want ErrNoReply", err)
+	}
+	if !strings.Contains(err.Error(), "4 probes sent") {
+		t.Errorf("error %q does not state the probes sent", err)
+	}
+}
+
+func TestRunNoReplyWithoutSudo(t *testing.T) {
+	if !privilegedProbes() {
+		t.Skip("mtr needs no privileges for its probes on this platform")
+	}
+	const hint = "mtr ran without sudo; it probably lacks the raw socket privileges"
+	silent := func() string {
+		seq := 0
+		return rawProbes(0, "", &seq, nil, 2) + rawProbes(1, "", &seq, nil, 2)
+	}
+
+	fakeMTR(t, silent())
+	_, err := Run(context.Background(), testConfig(2))
+	if !errors.Is(err, ErrNoReply) || !strings.Contains(err.Error(), hint) {
+		t.Errorf("without sudo: error %v, want the privileges hint", err)
+	}
+
+	// Through sudo the loss is taken at face value. The fake sudo drops
+	// its -n and runs mtr.
+	sudo := filepath.Join(t.TempDir(), "sudo")
+	if err := os.WriteFile(sudo, []byte("#!/bin/sh\nshift\nexec \"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := sudoPath
+	sudoPath = sudo
+	t.Cleanup(func() { sudoPath = old })
+	cfg := testConfig(2)
+	cfg.NoSudo = false
+	_, err = Run(context.Background(), cfg)
+	if !errors.Is(err, ErrNoReply) || strings.Contains(err.Error(), "sudo") {
+		t.Errorf("through sudo: error %v, want no privileges hint", err)
+	}
+
+	// Without sudo, a trace with any reply is not suspect
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{1: 1}, 2)+rawProbes(1, "", &seq, nil, 2))
+	res, err := Run(context.Background(), testConfig(2))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if strings.Contains(strings.Join(res.Warnings, "\n"), "sudo") {
+		t.Errorf("warnings %q with a reply", res.Warnings)
+	}
+}
+
+func TestUnknownHostLabel(t *testing.T) {
+	empty, stars := "", "* * *"
+	tests := []struct {
+		name  string
+		label *string
+		want  string
+	}{
+		{"default", nil, DefaultUnknownHostLabel},
+		{"custom", &stars, stars},
+		{"empty", &empty, ""},
+	}
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "gw.example.net", Sent: 1},
+		{Hop: 2, Loss: 100, Sent: 1},
+	}
+	for _, tt := range tests {
+		cfg := Config{UnknownHostLabel: tt.label, NoColor: true}
+		if got := cfg.unknownHostLabel(); got != tt.want {
+			t.Errorf("%s: label %q, want %q", tt.name, got, tt.want)
+		}
+		// The label reaches every output that names hops
+		table := colorizeOutput(hops, cfg)
+		row := strings.Split(strings.TrimSpace(table), "\n")[3]
+		if !strings.HasSuffix(strings.TrimRight(row, " "), strings.TrimRight(" "+tt.want, " ")) {
+			t.Errorf("%s: table row %q does not end with %q", tt.name, row, tt.want)
+		}
+		if tt.want != DefaultUnknownHostLabel && strings.Contains(row, DefaultUnknownHostLabel) {
+			t.Errorf("%s: table row %q shows the default label", tt.name, row)
+		}
+		csv := formatCSV(&Result{Hops: hops}, cfg)
+		if !strings.Contains(csv, "\n2,"+tt.want+",,") {
+			t.Errorf("%s: csv %q lacks the label", tt.name, csv)
+		}
+		js := formatJSON(&Result{Hops: hops}, cfg)
+		if !strings.Contains(js, `"hostname": "`+tt.want+`"`) {
+			t.Errorf("%s: json lacks the label:\n%s", tt.name, js)
+		}
+	}
+}
+
+func TestUnknownHostsNullInJSON(t *testing.T) {
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "gw.example.net", Sent: 1},
+		{Hop: 2, Loss: 100, Sent: 1},
+	}
+	js := formatJSON(&Result{Hops: hops}, Config{NullUnknownHosts: true})
+	var out struct {
+		Hops []struct {
+			Hostname *string `json:"hostname"`
+			IP       *string `json:"ip"`
+		} `json:"hops"`
+	}
+	if err := json.Unmarshal([]byte(js), &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Hops[0].Hostname == nil || *out.Hops[0].Hostname != "gw.example.net" || out.Hops[0].IP == nil {
+		t.Errorf("answered hop: hostname %v ip %v", out.Hops[0].Hostname, out.Hops[0].IP)
+	}
+	if out.Hops[1].Hostname != nil || out.Hops[1].IP != nil {
+		t.Errorf("silent hop: hostname %v ip %v, want null", out.Hops[1].Hostname, out.Hops[1].IP)
+	}
+}
+
+func TestRunErrorsNameTarget(t *testing.T) {
+	fakeMTR(t, "Failure to resolve host\n")
+
+	// Checked targets are traced by their resolved address
+	cfg := testConfig(1)
+	cfg.Hostname = "trace.example.com"
+	deny, err := ParseTargetList("198.51.100.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Deny = deny
+	cfg.Resolver = slowResolver{addrs: []string{"192.0.2.1"}}
+	// mtr's JSON report is replaced with the error when it fails
+	cfg.NativeJSON = true
+	_, err = Run(context.Background(), cfg)
+	if err == nil || err.Error() != "failed to resolve hostname: trace.example.com" {
+		t.Errorf("Run error = %v, want it to name trace.example.com", err)
+	}
+}
+
+func TestRunDestinationHopNotDiscovered(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1))
+
+	cfg := testConfig(1)
+	cfg.DestinationHop = 5
+	_, err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "destination hop 5 was not discovered; the trace found hops 1 to 2") {
+		t.Errorf("Run error = %v", err)
+	}
+
+	cfg.DestinationHop = 1
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Hops) != 2 {
+		t.Errorf("got %d hops, want hops beyond the destination hop kept", len(res.Hops))
+	}
+}
+
+func TestRunAbortLatency(t *testing.T) {
+	seq := 0
+	output := rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1, 1: 2}, 2) +
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 20, 1: 500}, 2)
+
+	// mtr keeps running after the slow reply until it is stopped
+	fakeMTRScript(t, "cat <<'EOF'\n"+output+"EOF\nexec sleep 30\n")
+	cfg := testConfig(2)
+	cfg.AbortLatency = 100
+	start := time.Now()
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s to abort", elapsed)
+	}
+	if !res.Aborted || !strings.Contains(res.AbortReason, "latency 500.0 ms exceeded the 100.0 ms bound") {
+		t.Errorf("aborted %v: %q", res.Aborted, res.AbortReason)
+	}
+	if len(res.Hops) != 2 || res.Hops[1].Worst != 500 {
+		t.Errorf("hops %+v, want both with the replies so far", res.Hops)
+	}
+	if !strings.Contains(strings.Join(res.Warnings, "\n"), "Trace aborted early") {
+		t.Errorf("warnings %q lack the abort", res.Warnings)
+	}
+
+	// Replies within the bound run the trace to its end
+	fakeMTR(t, output)
+	cfg.AbortLatency = 1000
+	res, err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Aborted {
+		t.Errorf("aborted within the bound: %s", res.AbortReason)
+	}
+}
+
+func TestRunFewerProbesThanRequested(t *testing.T) {
+	// mtr stopped after 4 of the 10 cycles asked for
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1, 1: 1, 2: 1, 3: 1}, 4)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10, 1: 11, 3: 12}, 4))
+
+	cfg := testConfig(10)
+	cfg.NoColor = true
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Hops[0].Loss != 0 || res.Hops[1].Loss != 25 {
+		t.Errorf("loss %v and %v, want 0 and 25 from the probes sent", res.Hops[0].Loss, res.Hops[1].Loss)
+	}
+	if res.Hops[1].Sent != 4 || res.ProbesRequested != 10 || res.ProbesSent != 4 {
+		t.Errorf("sent %d, requested %d, probes sent %d", res.Hops[1].Sent, res.ProbesRequested, res.ProbesSent)
+	}
+	if !strings.Contains(strings.Join(res.Warnings, "\n"), "mtr sent 4 probes per hop instead of the requested 10") {
+		t.Errorf("warnings %q lack the shortfall", res.Warnings)
+	}
+	if !strings.Contains(res.Output, "Probes: 10 requested (4 sent to furthest hop)") {
+		t.Errorf("output does not state the probes sent:\n%s", res.Output)
+	}
+
+	// All requested probes sent: no warning
+	seq = 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1, 1: 1}, 2)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10, 1: 11}, 2))
+	res, err = Run(context.Background(), testConfig(2))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if strings.Contains(strings.Join(res.Warnings, "\n"), "instead of the requested") {
+		t.Errorf("warnings %q for a complete run", res.Warnings)
+	}
+}
+
+func TestProbeShortfallTolerance(t *testing.T) {
+	tests := []struct {
+		requested, sent int
+		warn            bool
+	}{
+		{10, 10, false},
+		{10, 9, false}, // the last cycle missed the collection window
+		{10, 8, true},
+		{50, 45, false},
+		{50, 44, true},
+		{5, 4, false},
+		{5, 3, true},
+	}
+	for _, tt := range tests {
+		seq := 0
+		fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, tt.sent)+
+			rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, tt.sent))
+		res, err := Run(context.Background(), testConfig(tt.requested))
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		warned := strings.Contains(strings.Join(res.Warnings, "\n"), "instead of the requested")
+		if warned != tt.warn {
+			t.Errorf("%d of %d probes sent: warned %v, want %v", tt.sent, tt.requested, warned, tt.warn)
+		}
+		// The count sent is reported either way
+		if res.ProbesSent != tt.sent {
+			t.Errorf("%d of %d probes sent: probes sent %d", tt.sent, tt.requested, res.ProbesSent)
+		}
+	}
+}
+
+func TestFormatProbes(t *testing.T) {
+	tests := []struct {
+		requested, sent int
+		want            string
+	}{
+		{20, 18, "Probes: 20 requested (18 sent to furthest hop)\n"},
+		{20, 20, "Probes: 20 requested\n"},
+		{20, 0, "Probes: 20 requested\n"},
+		{0, 0, ""},
+	}
+	for _, tt := range tests {
+		res := &Result{ProbesRequested: tt.requested, ProbesSent: tt.sent}
+		if got := formatProbes(res); got != tt.want {
+			t.Errorf("%d requested, %d sent: %q, want %q", tt.requested, tt.sent, got, tt.want)
+		}
+	}
+
+	// The header of the table and the markdown report state the counts
+	res := &Result{Target: "example.com", ProbesRequested: 20, ProbesSent: 18,
+		Hops: []HopData{{Hop: 1, IP: "192.0.2.1", Hostname: "example.com", Sent: 18}}}
+	for name, out := range map[string]string{
+		"table":    Render(res, Config{NoColor: true}),
+		"markdown": RenderMarkdown(res, Config{}),
+	} {
+		if !strings.Contains(out, "20 requested (18 sent to furthest hop)") {
+			t.Errorf("%s lacks the probe counts:\n%s", name, out)
+		}
+	}
+}
+
+func TestColumnAlignment(t *testing.T) {
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "gw.local", Sent: 10, Loss: 0, Last: 1.2, Avg: 1.5, Best: 0.9, Worst: 2.1},
+		{Hop: 12, IP: "192.0.2.1", Hostname: "dest.example.com", Sent: 10, Loss: 100, Last: 210.4, Avg: 1234.5, Best: 180, Worst: 2500},
+	}
+	// Loss and Avg are colored, which must not shift them
+	numeric := map[string]string{"Loss%": "loss", "Snt": "snt", "Avg": "avg", "Wrst": "worst"}
+
+	for _, align := range []Alignment{AlignLeft, AlignRight} {
+		cfg := Config{Align: align, NoColor: true}
+		lines := strings.Split(cfg.withColors(colorizeOutput(hops, cfg)), "\n")
+		header, rows := lines[0], lines[2:4]
+		for name, column := range numeric {
+			width := columnWidths[column]
+			// The header label is aligned like the numbers below it
+			start := strings.Index(header, name)
+			if align == AlignRight {
+				start -= width - len(name)
+			}
+			for _, row := range rows {
+				field := row[start : start+width]
+				trimmed := strings.TrimSpace(field)
+				want := trimmed + strings.Repeat(" ", width-len(trimmed))
+				if align == AlignRight {
+					want = strings.Repeat(" ", width-len(trimmed)) + trimmed
+				}
+				if trimmed == "" || field != want {
+					t.Errorf("%s: %s field %q not %s-aligned in %q", align, name, field, align, row)
+				}
+			}
+		}
+		// Hosts stay left-aligned
+		hostStart := strings.Index(header, "Host")
+		for i, row := range rows {
+			if !strings.HasPrefix(row[hostStart:], hops[i].Hostname) {
+				t.Errorf("%s: host not left-aligned in %q", align, row)
+			}
+		}
+	}
+
+	if _, err := ParseAlignment("center"); err == nil {
+		t.Error("alignment center accepted")
+	}
+}
+
+func TestFindMTR(t *testing.T) {
+	executable := func(dir string) string {
+		path := filepath.Join(dir, "mtr")
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	oldLocations := mtrLocations
+	t.Cleanup(func() { mtrLocations = oldLocations })
+	mtrLocations = nil
+
+	// Found on PATH
+	onPath := executable(t.TempDir())
+	t.Setenv("MTR_PATH", "")
+	t.Setenv("PATH", filepath.Dir(onPath))
+	if got := findMTR(); got != onPath {
+		t.Errorf("found %q, want %q from PATH", got, onPath)
+	}
+
+	// MTR_PATH overrides PATH
+	t.Setenv("MTR_PATH", "/custom/mtr")
+	if got := findMTR(); got != "/custom/mtr" {
+		t.Errorf("found %q, want MTR_PATH", got)
+	}
+
+	// Off PATH, the usual install locations are checked
+	t.Setenv("MTR_PATH", "")
+	t.Setenv("PATH", t.TempDir())
+	sbin := executable(t.TempDir())
+	mtrLocations = []string{filepath.Join(t.TempDir(), "mtr"), sbin}
+	if got := findMTR(); got != sbin {
+		t.Errorf("found %q, want %q from the install locations", got, sbin)
+	}
+
+	// Not installed: running the bare name fails with the install hint
+	mtrLocations = nil
+	if got := findMTR(); got != "mtr" {
+		t.Fatalf("found %q without mtr installed", got)
+	}
+	old := mtrPath
+	mtrPath = findMTR()
+	t.Cleanup(func() { mtrPath = old })
+	if _, err := Run(context.Background(), testConfig(1)); err == nil || !strings.Contains(err.Error(), "please install mtr") {
+		t.Errorf("Run error = %v, want the install hint", err)
+	}
+}
@@ -0,0 +1,95 @@
+package mtr
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// jsonReport is the part of mtr's --json output the hops are read from
+type jsonReport struct {
+	Report struct {
+		Hubs []jsonHub `json:"hubs"`
+	} `json:"report"`
+}
+
+// jsonHub is one hop of mtr's JSON report. Older mtr versions write the hop
+// number as a string.
+type jsonHub struct {
+	Count jsonNumber `json:"count"`
+	Host  string     `json:"host"`
+	Loss  float64    `json:"Loss%"`
+	Sent  jsonNumber `json:"Snt"`
+	Last  float64    `json:"Last"`
+	Avg   float64    `json:"Avg"`
+	Best  float64    `json:"Best"`
+	Worst float64    `json:"Wrst"`
+	StDev float64    `json:"StDev"`
+}
+
+// jsonNumber is an integer that may be written as a number or a string
+type jsonNumber int
+
+func (n *jsonNumber) UnmarshalJSON(b []byte) error {
+	v, err := strconv.Atoi(strings.Trim(string(b), `"`))
+	if err != nil {
+		return fmt.Errorf("invalid number %s", b)
+	}
+	*n = jsonNumber(v)
+	return nil
+}
+
+// unsupportedOption matches the usage errors of mtr versions without --json
+var unsupportedOption = regexp.MustCompile(`(unrecognized|invalid|unknown) option`)
+
+// jsonUnsupported reports whether mtr rejected the --json option
+func jsonUnsupported(output string) bool {
+	return strings.Contains(output, "json") && unsupportedOption.MatchString(output)
+}
+
+// parseJSONReport reads the hops of mtr's --json report, taking its
+// statistics as they are. The report has no per-probe records, so the hops
+// have no samples.
+func parseJSONReport(output string) ([]HopData, error) {
+	// mtr may print warnings before the report
+	start := strings.Index(output, "{")
+	if start < 0 {
+		return nil, fmt.Errorf("no JSON report in mtr output")
+	}
+	var report jsonReport
+	if err := json.Unmarshal([]byte(output[start:]), &report); err != nil {
+		return nil, fmt.Errorf("invalid JSON report from mtr: %v", err)
+	}
+
+	hops := make([]HopData, 0, len(report.Report.Hubs))
+	for _, hub := range report.Report.Hubs {
+		hop := HopData{
+			Hop:   int(hub.Count),
+			Loss:  hub.Loss,
+			Sent:  int(hub.Sent),
+			Last:  hub.Last,
+			Avg:   hub.Avg,
+			Best:  hub.Best,
+			Worst: hub.Worst,
+			StDev: hub.StDev,
+		}
+		hop.Hostname, hop.IP = splitJSONHost(hub.Host)
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// splitJSONHost splits a report host, "name (ip)" with --show-ips or a bare
+// name or address, into the hop's name and IP. Hops that never answered are
+// "???".
+func splitJSONHost(host string) (hostname, ip string) {
+	if host == "???" || host == "" {
+		return "", ""
+	}
+	if name, addr, ok := strings.Cut(host, " ("); ok && strings.HasSuffix(addr, ")") {
+		return name, strings.TrimSuffix(addr, ")")
+	}
+	return host, host
+}
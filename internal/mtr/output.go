@@ -0,0 +1,100 @@
+package mtr
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// jsonOutput is the document printed by the json format: the hops with a
+// small envelope describing the trace
+type jsonOutput struct {
+	SchemaVersion      int       `json:"schema_version"`
+	Target             string    `json:"target"`
+	Count              int       `json:"count"`
+	StartedAt          time.Time `json:"started_at"`
+	FinishedAt         time.Time `json:"finished_at"`
+	DurationMS         float64   `json:"duration_ms"`
+	Meta               *Meta     `json:"meta,omitempty"`
+	Health             Health    `json:"health"`
+	DestinationReached bool      `json:"destination_reached"`
+	Warnings           []string  `json:"warnings,omitempty"`
+	Analysis           *Analysis `json:"analysis,omitempty"`
+	Hops               []hopJSON `json:"hops"`
+}
+
+// hopJSON replaces the hostname and IP of a hop so hops that never answered
+// show the unknown host label, or null for both
+type hopJSON struct {
+	HopData
+	Hostname *string `json:"hostname"`
+	IP       *string `json:"ip"`
+}
+
+// formatJSON renders the hops of res as indented JSON, keyed in
+// cfg.JSONKeys style. It never contains color codes.
+func formatJSON(res *Result, cfg Config) string {
+	out := jsonOutput{
+		SchemaVersion:      SchemaVersion,
+		Target:             res.Target,
+		Count:              cfg.Count,
+		StartedAt:          res.StartedAt,
+		FinishedAt:         res.FinishedAt,
+		DurationMS:         float64(res.Duration.Microseconds()) / 1000.0,
+		Meta:               res.Meta,
+		Health:             res.Health,
+		DestinationReached: res.DestinationReached,
+		Warnings:           res.Warnings,
+		Hops:               make([]hopJSON, len(res.Hops)),
+	}
+	if res.Analysis.Classification != "" {
+		out.Analysis = &res.Analysis
+	}
+	label := cfg.unknownHostLabel()
+	for i, hop := range res.Hops {
+		h := hopJSON{HopData: hop}
+		if hop.IP != "" || !cfg.NullUnknownHosts {
+			h.IP = &res.Hops[i].IP
+		}
+		if hop.Hostname != "" {
+			h.Hostname = &res.Hops[i].Hostname
+		} else if !cfg.NullUnknownHosts {
+			h.Hostname = &label
+		}
+		out.Hops[i] = h
+	}
+
+	data, err := MarshalKeys(out, cfg.JSONKeys)
+	if err != nil {
+		return ""
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, data, "", "  "); err != nil {
+		return string(data)
+	}
+	return indented.String()
+}
+
+// csvHeader names the columns of the csv format
+var csvHeader = []string{"hop", "host", "ip", "loss", "sent", "last", "avg", "best", "worst", "stdev", "jitter"}
+
+// formatCSV renders one row per hop with a header row
+func formatCSV(res *Result, cfg Config) string {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvHeader)
+	label := cfg.unknownHostLabel()
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+	for _, hop := range res.Hops {
+		w.Write([]string{
+			strconv.Itoa(hop.Hop), displayHost(hop, label), hop.IP,
+			f(hop.Loss), strconv.Itoa(hop.Sent), f(hop.Last), f(hop.Avg), f(hop.Best), f(hop.Worst), f(hop.StDev),
+			f(hop.Jitter),
+		})
+	}
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
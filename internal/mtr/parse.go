@@ -0,0 +1,303 @@
+package mtr
+
+import (
+	"bytes"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// parser incrementally builds per-hop statistics from mtr --raw output so
+// results can be evaluated while the trace is still running
+type parser struct {
+	count  int
+	hopMap map[string]*HopData
+
+	// Track sequence numbers to match p lines with their corresponding hop
+	seqMap map[string]string // maps sequence -> hop number
+
+	// Track received pings per hop
+	receivedPings map[string]int
+
+	// Sum of squared deviations from the running mean per hop, for the
+	// standard deviation (Welford's algorithm)
+	m2 map[string]float64
+}
+
+func newParser(count int) *parser {
+	return &parser{
+		count:         count,
+		hopMap:        make(map[string]*HopData),
+		seqMap:        make(map[string]string),
+		receivedPings: make(map[string]int),
+		m2:            make(map[string]float64),
+	}
+}
+
+func parseOutput(output string, count int) []HopData {
+	p := newParser(count)
+	for _, line := range strings.Split(output, "\n") {
+		p.feed(line)
+	}
+	return p.hops()
+}
+
+// feed processes a single line of raw output. When the line is a ping reply
+// it returns the hop it belongs to and the round-trip time in ms.
+func (p *parser) feed(line string) (hopNumInt int, ms float64, reply bool) {
+	// Lines can end in \r\n on some platforms; a stray \r would otherwise
+	// end up in DNS names
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" {
+		return 0, 0, false
+	}
+
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		log.Debug().Str("line", line).Msg("Skipping mtr output line that is not a record")
+		return 0, 0, false
+	}
+
+	recordType := parts[0]
+	hopNum := parts[1]
+
+	// Convert hop number to 1-based index for display
+	hopNumInt, _ = strconv.Atoi(hopNum)
+	hopNumInt++ // Convert to 1-based
+	hopNum = strconv.Itoa(hopNumInt)
+
+	// Initialize hop if not exists
+	if _, exists := p.hopMap[hopNum]; !exists {
+		p.hopMap[hopNum] = &HopData{
+			Hop:   hopNumInt,
+			IP:    "",
+			Loss:  100.0,
+			Sent:  p.count, // Replaced by the probes actually sent in hops()
+			Last:  0.0,
+			Avg:   0.0,
+			Best:  math.MaxFloat64,
+			Worst: 0.0,
+			StDev: 0.0,
+		}
+	}
+
+	hop := p.hopMap[hopNum]
+
+	switch recordType {
+	case "h": // IP address
+		if len(parts) >= 3 {
+			// The first address seen is the hop's IP; any later ones are
+			// alternative paths, e.g. through a load balancer
+			if hop.IP == "" {
+				hop.IP = parts[2]
+			} else {
+				hop.addAltIP(parts[2])
+			}
+			if hop.Hostname == "" { // Only use IP as hostname if we don't have a DNS name
+				hop.Hostname = parts[2]
+			}
+		}
+
+	case "d": // DNS name
+		if len(parts) >= 3 {
+			hostname := strings.Join(parts[2:], " ")
+			hop.Hostname = hostname
+		}
+
+	case "x": // New sequence
+		if len(parts) >= 3 {
+			p.seqMap[parts[2]] = hopNum
+			hop.Samples = append(hop.Samples, Sample{Seq: parts[2], Lost: true})
+		}
+
+	case "p": // Ping result
+		if len(parts) >= 4 {
+			// Match sequence number to get correct hop
+			seq := parts[3]
+			if hopForSeq, exists := p.seqMap[seq]; exists {
+				hop = p.hopMap[hopForSeq]
+				p.receivedPings[hopNum]++
+
+				// Convert usec to ms
+				usec, err := strconv.ParseFloat(parts[2], 64)
+				if err == nil {
+					ms = usec / 1000.0
+					hop.Last = ms
+					hop.recordSample(seq, ms)
+
+					// Update Best/Worst
+					if ms < hop.Best {
+						hop.Best = ms
+					}
+					if ms > hop.Worst {
+						hop.Worst = ms
+					}
+
+					// Update Average and StDev in one pass
+					received := float64(p.receivedPings[hopNum])
+					delta := ms - hop.Avg
+					hop.Avg += delta / received
+					p.m2[hopNum] += delta * (ms - hop.Avg)
+					if received > 1 {
+						hop.StDev = math.Sqrt(p.m2[hopNum] / (received - 1))
+					}
+					return hop.Hop, ms, true
+				}
+			}
+			log.Debug().Str("line", line).Msg("Skipping reply to an unknown or malformed probe")
+		}
+
+	default:
+		log.Debug().Str("line", line).Msg("Skipping unknown mtr record type")
+	}
+
+	return hopNumInt, 0, false
+}
+
+// hops returns the finished statistics for every hop seen so far, ordered by
+// hop number
+func (p *parser) hops() []HopData {
+	// Convert map to sorted slice
+	var result []HopData
+	maxHop := 0
+	for _, hop := range p.hopMap {
+		if hop.Hop > maxHop {
+			maxHop = hop.Hop
+		}
+	}
+
+	// Loss is computed from the probes mtr actually sent, which can be fewer
+	// than requested (e.g. when the trace is cut short) and differ per hop.
+	// Hops without probe records fall back to the most sent to any hop.
+	count := p.count
+	if sent := p.probesSent(); sent > 0 {
+		count = sent
+	}
+
+	// Build sorted result
+	for i := 1; i <= maxHop; i++ {
+		hopNum := strconv.Itoa(i)
+		stored, exists := p.hopMap[hopNum]
+		if !exists {
+			continue
+		}
+		hop := *stored
+
+		// Initialize Best to 0 for hops with no successful pings
+		if hop.Best == math.MaxFloat64 {
+			hop.Best = 0
+		}
+
+		// Calculate loss percentage based on received pings
+		received := float64(p.receivedPings[hopNum])
+		hop.Sent = count
+		if len(hop.Samples) > 0 {
+			hop.Sent = len(hop.Samples)
+		}
+		if hop.Sent > 0 {
+			hop.Loss = 100.0 * (float64(hop.Sent) - received) / float64(hop.Sent)
+		} else {
+			hop.Loss = 100.0
+		}
+
+		hop.Jitter = sampleJitter(hop.Samples)
+		hop.P50, hop.P95, hop.P99 = samplePercentiles(hop.Samples)
+
+		result = append(result, hop)
+	}
+
+	return result
+}
+
+// samplePercentiles returns the nearest-rank p50, p95 and p99 of the RTTs
+// of the answered samples, or zeros when none was answered
+func samplePercentiles(samples []Sample) (p50, p95, p99 float64) {
+	var rtts []float64
+	for _, sample := range samples {
+		if !sample.Lost {
+			rtts = append(rtts, sample.RTT)
+		}
+	}
+	if len(rtts) == 0 {
+		return 0, 0, 0
+	}
+	sort.Float64s(rtts)
+	return percentile(rtts, 50), percentile(rtts, 95), percentile(rtts, 99)
+}
+
+// sampleJitter returns the mean absolute difference between the RTTs of
+// consecutive answered samples, skipping lost ones, or 0 with fewer than two
+func sampleJitter(samples []Sample) float64 {
+	var sum, prev float64
+	n := 0
+	for _, sample := range samples {
+		if sample.Lost {
+			continue
+		}
+		if n > 0 {
+			sum += math.Abs(sample.RTT - prev)
+		}
+		prev = sample.RTT
+		n++
+	}
+	if n < 2 {
+		return 0
+	}
+	return sum / float64(n-1)
+}
+
+// snapshot returns the statistics of one hop so far, with copies of its
+// samples and addresses that later lines cannot modify
+func (p *parser) snapshot(hopNum int) HopData {
+	for _, hop := range p.hops() {
+		if hop.Hop == hopNum {
+			hop.Samples = append([]Sample(nil), hop.Samples...)
+			hop.AltIPs = append([]string(nil), hop.AltIPs...)
+			return hop
+		}
+	}
+	return HopData{Hop: hopNum}
+}
+
+// probesSent returns the most probes mtr sent to any hop, or 0 when the
+// output has no probe records
+func (p *parser) probesSent() int {
+	sent := 0
+	for _, hop := range p.hopMap {
+		if len(hop.Samples) > sent {
+			sent = len(hop.Samples)
+		}
+	}
+	return sent
+}
+
+// lineWriter splits everything written to it into lines and hands each
+// complete line to fn
+type lineWriter struct {
+	buf []byte
+	fn  func(line string)
+}
+
+func (w *lineWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// flush passes on a trailing line that was not terminated by a newline
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = nil
+	}
+}
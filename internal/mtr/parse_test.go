@@ -0,0 +1,182 @@
+package mtr
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReplyTTLsIgnored(t *testing.T) {
+	// mtr's raw reply records carry no reply TTL, so there are no
+	// return-path hints to report. Should a build append one, the trace
+	// reads exactly as without it.
+	seq := 0
+	plain := rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1, 1: 2, 2: 1.5}, 3) +
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10, 2: 12}, 3)
+	withTTL := regexp.MustCompile(`(?m)^(p \d+ \d+ \d+)$`).ReplaceAllString(plain, "$1 57")
+	if withTTL == plain {
+		t.Fatal("no reply records got a TTL")
+	}
+
+	run := func(output string) *Result {
+		fakeMTR(t, output)
+		cfg := testConfig(3)
+		cfg.NoColor = true
+		res, err := Run(context.Background(), cfg)
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+		return res
+	}
+	want, got := run(plain), run(withTTL)
+	if got.Output != want.Output {
+		t.Errorf("table with reply TTLs:\n%s\nwant:\n%s", got.Output, want.Output)
+	}
+	if !reflect.DeepEqual(got.Hops, want.Hops) {
+		t.Errorf("hops with reply TTLs:\n%+v\nwant:\n%+v", got.Hops, want.Hops)
+	}
+	if !reflect.DeepEqual(got.Warnings, want.Warnings) || !reflect.DeepEqual(got.Analysis, want.Analysis) {
+		t.Errorf("warnings %q and analysis %+v with reply TTLs, want %q and %+v", got.Warnings, got.Analysis, want.Warnings, want.Analysis)
+	}
+}
+
+func TestParseStDev(t *testing.T) {
+	tests := []struct {
+		name string
+		rtts []float64
+		avg  float64
+		want float64
+	}{
+		{"single reply", []float64{10}, 10, 0},
+		{"10, 20 and 30 ms", []float64{10, 20, 30}, 20, 10},
+		{"30, 10 and 20 ms", []float64{30, 10, 20}, 20, 10},
+		{"constant", []float64{15, 15, 15, 15}, 15, 0},
+		{"2, 4, 4, 4, 5, 5, 7 and 9 ms", []float64{2, 4, 4, 4, 5, 5, 7, 9}, 5, math.Sqrt(32.0 / 7)},
+	}
+	for _, tt := range tests {
+		rtts := make(map[int]float64)
+		for i, ms := range tt.rtts {
+			rtts[i] = ms
+		}
+		seq := 0
+		hops := parseOutput(rawProbes(0, "192.0.2.1", &seq, rtts, len(tt.rtts)), len(tt.rtts))
+		if len(hops) != 1 {
+			t.Fatalf("%s: got %d hops, want 1", tt.name, len(hops))
+		}
+		if math.Abs(hops[0].Avg-tt.avg) > 1e-9 || math.Abs(hops[0].StDev-tt.want) > 1e-9 {
+			t.Errorf("%s: avg %v stdev %v, want %v and %v", tt.name, hops[0].Avg, hops[0].StDev, tt.avg, tt.want)
+		}
+	}
+
+	// Lost probes take no part
+	seq := 0
+	hops := parseOutput(rawProbes(0, "192.0.2.1", &seq, map[int]float64{0: 10, 2: 20, 4: 30}, 5), 5)
+	if hops[0].StDev != 10 || hops[0].Loss != 40 {
+		t.Errorf("with lost probes: stdev %v loss %v, want 10 and 40", hops[0].StDev, hops[0].Loss)
+	}
+}
+
+func TestParseCRLF(t *testing.T) {
+	lf := "h 0 10.0.0.1\nx 0 1\np 0 1500 1\nd 0 gw.example.net\n" +
+		"h 1 192.0.2.1\nx 1 2\np 1 10000 2\nd 1 dest.example.com\n"
+	crlf := strings.ReplaceAll(lf, "\n", "\r\n")
+	// Trailing blanks after a record are dropped as well
+	crlf = strings.Replace(crlf, "dest.example.com\r\n", "dest.example.com \t\r\n", 1)
+
+	want, got := parseOutput(lf, 1), parseOutput(crlf, 1)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("hops from CRLF output:\n%+v\nwant:\n%+v", got, want)
+	}
+	if len(got) != 2 || got[0].Hostname != "gw.example.net" || got[1].Hostname != "dest.example.com" {
+		t.Errorf("hostnames %q", []string{got[0].Hostname, got[1].Hostname})
+	}
+	if got[0].Avg != 1.5 || got[1].Avg != 10 {
+		t.Errorf("averages %v and %v", got[0].Avg, got[1].Avg)
+	}
+
+	// The output as a whole goes through the same parsing
+	fakeMTR(t, crlf)
+	res, err := Run(context.Background(), testConfig(1))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.Hops[1].Hostname != "dest.example.com" || !res.DestinationReached {
+		t.Errorf("hop 2 %q, reached %v", res.Hops[1].Hostname, res.DestinationReached)
+	}
+}
+
+func TestParseSentPerHop(t *testing.T) {
+	// Hop 2 only got 4 of the 10 probes before the trace ended, and
+	// answered 3 of them
+	seq := 0
+	output := rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1, 1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1, 7: 1, 8: 1}, 10) +
+		rawProbes(1, "10.0.0.2", &seq, map[int]float64{0: 5, 1: 5, 3: 5}, 4) +
+		rawProbes(2, "192.0.2.1", &seq, map[int]float64{0: 10, 1: 10, 2: 10, 3: 10, 4: 10}, 5)
+
+	hops := parseOutput(output, 10)
+	want := []struct {
+		sent int
+		loss float64
+	}{{10, 10}, {4, 25}, {5, 0}}
+	if len(hops) != len(want) {
+		t.Fatalf("got %d hops, want %d", len(hops), len(want))
+	}
+	for i, w := range want {
+		if hops[i].Sent != w.sent || hops[i].Loss != w.loss {
+			t.Errorf("hop %d: sent %d loss %v, want %d and %v", hops[i].Hop, hops[i].Sent, hops[i].Loss, w.sent, w.loss)
+		}
+	}
+
+	// The table shows each hop's own count
+	cfg := Config{NoColor: true}
+	table := cfg.withColors(colorizeOutput(hops, cfg))
+	row := strings.Fields(strings.Split(table, "\n")[3])
+	if row[1] != "25.0" || row[2] != "4" {
+		t.Errorf("hop 2 row %q, want 25.0%% loss of 4 sent", row)
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		rtts          []float64
+		p50, p95, p99 float64
+	}{
+		{"single reply", []float64{7}, 7, 7, 7},
+		{"five replies", []float64{50, 15, 40, 20, 35}, 35, 50, 50},
+		// 1 to 20 ms, shuffled
+		{"twenty replies", []float64{12, 3, 20, 7, 1, 16, 9, 14, 5, 18, 2, 11, 19, 6, 13, 8, 17, 4, 10, 15}, 10, 19, 20},
+	}
+	for _, tt := range tests {
+		rtts := make(map[int]float64)
+		for i, ms := range tt.rtts {
+			rtts[i] = ms
+		}
+		seq := 0
+		// A lost probe between the replies takes no part
+		hops := parseOutput(rawProbes(0, "192.0.2.1", &seq, rtts, len(tt.rtts)+1), len(tt.rtts)+1)
+		if hops[0].P50 != tt.p50 || hops[0].P95 != tt.p95 || hops[0].P99 != tt.p99 {
+			t.Errorf("%s: p50 %v p95 %v p99 %v, want %v, %v and %v",
+				tt.name, hops[0].P50, hops[0].P95, hops[0].P99, tt.p50, tt.p95, tt.p99)
+		}
+	}
+
+	seq := 0
+	hops := parseOutput(rawProbes(0, "192.0.2.1", &seq, map[int]float64{0: 10, 1: 30, 2: 20}, 3), 3)
+	// The columns are optional in the table, always in JSON
+	cfg := Config{NoColor: true}
+	if table := colorizeOutput(hops, cfg); strings.Contains(table, "p95") {
+		t.Errorf("percentile columns without Percentiles:\n%s", table)
+	}
+	cfg.Percentiles = true
+	table := cfg.withColors(colorizeOutput(hops, cfg))
+	if !strings.Contains(table, "p50      p95      p99") || !strings.Contains(table, "20.0     30.0     30.0") {
+		t.Errorf("percentile columns missing:\n%s", table)
+	}
+	if js := formatJSON(&Result{Hops: hops}, Config{}); !strings.Contains(js, `"p95": 30`) {
+		t.Errorf("json lacks the percentiles:\n%s", js)
+	}
+}
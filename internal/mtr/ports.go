@@ -0,0 +1,208 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxVaryPorts bounds how many concurrent mtr runs a vary-port trace starts
+const maxVaryPorts = 16
+
+// ValidateProbeMode checks that at most one of TCP and UDP probes is
+// chosen, with the destination port both require, and that TCP probes are
+// not combined with the UDP probes of a vary-port trace
+func ValidateProbeMode(tcp, udp bool, port int, varyPort bool) error {
+	switch {
+	case tcp && udp:
+		return fmt.Errorf("TCP and UDP probes cannot both be enabled")
+	case (tcp || udp) && port == 0:
+		return fmt.Errorf("TCP and UDP probes need a destination port")
+	case port < 0 || port > 65535:
+		return fmt.Errorf("invalid destination port %d", port)
+	case port != 0 && !tcp && !udp:
+		return fmt.Errorf("a destination port is only used with TCP or UDP probes")
+	case tcp && varyPort:
+		return fmt.Errorf("TCP probes cannot be combined with vary-port, which uses UDP")
+	}
+	return nil
+}
+
+// ParsePortRange parses a source port range such as "33434-33441" (or a
+// single port) for Config.VaryPorts
+func ParsePortRange(spec string) ([]int, error) {
+	from, to, isRange := strings.Cut(spec, "-")
+	if !isRange {
+		to = from
+	}
+	lo, errLo := strconv.Atoi(strings.TrimSpace(from))
+	hi, errHi := strconv.Atoi(strings.TrimSpace(to))
+	if errLo != nil || errHi != nil || lo < 1 || hi > 65535 || lo > hi {
+		return nil, fmt.Errorf("invalid port range %q, expected e.g. 33434-33441", spec)
+	}
+	if hi-lo+1 > maxVaryPorts {
+		return nil, fmt.Errorf("port range %q is too wide (at most %d ports)", spec, maxVaryPorts)
+	}
+
+	var ports []int
+	for port := lo; port <= hi; port++ {
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+// executeVaried spreads the probe cycles of cfg over one mtr run per source
+// port in cfg.VaryPorts and merges the hops they discovered. Per-flow load
+// balancers hash the source port, so each run may take a different path.
+func executeVaried(ctx context.Context, cfg Config, res *Result) ([]HopData, error) {
+	counts := splitCount(cfg.Count, len(cfg.VaryPorts))
+
+	type run struct {
+		port int
+		hops []HopData
+		res  Result
+		err  error
+	}
+	var runs []*run
+	var wg sync.WaitGroup
+	for i, port := range cfg.VaryPorts {
+		if counts[i] == 0 {
+			continue
+		}
+		sub := cfg
+		sub.VaryPorts = nil
+		sub.LocalPort = port
+		sub.Count = counts[i]
+
+		r := &run{port: port}
+		runs = append(runs, r)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.hops, r.err = execute(ctx, sub, &r.res)
+		}()
+	}
+	wg.Wait()
+
+	var raw, rawErr strings.Builder
+	var hopSets [][]HopData
+	var lastErr error
+	for _, r := range runs {
+		raw.WriteString(fmt.Sprintf("# source port %d\n%s", r.port, r.res.RawOutput))
+		rawErr.WriteString(r.res.ErrOutput)
+		if r.err != nil {
+			lastErr = r.err
+			res.Warnings = append(res.Warnings, fmt.Sprintf("Probes from source port %d failed: %v", r.port, r.err))
+			continue
+		}
+		if r.res.Aborted && !res.Aborted {
+			res.Aborted = true
+			res.AbortReason = r.res.AbortReason
+		}
+		hopSets = append(hopSets, r.hops)
+	}
+	res.RawOutput = raw.String()
+	res.ErrOutput = rawErr.String()
+
+	if len(hopSets) == 0 {
+		return nil, lastErr
+	}
+	return mergeHops(hopSets), nil
+}
+
+// splitCount divides count cycles as evenly as possible over n runs
+func splitCount(count, n int) []int {
+	counts := make([]int, n)
+	for i := range counts {
+		counts[i] = count / n
+		if i < count%n {
+			counts[i]++
+		}
+	}
+	return counts
+}
+
+// mergeHops combines the hops of several runs by hop number. The first
+// address seen becomes the hop's IP and every other address an alternative;
+// statistics are recomputed from the combined samples.
+func mergeHops(hopSets [][]HopData) []HopData {
+	byHop := make(map[int]*HopData)
+	maxHop := 0
+	for _, hops := range hopSets {
+		for _, hop := range hops {
+			merged, exists := byHop[hop.Hop]
+			if !exists {
+				merged = &HopData{Hop: hop.Hop}
+				byHop[hop.Hop] = merged
+				if hop.Hop > maxHop {
+					maxHop = hop.Hop
+				}
+			}
+			if merged.IP == "" {
+				merged.IP = hop.IP
+				merged.Hostname = hop.Hostname
+			} else if hop.IP != "" {
+				merged.addAltIP(hop.IP)
+			}
+			for _, alt := range hop.AltIPs {
+				merged.addAltIP(alt)
+			}
+			merged.Sent += hop.Sent
+			merged.Samples = append(merged.Samples, hop.Samples...)
+		}
+	}
+
+	var result []HopData
+	for i := 1; i <= maxHop; i++ {
+		if hop, exists := byHop[i]; exists {
+			hop.updateStats()
+			result = append(result, *hop)
+		}
+	}
+	return result
+}
+
+// updateStats recomputes the latency and loss statistics from the samples
+func (h *HopData) updateStats() {
+	var received int
+	var sum float64
+	h.Best, h.Worst, h.Last = 0, 0, 0
+	for _, sample := range h.Samples {
+		if sample.Lost {
+			continue
+		}
+		if received == 0 || sample.RTT < h.Best {
+			h.Best = sample.RTT
+		}
+		if sample.RTT > h.Worst {
+			h.Worst = sample.RTT
+		}
+		h.Last = sample.RTT
+		sum += sample.RTT
+		received++
+	}
+
+	h.Avg, h.StDev = 0, 0
+	if received > 0 {
+		h.Avg = sum / float64(received)
+	}
+	if received > 1 {
+		var sumSq float64
+		for _, sample := range h.Samples {
+			if !sample.Lost {
+				sumSq += (sample.RTT - h.Avg) * (sample.RTT - h.Avg)
+			}
+		}
+		h.StDev = math.Sqrt(sumSq / float64(received-1))
+	}
+	h.Jitter = sampleJitter(h.Samples)
+	h.P50, h.P95, h.P99 = samplePercentiles(h.Samples)
+
+	h.Loss = 100.0
+	if h.Sent > 0 {
+		h.Loss = 100.0 * float64(h.Sent-received) / float64(h.Sent)
+	}
+}
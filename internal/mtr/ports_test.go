@@ -0,0 +1,108 @@
+package mtr
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParsePortRange(t *testing.T) {
+	ports, err := ParsePortRange("33434-33437")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ports) != 4 || ports[0] != 33434 || ports[3] != 33437 {
+		t.Errorf("ports %v", ports)
+	}
+	if ports, err := ParsePortRange("53"); err != nil || len(ports) != 1 || ports[0] != 53 {
+		t.Errorf("single port: %v, %v", ports, err)
+	}
+	for _, spec := range []string{"", "a-b", "0-10", "10-5", "65535-65536", "1000-1016"} {
+		if _, err := ParsePortRange(spec); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestVaryPortArgs(t *testing.T) {
+	cfg := testConfig(5)
+	cfg.LocalPort = 33435
+	argv := commandLine(cfg)
+	if !hasArgs(argv, "-u") || !hasArgs(argv, "-L", "33435") {
+		t.Errorf("command line %q lacks UDP probes from port 33435", argv)
+	}
+
+	// A destination port goes with the source port, still over UDP once
+	cfg.UDP = true
+	cfg.Port = 53
+	argv = commandLine(cfg)
+	if strings.Count(strings.Join(argv, " "), "-u") != 1 || !hasArgs(argv, "-L", "33435", "-P", "53") {
+		t.Errorf("command line %q", argv)
+	}
+
+	if got := splitCount(5, 3); got[0] != 2 || got[1] != 2 || got[2] != 1 {
+		t.Errorf("5 cycles over 3 ports split as %v", got)
+	}
+}
+
+func TestMergeHops(t *testing.T) {
+	a := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Sent: 2, Samples: []Sample{{RTT: 1}, {RTT: 3}}},
+		{Hop: 2, IP: "10.1.0.1", Sent: 2, Samples: []Sample{{RTT: 10}, {Lost: true}}},
+	}
+	b := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Sent: 1, Samples: []Sample{{RTT: 2}}},
+		{Hop: 2, IP: "10.2.0.1", AltIPs: []string{"10.1.0.1", "10.3.0.1"}, Sent: 2, Samples: []Sample{{RTT: 20}, {RTT: 30}}},
+		{Hop: 3, IP: "192.0.2.1", Sent: 1, Samples: []Sample{{RTT: 40}}},
+	}
+
+	hops := mergeHops([][]HopData{a, b})
+	if len(hops) != 3 {
+		t.Fatalf("got %d hops, want 3", len(hops))
+	}
+	if hops[0].IP != "10.0.0.1" || len(hops[0].AltIPs) != 0 || hops[0].Sent != 3 || hops[0].Avg != 2 {
+		t.Errorf("hop 1: %+v", hops[0])
+	}
+	// The first address seen stays the hop's, the others become alternatives
+	if hops[1].IP != "10.1.0.1" || strings.Join(hops[1].AltIPs, ",") != "10.2.0.1,10.3.0.1" {
+		t.Errorf("hop 2 ip %s alternatives %v", hops[1].IP, hops[1].AltIPs)
+	}
+	if hops[1].Sent != 4 || hops[1].Loss != 25 || hops[1].Avg != 20 || hops[1].Best != 10 || hops[1].Worst != 30 {
+		t.Errorf("hop 2 stats: %+v", hops[1])
+	}
+}
+
+func TestRunVaryPorts(t *testing.T) {
+	// Each source port takes a different path through hop 2
+	seq := 0
+	first := rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)
+	viaA := rawProbes(1, "10.1.0.1", &seq, map[int]float64{0: 5}, 1)
+	viaB := rawProbes(1, "10.2.0.1", &seq, map[int]float64{0: 6}, 1)
+	dest := rawProbes(2, "192.0.2.1", &seq, map[int]float64{0: 10}, 1)
+	fakeMTRScript(t, "cat <<'EOF'\n"+first+"EOF\n"+
+		"case \"$*\" in *'-L 40000'*) cat <<'EOF'\n"+viaA+"EOF\n;; *) cat <<'EOF'\n"+viaB+"EOF\n;; esac\n"+
+		"cat <<'EOF'\n"+dest+"EOF\n")
+
+	cfg := testConfig(2)
+	cfg.VaryPorts = []int{40000, 40001}
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Hops) != 3 {
+		t.Fatalf("got %d hops, want 3", len(res.Hops))
+	}
+	hop := res.Hops[1]
+	ips := append([]string{hop.IP}, hop.AltIPs...)
+	if len(ips) != 2 || !strings.Contains(strings.Join(ips, ","), "10.1.0.1") || !strings.Contains(strings.Join(ips, ","), "10.2.0.1") {
+		t.Errorf("hop 2 addresses %v, want both paths", ips)
+	}
+	if res.Hops[0].Sent != 2 {
+		t.Errorf("hop 1 sent %d, want the cycles of both runs", res.Hops[0].Sent)
+	}
+	for _, port := range []string{"# source port 40000", "# source port 40001"} {
+		if !strings.Contains(res.RawOutput, port) {
+			t.Errorf("raw output lacks %q", port)
+		}
+	}
+}
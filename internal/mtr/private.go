@@ -0,0 +1,91 @@
+package mtr
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+)
+
+// ErrPrivateTarget is returned, wrapped, for a target in a private or
+// reserved range when Config.DenyPrivate is set
+var ErrPrivateTarget = errors.New("private or reserved range")
+
+// reservedRanges are the networks DenyPrivate refuses to trace: private,
+// loopback, link-local, shared, documentation, multicast and other special
+// purpose ranges (RFC 6890)
+var reservedRanges = func() []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, cidr := range []string{
+		"0.0.0.0/8",       // "this" network
+		"10.0.0.0/8",      // private
+		"100.64.0.0/10",   // carrier-grade NAT
+		"127.0.0.0/8",     // loopback
+		"169.254.0.0/16",  // link-local
+		"172.16.0.0/12",   // private
+		"192.0.0.0/24",    // IETF protocol assignments
+		"192.0.2.0/24",    // documentation
+		"192.88.99.0/24",  // 6to4 relay anycast
+		"192.168.0.0/16",  // private
+		"198.18.0.0/15",   // benchmarking
+		"198.51.100.0/24", // documentation
+		"203.0.113.0/24",  // documentation
+		"224.0.0.0/4",     // multicast
+		"240.0.0.0/4",     // reserved, including broadcast
+		"::/128",          // unspecified
+		"::1/128",         // loopback
+		"64:ff9b:1::/48",  // local-use NAT64
+		"100::/64",        // discard
+		"2001::/23",       // IETF protocol assignments
+		"2001:db8::/32",   // documentation
+		"fc00::/7",        // unique local
+		"fe80::/10",       // link-local
+		"ff00::/8",        // multicast
+	} {
+		prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+	}
+	return prefixes
+}()
+
+// nat64Prefix is the well-known NAT64 prefix (RFC 6052), whose addresses
+// embed the IPv4 address they reach in their last four bytes
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// isReserved reports whether ip is in one of the ranges DenyPrivate refuses.
+// IPv4-mapped and NAT64 addresses are judged as the IPv4 address they reach.
+func isReserved(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if nat64Prefix.Contains(ip) {
+		b := ip.As16()
+		ip = netip.AddrFrom4([4]byte(b[12:]))
+	}
+	for _, prefix := range reservedRanges {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedOverlap reports whether any address of subnet is reserved
+func reservedOverlap(subnet netip.Prefix) bool {
+	for _, prefix := range reservedRanges {
+		if prefix.Overlaps(subnet) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPublic returns an ErrPrivateTarget error for the first reserved
+// address of target among ips
+func checkPublic(target string, ips []string) error {
+	for _, addr := range ips {
+		if ip, err := netip.ParseAddr(addr); err == nil && isReserved(ip) {
+			if _, err := netip.ParseAddr(target); err == nil {
+				return fmt.Errorf("target %s is in a %w", target, ErrPrivateTarget)
+			}
+			return fmt.Errorf("target %s resolves to %s, which is in a %w", target, addr, ErrPrivateTarget)
+		}
+	}
+	return nil
+}
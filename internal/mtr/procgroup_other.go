@@ -0,0 +1,19 @@
+//go:build !unix
+
+package mtr
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup does nothing where there are no process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalGroup sends sig to cmd itself where there are no process groups
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	if sig == syscall.SIGKILL {
+		return cmd.Process.Kill()
+	}
+	return cmd.Process.Signal(sig)
+}
@@ -0,0 +1,125 @@
+//go:build unix
+
+package mtr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// processGone waits up to a few seconds for the process pid to exit. An
+// exited process that was not reaped yet counts as gone.
+func processGone(pid int) bool {
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+			return true
+		}
+		if stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat"); err == nil && strings.Contains(string(stat), ") Z ") {
+			return true
+		}
+	}
+	return false
+}
+
+// readPID waits for the fake mtr to write a PID to path
+func readPID(t *testing.T, path string) int {
+	t.Helper()
+	for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, err := os.ReadFile(path); err == nil && strings.HasSuffix(string(data), "\n") {
+			pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return pid
+		}
+	}
+	t.Fatalf("no PID written to %s", path)
+	return 0
+}
+
+func TestExecuteKillsProcessIgnoringSIGTERM(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	// The shell and the sleep it waits for both ignore SIGTERM
+	fakeMTRScript(t, "trap '' TERM\necho 'h 0 10.0.0.1'\nsleep 30 &\necho $! > "+pidFile+"\nwait\n")
+
+	cfg := testConfig(1)
+	cfg.KillGrace = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Run(ctx, cfg)
+	if err == nil || !strings.Contains(err.Error(), "did not exit within 300ms of SIGTERM and was killed") {
+		t.Fatalf("Run error = %v, want the kill reported", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s", elapsed)
+	}
+	if pid := readPID(t, pidFile); !processGone(pid) {
+		t.Errorf("child %d still running after the kill", pid)
+	}
+}
+
+func TestExecuteGracefulExitNotKilled(t *testing.T) {
+	fakeMTRScript(t, "trap 'exit 0' TERM\necho 'h 0 10.0.0.1'\nwhile :; do sleep 0.05; done\n")
+
+	cfg := testConfig(1)
+	cfg.KillGrace = 2 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := Run(ctx, cfg)
+	if err != nil && strings.Contains(err.Error(), "was killed") {
+		t.Errorf("Run error = %v for an mtr that exited on SIGTERM", err)
+	}
+	if elapsed := time.Since(start); elapsed >= cfg.KillGrace {
+		t.Errorf("Run took %s, waiting out the grace period", elapsed)
+	}
+}
+
+func TestCancelKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	mtrPIDFile, childPIDFile := filepath.Join(dir, "mtr.pid"), filepath.Join(dir, "child.pid")
+	// sudo runs mtr as its child rather than exec'ing it, and mtr leaves a
+	// child of its own, so only signalling the whole group reaches them
+	sudo := filepath.Join(dir, "sudo")
+	if err := os.WriteFile(sudo, []byte("#!/bin/sh\nshift\n\"$@\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	old := sudoPath
+	sudoPath = sudo
+	t.Cleanup(func() { sudoPath = old })
+	fakeMTRScript(t, "echo $$ > "+mtrPIDFile+"\necho 'h 0 10.0.0.1'\nsleep 30 &\necho $! > "+childPIDFile+"\nwait\n")
+
+	cfg := testConfig(1)
+	cfg.NoSudo = false
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := Run(ctx, cfg)
+		done <- err
+	}()
+
+	// Cancel mid-run, once mtr and its child are running
+	mtrPID, childPID := readPID(t, mtrPIDFile), readPID(t, childPIDFile)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+	for name, pid := range map[string]int{"mtr": mtrPID, "mtr's child": childPID} {
+		if !processGone(pid) {
+			t.Errorf("%s (%d) still running after cancellation", name, pid)
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
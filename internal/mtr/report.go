@@ -0,0 +1,101 @@
+package mtr
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// OutputFormat selects how Result.Output renders the hops
+type OutputFormat string
+
+const (
+	FormatTable    OutputFormat = "table"    // Colored table with summary (default)
+	FormatReport   OutputFormat = "report"   // mtr's own --report-wide layout
+	FormatBinary   OutputFormat = "binary"   // Compact versioned encoding for archives
+	FormatGeoJSON  OutputFormat = "geojson"  // GeoJSON route of the geolocated hops
+	FormatJSON     OutputFormat = "json"     // Hops with a small envelope, for scripts
+	FormatCSV      OutputFormat = "csv"      // One row per hop
+	FormatHTML     OutputFormat = "html"     // Self-contained page for tickets
+	FormatMarkdown OutputFormat = "markdown" // GitHub-flavored Markdown for issues
+)
+
+// ParseFormat validates an output format; an empty string is the table
+func ParseFormat(format string) (OutputFormat, error) {
+	switch OutputFormat(format) {
+	case "":
+		return FormatTable, nil
+	case FormatTable, FormatReport, FormatBinary, FormatGeoJSON, FormatJSON, FormatCSV, FormatHTML, FormatMarkdown:
+		return OutputFormat(format), nil
+	}
+	return "", fmt.Errorf("invalid format %q (expected table, report, binary, geojson, json, csv, html or markdown)", format)
+}
+
+// reportMinHostWidth is the width of the "HOST:" column mtr's report uses
+// before widening it to fit the longest hop name. Rows and the header are
+// padded to the same width so the statistics line up.
+const reportMinHostWidth = 33
+
+// reportField is one column of mtr's report, with mtr's own width and format
+type reportField struct {
+	title  string
+	width  int
+	format string
+	value  func(HopData) any
+}
+
+// reportFields reproduces mtr's default report columns ("LS NABWV")
+var reportFields = []reportField{
+	{"Loss%", 6, " %4.1f%%", func(h HopData) any { return h.Loss }},
+	{"Snt", 6, " %5d", func(h HopData) any { return h.Sent }},
+	{"", 1, " ", nil},
+	{"Last", 6, " %5.1f", func(h HopData) any { return h.Last }},
+	{"Avg", 6, " %5.1f", func(h HopData) any { return h.Avg }},
+	{"Best", 6, " %5.1f", func(h HopData) any { return h.Best }},
+	{"Wrst", 6, " %5.1f", func(h HopData) any { return h.Worst }},
+	{"StDev", 6, " %5.1f", func(h HopData) any { return h.StDev }},
+}
+
+// formatReport renders hops the way mtr --report-wide does, so scripts that
+// parse mtr's report can parse this output unchanged
+func formatReport(hops []HopData, cfg Config, start time.Time) string {
+	label := cfg.unknownHostLabel()
+	width := reportMinHostWidth
+	for _, hop := range hops {
+		if n := len(reportRowPrefix(hop, label)) + 1; n > width {
+			width = n
+		}
+	}
+
+	localHost, err := os.Hostname()
+	if err != nil {
+		localHost = "localhost"
+	}
+
+	var report strings.Builder
+	report.WriteString(fmt.Sprintf("Start: %s\n", start.Format("2006-01-02T15:04:05-0700")))
+	report.WriteString(fmt.Sprintf("%-*s", width, "HOST: "+localHost))
+	for _, field := range reportFields {
+		report.WriteString(fmt.Sprintf("%*s", field.width, field.title))
+	}
+	report.WriteString("\n")
+
+	for _, hop := range hops {
+		report.WriteString(fmt.Sprintf("%-*s", width, reportRowPrefix(hop, label)))
+		for _, field := range reportFields {
+			if field.value == nil {
+				report.WriteString(field.format)
+				continue
+			}
+			report.WriteString(fmt.Sprintf(field.format, field.value(hop)))
+		}
+		report.WriteString("\n")
+	}
+	return report.String()
+}
+
+// reportRowPrefix is the hop number and name that start a report row
+func reportRowPrefix(hop HopData, label string) string {
+	return fmt.Sprintf(" %2d.|-- %s", hop.Hop, displayHost(hop, label))
+}
@@ -0,0 +1,45 @@
+package mtr
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatReport(t *testing.T) {
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "_gateway", Sent: 10, Last: 0.4, Avg: 0.4, Best: 0.3, Worst: 0.5, StDev: 0.1},
+		{Hop: 2, Loss: 100, Sent: 10},
+		{Hop: 3, IP: "93.184.216.34", Hostname: "93.184.216.34", Loss: 10, Sent: 10, Last: 85.1, Avg: 84.3, Best: 83.9, Worst: 86.0, StDev: 0.6},
+	}
+	// The layout of mtr --report-wide on a host named vm
+	want := "" +
+		"Start: 2024-05-01T12:00:00+0000\n" +
+		"HOST: vm                          Loss%   Snt   Last   Avg  Best  Wrst StDev\n" +
+		"  1.|-- _gateway                   0.0%    10    0.4   0.4   0.3   0.5   0.1\n" +
+		"  2.|-- ???                       100.0%    10    0.0   0.0   0.0   0.0   0.0\n" +
+		"  3.|-- 93.184.216.34             10.0%    10   85.1  84.3  83.9  86.0   0.6\n"
+
+	got := formatReport(hops, Config{}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	gotLines, wantLines := strings.Split(got, "\n"), strings.Split(want, "\n")
+	if len(gotLines) != len(wantLines) {
+		t.Fatalf("report:\n%s\nwant:\n%s", got, want)
+	}
+	for i := range wantLines {
+		if i == 1 {
+			// The header names the local host
+			host, err := os.Hostname()
+			if err != nil {
+				host = "localhost"
+			}
+			if !strings.HasPrefix(gotLines[i], "HOST: "+host+" ") || !strings.HasSuffix(gotLines[i], "Loss%   Snt   Last   Avg  Best  Wrst StDev") {
+				t.Errorf("header %q", gotLines[i])
+			}
+			continue
+		}
+		if gotLines[i] != wantLines[i] {
+			t.Errorf("line %d:\n%q\nwant\n%q", i+1, gotLines[i], wantLines[i])
+		}
+	}
+}
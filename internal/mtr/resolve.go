@@ -0,0 +1,115 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Resolver looks up the addresses of a host; *net.Resolver satisfies it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+func (c Config) resolver() Resolver {
+	if c.Resolver != nil {
+		return c.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// ValidateIPLiteral checks that target is an IP address (or a subnet written
+// with one) so that it can be traced without any DNS lookup
+func ValidateIPLiteral(target string) error {
+	if IsCIDR(target) {
+		_, err := ParseCIDR(target)
+		return err
+	}
+	if net.ParseIP(target) == nil {
+		return fmt.Errorf("target %q is not an IP address (hostnames are not allowed in IP-only mode)", target)
+	}
+	return nil
+}
+
+// ValidateHostname rejects targets mtr would take for an option, such as
+// "--help" or "-i0.01"
+func ValidateHostname(target string) error {
+	if strings.HasPrefix(target, "-") {
+		return fmt.Errorf("invalid hostname %q: must not start with a dash", target)
+	}
+	return nil
+}
+
+// ValidateFamily checks that at most one address family is forced
+func ValidateFamily(ipv4, ipv6 bool) error {
+	if ipv4 && ipv6 {
+		return fmt.Errorf("IPv4 and IPv6 cannot both be forced")
+	}
+	return nil
+}
+
+// familyMatches reports whether ip belongs to the address family cfg forces,
+// if any
+func (c Config) familyMatches(ip net.IP) bool {
+	isV4 := ip.To4() != nil
+	return !(c.IPv4 && !isV4) && !(c.IPv6 && isV4)
+}
+
+// familyName names the address family cfg forces
+func (c Config) familyName() string {
+	if c.IPv4 {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// resolveTarget resolves the configured hostname, recording the addresses and
+// how long the lookup took. IP literals are recorded without a lookup.
+// Traces forced to one address family only keep addresses of that family.
+func resolveTarget(ctx context.Context, cfg Config, res *Result) error {
+	if ip := net.ParseIP(cfg.Hostname); ip != nil {
+		if !cfg.familyMatches(ip) {
+			return fmt.Errorf("target %s is not an %s address", cfg.Hostname, cfg.familyName())
+		}
+		res.ResolvedIPs = []string{ip.String()}
+		return nil
+	}
+
+	start := time.Now()
+	addrs, err := cfg.resolver().LookupIPAddr(ctx, cfg.Hostname)
+	res.DNSResolution = time.Since(start)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("failed to resolve hostname: %s", cfg.Hostname)
+	}
+
+	for _, addr := range addrs {
+		if !cfg.familyMatches(addr.IP) {
+			continue
+		}
+		res.ResolvedIPs = append(res.ResolvedIPs, addr.IP.String())
+	}
+	if len(res.ResolvedIPs) == 0 {
+		return fmt.Errorf("hostname %s has no %s address", cfg.Hostname, cfg.familyName())
+	}
+	return nil
+}
+
+// destinationReached reports whether the final hop answered from one of the
+// target's resolved addresses
+func destinationReached(hops []HopData, resolved []string) bool {
+	if len(hops) == 0 {
+		return false
+	}
+	last := net.ParseIP(hops[len(hops)-1].IP)
+	if last == nil {
+		return false
+	}
+	for _, addr := range resolved {
+		if last.Equal(net.ParseIP(addr)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowResolver answers every lookup with its addresses after delay
+type slowResolver struct {
+	delay time.Duration
+	addrs []string
+	err   error
+}
+
+func (r slowResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	time.Sleep(r.delay)
+	var addrs []net.IPAddr
+	for _, ip := range r.addrs {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, r.err
+}
+
+func TestResolveTargetTimed(t *testing.T) {
+	cfg := Config{Hostname: "example.com", Resolver: slowResolver{delay: 50 * time.Millisecond, addrs: []string{"192.0.2.1", "2001:db8::1"}}}
+	res := &Result{}
+	if err := resolveTarget(context.Background(), cfg, res); err != nil {
+		t.Fatal(err)
+	}
+	if res.DNSResolution < 50*time.Millisecond || res.DNSResolution > time.Second {
+		t.Errorf("DNS resolution took %s, want about 50ms", res.DNSResolution)
+	}
+	if strings.Join(res.ResolvedIPs, ",") != "192.0.2.1,2001:db8::1" {
+		t.Errorf("resolved %v", res.ResolvedIPs)
+	}
+
+	// The summary reports it
+	res.Target, res.Hops = "example.com", []HopData{{Hop: 1, IP: "192.0.2.1", Sent: 1}}
+	if summary := generateSummary(res, cfg); !strings.Contains(summary, "DNS resolution of example.com: ") {
+		t.Errorf("summary lacks the DNS resolution time:\n%s", summary)
+	}
+
+	// A failed lookup is timed as well
+	cfg.Resolver = slowResolver{delay: 20 * time.Millisecond, err: errors.New("no such host")}
+	res = &Result{}
+	if err := resolveTarget(context.Background(), cfg, res); err == nil {
+		t.Error("failed lookup resolved")
+	}
+	if res.DNSResolution < 20*time.Millisecond {
+		t.Errorf("failed lookup took %s, want about 20ms", res.DNSResolution)
+	}
+
+	// IP literals are not looked up
+	cfg = Config{Hostname: "192.0.2.1", Resolver: slowResolver{err: errors.New("looked up")}}
+	res = &Result{}
+	if err := resolveTarget(context.Background(), cfg, res); err != nil || res.DNSResolution != 0 {
+		t.Errorf("IP literal: err %v, DNS resolution %s", err, res.DNSResolution)
+	}
+}
+
+// forbiddenResolver fails the test on any lookup
+type forbiddenResolver struct{ t *testing.T }
+
+func (r forbiddenResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.t.Errorf("DNS lookup of %s", host)
+	return nil, errors.New("lookups are forbidden")
+}
+
+func TestIPOnly(t *testing.T) {
+	for _, target := range []string{"192.0.2.1", "2001:db8::1", "::ffff:192.0.2.1", "192.0.2.0/24"} {
+		if err := ValidateIPLiteral(target); err != nil {
+			t.Errorf("%s refused: %v", target, err)
+		}
+	}
+	for _, target := range []string{"example.com", "localhost", "192.0.2.256", "0x7f.1", ""} {
+		if err := ValidateIPLiteral(target); err == nil {
+			t.Errorf("%s accepted", target)
+		}
+	}
+
+	// Hostnames are refused before anything is resolved or run
+	fakeMTRScript(t, "echo 'mtr ran' >&2\nexit 1\n")
+	cfg := testConfig(1)
+	cfg.IPOnly = true
+	cfg.Hostname = "example.com"
+	cfg.Resolver = forbiddenResolver{t}
+	if _, err := Run(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "not an IP address") {
+		t.Errorf("Run error = %v, want the hostname refused", err)
+	}
+
+	// mtr never resolves hop names, whatever else is configured
+	for _, literal := range []string{"192.0.2.1", "2001:db8::1"} {
+		cfg.Hostname = literal
+		for _, report := range []bool{true, false} {
+			cfg.Report, cfg.Resolve = report, true
+			if !hasArgs(commandLine(cfg), "-n") {
+				t.Errorf("%s, report %v: command line %q lacks -n", literal, report, commandLine(cfg))
+			}
+		}
+	}
+
+	seq := 0
+	fakeMTR(t, rawProbes(0, "2001:db8:1::1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "2001:db8::1", &seq, map[int]float64{0: 10}, 1))
+	cfg.Report, cfg.Resolve = true, false
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !res.DestinationReached || res.DNSResolution != 0 {
+		t.Errorf("reached %v, DNS resolution %s", res.DestinationReached, res.DNSResolution)
+	}
+}
+
+func TestRunIPv6(t *testing.T) {
+	seq := 0
+	output := rawProbes(0, "2001:db8:1::1", &seq, map[int]float64{0: 1, 1: 1}, 2) + "d 0 gw6.example.net\n" +
+		rawProbes(1, "2001:db8::1", &seq, map[int]float64{0: 10, 1: 12}, 2)
+	args := filepath.Join(t.TempDir(), "args")
+	fakeMTRScript(t, "echo \"$@\" > "+args+"\ncat <<'EOF'\n"+output+"EOF\n")
+
+	cfg := testConfig(2)
+	cfg.Hostname = "v6.example.com"
+	cfg.Resolver = slowResolver{addrs: []string{"192.0.2.1", "2001:db8::1"}}
+	cfg.IPv6 = true
+	cfg.NoColor = true
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	argv, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasArgs(strings.Fields(string(argv)), "-6") {
+		t.Errorf("mtr ran with %q, want -6", argv)
+	}
+
+	if len(res.Hops) != 2 || res.Hops[0].IP != "2001:db8:1::1" || res.Hops[1].IP != "2001:db8::1" {
+		t.Fatalf("hops %+v", res.Hops)
+	}
+	if !res.DestinationReached || strings.Join(res.ResolvedIPs, ",") != "2001:db8::1" {
+		t.Errorf("reached %v, resolved %v; want the IPv6 address only", res.DestinationReached, res.ResolvedIPs)
+	}
+	// Named hops show their address once, bare ones only the address
+	var rows []string
+	for _, line := range strings.Split(res.Output, "\n") {
+		if strings.HasPrefix(line, "1 ") || strings.HasPrefix(line, "2 ") {
+			rows = append(rows, strings.TrimRight(line, " "))
+		}
+	}
+	if len(rows) != 2 || !strings.HasSuffix(rows[0], "  gw6.example.net (2001:db8:1::1)") || !strings.HasSuffix(rows[1], "  2001:db8::1") {
+		t.Errorf("table rows %q", rows)
+	}
+
+	if ValidateFamily(true, true) == nil {
+		t.Error("IPv4 and IPv6 both forced")
+	}
+}
@@ -0,0 +1,83 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// MaxRetries bounds Config.Retries
+const MaxRetries = 5
+
+// retryBackoff is the pause before the first retry; it doubles for every
+// further one
+const retryBackoff = 500 * time.Millisecond
+
+// ValidateRetries checks a retry count
+func ValidateRetries(n int) error {
+	if n < 0 || n > MaxRetries {
+		return fmt.Errorf("retries must be between 0 and %d", MaxRetries)
+	}
+	return nil
+}
+
+// transientError is an mtr failure a retry may well not repeat, such as a
+// socket error while the host is short of buffers. Any other failure, like
+// a usage error or an unresolvable target, would only fail again.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// transientDiagnostics are what mtr prints when it runs short of a resource
+// or its probes are refused for the moment
+var transientDiagnostics = []string{
+	"No buffer space available",
+	"Resource temporarily unavailable",
+	"Cannot allocate memory",
+	"Too many open files",
+	"sendto:",
+	"socket:",
+}
+
+// transientFailure reports whether mtr's output names a transient failure
+func transientFailure(output string) bool {
+	for _, diagnostic := range transientDiagnostics {
+		if strings.Contains(output, diagnostic) {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetries runs trace, and again up to cfg.Retries times with a growing
+// pause while it fails transiently. Every retry is noted on res.
+func withRetries(ctx context.Context, cfg Config, res *Result, trace func() ([]HopData, error)) ([]HopData, error) {
+	for attempt := 0; ; attempt++ {
+		hops, err := trace()
+		var transient *transientError
+		if err == nil || !errors.As(err, &transient) {
+			return hops, err
+		}
+		if attempt >= cfg.Retries {
+			if attempt > 0 {
+				return nil, fmt.Errorf("%v (after %d retries)", err, attempt)
+			}
+			return nil, err
+		}
+
+		res.Warnings = append(res.Warnings, fmt.Sprintf("Retried after a transient mtr failure: %v", err))
+		log.Debug().Err(err).Int("attempt", attempt+1).Msg("Retrying transient mtr failure")
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(retryBackoff << attempt):
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package mtr
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingMTR replaces mtr with a script that counts its runs in a file,
+// prints diagnostic to stderr and fails
+func countingMTR(t *testing.T, diagnostic string) func() int {
+	t.Helper()
+	runs := filepath.Join(t.TempDir(), "runs")
+	fakeMTRScript(t, "echo run >> "+runs+"\necho '"+diagnostic+"' >&2\nexit 1\n")
+	return func() int {
+		data, _ := os.ReadFile(runs)
+		return strings.Count(string(data), "run")
+	}
+}
+
+func TestRetryOnlyTransientFailures(t *testing.T) {
+	cfg := testConfig(1)
+	cfg.Retries = 2
+
+	// A usage error would only fail again
+	runs := countingMTR(t, "mtr: unrecognized option '--bogus'")
+	res, err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "unrecognized option") || strings.Contains(err.Error(), "retries") {
+		t.Errorf("Run error = %v, want the usage error as is", err)
+	}
+	if n := runs(); n != 1 {
+		t.Errorf("mtr ran %d times for a usage error, want once", n)
+	}
+	if res != nil {
+		t.Errorf("result %+v for a failed trace", res)
+	}
+
+	cfg.Retries = 1
+	runs = countingMTR(t, "mtr: sendto: No buffer space available")
+	_, err = Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "(after 1 retries)") {
+		t.Errorf("Run error = %v, want the retry noted", err)
+	}
+	if n := runs(); n != 2 {
+		t.Errorf("mtr ran %d times for a transient failure, want twice", n)
+	}
+}
@@ -0,0 +1,313 @@
+package mtr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SummaryLevel selects how much detail the summary below the hop table shows
+type SummaryLevel string
+
+const (
+	SummaryMinimal  SummaryLevel = "minimal"  // One line
+	SummaryNormal   SummaryLevel = "normal"   // Worst hops, end-to-end metrics and warnings
+	SummaryDetailed SummaryLevel = "detailed" // Normal plus rankings, percentiles, segments and interpretation
+)
+
+// summaryTopN is how many hops the detailed rankings list
+const summaryTopN = 3
+
+// ParseSummaryLevel validates a summary level; an empty string is normal
+func ParseSummaryLevel(level string) (SummaryLevel, error) {
+	switch SummaryLevel(level) {
+	case "":
+		return SummaryNormal, nil
+	case SummaryMinimal, SummaryNormal, SummaryDetailed:
+		return SummaryLevel(level), nil
+	}
+	return "", fmt.Errorf("invalid summary level %q (expected minimal, normal or detailed)", level)
+}
+
+// Summary is the structured content of a trace summary, rendered at a
+// SummaryLevel
+type Summary struct {
+	Target      string
+	ResolvedIPs []string
+	Health      Health
+
+	WorstLoss    HopData
+	WorstLatency HopData
+	Destination  HopData
+	// DestinationLossOnly judges loss at the destination only
+	DestinationLossOnly bool
+	// IgnoreLossBeforeHop is the last hop whose loss is not judged
+	IgnoreLossBeforeHop int
+
+	// TopLoss and TopLatency rank the worst hops, worst first
+	TopLoss    []HopData
+	TopLatency []HopData
+	// Percentiles of the destination's replies in ms, keyed by percentile
+	Percentiles map[int]float64
+	// Segments is the latency each hop adds over the previous one, largest first
+	Segments []Segment
+
+	AltPaths      []HopData
+	Annotated     []HopData
+	DNSResolution time.Duration
+	Warnings      []string
+	Analysis      Analysis
+	History       *HistoryComparison
+}
+
+// Segment is the latency added between two consecutive hops
+type Segment struct {
+	From, To HopData
+	Added    float64
+}
+
+// summaryPercentiles are the percentiles reported in detailed summaries
+var summaryPercentiles = []int{50, 90, 99}
+
+// buildSummary collects the summary content for a result with hops
+func buildSummary(res *Result, cfg Config) Summary {
+	hops := res.Hops
+	s := Summary{
+		Target:              res.Target,
+		ResolvedIPs:         res.ResolvedIPs,
+		Health:              res.Health,
+		WorstLatency:        hops[0],
+		Destination:         cfg.destination(hops),
+		DestinationLossOnly: cfg.DestinationLossOnly,
+		IgnoreLossBeforeHop: cfg.IgnoreLossBeforeHop,
+		DNSResolution:       res.DNSResolution,
+		Warnings:            res.Warnings,
+		Analysis:            res.Analysis,
+		History:             res.History,
+	}
+
+	// Hops after a DestinationHop are listed but not ranked, and the loss
+	// of hops up to IgnoreLossBeforeHop is not ranked either, as it is not
+	// judged for the health verdict
+	var judged, lossJudged []HopData
+	for _, hop := range hops {
+		if !cfg.beyondDestination(hop) {
+			judged = append(judged, hop)
+			if !cfg.lossIgnored(hop) {
+				lossJudged = append(lossJudged, hop)
+			}
+		}
+	}
+
+	for _, hop := range lossJudged {
+		if hop.Loss > s.WorstLoss.Loss {
+			s.WorstLoss = hop
+		}
+	}
+	for _, hop := range judged {
+		if hop.Avg > s.WorstLatency.Avg {
+			s.WorstLatency = hop
+		}
+	}
+	for _, hop := range hops {
+		if len(hop.AltIPs) > 0 {
+			s.AltPaths = append(s.AltPaths, hop)
+		}
+		if len(hop.Annotations) > 0 {
+			s.Annotated = append(s.Annotated, hop)
+		}
+	}
+
+	s.TopLoss = topHops(lossJudged, func(h HopData) float64 { return h.Loss })
+	s.TopLatency = topHops(judged, func(h HopData) float64 { return h.Avg })
+
+	var rtts []float64
+	for _, sample := range s.Destination.Samples {
+		if !sample.Lost {
+			rtts = append(rtts, sample.RTT)
+		}
+	}
+	if len(rtts) > 0 {
+		sort.Float64s(rtts)
+		s.Percentiles = make(map[int]float64)
+		for _, p := range summaryPercentiles {
+			s.Percentiles[p] = percentile(rtts, p)
+		}
+	}
+
+	for i := 1; i < len(hops); i++ {
+		// Hops without replies have no latency to compare
+		if hops[i].Loss >= 100 || hops[i-1].Loss >= 100 {
+			continue
+		}
+		if added := hops[i].Avg - hops[i-1].Avg; added > 0 {
+			s.Segments = append(s.Segments, Segment{From: hops[i-1], To: hops[i], Added: added})
+		}
+	}
+	sort.SliceStable(s.Segments, func(i, j int) bool { return s.Segments[i].Added > s.Segments[j].Added })
+	if len(s.Segments) > summaryTopN {
+		s.Segments = s.Segments[:summaryTopN]
+	}
+
+	return s
+}
+
+// topHops returns up to summaryTopN hops with the highest non-zero metric
+func topHops(hops []HopData, metric func(HopData) float64) []HopData {
+	var ranked []HopData
+	for _, hop := range hops {
+		if metric(hop) > 0 {
+			ranked = append(ranked, hop)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return metric(ranked[i]) > metric(ranked[j]) })
+	if len(ranked) > summaryTopN {
+		ranked = ranked[:summaryTopN]
+	}
+	return ranked
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// generateSummary renders the summary of res at cfg.SummaryLevel
+func generateSummary(res *Result, cfg Config) string {
+	if len(res.Hops) == 0 {
+		return "\nNo route data available.\n"
+	}
+	return buildSummary(res, cfg).render(cfg.SummaryLevel, cfg.unknownHostLabel())
+}
+
+// render formats the summary at the given level (normal when empty)
+func (s Summary) render(level SummaryLevel, label string) string {
+	if level == SummaryMinimal {
+		return s.renderMinimal(label)
+	}
+
+	var summary strings.Builder
+	summary.WriteString("\nSummary:\n")
+	summary.WriteString("--------\n")
+	summary.WriteString(fmt.Sprintf("Connection quality: %s\n", s.Health))
+
+	// Report worst loss
+	if s.DestinationLossOnly {
+		summary.WriteString(fmt.Sprintf("Destination packet loss at hop %d (%s): %.1f%% (intermediate hop loss ignored)\n",
+			s.Destination.Hop, displayHost(s.Destination, label), s.Destination.Loss))
+	} else if s.WorstLoss.Loss > 0 {
+		summary.WriteString(fmt.Sprintf("Worst packet loss at hop %d (%s): %.1f%%\n",
+			s.WorstLoss.Hop, displayHost(s.WorstLoss, label), s.WorstLoss.Loss))
+	} else if s.IgnoreLossBeforeHop > 0 {
+		summary.WriteString(fmt.Sprintf("No packet loss detected (loss up to hop %d ignored)\n", s.IgnoreLossBeforeHop))
+	} else {
+		summary.WriteString("No packet loss detected\n")
+	}
+
+	// Report worst latency
+	summary.WriteString(fmt.Sprintf("Highest average latency at hop %d (%s): %.1f ms\n",
+		s.WorstLatency.Hop, displayHost(s.WorstLatency, label), s.WorstLatency.Avg))
+
+	// End-to-end metrics
+	summary.WriteString(fmt.Sprintf("\nEnd-to-end metrics for %s:\n", displayHost(s.Destination, label)))
+	summary.WriteString(fmt.Sprintf("  Average: %.1f ms\n", s.Destination.Avg))
+	summary.WriteString(fmt.Sprintf("  Best: %.1f ms\n", s.Destination.Best))
+	summary.WriteString(fmt.Sprintf("  Worst: %.1f ms\n", s.Destination.Worst))
+	summary.WriteString(fmt.Sprintf("  Standard Deviation: %.1f ms\n", s.Destination.StDev))
+	if level == SummaryDetailed {
+		for _, p := range summaryPercentiles {
+			if v, ok := s.Percentiles[p]; ok {
+				summary.WriteString(fmt.Sprintf("  p%d: %.1f ms\n", p, v))
+			}
+		}
+	}
+
+	if level == SummaryDetailed {
+		summary.WriteString(s.renderDetails(label))
+	}
+
+	// Report hops where more than one address answered
+	if len(s.AltPaths) > 0 {
+		summary.WriteString("\nAlternate paths:\n")
+		for _, hop := range s.AltPaths {
+			summary.WriteString(fmt.Sprintf("  Hop %d: %s, also %s\n", hop.Hop, hop.IP, strings.Join(hop.AltIPs, ", ")))
+		}
+	}
+
+	// Report what enrichers know about the hops
+	if len(s.Annotated) > 0 {
+		summary.WriteString("\nAnnotations:\n")
+		for _, hop := range s.Annotated {
+			summary.WriteString(fmt.Sprintf("  Hop %d (%s): %s\n", hop.Hop, displayHost(hop, label), formatAnnotations(hop.Annotations)))
+		}
+	}
+
+	// Report how long resolving the target took
+	if s.DNSResolution > 0 {
+		summary.WriteString(fmt.Sprintf("\nDNS resolution of %s: %.1f ms (%s)\n",
+			s.Target, float64(s.DNSResolution.Microseconds())/1000.0, strings.Join(s.ResolvedIPs, ", ")))
+	}
+
+	// Report how the trace compares with the target's history
+	if s.History != nil {
+		summary.WriteString(formatHistory(s.History))
+	}
+
+	// Report anything unusual noticed while processing the trace
+	if len(s.Warnings) > 0 {
+		summary.WriteString("\nWarnings:\n")
+		for _, warning := range s.Warnings {
+			summary.WriteString(fmt.Sprintf("  %s%s%s\n", colorYellow, warning, colorReset))
+		}
+	}
+
+	if level == SummaryDetailed && len(s.Analysis.Findings) > 0 {
+		summary.WriteString(formatExplanation(s.Analysis))
+	}
+
+	return summary.String()
+}
+
+// renderMinimal formats the summary as a single line
+func (s Summary) renderMinimal(label string) string {
+	line := fmt.Sprintf("\nSummary: %s, %s loss %.1f%%, avg %.1f ms",
+		s.Health, displayHost(s.Destination, label), s.Destination.Loss, s.Destination.Avg)
+	if s.History != nil && len(s.History.Anomalies) > 0 {
+		line += ", above historical p95"
+	}
+	if len(s.Warnings) > 0 {
+		line += fmt.Sprintf(", %d warning(s)", len(s.Warnings))
+	}
+	return line + "\n"
+}
+
+// renderDetails formats the rankings and segment analysis of detailed summaries
+func (s Summary) renderDetails(label string) string {
+	var details strings.Builder
+	if len(s.TopLoss) > 0 {
+		details.WriteString("\nWorst hops by loss:\n")
+		for _, hop := range s.TopLoss {
+			details.WriteString(fmt.Sprintf("  Hop %d (%s): %.1f%%\n", hop.Hop, displayHost(hop, label), hop.Loss))
+		}
+	}
+	if len(s.TopLatency) > 0 {
+		details.WriteString("\nWorst hops by average latency:\n")
+		for _, hop := range s.TopLatency {
+			details.WriteString(fmt.Sprintf("  Hop %d (%s): %.1f ms\n", hop.Hop, displayHost(hop, label), hop.Avg))
+		}
+	}
+	if len(s.Segments) > 0 {
+		details.WriteString("\nLargest latency increases:\n")
+		for _, seg := range s.Segments {
+			details.WriteString(fmt.Sprintf("  Hop %d -> %d (%s -> %s): +%.1f ms\n",
+				seg.From.Hop, seg.To.Hop, displayHost(seg.From, label), displayHost(seg.To, label), seg.Added))
+		}
+	}
+	return details.String()
+}
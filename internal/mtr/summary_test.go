@@ -0,0 +1,131 @@
+package mtr
+
+import (
+	"strings"
+	"testing"
+)
+
+// firewalledRoute is a route whose hops past hop 2, the edge of the network
+// traced, are slow and lossy
+func firewalledRoute() []HopData {
+	return []HopData{
+		{Hop: 1, IP: "10.0.0.1", Sent: 10, Avg: 1, Samples: []Sample{{RTT: 1}}},
+		{Hop: 2, IP: "10.0.0.2", Hostname: "edge.example.net", Sent: 10, Loss: 2, Avg: 5, Samples: []Sample{{RTT: 4}, {RTT: 6}}},
+		{Hop: 3, IP: "192.0.2.1", Sent: 10, Loss: 60, Avg: 200, Samples: []Sample{{RTT: 200}}},
+		{Hop: 4, IP: "192.0.2.2", Sent: 10, Loss: 80, Avg: 250, Samples: []Sample{{RTT: 250}}},
+	}
+}
+
+func hopNumbers(hops []HopData) []int {
+	var nums []int
+	for _, hop := range hops {
+		nums = append(nums, hop.Hop)
+	}
+	return nums
+}
+
+func TestBuildSummaryDestinationHop(t *testing.T) {
+	res := &Result{Target: "example.com", Hops: firewalledRoute()}
+
+	s := buildSummary(res, Config{DestinationHop: 2})
+	if s.Destination.Hop != 2 {
+		t.Errorf("destination hop %d, want 2", s.Destination.Hop)
+	}
+	if s.WorstLoss.Hop != 2 || s.WorstLatency.Hop != 2 {
+		t.Errorf("worst loss hop %d, worst latency hop %d, want 2 and 2", s.WorstLoss.Hop, s.WorstLatency.Hop)
+	}
+	for name, ranked := range map[string][]HopData{"loss": s.TopLoss, "latency": s.TopLatency} {
+		for _, hop := range ranked {
+			if hop.Hop > 2 {
+				t.Errorf("%s ranking %v includes hops beyond the destination", name, hopNumbers(ranked))
+				break
+			}
+		}
+	}
+	// Percentiles are of the designated destination's replies
+	if s.Percentiles[99] != 6 {
+		t.Errorf("p99 %v, want 6 ms from hop 2", s.Percentiles[99])
+	}
+
+	// Without a destination hop the final hop is judged and every hop ranked
+	s = buildSummary(res, Config{})
+	if s.Destination.Hop != 4 || s.WorstLoss.Hop != 4 || s.WorstLatency.Hop != 4 {
+		t.Errorf("destination %d, worst loss %d, worst latency %d, want hop 4 for each",
+			s.Destination.Hop, s.WorstLoss.Hop, s.WorstLatency.Hop)
+	}
+	if got := hopNumbers(s.TopLoss); len(got) != 3 || got[0] != 4 || got[1] != 3 || got[2] != 2 {
+		t.Errorf("loss ranking %v, want [4 3 2]", got)
+	}
+}
+
+func TestSummaryLevels(t *testing.T) {
+	hops := firewalledRoute()
+	res := &Result{
+		Target:   "example.com",
+		Hops:     hops,
+		Health:   HealthPoor,
+		Warnings: []string{"Destination 192.0.2.2 was not reached"},
+		Analysis: analyze(hops, &Result{}, nil),
+	}
+	summary := func(level SummaryLevel) string {
+		return generateSummary(res, Config{SummaryLevel: level, NoColor: true})
+	}
+
+	minimal := summary(SummaryMinimal)
+	if minimal != "\nSummary: Poor, ??? loss 80.0%, avg 250.0 ms, 1 warning(s)\n" {
+		t.Errorf("minimal summary %q", minimal)
+	}
+
+	normal := summary(SummaryNormal)
+	if summary("") != normal {
+		t.Error("an empty level does not render the normal summary")
+	}
+	for _, want := range []string{"Connection quality: Poor", "Worst packet loss at hop 4", "End-to-end metrics", "Warnings:"} {
+		if !strings.Contains(normal, want) {
+			t.Errorf("normal summary lacks %q:\n%s", want, normal)
+		}
+	}
+
+	detailed := summary(SummaryDetailed)
+	only := []string{"p50:", "Worst hops by loss:", "Worst hops by average latency:", "Largest latency increases:", "Interpretation:"}
+	for _, want := range only {
+		if !strings.Contains(detailed, want) {
+			t.Errorf("detailed summary lacks %q:\n%s", want, detailed)
+		}
+		if strings.Contains(normal, want) {
+			t.Errorf("normal summary has the detailed %q", want)
+		}
+	}
+	for _, want := range []string{"Connection quality: Poor", "End-to-end metrics", "Warnings:"} {
+		if !strings.Contains(detailed, want) {
+			t.Errorf("detailed summary lacks %q", want)
+		}
+	}
+}
+
+func TestSummaryIgnoresEarlyLoss(t *testing.T) {
+	// Only the gateway, whose loss is ignored, drops probes
+	hops := []HopData{
+		{Hop: 1, IP: "10.0.0.1", Hostname: "gw.local", Sent: 10, Loss: 40, Avg: 1},
+		{Hop: 2, IP: "10.0.0.2", Hostname: "isp.example.net", Sent: 10, Avg: 5},
+		{Hop: 3, IP: "192.0.2.1", Hostname: "dest.example.com", Sent: 10, Avg: 20},
+	}
+	cfg := Config{IgnoreLossBeforeHop: 1, NoColor: true, SummaryLevel: SummaryDetailed}
+	res := &Result{Target: "example.com", Hops: hops, Health: evaluateHealth(hops, cfg)}
+
+	s := buildSummary(res, cfg)
+	if s.WorstLoss.Loss != 0 || len(s.TopLoss) != 0 {
+		t.Errorf("worst loss %+v, top loss %v; want the ignored hop left out", s.WorstLoss, hopNumbers(s.TopLoss))
+	}
+	out := generateSummary(res, cfg)
+	if !strings.Contains(out, "No packet loss detected (loss up to hop 1 ignored)") || strings.Contains(out, "40.0%") {
+		t.Errorf("summary names the ignored hop:\n%s", out)
+	}
+
+	// Loss after the ignored hops is still reported
+	hops[2].Loss = 10
+	s = buildSummary(res, cfg)
+	if s.WorstLoss.Hop != 3 || len(s.TopLoss) != 1 {
+		t.Errorf("worst loss at hop %d, top loss %v; want hop 3", s.WorstLoss.Hop, hopNumbers(s.TopLoss))
+	}
+}
@@ -0,0 +1,204 @@
+package mtr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sweepLossIncrease is how many percentage points a hop's loss must rise
+// above its loss at the slowest interval before it counts as rate limited
+const sweepLossIncrease = 10.0
+
+// MinSweepInterval is the fastest probe interval a sweep accepts
+const MinSweepInterval = 10 * time.Millisecond
+
+// ParseIntervals parses a comma-separated list of probe intervals such as
+// "1s,500ms,200ms" and returns them ordered from slowest to fastest
+func ParseIntervals(list string) ([]time.Duration, error) {
+	var intervals []time.Duration
+	seen := make(map[time.Duration]bool)
+	for _, field := range strings.Split(list, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid probe interval %q: %v", field, err)
+		}
+		if d < MinSweepInterval {
+			return nil, fmt.Errorf("probe interval %s is below the minimum of %s", d, MinSweepInterval)
+		}
+		if seen[d] {
+			return nil, fmt.Errorf("probe interval %s listed twice", d)
+		}
+		seen[d] = true
+		intervals = append(intervals, d)
+	}
+	if len(intervals) < 2 {
+		return nil, fmt.Errorf("an interval sweep needs at least two intervals")
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i] > intervals[j] })
+	return intervals, nil
+}
+
+// SweepResult is the outcome of tracing at one probe interval. Err is set
+// instead of Result when the trace failed or was skipped.
+type SweepResult struct {
+	Interval time.Duration
+	Result   *Result
+	Err      error
+	// Skipped is set when the trace did not run because of the time budget
+	Skipped bool
+}
+
+// RunIntervalSweep traces cfg once per interval, slowest first, so the rate
+// at which intermediate hops start dropping probes can be compared. The runs
+// are sequential since concurrent probes would distort the rates. A run that
+// would not finish within the remaining budget is skipped.
+func RunIntervalSweep(ctx context.Context, cfg Config, intervals []time.Duration, budget time.Duration) []SweepResult {
+	deadline := time.Now().Add(budget)
+	results := make([]SweepResult, len(intervals))
+	for i, interval := range intervals {
+		results[i].Interval = interval
+
+		// A cycle per interval, plus the time mtr waits for the last replies
+		estimate := time.Duration(cfg.Count)*interval + time.Second
+		if remaining := time.Until(deadline); estimate > remaining {
+			results[i].Err = fmt.Errorf("skipped: would exceed the time budget of %s", budget)
+			results[i].Skipped = true
+			continue
+		}
+
+		sub := cfg
+		sub.Interval = interval
+		sub.Labels = make(map[string]string, len(cfg.Labels)+1)
+		for k, v := range cfg.Labels {
+			sub.Labels[k] = v
+		}
+		sub.Labels["interval"] = interval.String()
+
+		runCtx, cancel := context.WithDeadline(ctx, deadline)
+		results[i].Result, results[i].Err = Run(runCtx, sub)
+		cancel()
+	}
+	return results
+}
+
+// RateLimitOnset is the interval at which an intermediate hop started
+// dropping probes during a sweep
+type RateLimitOnset struct {
+	Hop      int
+	Host     string
+	Interval time.Duration
+	BaseLoss float64
+	Loss     float64
+}
+
+// rateLimitOnsets finds, for each intermediate hop, the slowest interval at
+// which its loss rose sweepLossIncrease points above its loss at the slowest
+// successful interval. The destination is left out since loss there is real.
+func rateLimitOnsets(results []SweepResult, label string) []RateLimitOnset {
+	var runs []SweepResult
+	for _, r := range results {
+		if r.Err == nil && len(r.Result.Hops) > 0 {
+			runs = append(runs, r)
+		}
+	}
+	if len(runs) < 2 {
+		return nil
+	}
+
+	base := runs[0].Result.Hops
+	var onsets []RateLimitOnset
+	for _, baseHop := range base[:len(base)-1] {
+		for _, run := range runs[1:] {
+			hop, ok := hopByNumber(run.Result.Hops, baseHop.Hop)
+			if !ok || hop.Hop == run.Result.Hops[len(run.Result.Hops)-1].Hop {
+				continue
+			}
+			if hop.Loss-baseHop.Loss >= sweepLossIncrease {
+				onsets = append(onsets, RateLimitOnset{
+					Hop:      hop.Hop,
+					Host:     displayHost(hop, label),
+					Interval: run.Interval,
+					BaseLoss: baseHop.Loss,
+					Loss:     hop.Loss,
+				})
+				break
+			}
+		}
+	}
+	return onsets
+}
+
+// hopByNumber returns the hop with the given number
+func hopByNumber(hops []HopData, n int) (HopData, bool) {
+	for _, hop := range hops {
+		if hop.Hop == n {
+			return hop, true
+		}
+	}
+	return HopData{}, false
+}
+
+// FormatIntervalSweep renders the loss of every hop at each probe interval,
+// followed by where intermediate hops began rate limiting
+func FormatIntervalSweep(results []SweepResult, cfg Config) string {
+	var out strings.Builder
+	out.WriteString(formatHeader())
+	var meta *Meta
+	if !cfg.NoMeta {
+		meta = newMeta(time.Now())
+	}
+	out.WriteString(formatHostInfo(cfg.Hostname, "", meta))
+	label := cfg.unknownHostLabel()
+
+	out.WriteString("Loss% by Probe Interval:\n")
+	out.WriteString(fmt.Sprintf("%-*s%-*s", columnWidths["hop"]+2, "Hop", columnWidths["host"], "Host"))
+	maxHops := 0
+	var hosts []HopData
+	for _, r := range results {
+		out.WriteString(fmt.Sprintf("%-10s", r.Interval))
+		if r.Err == nil && len(r.Result.Hops) > maxHops {
+			maxHops = len(r.Result.Hops)
+			hosts = r.Result.Hops
+		}
+	}
+	out.WriteString("\n")
+	for i := 0; i < maxHops; i++ {
+		out.WriteString(fmt.Sprintf("%-*d%-*s", columnWidths["hop"]+2, hosts[i].Hop, columnWidths["host"], displayHost(hosts[i], label)))
+		for _, r := range results {
+			cell := "-"
+			if r.Err == nil {
+				if hop, ok := hopByNumber(r.Result.Hops, hosts[i].Hop); ok {
+					cell = fmt.Sprintf("%.1f", hop.Loss)
+				}
+			}
+			out.WriteString(fmt.Sprintf("%-10s", cell))
+		}
+		out.WriteString("\n")
+	}
+
+	separated := false
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if !separated {
+			out.WriteString("\n")
+			separated = true
+		}
+		out.WriteString(fmt.Sprintf("%sInterval %s: %v%s\n", colorYellow, r.Interval, r.Err, colorReset))
+	}
+
+	out.WriteString("\nRate Limiting:\n")
+	onsets := rateLimitOnsets(results, label)
+	if len(onsets) == 0 {
+		out.WriteString("  No intermediate hop dropped more probes at faster intervals\n")
+	}
+	for _, o := range onsets {
+		out.WriteString(fmt.Sprintf("  Hop %d (%s) starts dropping probes at %s (%.1f probes/s): loss %.1f%% -> %.1f%%\n",
+			o.Hop, o.Host, o.Interval, float64(time.Second)/float64(o.Interval), o.BaseLoss, o.Loss))
+	}
+	return cfg.withColors(out.String())
+}
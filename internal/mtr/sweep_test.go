@@ -0,0 +1,105 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sweptRun is a run of the sweep whose hops have the given loss
+func sweptRun(interval time.Duration, loss ...float64) SweepResult {
+	res := traced("10.0.0.1", "10.1.0.1", "10.2.0.1", "192.0.2.1")
+	for i := range res.Hops {
+		res.Hops[i].Loss = loss[i]
+	}
+	return SweepResult{Interval: interval, Result: &res}
+}
+
+func TestParseIntervals(t *testing.T) {
+	intervals, err := ParseIntervals("200ms, 1s,500ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(intervals) != 3 || intervals[0] != time.Second || intervals[2] != 200*time.Millisecond {
+		t.Errorf("intervals %v, want slowest first", intervals)
+	}
+	for _, list := range []string{"1s", "1s,1s", "1s,5ms", "1s,fast"} {
+		if _, err := ParseIntervals(list); err == nil {
+			t.Errorf("%q accepted", list)
+		}
+	}
+}
+
+func TestRateLimitOnsets(t *testing.T) {
+	results := []SweepResult{
+		sweptRun(time.Second, 0, 0, 5, 0),
+		sweptRun(500*time.Millisecond, 0, 30, 10, 0),
+		{Interval: 200 * time.Millisecond, Err: errors.New("mtr error: exit status 1")},
+		sweptRun(100*time.Millisecond, 0, 60, 20, 40),
+	}
+
+	onsets := rateLimitOnsets(results, DefaultUnknownHostLabel)
+	if len(onsets) != 2 {
+		t.Fatalf("onsets %+v, want hops 2 and 3", onsets)
+	}
+	// Hop 2 jumps at 500ms; hop 3 only rises 10 points at 100ms
+	if o := onsets[0]; o.Hop != 2 || o.Interval != 500*time.Millisecond || o.BaseLoss != 0 || o.Loss != 30 {
+		t.Errorf("hop 2 onset %+v", o)
+	}
+	if o := onsets[1]; o.Hop != 3 || o.Interval != 100*time.Millisecond || o.BaseLoss != 5 || o.Loss != 20 {
+		t.Errorf("hop 3 onset %+v", o)
+	}
+
+	out := FormatIntervalSweep(results, Config{Hostname: "192.0.2.1", NoColor: true, NoMeta: true})
+	rows := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && rows[fields[0]] == "" {
+			rows[fields[0]] = strings.Join(fields, " ")
+		}
+	}
+	if got := rows["Hop"]; got != "Hop Host 1s 500ms 200ms 100ms" {
+		t.Errorf("header %q", got)
+	}
+	if got := rows["2"]; got != "2 10.1.0.1 0.0 30.0 - 60.0" {
+		t.Errorf("hop 2 row %q", got)
+	}
+	for _, want := range []string{
+		"Interval 200ms: mtr error: exit status 1",
+		"Hop 2 (10.1.0.1) starts dropping probes at 500ms (2.0 probes/s): loss 0.0% -> 30.0%",
+		"Hop 3 (10.2.0.1) starts dropping probes at 100ms (10.0 probes/s): loss 5.0% -> 20.0%",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("sweep lacks %q:\n%s", want, out)
+		}
+	}
+	// Loss at the destination is real, not rate limiting
+	if strings.Contains(out, "Hop 4 (") {
+		t.Errorf("destination reported as rate limiting:\n%s", out)
+	}
+
+	steady := FormatIntervalSweep(results[:1], Config{Hostname: "192.0.2.1", NoColor: true, NoMeta: true})
+	if !strings.Contains(steady, "No intermediate hop dropped more probes") {
+		t.Errorf("single run reported rate limiting:\n%s", steady)
+	}
+}
+
+func TestRunIntervalSweepBudget(t *testing.T) {
+	seq := 0
+	fakeMTR(t, rawProbes(0, "10.0.0.1", &seq, map[int]float64{0: 1}, 1)+
+		rawProbes(1, "192.0.2.1", &seq, map[int]float64{0: 10}, 1))
+
+	// One cycle at 1s fits the budget with mtr's wait for late replies;
+	// the 10s interval does not
+	results := RunIntervalSweep(context.Background(), testConfig(1), []time.Duration{10 * time.Second, time.Second}, 3*time.Second)
+	if !results[0].Skipped || results[0].Err == nil {
+		t.Errorf("10s interval not skipped: %+v", results[0])
+	}
+	if results[1].Skipped || results[1].Err != nil {
+		t.Fatalf("1s interval: %+v", results[1])
+	}
+	if got := results[1].Result.Labels["interval"]; got != "1s" {
+		t.Errorf("interval label %q", got)
+	}
+}
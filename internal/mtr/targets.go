@@ -0,0 +1,186 @@
+package mtr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ErrTargetNotAllowed is returned, wrapped, for a target that Config.Deny
+// matches or Config.Allow does not
+var ErrTargetNotAllowed = errors.New("target not allowed")
+
+// TargetList is a list of hostnames, domains, IP addresses and subnets that
+// targets are matched against. A name matches itself and its subdomains,
+// so "example.com" also matches "www.example.com".
+type TargetList struct {
+	names    []string
+	prefixes []netip.Prefix
+}
+
+// ParseTargetList parses comma-separated entries of a TargetList
+func ParseTargetList(s string) (TargetList, error) {
+	var list TargetList
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(entry), "."))
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return TargetList{}, fmt.Errorf("invalid subnet %q in target list", entry)
+			}
+			list.prefixes = append(list.prefixes, prefix.Masked())
+		default:
+			if addr, err := netip.ParseAddr(entry); err == nil {
+				list.prefixes = append(list.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+				continue
+			}
+			if strings.HasPrefix(entry, "-") || strings.ContainsAny(entry, " \t;&|") {
+				return TargetList{}, fmt.Errorf("invalid hostname %q in target list", entry)
+			}
+			list.names = append(list.names, strings.TrimPrefix(entry, "*."))
+		}
+	}
+	return list, nil
+}
+
+// Empty reports whether the list has no entries
+func (l TargetList) Empty() bool {
+	return len(l.names) == 0 && len(l.prefixes) == 0
+}
+
+// matchName returns the entry matching hostname, if any
+func (l TargetList) matchName(hostname string) (string, bool) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	for _, name := range l.names {
+		if hostname == name || strings.HasSuffix(hostname, "."+name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// matchAddr returns the subnet containing addr, if any
+func (l TargetList) matchAddr(addr netip.Addr) (netip.Prefix, bool) {
+	addr = addr.Unmap()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// checkLists applies cfg.Deny and cfg.Allow to the target hostname and the
+// addresses it resolves to. A target is denied when its name or any of its
+// addresses is on the deny list. With an allow list, it must be on it by
+// name, or with every one of its addresses.
+func (c Config) checkLists(hostname string, ips []string) error {
+	if entry, ok := c.Deny.matchName(hostname); ok {
+		return fmt.Errorf("%w: %s matches %s on the deny list", ErrTargetNotAllowed, hostname, entry)
+	}
+	var addrs []netip.Addr
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, addr := range addrs {
+		prefix, ok := c.Deny.matchAddr(addr)
+		switch {
+		case !ok:
+		case len(ips) == 1 && ips[0] == hostname:
+			return fmt.Errorf("%w: %s matches %s on the deny list", ErrTargetNotAllowed, hostname, prefix)
+		default:
+			return fmt.Errorf("%w: %s resolves to %s, which matches %s on the deny list", ErrTargetNotAllowed, hostname, addr, prefix)
+		}
+	}
+
+	if c.Allow.Empty() {
+		return nil
+	}
+	if _, ok := c.Allow.matchName(hostname); ok {
+		return nil
+	}
+	for _, addr := range addrs {
+		if _, ok := c.Allow.matchAddr(addr); !ok {
+			return fmt.Errorf("%w: %s is not on the allow list", ErrTargetNotAllowed, hostname)
+		}
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("%w: %s is not on the allow list", ErrTargetNotAllowed, hostname)
+	}
+	return nil
+}
+
+// restrictsTargets reports whether cfg limits which targets may be traced
+func (c Config) restrictsTargets() bool {
+	return c.DenyPrivate || !c.Allow.Empty() || !c.Deny.Empty()
+}
+
+// checkTarget applies DenyPrivate and the allow and deny lists to the
+// target hostname and the addresses it resolves to
+func (c Config) checkTarget(hostname string, ips []string) error {
+	if c.DenyPrivate {
+		if err := checkPublic(hostname, ips); err != nil {
+			return err
+		}
+	}
+	return c.checkLists(hostname, ips)
+}
+
+// checkSubnet applies DenyPrivate and the allow and deny lists to a subnet
+// target: it is refused when it overlaps a reserved range or a denied
+// subnet, and with an allow list it must lie within an allowed subnet
+func (c Config) checkSubnet(target string, subnet netip.Prefix) error {
+	if c.DenyPrivate && reservedOverlap(subnet) {
+		return fmt.Errorf("target %s overlaps a %w", target, ErrPrivateTarget)
+	}
+	for _, prefix := range c.Deny.prefixes {
+		if prefix.Overlaps(subnet) {
+			return fmt.Errorf("%w: %s overlaps %s on the deny list", ErrTargetNotAllowed, target, prefix)
+		}
+	}
+	if c.Allow.Empty() {
+		return nil
+	}
+	for _, prefix := range c.Allow.prefixes {
+		if prefix.Bits() <= subnet.Bits() && prefix.Contains(subnet.Addr()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not on the allow list", ErrTargetNotAllowed, target)
+}
+
+// CheckTarget returns an ErrPrivateTarget or ErrTargetNotAllowed error when
+// cfg.DenyPrivate, cfg.Allow or cfg.Deny refuse the target, so a server can
+// refuse the request before accepting it. Run checks again once the target
+// is resolved; one that does not resolve here is only checked by name.
+func CheckTarget(ctx context.Context, cfg Config) error {
+	if !cfg.restrictsTargets() {
+		return nil
+	}
+	if IsCIDR(cfg.Hostname) {
+		subnet, err := ParseCIDR(cfg.Hostname)
+		if err != nil {
+			return nil
+		}
+		return cfg.checkSubnet(cfg.Hostname, subnet)
+	}
+	if _, err := netip.ParseAddr(cfg.Hostname); err == nil {
+		return cfg.checkTarget(cfg.Hostname, []string{cfg.Hostname})
+	}
+	addrs, err := cfg.resolver().LookupIPAddr(ctx, cfg.Hostname)
+	if err != nil {
+		// A name on the deny list is refused even when it does not resolve
+		return cfg.checkLists(cfg.Hostname, nil)
+	}
+	var ips []string
+	for _, addr := range addrs {
+		ips = append(ips, addr.IP.String())
+	}
+	return cfg.checkTarget(cfg.Hostname, ips)
+}
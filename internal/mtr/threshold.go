@@ -0,0 +1,63 @@
+package mtr
+
+import "fmt"
+
+// Thresholds are limits a trace must stay within: the loss of any hop, as
+// judged for the health verdict, and the destination's average latency.
+// Zero disables a limit.
+type Thresholds struct {
+	Loss    float64 // Loss%
+	Latency float64 // Average latency in ms
+}
+
+// ValidateThresholds checks that the limits are in range
+func ValidateThresholds(t Thresholds) error {
+	if t.Loss < 0 || t.Loss > 100 {
+		return fmt.Errorf("invalid loss threshold %g (must be 0-100%%)", t.Loss)
+	}
+	if t.Latency < 0 {
+		return fmt.Errorf("invalid latency threshold %g (must not be negative)", t.Latency)
+	}
+	return nil
+}
+
+// Enabled reports whether any limit is set
+func (t Thresholds) Enabled() bool {
+	return t.Loss > 0 || t.Latency > 0
+}
+
+// Check returns a description of every limit res exceeds, or nil when it
+// stays within them. Like the health verdict, loss only counts at the
+// destination with DestinationLossOnly and not at hops up to
+// IgnoreLossBeforeHop or after DestinationHop.
+func (t Thresholds) Check(res *Result, cfg Config) []string {
+	if len(res.Hops) == 0 {
+		return nil
+	}
+	dest := cfg.destination(res.Hops)
+
+	var breaches []string
+	if t.Loss > 0 {
+		for _, hop := range res.Hops {
+			if cfg.LossJudged(res.Hops, hop) && hop.Loss > t.Loss {
+				breaches = append(breaches, fmt.Sprintf("hop %d (%s) loss %.1f%% exceeds %g%%",
+					hop.Hop, displayHost(hop, cfg.unknownHostLabel()), hop.Loss, t.Loss))
+			}
+		}
+	}
+	if t.Latency > 0 && dest.Avg > t.Latency {
+		breaches = append(breaches, fmt.Sprintf("destination %s average latency %.1f ms exceeds %g ms",
+			displayHost(dest, cfg.unknownHostLabel()), dest.Avg, t.Latency))
+	}
+	return breaches
+}
+
+// LossJudged reports whether the loss of hop, one of hops, counts against
+// the loss threshold: not at hops up to IgnoreLossBeforeHop or after
+// DestinationHop, and only at the destination with DestinationLossOnly
+func (c Config) LossJudged(hops []HopData, hop HopData) bool {
+	if c.DestinationLossOnly && hop.Hop != c.destination(hops).Hop {
+		return false
+	}
+	return !c.lossIgnored(hop) && !c.beyondDestination(hop)
+}
@@ -0,0 +1,25 @@
+package mtr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestThresholdsDestinationHop(t *testing.T) {
+	limits := Thresholds{Loss: 10, Latency: 100}
+	hops := firewalledRoute()
+	res := &Result{Hops: hops}
+
+	if breaches := limits.Check(res, Config{DestinationHop: 2}); breaches != nil {
+		t.Errorf("breaches beyond destination hop 2: %q", breaches)
+	}
+	if breaches := limits.Check(res, Config{}); len(breaches) != 3 {
+		t.Errorf("breaches without a destination hop: %q, want loss at hops 3 and 4 and the latency", breaches)
+	}
+
+	hops[1].Avg = 150
+	breaches := limits.Check(res, Config{DestinationHop: 2})
+	if len(breaches) != 1 || !strings.Contains(breaches[0], "destination edge.example.net average latency 150.0 ms") {
+		t.Errorf("breaches with a slow destination hop: %q", breaches)
+	}
+}
@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+)
+
+// NATS publishes every result as JSON to a NATS subject
+type NATS struct {
+	conn    *nats.Conn
+	subject string
+	keys    mtr.KeyStyle
+}
+
+// NewNATS connects to the NATS server at url. An unreachable server does not
+// fail startup: the connection keeps retrying in the background and results
+// published in the meantime are buffered. keys selects the JSON key style.
+func NewNATS(url, subject string, keys mtr.KeyStyle) (*NATS, error) {
+	conn, err := nats.Connect(url,
+		nats.Name("mtr-tool"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Warn().Err(err).Msg("Disconnected from NATS")
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			log.Info().Str("url", c.ConnectedUrl()).Msg("Reconnected to NATS")
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{conn: conn, subject: subject, keys: keys}, nil
+}
+
+// Publish sends the result as a JSON message
+func (n *NATS) Publish(ctx context.Context, res *mtr.Result) error {
+	data, err := mtr.MarshalKeys(res, n.keys)
+	if err != nil {
+		return fmt.Errorf("nats: %v", err)
+	}
+	// Errors from the nats package already carry a "nats:" prefix
+	return n.conn.Publish(n.subject, data)
+}
+
+// Close flushes pending messages and closes the connection
+func (n *NATS) Close() error {
+	err := n.conn.FlushTimeout(2 * time.Second)
+	n.conn.Close()
+	return err
+}
@@ -0,0 +1,132 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// natsMessage is a message published to the fake NATS server
+type natsMessage struct {
+	subject string
+	data    []byte
+}
+
+// fakeNATS runs a server speaking just enough of the NATS protocol for a
+// client to connect and publish, and returns its URL and the messages it
+// receives
+func fakeNATS(t *testing.T) (string, <-chan natsMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan natsMessage, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveNATS(conn, messages)
+		}
+	}()
+	return "nats://" + ln.Addr().String(), messages
+}
+
+func serveNATS(conn net.Conn, messages chan<- natsMessage) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"fake\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return
+			}
+			messages <- natsMessage{fields[1], data[:size]}
+		}
+	}
+}
+
+func TestNATSPublish(t *testing.T) {
+	url, messages := fakeNATS(t)
+	n, err := NewNATS(url, "mtr.results", mtr.KeysSnake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := statsdResult()
+	if err := n.Publish(context.Background(), res); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-messages:
+		if msg.subject != "mtr.results" {
+			t.Errorf("subject %q", msg.subject)
+		}
+		var got struct {
+			Target string            `json:"target"`
+			Labels map[string]string `json:"labels"`
+			Hops   []struct {
+				Hop int `json:"hop"`
+			} `json:"hops"`
+		}
+		if err := json.Unmarshal(msg.data, &got); err != nil {
+			t.Fatalf("payload %s: %v", msg.data, err)
+		}
+		if got.Target != res.Target || len(got.Hops) != len(res.Hops) || got.Hops[1].Hop != 2 {
+			t.Errorf("payload %s does not match the result", msg.data)
+		}
+		if got.Labels["site"] != "ams" {
+			t.Errorf("payload labels %v", got.Labels)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message published")
+	}
+}
+
+func TestNATSUnavailable(t *testing.T) {
+	// Nothing listens on the port once the listener is closed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "nats://" + ln.Addr().String()
+	ln.Close()
+
+	n, err := NewNATS(url, "mtr.results", mtr.KeysSnake)
+	if err != nil {
+		t.Fatalf("startup failed without the server: %v", err)
+	}
+	defer n.conn.Close()
+	if err := n.Publish(context.Background(), statsdResult()); err != nil {
+		t.Errorf("publish not buffered while reconnecting: %v", err)
+	}
+}
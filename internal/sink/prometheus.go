@@ -0,0 +1,257 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultLatencyBuckets are the histogram buckets in seconds (1ms to ~2s)
+var DefaultLatencyBuckets = prometheus.ExponentialBuckets(0.001, 2, 12)
+
+// SeriesExpiry is how long the series of a target are kept after it was
+// last traced. Targets come from API callers, so without it the series of
+// every target ever traced would be exported forever.
+const SeriesExpiry = 24 * time.Hour
+
+// targetSeries are the label values of a target's series (with its trace
+// labels), so they can be replaced or removed
+type targetSeries struct {
+	target string
+	labels []string
+	// hops are the hop gauge label values of the most recent trace
+	hops [][]string
+	// latency are the histogram label values of every hop traced so far
+	latency map[string][]string
+	// statuses are the trace statuses counted
+	statuses map[string]bool
+	lastSeen time.Time
+}
+
+// Prometheus records results as metrics served by Handler
+type Prometheus struct {
+	registry *prometheus.Registry
+	// labelNames are the trace labels exported as metric labels; a trace
+	// without one of them exports it empty
+	labelNames  []string
+	latency     *prometheus.HistogramVec
+	traces      *prometheus.CounterVec
+	lastSuccess *prometheus.GaugeVec
+
+	// Per-hop statistics of the most recent trace of each target
+	hopLoss  *prometheus.GaugeVec
+	hopSent  *prometheus.GaugeVec
+	hopLast  *prometheus.GaugeVec
+	hopAvg   *prometheus.GaugeVec
+	hopBest  *prometheus.GaugeVec
+	hopWorst *prometheus.GaugeVec
+	hopStDev *prometheus.GaugeVec
+
+	// mu guards current, the series of every target (and trace labels)
+	// traced within expiry, so hops that left the path and targets no
+	// longer traced can be removed
+	mu      sync.Mutex
+	current map[string]*targetSeries
+	expiry  time.Duration
+	now     func() time.Time
+}
+
+// NewPrometheus creates a Prometheus sink exporting the given trace labels,
+// observing latencies into buckets (in seconds)
+func NewPrometheus(labelNames []string, buckets []float64) (*Prometheus, error) {
+	if err := mtr.ValidateLabels(labelSet(labelNames)); err != nil {
+		return nil, fmt.Errorf("prometheus: %v", err)
+	}
+	p := &Prometheus{
+		registry:   prometheus.NewRegistry(),
+		labelNames: labelNames,
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "mtr_hop_latency_seconds",
+			Help:    "Round-trip time of every answered probe per hop",
+			Buckets: buckets,
+		}, append([]string{"target", "hop"}, labelNames...)),
+		traces: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "mtr_traces_total",
+			Help: "Traces run, by status (success or error)",
+		}, append([]string{"target", "status"}, labelNames...)),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "mtr_last_success_timestamp_seconds",
+			Help: "Unix time of the last successful trace",
+		}, append([]string{"target"}, labelNames...)),
+		current: make(map[string]*targetSeries),
+		expiry:  SeriesExpiry,
+		now:     time.Now,
+	}
+	hopLabels := append([]string{"target", "hop", "ip"}, labelNames...)
+	hopGauge := func(name, help string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, hopLabels)
+	}
+	p.hopLoss = hopGauge("mtr_hop_loss_percent", "Loss% of the hop in the target's most recent trace")
+	p.hopSent = hopGauge("mtr_hop_sent", "Probes sent to the hop in the target's most recent trace")
+	p.hopLast = hopGauge("mtr_hop_last_seconds", "Round-trip time of the hop's last answered probe in the target's most recent trace")
+	p.hopAvg = hopGauge("mtr_hop_avg_seconds", "Average round-trip time of the hop in the target's most recent trace")
+	p.hopBest = hopGauge("mtr_hop_best_seconds", "Best round-trip time of the hop in the target's most recent trace")
+	p.hopWorst = hopGauge("mtr_hop_worst_seconds", "Worst round-trip time of the hop in the target's most recent trace")
+	p.hopStDev = hopGauge("mtr_hop_stdev_seconds", "Standard deviation of the hop's round-trip times in the target's most recent trace")
+
+	for _, c := range []prometheus.Collector{p.latency, p.traces, p.lastSuccess,
+		p.hopLoss, p.hopSent, p.hopLast, p.hopAvg, p.hopBest, p.hopWorst, p.hopStDev} {
+		if err := p.registry.Register(c); err != nil {
+			return nil, fmt.Errorf("prometheus: %v", err)
+		}
+	}
+	return p, nil
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// Publish observes every retained RTT sample of every hop and sets the hop
+// gauges to the statistics of this trace
+func (p *Prometheus) Publish(ctx context.Context, res *mtr.Result) error {
+	labels := p.labelValues(res.Labels)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	series := p.seen(res.Target, labels)
+
+	p.traces.WithLabelValues(append([]string{res.Target, "success"}, labels...)...).Inc()
+	series.statuses["success"] = true
+	p.lastSuccess.WithLabelValues(append([]string{res.Target}, labels...)...).SetToCurrentTime()
+
+	// The histogram is not labelled by IP: it accumulates across traces,
+	// and a series per address a hop ever answered from would only grow
+	var hopValues [][]string
+	for _, hop := range res.Hops {
+		number := strconv.Itoa(hop.Hop)
+		hopValues = append(hopValues, append([]string{res.Target, number, hop.IP}, labels...))
+		values := append([]string{res.Target, number}, labels...)
+		series.latency[number] = values
+		observer := p.latency.WithLabelValues(values...)
+		for _, sample := range hop.Samples {
+			if !sample.Lost {
+				observer.Observe(sample.RTT / 1000)
+			}
+		}
+	}
+	p.setHops(series, res.Hops, hopValues)
+	return nil
+}
+
+// seen returns the series of target with the trace label values labels,
+// marked as traced now, after removing the series of targets not traced
+// within the expiry. It is called with mu held.
+func (p *Prometheus) seen(target string, labels []string) *targetSeries {
+	now := p.now()
+	for id, series := range p.current {
+		if now.Sub(series.lastSeen) > p.expiry {
+			p.remove(series)
+			delete(p.current, id)
+		}
+	}
+
+	id := strings.Join(append([]string{target}, labels...), "\x00")
+	series := p.current[id]
+	if series == nil {
+		series = &targetSeries{target: target, labels: labels, latency: make(map[string][]string), statuses: make(map[string]bool)}
+		p.current[id] = series
+	}
+	series.lastSeen = now
+	return series
+}
+
+// remove deletes every series of an expired target
+func (p *Prometheus) remove(series *targetSeries) {
+	p.deleteHops(series)
+	for _, values := range series.latency {
+		p.latency.DeleteLabelValues(values...)
+	}
+	for status := range series.statuses {
+		p.traces.DeleteLabelValues(append([]string{series.target, status}, series.labels...)...)
+	}
+	p.lastSuccess.DeleteLabelValues(append([]string{series.target}, series.labels...)...)
+}
+
+// deleteHops deletes the hop gauges of the target's previous trace
+func (p *Prometheus) deleteHops(series *targetSeries) {
+	for _, values := range series.hops {
+		for _, g := range []*prometheus.GaugeVec{p.hopLoss, p.hopSent, p.hopLast, p.hopAvg, p.hopBest, p.hopWorst, p.hopStDev} {
+			g.DeleteLabelValues(values...)
+		}
+	}
+}
+
+// setHops replaces the hop gauges of the target's previous trace with the
+// statistics of hops, whose label values are hopValues. It is called with
+// mu held.
+func (p *Prometheus) setHops(series *targetSeries, hops []mtr.HopData, hopValues [][]string) {
+	p.deleteHops(series)
+	for i, hop := range hops {
+		values := hopValues[i]
+		p.hopLoss.WithLabelValues(values...).Set(hop.Loss)
+		p.hopSent.WithLabelValues(values...).Set(float64(hop.Sent))
+		p.hopLast.WithLabelValues(values...).Set(hop.Last / 1000)
+		p.hopAvg.WithLabelValues(values...).Set(hop.Avg / 1000)
+		p.hopBest.WithLabelValues(values...).Set(hop.Best / 1000)
+		p.hopWorst.WithLabelValues(values...).Set(hop.Worst / 1000)
+		p.hopStDev.WithLabelValues(values...).Set(hop.StDev / 1000)
+	}
+	series.hops = hopValues
+}
+
+// RecordFailure counts a trace that failed without producing a result
+func (p *Prometheus) RecordFailure(ctx context.Context, target string, labels map[string]string, err error) error {
+	values := p.labelValues(labels)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seen(target, values).statuses["error"] = true
+	p.traces.WithLabelValues(append([]string{target, "error"}, values...)...).Inc()
+	return nil
+}
+
+// Close is a no-op; metrics stay available until the process exits
+func (p *Prometheus) Close() error {
+	return nil
+}
+
+// labelValues returns the values of the exported trace labels, in order
+func (p *Prometheus) labelValues(labels map[string]string) []string {
+	values := make([]string, len(p.labelNames))
+	for i, name := range p.labelNames {
+		values[i] = labels[name]
+	}
+	return values
+}
+
+// ParseBuckets parses comma-separated histogram bucket boundaries in seconds
+func ParseBuckets(list string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(list, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || b <= 0 {
+			return nil, fmt.Errorf("invalid bucket %q (must be a positive number of seconds)", field)
+		}
+		if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+			return nil, fmt.Errorf("buckets must be in increasing order")
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, nil
+}
+
+func labelSet(names []string) map[string]string {
+	set := make(map[string]string, len(names))
+	for _, name := range names {
+		set[name] = ""
+	}
+	return set
+}
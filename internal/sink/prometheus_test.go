@@ -0,0 +1,159 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// gathered returns the metrics of family name whose labels include want
+func gathered(t *testing.T, p *Prometheus, name string, want map[string]string) []*dto.Metric {
+	t.Helper()
+	families, err := p.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics []*dto.Metric
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	metric:
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, pair := range m.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			for k, v := range want {
+				if labels[k] != v {
+					continue metric
+				}
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+func TestPrometheusLatencyBuckets(t *testing.T) {
+	buckets, err := ParseBuckets("0.005, 0.01,0.05")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewPrometheus(nil, buckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &mtr.Result{Target: "example.com", Hops: []mtr.HopData{
+		{Hop: 1, IP: "10.0.0.1", Samples: []mtr.Sample{{RTT: 2}, {RTT: 8}, {Lost: true}, {RTT: 20}, {RTT: 100}}},
+	}}
+	if err := p.Publish(context.Background(), res); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := gathered(t, p, "mtr_hop_latency_seconds", map[string]string{"target": "example.com", "hop": "1"})
+	if len(metrics) != 1 {
+		t.Fatalf("%d latency histograms, want 1", len(metrics))
+	}
+	h := metrics[0].GetHistogram()
+	if h.GetSampleCount() != 4 {
+		t.Errorf("%d observations, want the 4 answered probes", h.GetSampleCount())
+	}
+	if sum := h.GetSampleSum(); sum < 0.1299 || sum > 0.1301 {
+		t.Errorf("sum %v s, want 0.13", sum)
+	}
+	want := map[float64]uint64{0.005: 1, 0.01: 2, 0.05: 3}
+	for _, b := range h.GetBucket() {
+		if b.GetCumulativeCount() != want[b.GetUpperBound()] {
+			t.Errorf("bucket le=%v holds %d, want %d", b.GetUpperBound(), b.GetCumulativeCount(), want[b.GetUpperBound()])
+		}
+	}
+
+	for _, list := range []string{"", "0.01,0.005", "-1", "fast"} {
+		if _, err := ParseBuckets(list); err == nil {
+			t.Errorf("buckets %q accepted", list)
+		}
+	}
+}
+
+func TestPrometheusRecordFailure(t *testing.T) {
+	p, err := NewPrometheus([]string{"site"}, DefaultLatencyBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"site": "ams"}
+	traceErr := errors.New("failed to resolve hostname: example.invalid")
+	for i := 0; i < 2; i++ {
+		if err := p.RecordFailure(context.Background(), "example.invalid", labels, traceErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed := gathered(t, p, "mtr_traces_total", map[string]string{"target": "example.invalid", "status": "error", "site": "ams"})
+	if len(failed) != 1 || failed[0].GetCounter().GetValue() != 2 {
+		t.Errorf("error count %v, want 2", failed)
+	}
+	if got := gathered(t, p, "mtr_last_success_timestamp_seconds", map[string]string{"target": "example.invalid"}); len(got) != 0 {
+		t.Errorf("last success set for a target that never succeeded: %v", got)
+	}
+
+	// A later success sets the timestamp dashboards alert on
+	if err := p.Publish(context.Background(), &mtr.Result{Target: "example.invalid", Labels: labels}); err != nil {
+		t.Fatal(err)
+	}
+	if got := gathered(t, p, "mtr_last_success_timestamp_seconds", map[string]string{"target": "example.invalid", "site": "ams"}); len(got) != 1 || got[0].GetGauge().GetValue() == 0 {
+		t.Errorf("last success %v after a successful trace", got)
+	}
+}
+
+func TestPrometheusSeriesExpire(t *testing.T) {
+	p, err := NewPrometheus(nil, DefaultLatencyBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	p.now = func() time.Time { return now }
+	traced := func(target string, ips ...string) *mtr.Result {
+		res := &mtr.Result{Target: target}
+		for i, ip := range ips {
+			res.Hops = append(res.Hops, mtr.HopData{Hop: i + 1, IP: ip, Sent: 1, Samples: []mtr.Sample{{RTT: 5}}})
+		}
+		return res
+	}
+	series := func(target string) int {
+		n := 0
+		for _, name := range []string{"mtr_hop_latency_seconds", "mtr_traces_total", "mtr_last_success_timestamp_seconds", "mtr_hop_loss_percent"} {
+			n += len(gathered(t, p, name, map[string]string{"target": target}))
+		}
+		return n
+	}
+
+	// A hop answering from another address keeps its histogram
+	p.Publish(context.Background(), traced("once.example.com", "10.0.0.1", "192.0.2.1"))
+	p.Publish(context.Background(), traced("once.example.com", "10.0.0.2", "192.0.2.1"))
+	if got := gathered(t, p, "mtr_hop_latency_seconds", map[string]string{"target": "once.example.com", "hop": "1"}); len(got) != 1 || got[0].GetHistogram().GetSampleCount() != 2 {
+		t.Errorf("hop 1 histograms %v, want one with both traces", got)
+	}
+	p.RecordFailure(context.Background(), "failing.example.com", nil, errors.New("mtr error"))
+
+	now = now.Add(SeriesExpiry / 2)
+	p.Publish(context.Background(), traced("often.example.com", "192.0.2.1"))
+	now = now.Add(SeriesExpiry/2 + time.Minute)
+	p.Publish(context.Background(), traced("often.example.com", "192.0.2.1"))
+
+	for _, target := range []string{"once.example.com", "failing.example.com"} {
+		if n := series(target); n != 0 {
+			t.Errorf("%d series of %s left after it expired", n, target)
+		}
+	}
+	if n := series("often.example.com"); n != 4 {
+		t.Errorf("%d series of a target still traced, want 4", n)
+	}
+	if len(p.current) != 1 {
+		t.Errorf("%d targets tracked, want 1", len(p.current))
+	}
+}
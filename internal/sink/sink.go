@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/rs/zerolog/log"
+)
+
+// Sink receives every completed trace result
+type Sink interface {
+	Publish(ctx context.Context, res *mtr.Result) error
+	// Close flushes anything pending and releases the sink's connection
+	Close() error
+}
+
+// FailureRecorder is implemented by sinks that also account for traces that
+// failed entirely, so monitoring notices when no trace succeeds
+type FailureRecorder interface {
+	RecordFailure(ctx context.Context, target string, labels map[string]string, err error) error
+}
+
+// ConfigPublisher is implemented by sinks that judge results, so they are
+// judged with the settings of the trace that produced them, such as a
+// request's destination hop, rather than the sink's own
+type ConfigPublisher interface {
+	PublishWithConfig(ctx context.Context, res *mtr.Result, cfg mtr.Config) error
+}
+
+// PublishAll sends the result of a trace run with cfg to each sink. Failures
+// are logged and never abort the trace.
+func PublishAll(ctx context.Context, sinks []Sink, res *mtr.Result, cfg mtr.Config) {
+	for _, s := range sinks {
+		var err error
+		if p, ok := s.(ConfigPublisher); ok {
+			err = p.PublishWithConfig(ctx, res, cfg)
+		} else {
+			err = s.Publish(ctx, res)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("target", res.Target).Msg("Failed to publish trace result")
+		}
+	}
+}
+
+// RecordFailureAll reports a failed trace to every sink that records failures.
+// Failures to record are logged.
+func RecordFailureAll(ctx context.Context, sinks []Sink, target string, labels map[string]string, traceErr error) {
+	for _, s := range sinks {
+		if recorder, ok := s.(FailureRecorder); ok {
+			if err := recorder.RecordFailure(ctx, target, labels, traceErr); err != nil {
+				log.Warn().Err(err).Str("target", target).Msg("Failed to record trace failure")
+			}
+		}
+	}
+}
+
+// CloseAll closes every sink, logging failures
+func CloseAll(sinks []Sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Warn().Err(err).Msg("Failed to close result sink")
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+func TestLabelsReachSinks(t *testing.T) {
+	res := statsdResult()
+	res.Hops[1].Loss = 50
+
+	conn, read := listenUDP(t)
+	statsd, err := NewStatsD(conn.LocalAddr().String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prom, err := NewPrometheus([]string{"site"}, DefaultLatencyBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerts := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		alerts <- body
+	}))
+	defer srv.Close()
+	webhook, err := NewWebhook(srv.URL, mtr.Thresholds{Loss: 10}, mtr.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sinks := []Sink{statsd, prom, webhook}
+	PublishAll(context.Background(), sinks, res, mtr.Config{})
+	CloseAll(sinks)
+
+	for _, packet := range read(3) {
+		if !strings.Contains(packet, "site:ams") {
+			t.Errorf("statsd packet %q lacks the label", packet)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	prom.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, metric := range []string{"mtr_traces_total", "mtr_hop_loss_percent", "mtr_last_success_timestamp_seconds"} {
+		found := false
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			if strings.HasPrefix(line, metric+"{") {
+				found = true
+				if !strings.Contains(line, `site="ams"`) {
+					t.Errorf("metric %q lacks the label", line)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no %s metric", metric)
+		}
+	}
+
+	var alert WebhookAlert
+	if err := json.Unmarshal(<-alerts, &alert); err != nil {
+		t.Fatal(err)
+	}
+	if alert.Labels["site"] != "ams" {
+		t.Errorf("webhook alert labels %v", alert.Labels)
+	}
+}
@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// StatsD emits per-hop latency and loss metrics over UDP
+type StatsD struct {
+	conn net.Conn
+	// DogStatsD appends tags using the "|#key:value" extension instead of
+	// encoding them in the metric name
+	dogStatsD bool
+}
+
+// NewStatsD creates a StatsD sink sending to addr (host:port)
+func NewStatsD(addr string, dogStatsD bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd: %v", err)
+	}
+	return &StatsD{conn: conn, dogStatsD: dogStatsD}, nil
+}
+
+// Publish writes one packet per hop containing its latency and loss metrics
+func (s *StatsD) Publish(ctx context.Context, res *mtr.Result) error {
+	var failed int
+	var lastErr error
+	for _, hop := range res.Hops {
+		tags := []string{
+			"target:" + res.Target,
+			"hop:" + strconv.Itoa(hop.Hop),
+		}
+		if hop.Hostname != "" {
+			tags = append(tags, "host:"+hop.Hostname)
+		}
+		for _, name := range sortedKeys(res.Labels) {
+			tags = append(tags, name+":"+res.Labels[name])
+		}
+		packet := s.line("mtr.hop.latency", hop.Avg, "ms", res.Target, hop.Hop, tags) + "\n" +
+			s.line("mtr.hop.loss", hop.Loss, "g", res.Target, hop.Hop, tags)
+		if _, err := s.conn.Write([]byte(packet)); err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("statsd: %d of %d hops not sent: %v", failed, len(res.Hops), lastErr)
+	}
+	return s.countTrace("success", res.Target, res.Labels)
+}
+
+// RecordFailure counts a trace that failed without producing a result
+func (s *StatsD) RecordFailure(ctx context.Context, target string, labels map[string]string, err error) error {
+	return s.countTrace("error", target, labels)
+}
+
+// countTrace increments the mtr.traces counter for the given status
+func (s *StatsD) countTrace(status, target string, labels map[string]string) error {
+	var line string
+	if s.dogStatsD {
+		tags := []string{sanitizeTag("target:" + target), "status:" + status}
+		for _, name := range sortedKeys(labels) {
+			tags = append(tags, sanitizeTag(name+":"+labels[name]))
+		}
+		line = fmt.Sprintf("mtr.traces:1|c|#%s", strings.Join(tags, ","))
+	} else {
+		line = fmt.Sprintf("mtr.traces.%s.%s:1|c", status, sanitizeName(target))
+	}
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		return fmt.Errorf("statsd: %v", err)
+	}
+	return nil
+}
+
+// Close releases the UDP socket
+func (s *StatsD) Close() error {
+	return s.conn.Close()
+}
+
+// line formats a single metric. tags are "key:value" pairs; plain StatsD has
+// no tags, so there the target and hop are folded into the metric name and
+// the remaining tags are dropped.
+func (s *StatsD) line(name string, value float64, kind string, target string, hop int, tags []string) string {
+	val := strconv.FormatFloat(value, 'f', 3, 64)
+	if s.dogStatsD {
+		for i, tag := range tags {
+			tags[i] = sanitizeTag(tag)
+		}
+		return fmt.Sprintf("%s:%s|%s|#%s", name, val, kind, strings.Join(tags, ","))
+	}
+	return fmt.Sprintf("%s.%s.%d:%s|%s", name, sanitizeName(target), hop, val, kind)
+}
+
+// sortedKeys returns the keys of m in a stable order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sanitizeTag strips characters that carry meaning in the DogStatsD protocol
+func sanitizeTag(v string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace(v)
+}
+
+// sanitizeName makes v safe to use as a single StatsD metric name segment
+func sanitizeName(v string) string {
+	return strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_", " ", "_", "\n", "_").Replace(v)
+}
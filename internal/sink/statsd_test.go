@@ -0,0 +1,94 @@
+package sink
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+// listenUDP returns a local UDP listener and a function reading the next
+// n packets it receives
+func listenUDP(t *testing.T) (net.PacketConn, func(n int) []string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, func(n int) []string {
+		t.Helper()
+		var packets []string
+		buf := make([]byte, 64*1024)
+		for len(packets) < n {
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			m, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				t.Fatalf("after %d of %d packets: %v", len(packets), n, err)
+			}
+			packets = append(packets, string(buf[:m]))
+		}
+		return packets
+	}
+}
+
+func statsdResult() *mtr.Result {
+	return &mtr.Result{
+		Target: "example.com",
+		Labels: map[string]string{"site": "ams"},
+		Hops: []mtr.HopData{
+			{Hop: 1, Hostname: "gw.local", Avg: 1.5},
+			{Hop: 2, Avg: 12.25, Loss: 10},
+		},
+	}
+}
+
+func TestStatsDPlain(t *testing.T) {
+	conn, read := listenUDP(t)
+	s, err := NewStatsD(conn.LocalAddr().String(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Publish(context.Background(), statsdResult()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"mtr.hop.latency.example_com.1:1.500|ms\nmtr.hop.loss.example_com.1:0.000|g",
+		"mtr.hop.latency.example_com.2:12.250|ms\nmtr.hop.loss.example_com.2:10.000|g",
+		"mtr.traces.success.example_com:1|c",
+	}
+	for i, got := range read(len(want)) {
+		if got != want[i] {
+			t.Errorf("packet %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
+
+func TestStatsDTagged(t *testing.T) {
+	conn, read := listenUDP(t)
+	s, err := NewStatsD(conn.LocalAddr().String(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.Publish(context.Background(), statsdResult()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"mtr.hop.latency:1.500|ms|#target:example.com,hop:1,host:gw.local,site:ams\n" +
+			"mtr.hop.loss:0.000|g|#target:example.com,hop:1,host:gw.local,site:ams",
+		"mtr.hop.latency:12.250|ms|#target:example.com,hop:2,site:ams\n" +
+			"mtr.hop.loss:10.000|g|#target:example.com,hop:2,site:ams",
+		"mtr.traces:1|c|#target:example.com,status:success,site:ams",
+	}
+	for i, got := range read(len(want)) {
+		if got != want[i] {
+			t.Errorf("packet %d = %q, want %q", i, got, want[i])
+		}
+	}
+}
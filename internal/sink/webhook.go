@@ -0,0 +1,155 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/rs/zerolog/log"
+)
+
+// webhookTimeout bounds each POST to the webhook; a failed POST is retried
+// once after webhookRetryDelay
+const (
+	webhookTimeout    = 5 * time.Second
+	webhookRetryDelay = time.Second
+)
+
+// Webhook POSTs a JSON alert to a URL for every result that exceeds its
+// thresholds. Alerts are sent in the background so a slow webhook never
+// holds up tracing; Close waits for the ones in flight.
+type Webhook struct {
+	url        string
+	thresholds mtr.Thresholds
+	// judge holds the settings the thresholds are judged with, such as
+	// DestinationLossOnly, when the trace's own are not given
+	judge   mtr.Config
+	client  *http.Client
+	pending sync.WaitGroup
+}
+
+// WebhookHop identifies a hop in a webhook alert
+type WebhookHop struct {
+	Hop      int     `json:"hop"`
+	IP       string  `json:"ip"`
+	Hostname string  `json:"hostname"`
+	Loss     float64 `json:"loss"`
+	Avg      float64 `json:"avg"`
+}
+
+// WebhookAlert is the JSON body POSTed when a trace exceeds the thresholds
+type WebhookAlert struct {
+	Target          string            `json:"target"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Breaches        []string          `json:"breaches"`
+	WorstLossHop    WebhookHop        `json:"worst_loss_hop"`
+	WorstLatencyHop WebhookHop        `json:"worst_latency_hop"`
+	Timestamp       time.Time         `json:"timestamp"`
+}
+
+// NewWebhook creates a webhook sink alerting rawURL when a result exceeds
+// thresholds, judged with the loss settings of judge
+func NewWebhook(rawURL string, thresholds mtr.Thresholds, judge mtr.Config) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook: invalid URL %q", rawURL)
+	}
+	if !thresholds.Enabled() {
+		return nil, fmt.Errorf("webhook: no loss or latency threshold set")
+	}
+	return &Webhook{
+		url:        rawURL,
+		thresholds: thresholds,
+		judge:      judge,
+		client:     &http.Client{Timeout: webhookTimeout},
+	}, nil
+}
+
+// Publish starts sending an alert when res exceeds the thresholds
+func (w *Webhook) Publish(ctx context.Context, res *mtr.Result) error {
+	return w.PublishWithConfig(ctx, res, w.judge)
+}
+
+// PublishWithConfig starts sending an alert when res exceeds the thresholds,
+// judged with the loss settings and destination hop of cfg
+func (w *Webhook) PublishWithConfig(ctx context.Context, res *mtr.Result, cfg mtr.Config) error {
+	breaches := w.thresholds.Check(res, cfg)
+	if len(breaches) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(newWebhookAlert(res, cfg, breaches, time.Now()))
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		err := w.post(body)
+		if err != nil {
+			time.Sleep(webhookRetryDelay)
+			err = w.post(body)
+		}
+		if err != nil {
+			log.Warn().Err(err).Str("target", res.Target).Msg("Failed to send threshold webhook")
+		}
+	}()
+	return nil
+}
+
+// post sends one alert, failing on any non-2xx response
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// Close waits for the alerts still being sent
+func (w *Webhook) Close() error {
+	w.pending.Wait()
+	return nil
+}
+
+// newWebhookAlert describes res with the hops of worst average latency and
+// of worst loss among the hops whose loss cfg judges, as the breaches were
+func newWebhookAlert(res *mtr.Result, cfg mtr.Config, breaches []string, now time.Time) WebhookAlert {
+	alert := WebhookAlert{
+		Target:    res.Target,
+		Labels:    res.Labels,
+		Breaches:  breaches,
+		Timestamp: now.UTC(),
+	}
+	var worstLoss, worstLatency *mtr.HopData
+	for i := range res.Hops {
+		hop := &res.Hops[i]
+		if cfg.LossJudged(res.Hops, *hop) && (worstLoss == nil || hop.Loss > worstLoss.Loss) {
+			worstLoss = hop
+		}
+		if worstLatency == nil || hop.Avg > worstLatency.Avg {
+			worstLatency = hop
+		}
+	}
+	if worstLoss != nil {
+		alert.WorstLossHop = webhookHop(*worstLoss)
+	}
+	if worstLatency != nil {
+		alert.WorstLatencyHop = webhookHop(*worstLatency)
+	}
+	return alert
+}
+
+func webhookHop(hop mtr.HopData) WebhookHop {
+	return WebhookHop{Hop: hop.Hop, IP: hop.IP, Hostname: hop.Hostname, Loss: hop.Loss, Avg: hop.Avg}
+}
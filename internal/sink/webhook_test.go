@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+)
+
+func TestWebhookJudgesWithTraceConfig(t *testing.T) {
+	var alerts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alerts.Add(1)
+	}))
+	defer srv.Close()
+
+	// Only hop 3, beyond a destination hop of 2, exceeds the loss threshold
+	res := &mtr.Result{Target: "example.com", Hops: []mtr.HopData{
+		{Hop: 1, IP: "10.0.0.1", Avg: 1},
+		{Hop: 2, IP: "10.0.0.2", Avg: 5},
+		{Hop: 3, IP: "192.0.2.1", Loss: 50, Avg: 20},
+	}}
+	w, err := NewWebhook(srv.URL, mtr.Thresholds{Loss: 10}, mtr.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A request's destination hop overrides the sink's own settings
+	PublishAll(context.Background(), []Sink{w}, res, mtr.Config{DestinationHop: 2})
+	w.Close()
+	if n := alerts.Load(); n != 0 {
+		t.Errorf("%d alerts for loss beyond the request's destination hop", n)
+	}
+
+	PublishAll(context.Background(), []Sink{w}, res, mtr.Config{})
+	w.Close()
+	if n := alerts.Load(); n != 1 {
+		t.Errorf("%d alerts, want 1 for the loss at the destination", n)
+	}
+}
+
+func TestWebhookAlertWorstLossHop(t *testing.T) {
+	// The gateway rate-limits its replies; the destination loses probes
+	res := &mtr.Result{Target: "example.com", Hops: []mtr.HopData{
+		{Hop: 1, IP: "10.0.0.1", Loss: 60, Avg: 1},
+		{Hop: 2, IP: "10.0.0.2", Loss: 40, Avg: 5},
+		{Hop: 3, IP: "192.0.2.1", Loss: 20, Avg: 20},
+		{Hop: 4, IP: "192.0.2.2", Loss: 80, Avg: 30},
+	}}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		cfg  mtr.Config
+		want int
+	}{
+		{"all hops", mtr.Config{}, 4},
+		{"destination loss only", mtr.Config{DestinationLossOnly: true}, 4},
+		{"destination hop", mtr.Config{DestinationHop: 3}, 1},
+		{"destination loss only at a destination hop", mtr.Config{DestinationLossOnly: true, DestinationHop: 3}, 3},
+		{"early hops ignored", mtr.Config{IgnoreLossBeforeHop: 1, DestinationHop: 3}, 2},
+	}
+	for _, tt := range tests {
+		alert := newWebhookAlert(res, tt.cfg, []string{"loss"}, now)
+		if alert.WorstLossHop.Hop != tt.want {
+			t.Errorf("%s: worst loss hop %d, want %d", tt.name, alert.WorstLossHop.Hop, tt.want)
+		}
+		if alert.WorstLatencyHop.Hop != 4 {
+			t.Errorf("%s: worst latency hop %d, want 4", tt.name, alert.WorstLatencyHop.Hop)
+		}
+	}
+}
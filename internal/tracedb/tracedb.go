@@ -0,0 +1,200 @@
+package tracedb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	_ "modernc.org/sqlite" // registers the pure Go "sqlite" driver
+)
+
+// schema is created when the database is opened. Times are stored as UTC
+// RFC 3339 strings, which sort chronologically.
+const schema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+	target              TEXT    NOT NULL,
+	started_at          TEXT    NOT NULL,
+	health              TEXT    NOT NULL,
+	destination_reached INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS runs_by_target ON runs (target, started_at);
+CREATE TABLE IF NOT EXISTS hops (
+	run_id   INTEGER NOT NULL REFERENCES runs (id) ON DELETE CASCADE,
+	hop      INTEGER NOT NULL,
+	hostname TEXT    NOT NULL,
+	ip       TEXT    NOT NULL,
+	loss     REAL    NOT NULL,
+	sent     INTEGER NOT NULL,
+	last     REAL    NOT NULL,
+	avg      REAL    NOT NULL,
+	best     REAL    NOT NULL,
+	worst    REAL    NOT NULL,
+	stdev    REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS hops_by_run ON hops (run_id);
+`
+
+// Run is one recorded trace
+type Run struct {
+	ID                 int64     `json:"id"`
+	Target             string    `json:"target"`
+	StartedAt          time.Time `json:"started_at"`
+	Health             string    `json:"health"`
+	DestinationReached bool      `json:"destination_reached"`
+	Hops               []Hop     `json:"hops"`
+}
+
+// Hop is the statistics of one hop of a recorded trace
+type Hop struct {
+	Hop      int     `json:"hop"`
+	Hostname string  `json:"hostname"`
+	IP       string  `json:"ip"`
+	Loss     float64 `json:"loss"`
+	Sent     int     `json:"sent"`
+	Last     float64 `json:"last"`
+	Avg      float64 `json:"avg"`
+	Best     float64 `json:"best"`
+	Worst    float64 `json:"worst"`
+	StDev    float64 `json:"stdev"`
+}
+
+// DB records every completed trace, as a sink, in a SQLite database and
+// serves the recent runs of a target
+type DB struct {
+	db         *sql.DB
+	insertRun  *sql.Stmt
+	insertHop  *sql.Stmt
+	selectRuns *sql.Stmt
+	selectHops *sql.Stmt
+}
+
+// Open opens the database at path, creating it and its tables when needed
+func Open(path string) (*DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace database: %v", err)
+	}
+	// SQLite allows a single writer; one connection avoids "database is
+	// locked" errors between concurrent traces
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create trace database %s: %v", path, err)
+	}
+
+	d := &DB{db: db}
+	for _, s := range []struct {
+		stmt  **sql.Stmt
+		query string
+	}{
+		{&d.insertRun, `INSERT INTO runs (target, started_at, health, destination_reached) VALUES (?, ?, ?, ?)`},
+		{&d.insertHop, `INSERT INTO hops (run_id, hop, hostname, ip, loss, sent, last, avg, best, worst, stdev) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`},
+		{&d.selectRuns, `SELECT id, target, started_at, health, destination_reached FROM runs WHERE target = ? ORDER BY started_at DESC, id DESC LIMIT ?`},
+		{&d.selectHops, `SELECT hop, hostname, ip, loss, sent, last, avg, best, worst, stdev FROM hops WHERE run_id = ? ORDER BY hop`},
+	} {
+		if *s.stmt, err = db.Prepare(s.query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to prepare trace database query: %v", err)
+		}
+	}
+	return d, nil
+}
+
+// Publish records a completed trace with its hops
+func (d *DB) Publish(ctx context.Context, res *mtr.Result) error {
+	started := time.Now()
+	if res.Meta != nil {
+		started = res.Meta.StartedAt
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("trace database: %v", err)
+	}
+	defer tx.Rollback()
+	run, err := tx.StmtContext(ctx, d.insertRun).ExecContext(ctx,
+		res.Target, started.UTC().Format(time.RFC3339Nano), string(res.Health), res.DestinationReached)
+	if err != nil {
+		return fmt.Errorf("trace database: %v", err)
+	}
+	id, err := run.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("trace database: %v", err)
+	}
+	insertHop := tx.StmtContext(ctx, d.insertHop)
+	for _, hop := range res.Hops {
+		if _, err := insertHop.ExecContext(ctx, id, hop.Hop, hop.Hostname, hop.IP,
+			hop.Loss, hop.Sent, hop.Last, hop.Avg, hop.Best, hop.Worst, hop.StDev); err != nil {
+			return fmt.Errorf("trace database: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("trace database: %v", err)
+	}
+	return nil
+}
+
+// Recent returns the limit most recent runs of target, newest first
+func (d *DB) Recent(ctx context.Context, target string, limit int) ([]Run, error) {
+	rows, err := d.selectRuns.QueryContext(ctx, target, limit)
+	if err != nil {
+		return nil, fmt.Errorf("trace database: %v", err)
+	}
+	runs := []Run{}
+	for rows.Next() {
+		var run Run
+		var started string
+		if err := rows.Scan(&run.ID, &run.Target, &started, &run.Health, &run.DestinationReached); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("trace database: %v", err)
+		}
+		if run.StartedAt, err = time.Parse(time.RFC3339Nano, started); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("trace database: invalid time %q of run %d", started, run.ID)
+		}
+		runs = append(runs, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("trace database: %v", err)
+	}
+
+	// The hops are read once the runs are, as the only connection is busy
+	// while rows are open
+	for i := range runs {
+		if runs[i].Hops, err = d.hops(ctx, runs[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+// hops returns the hops of a run
+func (d *DB) hops(ctx context.Context, runID int64) ([]Hop, error) {
+	rows, err := d.selectHops.QueryContext(ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("trace database: %v", err)
+	}
+	defer rows.Close()
+	hops := []Hop{}
+	for rows.Next() {
+		var hop Hop
+		if err := rows.Scan(&hop.Hop, &hop.Hostname, &hop.IP, &hop.Loss, &hop.Sent,
+			&hop.Last, &hop.Avg, &hop.Best, &hop.Worst, &hop.StDev); err != nil {
+			return nil, fmt.Errorf("trace database: %v", err)
+		}
+		hops = append(hops, hop)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("trace database: %v", err)
+	}
+	return hops, nil
+}
+
+// Close closes the database
+func (d *DB) Close() error {
+	return d.db.Close()
+}
@@ -1,54 +1,715 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/kluwer/mtr-tool/internal/api"
+	"github.com/kluwer/mtr-tool/internal/audit"
+	"github.com/kluwer/mtr-tool/internal/cache"
+	"github.com/kluwer/mtr-tool/internal/canary"
+	"github.com/kluwer/mtr-tool/internal/geoip"
+	"github.com/kluwer/mtr-tool/internal/history"
 	"github.com/kluwer/mtr-tool/internal/mtr"
-	"github.com/gorilla/mux"
+	"github.com/kluwer/mtr-tool/internal/sink"
+	"github.com/kluwer/mtr-tool/internal/tracedb"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
 )
 
+// Exit codes returned by the CLI. 2 is left to the flag package, which uses
+// it for usage errors.
+const (
+	exitError                = 1
+	exitDestinationUnreached = 3
+	exitMTRCrashed           = 4
+	exitThresholdExceeded    = 5
+)
+
+// cliOptions holds settings that only apply to CLI mode
+type cliOptions struct {
+	Sinks []sink.Sink
+	// RequireDestination exits with exitDestinationUnreached when the final
+	// hop is not the target
+	RequireDestination bool
+	// DryRun prints the mtr command instead of running it; ExplainArgs
+	// additionally describes every argument
+	DryRun      bool
+	ExplainArgs bool
+	// FromInterfaces traces once per interface and prints a comparison
+	FromInterfaces []string
+	// QuietFailures reports failed traces to the sinks only and exits 0, for
+	// scheduled runs where monitoring alerts on the metrics instead
+	QuietFailures bool
+	// Intervals traces once per probe interval, within SweepBudget, and
+	// prints where hops start rate limiting
+	Intervals   []time.Duration
+	SweepBudget time.Duration
+	// DecodeFile renders the results stored in a binary file instead of tracing
+	DecodeFile string
+	// CompareFiles, when set, are a baseline and a current JSON result whose
+	// paths are compared instead of tracing
+	CompareFiles []string
+	// Targets are all hosts to trace; with more than one they are traced
+	// concurrently, at most Parallel at a time
+	Targets  []string
+	Parallel int
+	// Thresholds fail the run with exitThresholdExceeded when a result
+	// exceeds them
+	Thresholds mtr.Thresholds
+	// Out receives the results: stdout, or the -output file
+	Out io.Writer
+	// Timeout bounds a single trace, or each cycle of Watch
+	Timeout time.Duration
+	// Watch repeats the trace with this time between the starts of
+	// consecutive cycles until interrupted (0 traces once)
+	Watch time.Duration
+}
+
 func main() {
 	// Parse command line flags
 	var (
-		serverMode = flag.Bool("server", false, "Run in server mode")
-		port       = flag.String("port", "8080", "Server port (only in server mode)")
-		hostname   = flag.String("host", "", "Target hostname (only in CLI mode)")
-		count      = flag.Int("count", 20, "Number of packets to send")
-		report     = flag.Bool("report", false, "Enable report mode")
+		serverMode    = flag.Bool("server", false, "Run in server mode")
+		port          = flag.String("port", "8080", "Server port (only in server mode)")
+		count         = flag.Int("count", 20, "Number of packets to send")
+		report        = flag.Bool("report", false, "Enable report mode")
+		resolve       = flag.Bool("resolve", false, "Resolve hop names in live mode too (report mode always does)")
+		unknownLabel  = flag.String("unknown-host-label", mtr.DefaultUnknownHostLabel, "Label shown for hops with no IP or name")
+		destLossOnly  = flag.Bool("destination-loss-only", false, "Judge the path by the destination's loss only, ignoring intermediate hops")
+		ignoreLossTTL = flag.Int("ignore-loss-before-hop", 0, "Neither color nor judge the loss of hops up to and including this hop (0 disables)")
+		destHop       = flag.Int("destination-hop", 0, "Judge this hop as the destination in the summary, health verdict and thresholds, disregarding later hops (0 uses the final hop)")
+		abortLatency  = flag.Float64("abort-if-latency-exceeds", 0, "Abort the trace once any probe's latency exceeds this many ms (0 disables)")
+		ipv4          = flag.Bool("4", false, "Trace over IPv4 only")
+		ipv6          = flag.Bool("6", false, "Trace over IPv6 only")
+		firstHopOnly  = flag.Bool("first-hop-only", false, "Only probe the first hop (quick gateway reachability check)")
+		tcp           = flag.Bool("tcp", false, "Send TCP SYN probes to -probe-port instead of ICMP echo requests")
+		udp           = flag.Bool("udp", false, "Send UDP probes to -probe-port instead of ICMP echo requests")
+		probePort     = flag.Int("probe-port", 0, "Destination port of -tcp and -udp probes")
+		varyPort      = flag.String("vary-port", "", "Spread probes over UDP source ports in this range (e.g. 33434-33441) to discover ECMP paths")
+		maxDisplay    = flag.Int("max-display-hops", 0, "Show at most this many hops, collapsing the middle of the route (0 shows all)")
+		iface         = flag.String("interface", "", "Send probes out of this network interface")
+		interval      = flag.Duration("interval", 0, "Time between probe cycles (mtr -i), from 100ms to 10s (default: mtr's 1s)")
+		packetSize    = flag.Int("psize", 0, "Probe packet size in bytes (mtr -s, default: mtr's)")
+		intervalSweep = flag.String("interval-sweep", "", "Trace at each of these comma-separated probe intervals, e.g. 1s,500ms,200ms, and report where hops start rate limiting (only in CLI mode)")
+		sweepBudget   = flag.Duration("sweep-budget", 5*time.Minute, "Total time allowed for -interval-sweep")
+		fromIfaces    = flag.String("from-interfaces", "", "Trace from each of these comma-separated interfaces and compare the paths (only in CLI mode)")
+		matrix        = flag.Bool("matrix", false, "Show the RTT of every probe per hop and cycle")
+		noColor       = flag.Bool("no-color", false, "Render the table without color codes (also when NO_COLOR is set or stdout is not a terminal)")
+		outputFile    = flag.String("output", "", "Write the results to this file instead of stdout, without colors (only in CLI mode)")
+		format        = flag.String("format", "table", "Output format: table, report for mtr's own --report-wide layout, binary for archiving, geojson for maps, json, csv, html for a self-contained page or markdown")
+		align         = flag.String("align", "left", "Alignment of the table's numeric columns: left or right")
+		wide          = flag.Bool("wide", false, "Never truncate the table's host column, which otherwise grows to at most 60 characters")
+		percentiles   = flag.Bool("percentiles", false, "Add p50, p95 and p99 latency columns to the table")
+		hopBadges     = flag.Bool("hop-badges", false, "Show each hop's classification (stable, jittery or lossy) in the table")
+		lossyLimit    = flag.Float64("lossy-threshold", mtr.DefaultLossyThreshold, "Loss% above which a hop is classified lossy")
+		jitterCoV     = flag.Float64("jitter-cov", mtr.DefaultJitterCoV, "RTT coefficient of variation above which a hop is classified jittery")
+		compareFile   = flag.String("compare", "", "Compare the path of this baseline JSON result with the current one given as argument, e.g. -compare before.json after.json, and exit (only in CLI mode)")
+		decodeFile    = flag.String("decode", "", "Render the results stored in this binary file and exit (only in CLI mode)")
+		cidrPick      = flag.String("cidr-pick", "first", "Address traced when -host is a subnet: first (gateway) or random")
+		nice          = flag.Int("nice", 0, "Run mtr with this scheduling niceness, -20 to 19 (0 leaves it unchanged)")
+		ioniceClass   = flag.String("ionice", "", "Run mtr in this I/O scheduling class: idle or best-effort (Linux only)")
+		enrichPTR     = flag.Bool("enrich-ptr", false, "Annotate hops with their reverse DNS name")
+		enrichASN     = flag.Bool("enrich-asn", false, "Annotate hops with their origin AS, looked up through Team Cymru's DNS service")
+		lookupWorkers = flag.Int("lookup-workers", mtr.DefaultLookupWorkers, "Concurrent lookups for -enrich-ptr and -enrich-asn")
+		lookupCache   = flag.Int("lookup-cache-size", mtr.DefaultLookupCacheSize, "Number of IPs whose -enrich-ptr/-enrich-asn lookups are cached for the life of the process")
+		geoipDB       = flag.String("geoip-db", "", "Annotate hops with their country and city from this MaxMind GeoIP2/GeoLite2 mmdb file")
+		enrichCmd     = flag.String("enrich-cmd", "", "Annotate hops with the JSON this command prints when given the hops as JSON on stdin")
+		traceDBFile   = flag.String("db", "", "Record every completed trace in this SQLite database, served on /history in server mode")
+		historyFile   = flag.String("history-file", "", "Record every trace's end-to-end metrics in this file and flag traces above the target's historical p95")
+		historyWindow = flag.Int("history-window", history.DefaultWindow, "Number of recent traces of a target compared against with -history-file")
+		noSudo        = flag.Bool("no-sudo", false, "Run mtr directly instead of through sudo, for an mtr installed with the privileges it needs")
+		mtrJSON       = flag.Bool("mtr-json", false, "Take hop statistics from mtr's own --json report instead of computing them from raw probe records")
+		timeout       = flag.Duration("timeout", api.DefaultTraceTimeout, "Time limit of a single trace (in server mode, of every request's trace)")
+		retries       = flag.Int("retries", 0, "Run a trace again up to this many times (at most 5) when mtr fails transiently, e.g. with a socket error")
+		killGrace     = flag.Duration("kill-grace", mtr.DefaultKillGrace, "Time a cancelled mtr gets to exit after SIGTERM before it is killed")
+		enrichTimeout = flag.Duration("enrich-timeout", mtr.DefaultEnrichTimeout, "Time limit for the -enrich-cmd command")
+		ipOnly        = flag.Bool("ip-only", false, "Only accept IP address targets and never use DNS (in server mode, for every request)")
+		denyPrivate   = flag.Bool("deny-private", false, "Refuse targets in private, loopback, link-local and other reserved ranges (in server mode, with 403)")
+		allowTargets  = flag.String("allow", "", "Only trace targets on this comma-separated list of hostnames, domains, IPs and subnets (in server mode, others get 403)")
+		denyTargets   = flag.String("deny", "", "Refuse targets on this comma-separated list of hostnames, domains, IPs and subnets (in server mode, with 403)")
+		noMeta        = flag.Bool("no-meta", false, "Leave the local hostname, start time and tool version out of reports")
+		summaryLevel  = flag.String("summary-level", "normal", "Summary detail: minimal, normal or detailed")
+		explain       = flag.Bool("explain", false, "Explain in plain language what the trace indicates")
+		allowedCounts = flag.String("allowed-counts", "", "Comma-separated list of count values the API accepts (only in server mode)")
+		dryRun        = flag.Bool("dry-run", false, "Print the mtr command that would be run and exit (only in CLI mode)")
+		explainArgs   = flag.Bool("explain-args", false, "Like -dry-run, but also explain every mtr argument (only in CLI mode)")
+		quietFailures = flag.Bool("quiet-failures", false, "On a failed trace, only report it to the metrics sinks and exit 0 (only in CLI mode)")
+		requireDest   = flag.Bool("require-destination", false, "Exit with code 3 when the destination is not reached (only in CLI mode)")
+		cacheTTL      = flag.Duration("cache-ttl", 0, "Cache completed results for this long, e.g. 5m (only in server mode, 0 disables)")
+		cacheFile     = flag.String("cache-file", "", "Persist the result cache to this file across restarts (requires -cache-ttl)")
+		listRecent    = flag.Bool("list-recent", false, "List the most recently traced targets in -cache-file and exit")
+		failLoss      = flag.Float64("fail-loss", 0, "Exit with code 5 (in CLI mode) and alert -webhook-url when any hop's loss exceeds this percentage (0 disables)")
+		failLatency   = flag.Float64("fail-latency", 0, "Exit with code 5 (in CLI mode) and alert -webhook-url when the destination's average latency exceeds this many ms (0 disables)")
+		webhookURL    = flag.String("webhook-url", "", "POST a JSON alert to this URL whenever a trace exceeds -fail-loss or -fail-latency")
+		watch         = flag.Bool("watch", false, "Re-run the trace every -interval-watch until interrupted (only in CLI mode)")
+		watchEvery    = flag.Duration("interval-watch", time.Minute, "Time between the starts of -watch cycles")
+		parallel      = flag.Int("parallel", 4, "Number of -host targets traced at the same time")
+		recentLimit   = flag.Int("recent-limit", 20, "Number of targets -list-recent shows (0 shows all)")
+		canaryHost    = flag.String("canary-host", "", "Periodically trace this host and fail /readyz when it keeps failing (only in server mode)")
+		canaryEvery   = flag.Duration("canary-interval", time.Minute, "Interval between canary traces")
+		canaryFails   = flag.Int("canary-failures", 3, "Consecutive canary failures before /readyz reports unready")
+		auditLogFile  = flag.String("audit-log", "", "Append a JSON line for every trace request to this file (only in server mode)")
+		auditChain    = flag.Bool("audit-hash-chain", false, "Link audit log entries by hash so tampering is detectable")
+		auditMaxSize  = flag.Int64("audit-max-bytes", 100<<20, "Rotate the audit log when it reaches this size (0 never rotates)")
+		auditKey      = flag.String("audit-hmac-key", "", "Hash the audit log chain with HMAC-SHA256 and this key, so it cannot be recomputed without it (implies -audit-hash-chain)")
+		verifyAudit   = flag.String("verify-audit-log", "", "Verify the hash chain of this audit log and the files it was rotated into, then exit")
+		auditAnchor   = flag.String("verify-audit-anchor", "", "Hash the first entry checked by -verify-audit-log must link to, when older rotated files were removed")
+		urlSecret     = flag.String("url-secret", "", "Only accept GET requests with a URL signed with this secret, except for the health probes (only in server mode)")
+		signURL       = flag.String("sign-url", "", "Print this path and query, e.g. \"/mtr?hostname=example.com\", signed with -url-secret and exit")
+		signTTL       = flag.Duration("sign-ttl", time.Hour, "How long a URL signed with -sign-url stays valid")
+		authToken     = flag.String("auth-token", "", "Require this bearer token on every request except /healthz and /readyz (only in server mode)")
+		rateSpec      = flag.String("rate", "", "Limit trace requests per client IP, e.g. 5/min (only in server mode)")
+		prometheusOn  = flag.Bool("prometheus", false, "Serve Prometheus metrics on /metrics (only in server mode)")
+		buckets       = flag.String("latency-buckets", "", "Comma-separated hop latency histogram buckets in seconds (default: 1ms doubling to ~2s)")
+		metricsLabels = flag.String("metrics-labels", "", "Comma-separated trace label names exported as Prometheus labels")
+		statsdAddr    = flag.String("statsd", "", "Send per-hop metrics to this StatsD address (host:port)")
+		dogStatsD     = flag.Bool("dogstatsd", false, "Use DogStatsD tag syntax for StatsD metrics")
+		natsURL       = flag.String("nats-url", "", "Publish every result as JSON to this NATS server")
+		natsSubject   = flag.String("nats-subject", "mtr.results", "NATS subject results are published to")
+		jsonKeys      = flag.String("json-keys", "snake", "JSON key style of published results and -format json: snake or camel")
+		jsonNull      = flag.Bool("json-null-unknown", false, "With -format json, write the hostname and IP of hops that never answered as null")
 	)
+	var hosts hostsFlag
+	flag.Var(&hosts, "host", "Target hostname; repeat or separate with commas to trace several at once (only in CLI mode)")
+	labels := labelFlag{}
+	flag.Var(labels, "label", "Attach a key=value label to the trace (repeatable)")
+	flag.String("config", "", "Read defaults from this YAML file; flags given on the command line override it")
+	var verbose bool
+	flag.BoolVar(&verbose, "v", false, "Log the mtr command line, mtr's raw output and how it was parsed (to stderr in CLI mode)")
+	flag.BoolVar(&verbose, "debug", false, "Same as -v")
+	flag.Usage = usage
 	flag.Parse()
+	if err := loadSettings(flag.CommandLine, labels); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	// NO_COLOR (https://no-color.org) disables colors when set to anything
+	if os.Getenv("NO_COLOR") != "" {
+		*noColor = true
+	}
+
+	if *signURL != "" {
+		if *urlSecret == "" {
+			fmt.Println("Error: -sign-url requires -url-secret")
+			os.Exit(exitError)
+		}
+		signed, err := api.SignURL(*urlSecret, *signURL, time.Now().Add(*signTTL))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		fmt.Println(signed)
+		return
+	}
+	if *verifyAudit != "" {
+		if err := verifyAuditLog(*verifyAudit, *auditAnchor, *auditKey); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		return
+	}
+	if *listRecent {
+		if err := listRecentTraces(*cacheFile, *cacheTTL, *recentLimit); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		return
+	}
+
+	if err := mtr.ValidateLabels(labels); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if err := mtr.ValidateFamily(*ipv4, *ipv6); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if *iface != "" {
+		if err := mtr.ValidateInterface(*iface); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+	level, err := mtr.ParseSummaryLevel(*summaryLevel)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	outputFormat, err := mtr.ParseFormat(*format)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	alignment, err := mtr.ParseAlignment(*align)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	hopClasses := mtr.HopClassThresholds{Loss: *lossyLimit, CoV: *jitterCoV}
+	if err := hopClasses.Validate(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if err := mtr.ValidatePriority(*nice, *ioniceClass); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	var enrichers []mtr.Enricher
+	if *enrichPTR || *enrichASN {
+		if *ipOnly {
+			fmt.Println("Error: -enrich-ptr and -enrich-asn use DNS and cannot be combined with -ip-only")
+			os.Exit(exitError)
+		}
+		enrichers = append(enrichers, mtr.NewLookupEnricher(*enrichPTR, *enrichASN, *lookupWorkers, *lookupCache))
+	}
+	if *geoipDB != "" {
+		db, err := geoip.Open(*geoipDB)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		enrichers = append(enrichers, db)
+	}
+	if *enrichCmd != "" {
+		enrichers = append(enrichers, mtr.CommandEnricher{Path: *enrichCmd, Timeout: *enrichTimeout})
+	}
+	pick, err := mtr.ParseCIDRPick(*cidrPick)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if *ipOnly && *resolve {
+		fmt.Println("Error: -resolve uses DNS and cannot be combined with -ip-only")
+		os.Exit(exitError)
+	}
+	for _, host := range hosts {
+		if err := mtr.ValidateHostname(host); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if *ipOnly && !*serverMode {
+			if err := mtr.ValidateIPLiteral(host); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitError)
+			}
+		}
+		if mtr.IsCIDR(host) {
+			if _, err := mtr.ParseCIDR(host); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitError)
+			}
+		}
+	}
+	if *timeout <= 0 {
+		fmt.Println("Error: -timeout must be positive")
+		os.Exit(exitError)
+	}
+	if *parallel < 1 {
+		fmt.Println("Error: -parallel must be at least 1")
+		os.Exit(exitError)
+	}
+	if *destHop < 0 {
+		fmt.Println("Error: -destination-hop must not be negative")
+		os.Exit(exitError)
+	}
+	var traceHistory mtr.History
+	if *historyFile != "" {
+		traceHistory = history.New(*historyFile, *historyWindow)
+	}
+	if err := mtr.ValidatePacketSize(*packetSize); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if err := mtr.ValidateRetries(*retries); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if *interval != 0 {
+		if err := mtr.ValidateInterval(*interval); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		if *intervalSweep != "" {
+			fmt.Println("Error: -interval cannot be combined with -interval-sweep")
+			os.Exit(exitError)
+		}
+	}
+	thresholds := mtr.Thresholds{Loss: *failLoss, Latency: *failLatency}
+	if err := mtr.ValidateThresholds(thresholds); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	var watchInterval time.Duration
+	if *watch {
+		switch {
+		case *watchEvery <= 0:
+			fmt.Println("Error: -interval-watch must be positive")
+			os.Exit(exitError)
+		case *intervalSweep != "":
+			fmt.Println("Error: -watch cannot be combined with -interval-sweep")
+			os.Exit(exitError)
+		case outputFormat == mtr.FormatBinary:
+			fmt.Println("Error: -watch cannot be combined with -format=binary")
+			os.Exit(exitError)
+		}
+		watchInterval = *watchEvery
+	}
+	var intervals []time.Duration
+	if *intervalSweep != "" {
+		if intervals, err = mtr.ParseIntervals(*intervalSweep); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+	var compareFiles []string
+	if *compareFile != "" {
+		if flag.NArg() != 1 {
+			fmt.Println("Error: -compare takes the baseline file and the current file, e.g. -compare before.json after.json")
+			os.Exit(exitError)
+		}
+		compareFiles = []string{*compareFile, flag.Arg(0)}
+	}
+	var ifaces []string
+	if *fromIfaces != "" {
+		if ifaces, err = mtr.ParseInterfaces(*fromIfaces); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
 
 	if *serverMode {
 		// Configure logging for server mode
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339})
-		runServer(*port)
+	} else if verbose {
+		// Debug logs go to stderr so stdout can still be piped
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339, NoColor: *noColor || !isTerminal(os.Stderr)})
+	}
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	if verbose {
+		zerolog.SetGlobalLevel(zerolog.DebugLevel)
+	}
+
+	allowList, err := mtr.ParseTargetList(*allowTargets)
+	if err != nil {
+		fmt.Printf("Error: -allow: %v\n", err)
+		os.Exit(exitError)
+	}
+	denyList, err := mtr.ParseTargetList(*denyTargets)
+	if err != nil {
+		fmt.Printf("Error: -deny: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	var varyPorts []int
+	if *varyPort != "" {
+		var err error
+		if varyPorts, err = mtr.ParsePortRange(*varyPort); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+	}
+	if err := mtr.ValidateProbeMode(*tcp, *udp, *probePort, *varyPort != ""); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+
+	var sinks []sink.Sink
+	if *statsdAddr != "" {
+		s, err := sink.NewStatsD(*statsdAddr, *dogStatsD)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		sinks = append(sinks, s)
+	}
+	var metrics http.Handler
+	if *prometheusOn && *serverMode {
+		p, err := newPrometheusSink(*buckets, *metricsLabels)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		sinks = append(sinks, p)
+		metrics = p.Handler()
+	}
+	keyStyle, err := mtr.ParseKeyStyle(*jsonKeys)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(exitError)
+	}
+	if *natsURL != "" {
+		n, err := sink.NewNATS(*natsURL, *natsSubject, keyStyle)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		sinks = append(sinks, n)
+	}
+	var traceDB *tracedb.DB
+	if *traceDBFile != "" {
+		if traceDB, err = tracedb.Open(*traceDBFile); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		sinks = append(sinks, traceDB)
+	}
+	if *webhookURL != "" {
+		w, err := sink.NewWebhook(*webhookURL, thresholds, mtr.Config{
+			DestinationLossOnly: *destLossOnly,
+			IgnoreLossBeforeHop: *ignoreLossTTL,
+			DestinationHop:      *destHop,
+			UnknownHostLabel:    unknownLabel,
+		})
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		sinks = append(sinks, w)
+	}
+
+	if *serverMode {
+		counts, err := parseCounts(*allowedCounts)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(exitError)
+		}
+		var resultCache *cache.Cache
+		if *cacheTTL > 0 {
+			resultCache = cache.New(*cacheTTL, *cacheFile)
+			if err := resultCache.Load(); err != nil {
+				log.Warn().Err(err).Msg("Starting with an empty result cache")
+			}
+		} else if *cacheFile != "" {
+			fmt.Println("Error: -cache-file requires -cache-ttl")
+			os.Exit(exitError)
+		}
+		var monitor *canary.Monitor
+		if *canaryHost != "" {
+			if monitor, err = canary.New(*canaryHost, *canaryEvery, *canaryFails); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitError)
+			}
+		}
+		var auditLog *audit.Log
+		if *auditLogFile != "" {
+			if auditLog, err = audit.Open(*auditLogFile, *auditMaxSize, *auditChain || *auditKey != "", auditHMACKey(*auditKey)); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitError)
+			}
+			defer auditLog.Close()
+		}
+		var rateLimiter *api.RateLimiter
+		if *rateSpec != "" {
+			if rateLimiter, err = api.ParseRateLimit(*rateSpec); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitError)
+			}
+		}
+		runServer(*port, api.Options{
+			UnknownHostLabel: unknownLabel,
+			AllowedCounts:    counts,
+			Sinks:            sinks,
+			Cache:            resultCache,
+			Canary:           monitor,
+			Nice:             *nice,
+			IONiceClass:      *ioniceClass,
+			Enrichers:        enrichers,
+			IPOnly:           *ipOnly,
+			DenyPrivate:      *denyPrivate,
+			Allow:            allowList,
+			Deny:             denyList,
+			NoSudo:           *noSudo,
+			NativeJSON:       *mtrJSON,
+			NoColor:          *noColor,
+			TraceTimeout:     *timeout,
+			KillGrace:        *killGrace,
+			Retries:          *retries,
+			HopClasses:       hopClasses,
+			URLSecret:        *urlSecret,
+			History:          traceHistory,
+			AuthToken:        *authToken,
+			RateLimiter:      rateLimiter,
+			TraceDB:          traceDB,
+			AuditLog:         auditLog,
+		}, metrics)
+		sink.CloseAll(sinks)
 	} else {
-		runCLI(*hostname, *count, *report)
+		out := io.Writer(os.Stdout)
+		var outFile *os.File
+		if *outputFile != "" {
+			if outFile, err = createOutput(*outputFile); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(exitError)
+			}
+			out = outFile
+		}
+		code := runCLI(mtr.Config{
+			Hostname:         hosts.first(),
+			Count:            *count,
+			Report:           *report,
+			Resolve:          *resolve,
+			UnknownHostLabel: unknownLabel,
+
+			DestinationLossOnly: *destLossOnly,
+			IgnoreLossBeforeHop: *ignoreLossTTL,
+			DestinationHop:      *destHop,
+			Matrix:              *matrix,
+			Explain:             *explain,
+			SummaryLevel:        level,
+			Format:              outputFormat,
+			Align:               alignment,
+			Wide:                *wide,
+			Percentiles:         *percentiles,
+			NoColor:             *noColor || !isTerminal(out),
+			JSONKeys:            keyStyle,
+			NullUnknownHosts:    *jsonNull,
+			HopClasses:          hopClasses,
+			HopBadges:           *hopBadges,
+			NoMeta:              *noMeta,
+			CIDRPick:            pick,
+			Nice:                *nice,
+			IONiceClass:         *ioniceClass,
+			Enrichers:           enrichers,
+			IPOnly:              *ipOnly,
+			DenyPrivate:         *denyPrivate,
+			Allow:               allowList,
+			Deny:                denyList,
+			NoSudo:              *noSudo,
+			NativeJSON:          *mtrJSON,
+			KillGrace:           *killGrace,
+			Retries:             *retries,
+			History:             traceHistory,
+			FirstHopOnly:        *firstHopOnly,
+			Interval:            *interval,
+			PacketSize:          *packetSize,
+			IPv4:                *ipv4,
+			IPv6:                *ipv6,
+			VaryPorts:           varyPorts,
+			TCP:                 *tcp,
+			UDP:                 *udp,
+			Port:                *probePort,
+			Interface:           *iface,
+			MaxDisplayHops:      *maxDisplay,
+			AbortLatency:        *abortLatency,
+			Labels:              labels,
+		}, cliOptions{
+			Sinks:              sinks,
+			RequireDestination: *requireDest,
+			DryRun:             *dryRun,
+			ExplainArgs:        *explainArgs,
+			FromInterfaces:     ifaces,
+			Intervals:          intervals,
+			SweepBudget:        *sweepBudget,
+			DecodeFile:         *decodeFile,
+			CompareFiles:       compareFiles,
+			QuietFailures:      *quietFailures,
+			Targets:            hosts,
+			Parallel:           *parallel,
+			Thresholds:         thresholds,
+			Watch:              watchInterval,
+			Timeout:            *timeout,
+			Out:                out,
+		})
+		sink.CloseAll(sinks)
+		if outFile != nil {
+			if err := outFile.Close(); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				code = exitError
+			}
+		}
+		os.Exit(code)
 	}
 }
 
-func runServer(port string) {
+// listRecentTraces prints the most recently traced targets held in a
+// persisted result cache. Without a ttl every persisted entry is listed.
+func listRecentTraces(file string, ttl time.Duration, limit int) error {
+	if file == "" {
+		return fmt.Errorf("-list-recent requires -cache-file")
+	}
+	if _, err := os.Stat(file); err != nil {
+		return fmt.Errorf("cannot read cache file: %v", err)
+	}
+	if ttl <= 0 {
+		ttl = time.Duration(math.MaxInt64)
+	}
+	c := cache.New(ttl, file)
+	if err := c.Load(); err != nil {
+		return err
+	}
+	fmt.Print(cache.FormatRecent(c.Recent(limit)))
+	return nil
+}
+
+// auditHMACKey returns the -audit-hmac-key to hash with, or nil for plain
+// SHA-256
+func auditHMACKey(key string) []byte {
+	if key == "" {
+		return nil
+	}
+	return []byte(key)
+}
+
+// verifyAuditLog checks the hash chain of an audit log file and the files it
+// was rotated into, starting at anchor
+func verifyAuditLog(path, anchor, key string) error {
+	files, err := audit.Files(path)
+	if err != nil {
+		return err
+	}
+	n, err := audit.VerifyFiles(files, anchor, auditHMACKey(key))
+	if err != nil {
+		return fmt.Errorf("audit log %s is not intact: %v", path, err)
+	}
+	fmt.Printf("Audit log %s is intact (%d entries)\n", path, n)
+	return nil
+}
+
+func runServer(port string, opts api.Options, metrics http.Handler) {
 	// Create router and configure routes
+	h := api.NewHandler(opts)
 	r := mux.NewRouter()
-	r.HandleFunc("/mtr", api.HandleMTR).Methods("GET")
+	r.HandleFunc("/mtr", h.HandleMTR).Methods("GET", "POST")
+	r.HandleFunc("/mtr/raw", h.HandleRaw).Methods("GET", "POST")
+	r.HandleFunc("/mtr/stream", h.HandleStream).Methods("GET", "POST")
+	r.HandleFunc("/healthz", h.HandleHealthz).Methods("GET")
+	r.HandleFunc("/readyz", h.HandleReadyz).Methods("GET")
+	r.HandleFunc("/recent", h.HandleRecent).Methods("GET")
+	r.HandleFunc("/history", h.HandleHistory).Methods("GET")
+	if metrics != nil {
+		r.Handle("/metrics", metrics).Methods("GET")
+	}
+
+	// Start the canary monitor, if any, for the lifetime of the server
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	if opts.Canary != nil {
+		go opts.Canary.Run(monitorCtx)
+	}
 
 	// Configure server
 	addr := "0.0.0.0:" + port
 	srv := &http.Server{
 		Addr:         addr,
-		Handler:      r,
+		Handler:      h.RequireToken(h.RequireSignature(r)),
 		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: opts.TraceTimeout + time.Minute, // synchronous traces may run for several minutes
 		IdleTimeout:  60 * time.Second,
 	}
 
@@ -70,34 +731,486 @@ func runServer(port string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	err := srv.Shutdown(ctx)
+	h.Close()
+	if err != nil {
 		log.Fatal().Err(err).Msg("Server forced to shutdown")
 	}
 
 	log.Info().Msg("Server exited properly")
 }
 
-func runCLI(hostname string, count int, report bool) {
-	if hostname == "" {
+// runCLI runs a single trace and returns the process exit code
+func runCLI(cfg mtr.Config, opts cliOptions) int {
+	if opts.DecodeFile != "" {
+		if err := decodeResults(opts.Out, opts.DecodeFile, cfg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return exitError
+		}
+		return 0
+	}
+	if len(opts.CompareFiles) > 0 {
+		if err := compareResults(opts.Out, opts.CompareFiles[0], opts.CompareFiles[1], cfg); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return exitError
+		}
+		return 0
+	}
+
+	if cfg.Hostname == "" {
 		fmt.Println("Error: hostname is required")
 		flag.Usage()
-		os.Exit(1)
+		return exitError
+	}
+
+	if len(opts.Targets) > 1 && (len(opts.Intervals) > 0 || len(opts.FromInterfaces) > 0) {
+		fmt.Println("Error: -interval-sweep and -from-interfaces take a single -host")
+		return exitError
+	}
+
+	if opts.DryRun || opts.ExplainArgs {
+		for _, target := range opts.Targets {
+			cfg.Hostname = target
+			printCommand(cfg, opts.ExplainArgs)
+		}
+		return 0
 	}
 
-	cfg := mtr.Config{
-		Hostname: hostname,
-		Count:    count,
-		Report:   report,
+	if opts.Watch > 0 {
+		return runWatch(cfg, opts)
+	}
+	// mtr runs in a process group of its own, so a Ctrl-C in the terminal
+	// does not reach it; cancelling the trace stops it instead
+	interrupted, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if len(opts.Intervals) > 0 {
+		return runIntervalSweep(interrupted, cfg, opts)
 	}
+	return runTrace(interrupted, cfg, opts)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+// runTrace runs one trace, or one per interface or target, and prints it.
+// It returns the exit code of the run.
+func runTrace(parent context.Context, cfg mtr.Config, opts cliOptions) int {
+	ctx, cancel := context.WithTimeout(parent, opts.Timeout)
 	defer cancel()
 
+	if len(opts.FromInterfaces) > 0 {
+		return runInterfaces(ctx, cfg, opts)
+	}
+	if len(opts.Targets) > 1 {
+		return runTargets(ctx, cfg, opts)
+	}
+
 	result, err := mtr.Run(ctx, cfg)
 	if err != nil {
+		sink.RecordFailureAll(ctx, opts.Sinks, cfg.Hostname, cfg.Labels, err)
+		if opts.QuietFailures {
+			return 0
+		}
 		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		return errorExitCode(err)
+	}
+
+	sink.PublishAll(ctx, opts.Sinks, result, cfg)
+	if err := printResults(opts.Out, cfg, result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
+	}
+
+	breached := checkThresholds(result, cfg, opts.Thresholds)
+
+	if opts.RequireDestination && !result.DestinationReached {
+		return exitDestinationUnreached
+	}
+	if breached {
+		return exitThresholdExceeded
+	}
+	return 0
+}
+
+// checkThresholds reports every threshold res exceeds on stderr, so the
+// output stays parseable, and returns whether there were any
+func checkThresholds(res *mtr.Result, cfg mtr.Config, t mtr.Thresholds) bool {
+	breaches := t.Check(res, cfg)
+	for _, breach := range breaches {
+		fmt.Fprintf(os.Stderr, "Threshold exceeded for %s: %s\n", res.Target, breach)
+	}
+	return len(breaches) > 0
+}
+
+// runWatch runs the trace every opts.Watch until SIGINT or SIGTERM, each
+// cycle with the usual time limit. On a terminal the table is redrawn in
+// place; otherwise every cycle is appended. It returns the exit code of the
+// last cycle that completed.
+func runWatch(cfg mtr.Config, opts cliOptions) int {
+	stop, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	text := cfg.Format == mtr.FormatTable || cfg.Format == mtr.FormatReport
+	redraw := text && isTerminal(opts.Out)
+	code := 0
+	for cycle := 1; ; cycle++ {
+		start := time.Now()
+		if redraw {
+			fmt.Fprint(opts.Out, "\033[H\033[2J")
+		}
+		if text {
+			fmt.Fprintf(opts.Out, "Watch cycle %d at %s (every %s, Ctrl-C to stop)\n\n",
+				cycle, start.Format(time.RFC3339), opts.Watch)
+		}
+		cycleCode := runTrace(stop, cfg, opts)
+		if stop.Err() != nil {
+			// Interrupted during the cycle, whose result is incomplete
+			return code
+		}
+		code = cycleCode
+
+		select {
+		case <-stop.Done():
+			return code
+		case <-time.After(time.Until(start.Add(opts.Watch))):
+		}
+	}
+}
+
+// isTerminal reports whether w is a terminal rather than a file or pipe
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// printResults writes results to out in cfg.Format: binary results as one
+// stream, anything else as each result's rendered output
+func printResults(out io.Writer, cfg mtr.Config, results ...*mtr.Result) error {
+	if cfg.Format != mtr.FormatBinary {
+		for _, result := range results {
+			fmt.Fprintln(out, result.Output)
+		}
+		return nil
+	}
+	w, err := mtr.NewBinaryWriter(out)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if err := w.Write(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTargets traces every host in opts.Targets, at most opts.Parallel at a
+// time, and prints their results in the order given. A failed target is
+// reported in its place without stopping the others; the run only fails
+// when every target failed.
+func runTargets(ctx context.Context, cfg mtr.Config, opts cliOptions) int {
+	results := make([]*mtr.Result, len(opts.Targets))
+	errs := make([]error, len(opts.Targets))
+	var g errgroup.Group
+	g.SetLimit(opts.Parallel)
+	for i, target := range opts.Targets {
+		i, target := i, target
+		g.Go(func() error {
+			c := cfg
+			c.Hostname = target
+			results[i], errs[i] = mtr.Run(ctx, c)
+			return nil
+		})
+	}
+	g.Wait()
+
+	// Headers would break the machine-readable formats, which identify the
+	// target in each result instead
+	headers := cfg.Format == mtr.FormatTable || cfg.Format == mtr.FormatReport
+	failed, reached, breached := 0, true, false
+	for i, target := range opts.Targets {
+		if headers {
+			fmt.Fprintf(opts.Out, "=== %s ===\n", target)
+		}
+		if errs[i] != nil {
+			failed++
+			reached = false
+			sink.RecordFailureAll(ctx, opts.Sinks, target, cfg.Labels, errs[i])
+			if !opts.QuietFailures {
+				fmt.Printf("Error: %s: %v\n", target, errs[i])
+			}
+			continue
+		}
+		reached = reached && results[i].DestinationReached
+		sink.PublishAll(ctx, opts.Sinks, results[i], cfg)
+		if err := printResults(opts.Out, cfg, results[i]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitError
+		}
+		if checkThresholds(results[i], cfg, opts.Thresholds) {
+			breached = true
+		}
+	}
+
+	if failed == len(opts.Targets) && !opts.QuietFailures {
+		fmt.Println("Error: the trace failed for every target")
+		return exitError
+	}
+	if opts.RequireDestination && !reached {
+		return exitDestinationUnreached
+	}
+	if breached {
+		return exitThresholdExceeded
+	}
+	return 0
+}
+
+// createOutput creates the -output file, and any missing parent
+// directories, or truncates an existing one
+func createOutput(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// decodeResults prints every result stored in a binary file to out, rendered
+// with cfg
+func decodeResults(out io.Writer, path string, cfg mtr.Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := mtr.NewBinaryReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, mtr.Render(res, cfg))
+	}
+}
+
+// compareResults prints how the path of the JSON result in current differs
+// from the one in baseline, as a table or, with -format json, as JSON
+func compareResults(out io.Writer, baseline, current string, cfg mtr.Config) error {
+	var results []*mtr.Result
+	for _, path := range []string{baseline, current} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		res, err := mtr.ReadResultJSON(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		results = append(results, res)
+	}
+
+	diff := mtr.DiffResults(*results[0], *results[1])
+	if cfg.Format != mtr.FormatJSON {
+		fmt.Fprint(out, mtr.FormatDiff(diff, cfg))
+		return nil
+	}
+	data, err := mtr.MarshalKeys(diff, cfg.JSONKeys)
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	json.Indent(&indented, data, "", "  ")
+	fmt.Fprintln(out, indented.String())
+	return nil
+}
+
+// errorExitCode maps a trace error to the exit code that describes it
+func errorExitCode(err error) int {
+	var crash *mtr.CrashError
+	if errors.As(err, &crash) {
+		return exitMTRCrashed
+	}
+	return exitError
+}
+
+// runInterfaces traces from every interface in opts.FromInterfaces and
+// prints a comparison. It only fails when no interface produced a result.
+func runInterfaces(ctx context.Context, cfg mtr.Config, opts cliOptions) int {
+	results := mtr.RunInterfaces(ctx, cfg, opts.FromInterfaces)
+
+	succeeded, reached := 0, true
+	for _, r := range results {
+		if r.Err != nil {
+			reached = false
+			sink.RecordFailureAll(ctx, opts.Sinks, cfg.Hostname, interfaceLabels(cfg.Labels, r.Interface), r.Err)
+			continue
+		}
+		succeeded++
+		reached = reached && r.Result.DestinationReached
+		sink.PublishAll(ctx, opts.Sinks, r.Result, cfg)
+	}
+
+	fmt.Fprintln(opts.Out, mtr.FormatInterfaceComparison(results, cfg))
+
+	breached := false
+	for _, r := range results {
+		if r.Err == nil && checkThresholds(r.Result, cfg, opts.Thresholds) {
+			breached = true
+		}
 	}
 
-	fmt.Println(result.Output)
+	if succeeded == 0 && !opts.QuietFailures {
+		fmt.Println("Error: the trace failed from every interface")
+		return exitError
+	}
+	if opts.RequireDestination && !reached {
+		return exitDestinationUnreached
+	}
+	if breached {
+		return exitThresholdExceeded
+	}
+	return 0
+}
+
+// runIntervalSweep traces at every interval in opts.Intervals and prints
+// where hops start rate limiting. It only fails when no interval produced a
+// result.
+func runIntervalSweep(parent context.Context, cfg mtr.Config, opts cliOptions) int {
+	ctx, cancel := context.WithTimeout(parent, opts.SweepBudget)
+	defer cancel()
+
+	results := mtr.RunIntervalSweep(ctx, cfg, opts.Intervals, opts.SweepBudget)
+	succeeded := 0
+	for _, r := range results {
+		switch {
+		case r.Skipped:
+		case r.Err != nil:
+			labels := map[string]string{"interval": r.Interval.String()}
+			for k, v := range cfg.Labels {
+				labels[k] = v
+			}
+			sink.RecordFailureAll(ctx, opts.Sinks, cfg.Hostname, labels, r.Err)
+		default:
+			succeeded++
+			sink.PublishAll(ctx, opts.Sinks, r.Result, cfg)
+		}
+	}
+
+	fmt.Fprintln(opts.Out, mtr.FormatIntervalSweep(results, cfg))
+
+	if succeeded == 0 && !opts.QuietFailures {
+		fmt.Println("Error: the trace failed at every interval")
+		return exitError
+	}
+	return 0
+}
+
+// interfaceLabels returns labels plus the interface label RunInterfaces adds
+func interfaceLabels(labels map[string]string, iface string) map[string]string {
+	merged := map[string]string{"interface": iface}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// printCommand prints the command a trace would run, optionally followed by
+// an explanation of each argument
+func printCommand(cfg mtr.Config, explain bool) {
+	fmt.Println(mtr.Command(cfg))
+	if !explain {
+		return
+	}
+
+	fmt.Println("\nArguments:")
+	for _, arg := range mtr.ExplainArgs(cfg) {
+		fmt.Printf("  %-30s %s (option: %s)\n", strings.Join(arg.Args, " "), arg.Description, arg.Option)
+	}
+}
+
+// newPrometheusSink creates the Prometheus sink from the metrics flags
+func newPrometheusSink(bucketList, labelList string) (*sink.Prometheus, error) {
+	buckets := sink.DefaultLatencyBuckets
+	if bucketList != "" {
+		var err error
+		if buckets, err = sink.ParseBuckets(bucketList); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	if labelList != "" {
+		for _, name := range strings.Split(labelList, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+	return sink.NewPrometheus(names, buckets)
+}
+
+// parseCounts parses a comma-separated list of packet counts
+func parseCounts(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var counts []int
+	for _, field := range strings.Split(list, ",") {
+		count, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || count <= 0 || count > 100 {
+			return nil, fmt.Errorf("invalid count %q in allowed counts (must be 1-100)", field)
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+// hostsFlag collects repeated -host flags, each of which may be a
+// comma-separated list
+type hostsFlag []string
+
+func (h *hostsFlag) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *hostsFlag) Set(value string) error {
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			*h = append(*h, host)
+		}
+	}
+	return nil
+}
+
+// first returns the first host, or "" when none was given
+func (h hostsFlag) first() string {
+	if len(h) == 0 {
+		return ""
+	}
+	return h[0]
+}
+
+// labelFlag collects repeated -label key=value flags
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for key, value := range l {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlag) Set(value string) error {
+	key, val, found := strings.Cut(value, "=")
+	if !found {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	l[key] = val
+	return nil
 }
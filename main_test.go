@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kluwer/mtr-tool/internal/mtr"
+	"github.com/kluwer/mtr-tool/internal/sink"
+)
+
+// TestMain runs the tests with a fake mtr printing the file named by
+// FAKE_MTR_OUTPUT. The mtr package reads MTR_PATH when it is initialised,
+// so the tests are run again in a process that has it set.
+func TestMain(m *testing.M) {
+	if os.Getenv("MTR_PATH") != "" {
+		os.Exit(m.Run())
+	}
+	dir, err := os.MkdirTemp("", "fake-mtr")
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(dir, "mtr")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\ncat \"$FAKE_MTR_OUTPUT\"\n"), 0o755); err != nil {
+		panic(err)
+	}
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Env = append(os.Environ(), "MTR_PATH="+path)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	err = cmd.Run()
+	os.RemoveAll(dir)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	}
+	if err != nil {
+		panic(err)
+	}
+}
+
+// fakeMTR makes the fake mtr print output for the duration of the test
+func fakeMTR(t *testing.T, output string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "output")
+	if err := os.WriteFile(path, []byte(output), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_MTR_OUTPUT", path)
+}
+
+// twoHops is mtr --raw output of one cycle through 10.0.0.1 to last
+func twoHops(last string) string {
+	return "h 0 10.0.0.1\nx 0 1\np 0 1000 1\nh 1 " + last + "\nx 1 2\np 1 10000 2\n"
+}
+
+// traceConfig traces the documentation address 192.0.2.1 with the fake mtr
+func traceConfig() mtr.Config {
+	return mtr.Config{Hostname: "192.0.2.1", Count: 1, Report: true, NoSudo: true, NoMeta: true, NoColor: true}
+}
+
+// traceOptions prints results to out
+func traceOptions(out *bytes.Buffer) cliOptions {
+	return cliOptions{Targets: []string{"192.0.2.1"}, Parallel: 1, Out: out, Timeout: 10 * time.Second}
+}
+
+func TestParseCounts(t *testing.T) {
+	counts, err := parseCounts("10, 20,50")
+	if err != nil || !reflect.DeepEqual(counts, []int{10, 20, 50}) {
+		t.Errorf("parseCounts = %v, %v; want [10 20 50]", counts, err)
+	}
+	if counts, err := parseCounts(""); err != nil || counts != nil {
+		t.Errorf("empty list = %v, %v; want no restriction", counts, err)
+	}
+	for _, list := range []string{"10,abc", "0", "101", "10,,20", "-5"} {
+		if _, err := parseCounts(list); err == nil {
+			t.Errorf("%q accepted", list)
+		}
+	}
+}
+
+func TestRequireDestination(t *testing.T) {
+	tests := []struct {
+		name    string
+		last    string
+		require bool
+		want    int
+	}{
+		{"reached", "192.0.2.1", true, 0},
+		{"unreached", "198.51.100.7", true, exitDestinationUnreached},
+		{"unreached, not required", "198.51.100.7", false, 0},
+	}
+	for _, tt := range tests {
+		fakeMTR(t, twoHops(tt.last))
+		var out bytes.Buffer
+		opts := traceOptions(&out)
+		opts.RequireDestination = tt.require
+		if got := runTrace(context.Background(), traceConfig(), opts); got != tt.want {
+			t.Errorf("%s: exit code %d, want %d", tt.name, got, tt.want)
+		}
+		if out.Len() == 0 {
+			t.Errorf("%s: no result printed", tt.name)
+		}
+	}
+}
+
+func TestErrorExitCode(t *testing.T) {
+	crash := fmt.Errorf("trace failed: %w", &mtr.CrashError{Signal: syscall.SIGSEGV})
+	if got := errorExitCode(crash); got != exitMTRCrashed {
+		t.Errorf("crash exit code %d, want %d", got, exitMTRCrashed)
+	}
+	if got := errorExitCode(errors.New("mtr error: exit status 1")); got != exitError {
+		t.Errorf("failure exit code %d, want %d", got, exitError)
+	}
+}
+
+func TestQuietFailuresEmitMetrics(t *testing.T) {
+	// mtr prints nothing, so the trace fails
+	fakeMTR(t, "")
+	prom, err := sink.NewPrometheus(nil, sink.DefaultLatencyBuckets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, quiet := range []bool{true, false} {
+		var out bytes.Buffer
+		opts := traceOptions(&out)
+		opts.Sinks = []sink.Sink{prom}
+		opts.QuietFailures = quiet
+		code := runTrace(context.Background(), traceConfig(), opts)
+		if quiet && code != 0 {
+			t.Errorf("quiet failure exit code %d, want 0", code)
+		}
+		if !quiet && code == 0 {
+			t.Error("failure exit code 0 without -quiet-failures")
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	prom.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if want := `mtr_traces_total{status="error",target="192.0.2.1"} 2`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("metrics lack %s:\n%s", want, rec.Body.String())
+	}
+}